@@ -0,0 +1,220 @@
+// Package archive streams downloaded files as a ZIP or tar archive, writing
+// each file as it is read so that no temporary copy is needed
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"downloader/utils"
+)
+
+// Archive formats
+const (
+	FormatZIP = "zip"
+	FormatTar = "tar"
+)
+
+// Formats lists the archive formats, the default first
+var Formats = []string{FormatZIP, FormatTar}
+
+// ErrFormat is returned for a format not in Formats
+var ErrFormat = errors.New("unknown archive format")
+
+// Entry is one file of an archive
+type Entry struct {
+	Name    string // "/"-separated path in the archive and below the root
+	Size    int64
+	ModTime time.Time
+}
+
+// NewEntry describes the file name below root
+func NewEntry(root *os.Root, name string) (Entry, error) {
+	info, err := root.Stat(filepath.FromSlash(name))
+	if err != nil {
+		return Entry{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return Entry{}, fmt.Errorf("%s is not a file", name)
+	}
+	return Entry{Name: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// ContentType returns the MIME type of an archive in format
+func ContentType(format string) string {
+	if format == FormatTar {
+		return "application/x-tar"
+	}
+	return "application/zip"
+}
+
+// stored reports whether a file is added to a ZIP archive without
+// compression. Audio, video and images are compressed already, so deflating
+// them costs time for nothing.
+func stored(name string) bool {
+	switch utils.GetFileType(name) {
+	case "video", "audio", "image":
+		return true
+	}
+	return false
+}
+
+// Size returns the size of the archive of entries in format. exact is false
+// when it cannot be known before writing, for ZIP archives that deflate some
+// files or need ZIP64 records; size then counts those files uncompressed.
+func Size(format string, entries []Entry) (size int64, exact bool, err error) {
+	var headers counter
+	switch format {
+	case FormatTar:
+		for _, entry := range entries {
+			// A writer per header: each would refuse the next header
+			// without the content of the one before
+			if err := tar.NewWriter(&headers).WriteHeader(tarHeader(entry)); err != nil {
+				return 0, false, err
+			}
+			size += entry.Size + padding(entry.Size)
+		}
+		// Two zero blocks end the archive
+		return headers.n + size + 2*tarBlock, true, nil
+
+	case FormatZIP:
+		// Headers do not depend on the content as long as no ZIP64 records
+		// are needed, so writing them without content gives their size
+		exact = true
+		w := zip.NewWriter(&headers)
+		for _, entry := range entries {
+			if _, err := w.CreateHeader(zipHeader(entry)); err != nil {
+				return 0, false, err
+			}
+			size += entry.Size
+			exact = exact && stored(entry.Name)
+		}
+		if err := w.Close(); err != nil {
+			return 0, false, err
+		}
+		size += headers.n
+		return size, exact && size < math.MaxUint32, nil
+	}
+	return 0, false, ErrFormat
+}
+
+// Write writes the archive of entries, read from below root, in format to w.
+// It stops with ctx's error once ctx is done, such as when the client
+// receiving the archive disconnects; what was written is then incomplete.
+func Write(ctx context.Context, w io.Writer, root *os.Root, format string, entries []Entry) error {
+	switch format {
+	case FormatTar:
+		tw := tar.NewWriter(w)
+		for _, entry := range entries {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := tw.WriteHeader(tarHeader(entry)); err != nil {
+				return err
+			}
+			if err := copyEntry(ctx, tw, root, entry); err != nil {
+				return err
+			}
+		}
+		return tw.Close()
+
+	case FormatZIP:
+		zw := zip.NewWriter(w)
+		for _, entry := range entries {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			fw, err := zw.CreateHeader(zipHeader(entry))
+			if err != nil {
+				return err
+			}
+			if err := copyEntry(ctx, fw, root, entry); err != nil {
+				return err
+			}
+		}
+		return zw.Close()
+	}
+	return ErrFormat
+}
+
+// copyEntry copies exactly the size of entry recorded in the headers, so
+// that a file growing meanwhile cannot corrupt the archive
+func copyEntry(ctx context.Context, w io.Writer, root *os.Root, entry Entry) error {
+	file, err := root.Open(filepath.FromSlash(entry.Name))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.CopyN(w, contextReader{ctx, file}, entry.Size); err != nil {
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s shrank while being archived", entry.Name)
+		}
+		return err
+	}
+	return nil
+}
+
+// tarBlock is the tar record size that headers and content are padded to
+const tarBlock = 512
+
+// padding returns the zero bytes that follow size bytes of content in a tar archive
+func padding(size int64) int64 {
+	return (tarBlock - size%tarBlock) % tarBlock
+}
+
+// tarHeader returns the header of entry in a tar archive
+func tarHeader(entry Entry) *tar.Header {
+	return &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     entry.Name,
+		Mode:     0o644,
+		Size:     entry.Size,
+		ModTime:  entry.ModTime.Truncate(time.Second),
+	}
+}
+
+// zipHeader returns the header of entry in a ZIP archive
+func zipHeader(entry Entry) *zip.FileHeader {
+	header := &zip.FileHeader{
+		Name:     entry.Name,
+		Method:   zip.Deflate,
+		Modified: entry.ModTime.Truncate(time.Second),
+	}
+	if stored(entry.Name) {
+		header.Method = zip.Store
+	}
+	header.SetMode(0o644)
+	return header
+}
+
+// counter counts the bytes written to it
+type counter struct {
+	n int64
+}
+
+func (c *counter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// contextReader fails reads once ctx is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
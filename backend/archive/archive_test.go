@@ -0,0 +1,149 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupRoot creates files below a temporary folder and returns it opened as
+// a root with the entries describing them
+func setupRoot(t *testing.T, files map[string]string) (*os.Root, []Entry) {
+	folder := t.TempDir()
+	for name, content := range files {
+		os.MkdirAll(filepath.Join(folder, filepath.Dir(name)), 0o755)
+		os.WriteFile(filepath.Join(folder, name), []byte(content), 0o644)
+	}
+	root, err := os.OpenRoot(folder)
+	if err != nil {
+		t.Fatalf("OpenRoot() = %v", err)
+	}
+	t.Cleanup(func() { root.Close() })
+
+	var entries []Entry
+	for _, name := range []string{"Channel/Talk.mp4", "Channel/Talk.en.vtt", "Song.mp3", strings.Repeat("long name ", 12) + ".mp3"} {
+		if _, ok := files[name]; !ok {
+			continue
+		}
+		entry, err := NewEntry(root, name)
+		if err != nil {
+			t.Fatalf("NewEntry(%q) = %v", name, err)
+		}
+		entries = append(entries, entry)
+	}
+	return root, entries
+}
+
+func TestWriteZIP(t *testing.T) {
+	root, entries := setupRoot(t, map[string]string{
+		"Channel/Talk.mp4":    strings.Repeat("video data ", 100),
+		"Channel/Talk.en.vtt": strings.Repeat("WEBVTT\n", 100),
+	})
+
+	var buf bytes.Buffer
+	if err := Write(context.Background(), &buf, root, FormatZIP, entries); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("invalid ZIP archive: %v", err)
+	}
+	methods := map[string]uint16{}
+	for _, file := range reader.File {
+		methods[file.Name] = file.Method
+		rc, _ := file.Open()
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil || int64(len(content)) != int64(file.UncompressedSize64) {
+			t.Errorf("failed to read %s back: %v", file.Name, err)
+		}
+	}
+	if methods["Channel/Talk.mp4"] != zip.Store || methods["Channel/Talk.en.vtt"] != zip.Deflate {
+		t.Errorf("expected media stored and subtitles deflated, got %v", methods)
+	}
+
+	// Deflated files make the size an estimate
+	size, exact, err := Size(FormatZIP, entries)
+	if err != nil || exact {
+		t.Errorf("Size() = %d, %v, %v; want an estimate", size, exact, err)
+	}
+}
+
+func TestSizeIsExact(t *testing.T) {
+	files := map[string]string{
+		"Channel/Talk.mp4": strings.Repeat("video data ", 1000),
+		"Song.mp3":         "audio",
+		strings.Repeat("long name ", 12) + ".mp3": "a name longer than tar headers hold",
+	}
+
+	for _, format := range Formats {
+		root, entries := setupRoot(t, files)
+		size, exact, err := Size(format, entries)
+		if err != nil || !exact {
+			t.Fatalf("Size(%s) = %d, %v, %v; want exact", format, size, exact, err)
+		}
+		var buf bytes.Buffer
+		if err := Write(context.Background(), &buf, root, format, entries); err != nil {
+			t.Fatalf("Write(%s) = %v", format, err)
+		}
+		if int64(buf.Len()) != size {
+			t.Errorf("Size(%s) = %d; wrote %d", format, size, buf.Len())
+		}
+	}
+}
+
+func TestWriteTar(t *testing.T) {
+	root, entries := setupRoot(t, map[string]string{"Channel/Talk.mp4": "video data", "Song.mp3": "audio"})
+
+	var buf bytes.Buffer
+	if err := Write(context.Background(), &buf, root, FormatTar, entries); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	reader := tar.NewReader(&buf)
+	var names []string
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("invalid tar archive: %v", err)
+		}
+		content, _ := io.ReadAll(reader)
+		if int64(len(content)) != header.Size {
+			t.Errorf("%s: read %d bytes, want %d", header.Name, len(content), header.Size)
+		}
+		names = append(names, header.Name)
+	}
+	if strings.Join(names, ",") != "Channel/Talk.mp4,Song.mp3" {
+		t.Errorf("unexpected entries %v", names)
+	}
+}
+
+func TestWriteStopsWhenCancelled(t *testing.T) {
+	root, entries := setupRoot(t, map[string]string{"Channel/Talk.mp4": "video data", "Song.mp3": "audio"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, format := range Formats {
+		if err := Write(ctx, io.Discard, root, format, entries); !errors.Is(err, context.Canceled) {
+			t.Errorf("Write(%s) = %v; want context.Canceled", format, err)
+		}
+	}
+}
+
+func TestWriteFailsForShrunkFiles(t *testing.T) {
+	root, entries := setupRoot(t, map[string]string{"Song.mp3": "audio"})
+	entries[0].Size = 100
+
+	if err := Write(context.Background(), io.Discard, root, FormatTar, entries); err == nil {
+		t.Error("expected an error for a file smaller than recorded")
+	}
+}
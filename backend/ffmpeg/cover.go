@@ -0,0 +1,85 @@
+package ffmpeg
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// coverExtensions are the outputs EmbedCover can write cover art into
+var coverExtensions = []string{".mp3", ".m4a", ".flac", ".mp4", ".m4v", ".mov", ".mkv", ".mka"}
+
+// SupportsCover reports whether cover art can be embedded in the file name
+func SupportsCover(name string) bool {
+	return slices.Contains(coverExtensions, strings.ToLower(filepath.Ext(name)))
+}
+
+// EmbedCover writes media to output with the JPEG cover as its cover art,
+// replacing any cover it already had. probe describes media.
+func EmbedCover(ctx context.Context, media, cover, output string, probe *ProbeResult) error {
+	args, err := CoverArgs(media, cover, output, probe)
+	if err != nil {
+		return err
+	}
+	return Run(ctx, args)
+}
+
+// CoverArgs returns the ffmpeg arguments for EmbedCover. Matroska stores the
+// cover as an attachment; the other containers as an attached picture stream
+// after the existing streams.
+func CoverArgs(media, cover, output string, probe *ProbeResult) ([]string, error) {
+	ext := strings.ToLower(filepath.Ext(media))
+	if !SupportsCover(media) {
+		return nil, fmt.Errorf("cover art cannot be embedded in %s files", ext)
+	}
+
+	if ext == ".mkv" || ext == ".mka" {
+		// 0:V skips an existing attached picture
+		return []string{
+			"-i", media, "-map", "0:V?", "-map", "0:a?", "-map", "0:s?", "-c", "copy",
+			"-attach", cover, "-metadata:s:t:0", "mimetype=image/jpeg", "-metadata:s:t:0", "filename=cover.jpg",
+			output,
+		}, nil
+	}
+
+	args := []string{"-i", media, "-i", cover}
+	pictureIndex := 0
+	switch ext {
+	case ".mp3", ".m4a", ".flac":
+		args = append(args, "-map", "0:a", "-map", "1:0", "-c", "copy")
+	default:
+		args = append(args, "-map", "0:V?", "-map", "0:a?", "-map", "0:s?", "-map", "1:0", "-c", "copy")
+		pictureIndex = videoStreams(probe)
+	}
+
+	picture := "-disposition:v:" + strconv.Itoa(pictureIndex)
+	args = append(args, picture, "attached_pic")
+	if ext == ".mp3" {
+		// ID3v2.3 is what most players read cover art from
+		args = append(args, "-id3v2_version", "3", "-metadata:s:v", "title=Album cover", "-metadata:s:v", "comment=Cover (front)")
+	}
+	return append(args, output), nil
+}
+
+// SquareCropArgs returns the ffmpeg arguments cropping the image input to the
+// largest centered square
+func SquareCropArgs(input, output string) []string {
+	return []string{"-i", input, "-vf", "crop='min(iw,ih)':'min(iw,ih)'", "-q:v", "2", output}
+}
+
+// videoStreams counts the video streams that are not cover art
+func videoStreams(probe *ProbeResult) int {
+	if probe == nil {
+		return 0
+	}
+	n := 0
+	for _, s := range probe.Streams {
+		if s.CodecType == "video" && s.Disposition.AttachedPic == 0 {
+			n++
+		}
+	}
+	return n
+}
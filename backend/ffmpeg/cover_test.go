@@ -0,0 +1,47 @@
+package ffmpeg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCoverArgs(t *testing.T) {
+	tests := []struct {
+		media    string
+		probe    *ProbeResult
+		expected string
+	}{
+		{
+			media:    "song.mp3",
+			expected: "-i song.mp3 -i cover.jpg -map 0:a -map 1:0 -c copy -disposition:v:0 attached_pic -id3v2_version 3 -metadata:s:v title=Album cover -metadata:s:v comment=Cover (front) out",
+		},
+		{
+			media:    "song.flac",
+			expected: "-i song.flac -i cover.jpg -map 0:a -map 1:0 -c copy -disposition:v:0 attached_pic out",
+		},
+		{
+			// The old cover is dropped, so the new one follows the single video stream
+			media:    "video.mp4",
+			probe:    probeWith("h264", "aac"),
+			expected: "-i video.mp4 -i cover.jpg -map 0:V? -map 0:a? -map 0:s? -map 1:0 -c copy -disposition:v:1 attached_pic out",
+		},
+		{
+			media:    "video.mkv",
+			expected: "-i video.mkv -map 0:V? -map 0:a? -map 0:s? -c copy -attach cover.jpg -metadata:s:t:0 mimetype=image/jpeg -metadata:s:t:0 filename=cover.jpg out",
+		},
+	}
+
+	for _, tt := range tests {
+		args, err := CoverArgs(tt.media, "cover.jpg", "out", tt.probe)
+		if err != nil {
+			t.Fatalf("CoverArgs(%s) = %v", tt.media, err)
+		}
+		if got := strings.Join(args, " "); got != tt.expected {
+			t.Errorf("CoverArgs(%s) =\n%s\nwant\n%s", tt.media, got, tt.expected)
+		}
+	}
+
+	if _, err := CoverArgs("song.opus", "cover.jpg", "out", nil); err == nil {
+		t.Error("expected an error for opus")
+	}
+}
@@ -0,0 +1,41 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Binary is the ffmpeg executable looked up on PATH
+var Binary = "ffmpeg"
+
+// Command builds the process for an ffmpeg run. It is a variable so tests can
+// substitute a fake process.
+var Command = func(ctx context.Context, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, Binary, args...)
+}
+
+// Run runs ffmpeg non-interactively with args. On failure the error carries
+// the last line ffmpeg wrote to stderr, which is where it explains itself.
+func Run(ctx context.Context, args []string) error {
+	_, err := runOutput(ctx, args)
+	return err
+}
+
+// runOutput is Run returning everything ffmpeg wrote to stderr, where
+// filters such as loudnorm print their reports
+func runOutput(ctx context.Context, args []string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := Command(ctx, append([]string{"-hide_banner", "-nostdin", "-y"}, args...)...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			lines := strings.Split(msg, "\n")
+			return stderr.String(), fmt.Errorf("ffmpeg: %w: %s", err, lines[len(lines)-1])
+		}
+		return stderr.String(), fmt.Errorf("ffmpeg: %w", err)
+	}
+	return stderr.String(), nil
+}
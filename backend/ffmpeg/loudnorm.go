@@ -0,0 +1,217 @@
+package ffmpeg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// LoudnessTarget is the level loudnorm normalizes to
+type LoudnessTarget struct {
+	Integrated float64 `json:"integrated"` // LUFS
+	TruePeak   float64 `json:"truePeak"`   // dBTP
+	LRA        float64 `json:"lra"`        // LU
+}
+
+// DefaultLoudnessTarget suits spoken word: -16 LUFS, the usual podcast level,
+// with 1.5 dB of headroom below full scale
+var DefaultLoudnessTarget = LoudnessTarget{Integrated: -16, TruePeak: -1.5, LRA: 11}
+
+// Ranges loudnorm accepts for the integrated loudness and true peak targets
+const (
+	MinIntegrated = -70.0
+	MaxIntegrated = -5.0
+	MinTruePeak   = -9.0
+	MaxTruePeak   = 0.0
+)
+
+// ErrSilent is returned for files whose loudness cannot be measured because
+// they contain no audible audio
+var ErrSilent = errors.New("audio is silent; loudness cannot be normalized")
+
+// Loudness is an EBU R128 measurement
+type Loudness struct {
+	Integrated float64 `json:"integrated"` // LUFS
+	TruePeak   float64 `json:"truePeak"`   // dBTP
+	LRA        float64 `json:"lra"`        // LU
+	Threshold  float64 `json:"threshold"`  // LUFS
+}
+
+// loudnormReport is the JSON block loudnorm prints with print_format=json.
+// Every value is a string.
+type loudnormReport struct {
+	InputI       string `json:"input_i"`
+	InputTP      string `json:"input_tp"`
+	InputLRA     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	OutputI      string `json:"output_i"`
+	OutputTP     string `json:"output_tp"`
+	OutputLRA    string `json:"output_lra"`
+	OutputThresh string `json:"output_thresh"`
+	TargetOffset string `json:"target_offset"`
+}
+
+// NormalizeLoudness writes input to output with its audio normalized to
+// target using loudnorm's two passes: the first measures each audio stream,
+// the second applies to each a linear gain computed from its own
+// measurement. Streams measuring as silent other than the first are copied
+// as they are. probe describes input and picks the audio encoders and sample
+// rates, since loudnorm resamples to 192 kHz. It returns the loudness of the
+// first audio stream before and after.
+func NormalizeLoudness(ctx context.Context, input, output string, target LoudnessTarget, probe *ProbeResult) (before, after Loudness, err error) {
+	streams := probe.AudioStreams()
+	if len(streams) == 0 {
+		return before, after, errors.New("file has no audio stream")
+	}
+
+	measured := make([]*loudnormReport, len(streams))
+	for n := range streams {
+		stderr, err := runOutput(ctx, measureArgs(input, n, target))
+		if err != nil {
+			return before, after, err
+		}
+		report, err := parseLoudnormReport(stderr)
+		if err != nil {
+			return before, after, err
+		}
+		loudness, err := report.input()
+		switch {
+		case n == 0 && err != nil:
+			return before, after, err
+		case n == 0:
+			before = loudness
+		case errors.Is(err, ErrSilent):
+			continue
+		case err != nil:
+			return before, after, err
+		}
+		measured[n] = report
+	}
+
+	stderr, err := runOutput(ctx, normalizeArgs(input, output, target, measured, streams))
+	if err != nil {
+		return before, after, err
+	}
+	// Each stream's loudnorm reports in stream order; the first is stream 0
+	applied, err := parseLoudnormReport(firstReport(stderr))
+	if err != nil {
+		return before, after, err
+	}
+	after, err = applied.output()
+	return before, after, err
+}
+
+// measureArgs runs loudnorm over audio stream n without writing output
+func measureArgs(input string, n int, target LoudnessTarget) []string {
+	return []string{"-i", input, "-map", fmt.Sprintf("0:a:%d", n), "-af", target.filter() + ":print_format=json", "-f", "null", "-"}
+}
+
+// normalizeArgs applies to each audio stream the gain measured for it,
+// re-encoding it, and copies the rest, including cover art and the streams
+// without a measurement
+func normalizeArgs(input, output string, target LoudnessTarget, measured []*loudnormReport, streams []Stream) []string {
+	args := []string{"-i", input, "-map", "0:v?", "-map", "0:a", "-map", "0:s?", "-c", "copy"}
+	for n, report := range measured {
+		if report == nil {
+			continue
+		}
+		filter := fmt.Sprintf("%s:measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true:print_format=json",
+			target.filter(), report.InputI, report.InputTP, report.InputLRA, report.InputThresh, report.TargetOffset)
+
+		sampleRate := streams[n].SampleRate
+		if sampleRate == "" || sampleRate == "0" {
+			sampleRate = "48000"
+		}
+		spec := fmt.Sprintf(":a:%d", n)
+		args = append(args, "-filter"+spec, filter)
+		args = append(args, loudnormEncoder(&streams[n], spec)...)
+		args = append(args, "-ar"+spec, sampleRate)
+	}
+	return append(args, output)
+}
+
+// loudnormEncoder re-encodes the output stream spec with the codec the audio
+// already uses, so the result fits the same container, at its bitrate if
+// known
+func loudnormEncoder(stream *Stream, spec string) []string {
+	bitrate := "192k"
+	if bps, err := strconv.Atoi(stream.BitRate); err == nil && bps > 0 {
+		bitrate = strconv.Itoa(bps/1000) + "k"
+	}
+	switch stream.CodecName {
+	case "flac", "alac", "pcm_s16le", "pcm_s24le", "pcm_f32le":
+		return []string{"-c" + spec, stream.CodecName}
+	case "mp3":
+		return []string{"-c" + spec, "libmp3lame", "-b" + spec, bitrate}
+	case "opus":
+		return []string{"-c" + spec, "libopus", "-b" + spec, bitrate}
+	case "vorbis":
+		return []string{"-c" + spec, "libvorbis", "-b" + spec, bitrate}
+	}
+	return []string{"-c" + spec, "aac", "-b" + spec, bitrate}
+}
+
+func (t LoudnessTarget) filter() string {
+	return fmt.Sprintf("loudnorm=I=%s:TP=%s:LRA=%s", formatFloat(t.Integrated), formatFloat(t.TruePeak), formatFloat(t.LRA))
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// firstReport cuts stderr after the first JSON block loudnorm printed
+func firstReport(stderr string) string {
+	start := strings.Index(stderr, `"input_i"`)
+	if start < 0 {
+		return stderr
+	}
+	if end := strings.Index(stderr[start:], "}"); end >= 0 {
+		return stderr[:start+end+1]
+	}
+	return stderr
+}
+
+// parseLoudnormReport finds the JSON block loudnorm prints last in stderr
+func parseLoudnormReport(stderr string) (*loudnormReport, error) {
+	start := strings.LastIndex(stderr, "{")
+	end := strings.LastIndex(stderr, "}")
+	if start < 0 || end < start {
+		return nil, errors.New("ffmpeg: no loudnorm report in output")
+	}
+	var report loudnormReport
+	if err := json.Unmarshal([]byte(stderr[start:end+1]), &report); err != nil {
+		return nil, fmt.Errorf("ffmpeg: parsing loudnorm report: %w", err)
+	}
+	return &report, nil
+}
+
+func (r *loudnormReport) input() (Loudness, error) {
+	return parseLoudness(r.InputI, r.InputTP, r.InputLRA, r.InputThresh)
+}
+
+func (r *loudnormReport) output() (Loudness, error) {
+	return parseLoudness(r.OutputI, r.OutputTP, r.OutputLRA, r.OutputThresh)
+}
+
+func parseLoudness(integrated, truePeak, lra, threshold string) (Loudness, error) {
+	var l Loudness
+	for _, field := range []struct {
+		value string
+		dest  *float64
+	}{{integrated, &l.Integrated}, {truePeak, &l.TruePeak}, {lra, &l.LRA}, {threshold, &l.Threshold}} {
+		v, err := strconv.ParseFloat(strings.TrimSpace(field.value), 64)
+		if err != nil {
+			return Loudness{}, fmt.Errorf("ffmpeg: invalid loudnorm value %q", field.value)
+		}
+		// Silence measures as -inf
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return Loudness{}, ErrSilent
+		}
+		*field.dest = v
+	}
+	return l, nil
+}
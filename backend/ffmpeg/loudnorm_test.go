@@ -0,0 +1,137 @@
+package ffmpeg
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const loudnormOutput = `Input #0, mp3, from 'in.mp3':
+  Duration: 00:01:35.50, start: 0.025057, bitrate: 192 kb/s
+[Parsed_loudnorm_0 @ 0x55d5c8a0] 
+{
+	"input_i" : "-27.61",
+	"input_tp" : "-4.47",
+	"input_lra" : "18.06",
+	"input_thresh" : "-39.20",
+	"output_i" : "-16.58",
+	"output_tp" : "-1.50",
+	"output_lra" : "14.78",
+	"output_thresh" : "-27.71",
+	"normalization_type" : "dynamic",
+	"target_offset" : "0.58"
+}
+`
+
+func TestParseLoudnormReport(t *testing.T) {
+	report, err := parseLoudnormReport(loudnormOutput)
+	if err != nil {
+		t.Fatalf("parseLoudnormReport() = %v", err)
+	}
+	input, err := report.input()
+	if err != nil {
+		t.Fatalf("input() = %v", err)
+	}
+	if want := (Loudness{Integrated: -27.61, TruePeak: -4.47, LRA: 18.06, Threshold: -39.2}); input != want {
+		t.Errorf("input() = %+v; want %+v", input, want)
+	}
+	output, _ := report.output()
+	if output.Integrated != -16.58 || output.TruePeak != -1.5 {
+		t.Errorf("unexpected output loudness %+v", output)
+	}
+	if report.TargetOffset != "0.58" {
+		t.Errorf("expected target offset 0.58, got %q", report.TargetOffset)
+	}
+}
+
+func TestParseLoudnormReportSilence(t *testing.T) {
+	silent := strings.Replace(loudnormOutput, `"input_i" : "-27.61"`, `"input_i" : "-inf"`, 1)
+	report, err := parseLoudnormReport(silent)
+	if err != nil {
+		t.Fatalf("parseLoudnormReport() = %v", err)
+	}
+	if _, err := report.input(); !errors.Is(err, ErrSilent) {
+		t.Errorf("expected ErrSilent, got %v", err)
+	}
+
+	if _, err := parseLoudnormReport("Conversion failed!"); err == nil {
+		t.Error("expected an error without a report")
+	}
+}
+
+func TestNormalizeArgs(t *testing.T) {
+	report, _ := parseLoudnormReport(loudnormOutput)
+	tests := []struct {
+		name     string
+		stream   Stream
+		expected string
+	}{
+		{
+			name:     "mp3 keeps bitrate and sample rate",
+			stream:   Stream{CodecName: "mp3", BitRate: "192000", SampleRate: "44100"},
+			expected: "-c:a:0 libmp3lame -b:a:0 192k -ar:a:0 44100 out.mp3",
+		},
+		{
+			name:     "flac stays lossless",
+			stream:   Stream{CodecName: "flac", SampleRate: "96000"},
+			expected: "-c:a:0 flac -ar:a:0 96000 out.mp3",
+		},
+		{
+			name:     "unknown rate",
+			stream:   Stream{CodecName: "aac"},
+			expected: "-c:a:0 aac -b:a:0 192k -ar:a:0 48000 out.mp3",
+		},
+	}
+
+	target := LoudnessTarget{Integrated: -16, TruePeak: -1.5, LRA: 11}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.Join(normalizeArgs("in.mp3", "out.mp3", target, []*loudnormReport{report}, []Stream{tt.stream}), " ")
+			prefix := "-i in.mp3 -map 0:v? -map 0:a -map 0:s? -c copy -filter:a:0 loudnorm=I=-16:TP=-1.5:LRA=11:measured_I=-27.61:measured_TP=-4.47:measured_LRA=18.06:measured_thresh=-39.20:offset=0.58:linear=true:print_format=json "
+			if got != prefix+tt.expected {
+				t.Errorf("normalizeArgs() =\n%s\nwant\n%s", got, prefix+tt.expected)
+			}
+		})
+	}
+}
+
+func TestNormalizeArgsPerStream(t *testing.T) {
+	first, _ := parseLoudnormReport(loudnormOutput)
+	second, _ := parseLoudnormReport(strings.NewReplacer(`"-27.61"`, `"-31.20"`, `"0.58"`, `"-0.40"`).Replace(loudnormOutput))
+	streams := []Stream{{CodecName: "aac", BitRate: "128000"}, {CodecName: "opus", SampleRate: "48000"}, {CodecName: "aac"}}
+
+	// The third stream is silent and copied as it is
+	got := strings.Join(normalizeArgs("in.mkv", "out.mkv", DefaultLoudnessTarget, []*loudnormReport{first, second, nil}, streams), " ")
+	for _, want := range []string{
+		"-filter:a:0 loudnorm=I=-16:TP=-1.5:LRA=11:measured_I=-27.61:",
+		"offset=0.58:linear=true:print_format=json -c:a:0 aac -b:a:0 128k -ar:a:0 48000 ",
+		"-filter:a:1 loudnorm=I=-16:TP=-1.5:LRA=11:measured_I=-31.20:",
+		"offset=-0.40:linear=true:print_format=json -c:a:1 libopus -b:a:1 192k -ar:a:1 48000 out.mkv",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("normalizeArgs() = %s; want it to contain %s", got, want)
+		}
+	}
+	if strings.Contains(got, ":a:2") {
+		t.Errorf("expected the silent stream copied, got %s", got)
+	}
+}
+
+func TestFirstReport(t *testing.T) {
+	second := strings.NewReplacer("Parsed_loudnorm_0", "Parsed_loudnorm_1", `"-16.58"`, `"-20.00"`).Replace(loudnormOutput)
+	report, err := parseLoudnormReport(firstReport(loudnormOutput + second))
+	if err != nil {
+		t.Fatalf("parseLoudnormReport() = %v", err)
+	}
+	if output, _ := report.output(); output.Integrated != -16.58 {
+		t.Errorf("expected the first stream's report, got %+v", output)
+	}
+}
+
+func TestMeasureArgs(t *testing.T) {
+	got := strings.Join(measureArgs("in.mkv", 1, LoudnessTarget{Integrated: -23, TruePeak: -2, LRA: 7.5}), " ")
+	want := "-i in.mkv -map 0:a:1 -af loudnorm=I=-23:TP=-2:LRA=7.5:print_format=json -f null -"
+	if got != want {
+		t.Errorf("measureArgs() = %s; want %s", got, want)
+	}
+}
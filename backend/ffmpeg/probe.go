@@ -0,0 +1,125 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProbeBinary is the ffprobe executable looked up on PATH
+var ProbeBinary = "ffprobe"
+
+// ProbeCommand builds the process for an ffprobe run. It is a variable so
+// tests can substitute a fake process.
+var ProbeCommand = func(ctx context.Context, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, ProbeBinary, args...)
+}
+
+// Stream is one stream of a probed file
+type Stream struct {
+	Index      int    `json:"index"`
+	CodecType  string `json:"codec_type"` // "video", "audio", "subtitle", "data", "attachment"
+	CodecName  string `json:"codec_name"`
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
+	Channels   int    `json:"channels,omitempty"`
+	SampleRate string `json:"sample_rate,omitempty"`
+	BitRate    string `json:"bit_rate,omitempty"`
+
+	// Cover art is stored as a single-frame video stream
+	Disposition struct {
+		AttachedPic int `json:"attached_pic"`
+	} `json:"disposition"`
+}
+
+// ProbeResult is what ffprobe reports about a file
+type ProbeResult struct {
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+		Size       string `json:"size"`
+	} `json:"format"`
+	Streams []Stream `json:"streams"`
+}
+
+// Probe runs ffprobe on path
+func Probe(ctx context.Context, path string) (*ProbeResult, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := ProbeCommand(ctx, "-v", "error", "-print_format", "json", "-show_format", "-show_streams", path)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("ffprobe: %w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("ffprobe: %w", err)
+	}
+
+	var result ProbeResult
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		return nil, fmt.Errorf("ffprobe: parsing output: %w", err)
+	}
+	return &result, nil
+}
+
+// Duration returns the container duration, or zero if unknown
+func (p *ProbeResult) Duration() time.Duration {
+	seconds, err := strconv.ParseFloat(p.Format.Duration, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// Codecs returns the codec of the first video and audio stream, skipping
+// cover art. Either is "" when the file has no such stream.
+func (p *ProbeResult) Codecs() (video, audio string) {
+	for _, s := range p.Streams {
+		switch {
+		case s.CodecType == "video" && s.Disposition.AttachedPic == 0 && video == "":
+			video = s.CodecName
+		case s.CodecType == "audio" && audio == "":
+			audio = s.CodecName
+		}
+	}
+	return video, audio
+}
+
+// AudioStream returns the first audio stream, or nil if there is none
+func (p *ProbeResult) AudioStream() *Stream {
+	for i := range p.Streams {
+		if p.Streams[i].CodecType == "audio" {
+			return &p.Streams[i]
+		}
+	}
+	return nil
+}
+
+// AudioStreams returns every audio stream, in the order ffmpeg numbers them
+// with the a:N stream specifier
+func (p *ProbeResult) AudioStreams() []Stream {
+	var streams []Stream
+	for _, s := range p.Streams {
+		if s.CodecType == "audio" {
+			streams = append(streams, s)
+		}
+	}
+	return streams
+}
+
+// VideoStream returns the first video stream that is not cover art, or nil if
+// there is none
+func (p *ProbeResult) VideoStream() *Stream {
+	for i := range p.Streams {
+		if p.Streams[i].CodecType == "video" && p.Streams[i].Disposition.AttachedPic == 0 {
+			return &p.Streams[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Progress is one report from ffmpeg's -progress output
+type Progress struct {
+	OutTime time.Duration // position reached in the output
+	Speed   string        // e.g. "2.5x"
+	Percent float64       // of the input duration, or -1 if unknown
+	Done    bool
+}
+
+// RunWithProgress runs ffmpeg like Run, calling onProgress for each report
+// ffmpeg writes with -progress. duration is the input length used to compute
+// Percent; pass zero if unknown.
+func RunWithProgress(ctx context.Context, args []string, duration time.Duration, onProgress func(Progress)) error {
+	var stderr bytes.Buffer
+	full := append([]string{"-hide_banner", "-nostdin", "-y", "-progress", "pipe:1", "-nostats"}, args...)
+	cmd := Command(ctx, full...)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("ffmpeg: %w", err)
+	}
+
+	readProgress(stdout, duration, onProgress)
+
+	if err := cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			lines := strings.Split(msg, "\n")
+			return fmt.Errorf("ffmpeg: %w: %s", err, lines[len(lines)-1])
+		}
+		return fmt.Errorf("ffmpeg: %w", err)
+	}
+	return nil
+}
+
+// readProgress parses -progress output: blocks of key=value lines, each
+// ended by "progress=continue" or, for the last one, "progress=end"
+func readProgress(r io.Reader, duration time.Duration, onProgress func(Progress)) {
+	scanner := bufio.NewScanner(r)
+	current := Progress{Percent: -1}
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "out_time_us", "out_time_ms":
+			// Despite its name out_time_ms is in microseconds as well
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+				current.OutTime = time.Duration(us) * time.Microsecond
+			}
+		case "speed":
+			current.Speed = strings.TrimSpace(value)
+		case "progress":
+			current.Done = value == "end"
+			if duration > 0 {
+				current.Percent = min(100, float64(current.OutTime)/float64(duration)*100)
+			}
+			if current.Done && duration > 0 {
+				current.Percent = 100
+			}
+			if onProgress != nil {
+				onProgress(current)
+			}
+			current = Progress{Percent: -1, OutTime: current.OutTime}
+		}
+	}
+}
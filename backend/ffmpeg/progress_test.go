@@ -0,0 +1,39 @@
+package ffmpeg
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadProgress(t *testing.T) {
+	output := "frame=10\nout_time_us=2500000\nspeed=1.5x\nprogress=continue\n" +
+		"out_time_ms=5000000\nspeed= 2x\nprogress=continue\n" +
+		"out_time_us=N/A\nprogress=end\n"
+
+	var reports []Progress
+	readProgress(strings.NewReader(output), 10*time.Second, func(p Progress) { reports = append(reports, p) })
+
+	expected := []Progress{
+		{OutTime: 2500 * time.Millisecond, Speed: "1.5x", Percent: 25},
+		{OutTime: 5 * time.Second, Speed: "2x", Percent: 50},
+		{OutTime: 5 * time.Second, Percent: 100, Done: true},
+	}
+	if len(reports) != len(expected) {
+		t.Fatalf("expected %d reports, got %+v", len(expected), reports)
+	}
+	for i := range expected {
+		if reports[i] != expected[i] {
+			t.Errorf("report %d = %+v; want %+v", i, reports[i], expected[i])
+		}
+	}
+}
+
+func TestReadProgressUnknownDuration(t *testing.T) {
+	var reports []Progress
+	readProgress(strings.NewReader("out_time_us=1000000\nprogress=end\n"), 0, func(p Progress) { reports = append(reports, p) })
+
+	if len(reports) != 1 || reports[0].Percent != -1 || !reports[0].Done {
+		t.Errorf("expected one finished report without a percentage, got %+v", reports)
+	}
+}
@@ -0,0 +1,166 @@
+package ffmpeg
+
+import (
+	"slices"
+	"strconv"
+)
+
+// Container is an output container and the codecs, by ffprobe name, it can
+// carry without re-encoding. Nil codec lists accept anything.
+type Container struct {
+	Name        string   `json:"name"`
+	VideoCodecs []string `json:"-"`
+	AudioCodecs []string `json:"-"`
+}
+
+// Containers are the video containers the transcoding stage produces
+var Containers = []Container{
+	{Name: "mp4", VideoCodecs: []string{"h264", "hevc", "av1", "mpeg4"}, AudioCodecs: []string{"aac", "mp3", "alac"}},
+	{Name: "mov", VideoCodecs: []string{"h264", "hevc", "mpeg4", "prores"}, AudioCodecs: []string{"aac", "mp3", "alac", "pcm_s16le"}},
+	{Name: "mkv"},
+	{Name: "webm", VideoCodecs: []string{"vp8", "vp9", "av1"}, AudioCodecs: []string{"opus", "vorbis"}},
+	{Name: "avi", VideoCodecs: []string{"mpeg4", "h264", "mjpeg"}, AudioCodecs: []string{"mp3", "ac3", "pcm_s16le"}},
+	{Name: "flv", VideoCodecs: []string{"h264"}, AudioCodecs: []string{"aac", "mp3"}},
+	{Name: "3gp", VideoCodecs: []string{"h264", "h263", "mpeg4"}, AudioCodecs: []string{"aac", "amr_nb"}},
+}
+
+// FindContainer returns the container named name
+func FindContainer(name string) (Container, bool) {
+	for _, c := range Containers {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Container{}, false
+}
+
+// Accepts reports whether the container can hold codec of the given stream type
+func (c Container) Accepts(streamType, codec string) bool {
+	if codec == "" {
+		return true
+	}
+	list := c.VideoCodecs
+	if streamType == "audio" {
+		list = c.AudioCodecs
+	}
+	return list == nil || slices.Contains(list, codec)
+}
+
+// Preset is a named set of encoder settings
+type Preset struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Preset names
+const (
+	PresetCompatible = "compatible"
+	PresetSmall      = "small"
+	PresetArchive    = "archive"
+)
+
+// Presets are the accepted preset values
+var Presets = []Preset{
+	{PresetCompatible, "H.264/AAC (VP9/Opus for WebM, MPEG-4/MP3 for AVI) that plays almost everywhere"},
+	{PresetSmall, "Same codecs at lower quality for a smaller file"},
+	{PresetArchive, "Same codecs at high quality, lossless FLAC audio in MKV; large files"},
+}
+
+// encoderSettings are the quality knobs of one preset
+type encoderSettings struct {
+	x264Preset  string
+	x264CRF     int
+	vp9CRF      int
+	mpeg4Q      int
+	aacBitrate  string
+	opusBitrate string
+	mp3Bitrate  string
+}
+
+var presetSettings = map[string]encoderSettings{
+	PresetCompatible: {"medium", 23, 32, 4, "160k", "128k", "192k"},
+	PresetSmall:      {"slow", 28, 40, 8, "96k", "64k", "128k"},
+	PresetArchive:    {"slow", 18, 24, 2, "256k", "192k", "320k"},
+}
+
+// TranscodeNeeded reports whether probe, a file already in container, has
+// to be converted. A preset always re-encodes.
+func TranscodeNeeded(probe *ProbeResult, container Container, preset string) bool {
+	if preset != "" {
+		return true
+	}
+	video, audio := probe.Codecs()
+	return !container.Accepts("video", video) || !container.Accepts("audio", audio)
+}
+
+// TranscodeArgs returns the ffmpeg arguments converting input to output in
+// container. With a preset every stream is re-encoded with its settings.
+// Without one, streams the container accepts are copied and the rest are
+// re-encoded with the compatible preset.
+func TranscodeArgs(input, output string, container Container, preset string, probe *ProbeResult) []string {
+	video, audio := "", ""
+	if probe != nil {
+		video, audio = probe.Codecs()
+	}
+	copyVideo := preset == "" && video != "" && container.Accepts("video", video)
+	copyAudio := preset == "" && audio != "" && container.Accepts("audio", audio)
+	if preset == "" {
+		preset = PresetCompatible
+	}
+	settings := presetSettings[preset]
+
+	// 0:V skips cover art, which most of these containers cannot hold
+	args := []string{"-i", input, "-map", "0:V?", "-map", "0:a?"}
+
+	if copyVideo {
+		args = append(args, "-c:v", "copy")
+	} else {
+		args = append(args, videoEncoder(container.Name, settings)...)
+	}
+	if copyAudio {
+		args = append(args, "-c:a", "copy")
+	} else {
+		args = append(args, audioEncoder(container.Name, preset, settings)...)
+	}
+
+	switch container.Name {
+	case "mkv":
+		args = append(args, "-map", "0:s?", "-c:s", "copy")
+	case "mp4", "mov":
+		args = append(args, "-map", "0:s?", "-c:s", "mov_text", "-movflags", "+faststart")
+	case "webm":
+		args = append(args, "-map", "0:s?", "-c:s", "webvtt")
+	}
+	return append(args, output)
+}
+
+func videoEncoder(container string, s encoderSettings) []string {
+	switch container {
+	case "webm":
+		return []string{"-c:v", "libvpx-vp9", "-crf", strconv.Itoa(s.vp9CRF), "-b:v", "0", "-row-mt", "1"}
+	case "avi":
+		return []string{"-c:v", "mpeg4", "-q:v", strconv.Itoa(s.mpeg4Q), "-tag:v", "XVID"}
+	}
+	args := []string{"-c:v", "libx264", "-preset", s.x264Preset, "-crf", strconv.Itoa(s.x264CRF), "-pix_fmt", "yuv420p"}
+	if container == "3gp" {
+		// Phones that want 3GP only decode baseline profile
+		args = append(args, "-profile:v", "baseline", "-level", "3.0")
+	}
+	return args
+}
+
+func audioEncoder(container, preset string, s encoderSettings) []string {
+	switch container {
+	case "webm":
+		return []string{"-c:a", "libopus", "-b:a", s.opusBitrate}
+	case "avi":
+		return []string{"-c:a", "libmp3lame", "-b:a", s.mp3Bitrate}
+	case "flv":
+		return []string{"-c:a", "aac", "-b:a", s.aacBitrate, "-ar", "44100"}
+	case "mkv":
+		if preset == PresetArchive {
+			return []string{"-c:a", "flac"}
+		}
+	}
+	return []string{"-c:a", "aac", "-b:a", s.aacBitrate}
+}
@@ -0,0 +1,94 @@
+package ffmpeg
+
+import (
+	"strings"
+	"testing"
+)
+
+func probeWith(video, audio string) *ProbeResult {
+	p := &ProbeResult{}
+	cover := Stream{CodecType: "video", CodecName: "mjpeg"}
+	cover.Disposition.AttachedPic = 1
+	p.Streams = []Stream{cover, {CodecType: "video", CodecName: video}, {CodecType: "audio", CodecName: audio}}
+	return p
+}
+
+func TestProbeCodecsSkipsCoverArt(t *testing.T) {
+	video, audio := probeWith("vp9", "opus").Codecs()
+	if video != "vp9" || audio != "opus" {
+		t.Errorf("Codecs() = %q, %q; want vp9, opus", video, audio)
+	}
+}
+
+func TestTranscodeNeeded(t *testing.T) {
+	mp4, _ := FindContainer("mp4")
+	mkv, _ := FindContainer("mkv")
+	tests := []struct {
+		name      string
+		probe     *ProbeResult
+		container Container
+		preset    string
+		expected  bool
+	}{
+		{"compatible", probeWith("h264", "aac"), mp4, "", false},
+		{"incompatible audio", probeWith("h264", "opus"), mp4, "", true},
+		{"mkv takes anything", probeWith("vp9", "opus"), mkv, "", false},
+		{"preset re-encodes", probeWith("h264", "aac"), mp4, PresetSmall, true},
+	}
+
+	for _, tt := range tests {
+		if got := TranscodeNeeded(tt.probe, tt.container, tt.preset); got != tt.expected {
+			t.Errorf("%s: TranscodeNeeded() = %v; want %v", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestTranscodeArgs(t *testing.T) {
+	tests := []struct {
+		container string
+		preset    string
+		probe     *ProbeResult
+		expected  string
+	}{
+		{
+			container: "mp4",
+			probe:     probeWith("h264", "opus"),
+			expected:  "-i in.mkv -map 0:V? -map 0:a? -c:v copy -c:a aac -b:a 160k -map 0:s? -c:s mov_text -movflags +faststart out.mp4",
+		},
+		{
+			container: "avi",
+			probe:     probeWith("vp9", "opus"),
+			expected:  "-i in.mkv -map 0:V? -map 0:a? -c:v mpeg4 -q:v 4 -tag:v XVID -c:a libmp3lame -b:a 192k out.avi",
+		},
+		{
+			container: "webm",
+			preset:    PresetSmall,
+			probe:     probeWith("vp9", "opus"),
+			expected:  "-i in.mkv -map 0:V? -map 0:a? -c:v libvpx-vp9 -crf 40 -b:v 0 -row-mt 1 -c:a libopus -b:a 64k -map 0:s? -c:s webvtt out.webm",
+		},
+		{
+			container: "3gp",
+			probe:     probeWith("vp9", "opus"),
+			expected:  "-i in.mkv -map 0:V? -map 0:a? -c:v libx264 -preset medium -crf 23 -pix_fmt yuv420p -profile:v baseline -level 3.0 -c:a aac -b:a 160k out.3gp",
+		},
+		{
+			container: "mkv",
+			preset:    PresetArchive,
+			probe:     probeWith("h264", "aac"),
+			expected:  "-i in.mkv -map 0:V? -map 0:a? -c:v libx264 -preset slow -crf 18 -pix_fmt yuv420p -c:a flac -map 0:s? -c:s copy out.mkv",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.container+"/"+tt.preset, func(t *testing.T) {
+			container, ok := FindContainer(tt.container)
+			if !ok {
+				t.Fatalf("unknown container %s", tt.container)
+			}
+			got := strings.Join(TranscodeArgs("in.mkv", "out."+tt.container, container, tt.preset, tt.probe), " ")
+			if got != tt.expected {
+				t.Errorf("TranscodeArgs() =\n%s\nwant\n%s", got, tt.expected)
+			}
+		})
+	}
+}
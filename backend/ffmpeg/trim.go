@@ -0,0 +1,57 @@
+package ffmpeg
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Trim writes the part of input between start and end to output. end of zero
+// means until the end of input. A fast trim copies the streams and can only
+// cut at keyframes; an accurate trim decodes from the start of input and
+// re-encodes with the default codecs for output's container.
+func Trim(ctx context.Context, input, output string, start, end time.Duration, accurate bool) error {
+	return Run(ctx, trimArgs(input, output, start, end, accurate, nil))
+}
+
+// TrimTagged is Trim for extracting one part of a longer file, such as a
+// chapter: the source's chapter list is dropped and tags overwrite its
+// metadata (title, track, album and so on).
+func TrimTagged(ctx context.Context, input, output string, start, end time.Duration, accurate bool, tags map[string]string) error {
+	return Run(ctx, trimArgs(input, output, start, end, accurate, tags))
+}
+
+func trimArgs(input, output string, start, end time.Duration, accurate bool, tags map[string]string) []string {
+	var args []string
+	if accurate {
+		args = []string{"-i", input, "-ss", seconds(start)}
+	} else {
+		// Seeking before -i jumps to the keyframe preceding start
+		args = []string{"-ss", seconds(start), "-i", input}
+	}
+	if end > start {
+		args = append(args, "-t", seconds(end-start))
+	}
+
+	args = append(args, "-map", "0:v?", "-map", "0:a?")
+	if tags != nil {
+		args = append(args, "-map_chapters", "-1")
+		keys := make([]string, 0, len(tags))
+		for key := range tags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			args = append(args, "-metadata", key+"="+tags[key])
+		}
+	}
+	if accurate {
+		return append(args, output)
+	}
+	return append(args, "-map", "0:s?", "-c", "copy", "-avoid_negative_ts", "make_zero", output)
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
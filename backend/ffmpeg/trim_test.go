@@ -0,0 +1,53 @@
+package ffmpeg
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrimArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		start    time.Duration
+		end      time.Duration
+		accurate bool
+		tags     map[string]string
+		expected string
+	}{
+		{
+			name:     "fast",
+			start:    90 * time.Second,
+			end:      120 * time.Second,
+			expected: "-ss 90 -i in.mp4 -t 30 -map 0:v? -map 0:a? -map 0:s? -c copy -avoid_negative_ts make_zero out.mp4",
+		},
+		{
+			name:     "accurate",
+			start:    1500 * time.Millisecond,
+			end:      3 * time.Second,
+			accurate: true,
+			expected: "-i in.mp4 -ss 1.5 -t 1.5 -map 0:v? -map 0:a? out.mp4",
+		},
+		{
+			name:     "tagged",
+			start:    30 * time.Second,
+			end:      95 * time.Second,
+			tags:     map[string]string{"title": "Main", "track": "2/3"},
+			expected: "-ss 30 -i in.mp4 -t 65 -map 0:v? -map 0:a? -map_chapters -1 -metadata title=Main -metadata track=2/3 -map 0:s? -c copy -avoid_negative_ts make_zero out.mp4",
+		},
+		{
+			name:     "open end",
+			start:    time.Minute,
+			expected: "-ss 60 -i in.mp4 -map 0:v? -map 0:a? -map 0:s? -c copy -avoid_negative_ts make_zero out.mp4",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := strings.Join(trimArgs("in.mp4", "out.mp4", tt.start, tt.end, tt.accurate, tt.tags), " ")
+			if args != tt.expected {
+				t.Errorf("trimArgs() =\n%s\nwant\n%s", args, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"downloader/archive"
+	"downloader/jobs"
+	"downloader/logging"
+	"downloader/metrics"
+	"downloader/utils"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ArchiveRequest is the body of POST /files/archive. Files, folders and the
+// files of a job, a batch or a subscription are combined; each file is added
+// once.
+type ArchiveRequest struct {
+	Files          []string `json:"files"`
+	Folders        []string `json:"folders"`
+	JobID          string   `json:"jobId"`
+	BatchID        string   `json:"batchId"`
+	SubscriptionID string   `json:"subscriptionId"` // its jobs still in the job history
+	Format         string   `json:"format"`         // zip (default) or tar
+	Name           string   `json:"name"`           // file name of the archive, without extension
+}
+
+// CreateArchive streams the requested files as one ZIP or tar archive. Files
+// keep their path below the download folder. Content-Length is set when the
+// size is known before writing; otherwise X-Estimated-Size estimates it.
+// The archive stops when the client disconnects.
+func CreateArchive(c *gin.Context) {
+	var req ArchiveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.Format == "" {
+		req.Format = archive.Formats[0]
+	}
+	if !slices.Contains(archive.Formats, req.Format) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid archive format; use one of %v", archive.Formats)})
+		return
+	}
+	if len(req.Files) == 0 && len(req.Folders) == 0 && req.JobID == "" && req.BatchID == "" && req.SubscriptionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "files, folders, jobId, batchId or subscriptionId is required"})
+		return
+	}
+
+	logger := logging.FromContext(c.Request.Context())
+
+	folder := utils.GetDownloadFolder()
+	root, err := os.OpenRoot(folder)
+	if err != nil {
+		logger.Error("failed to open download folder", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create archive"})
+		return
+	}
+	defer root.Close()
+
+	names, status, msg := archiveNames(folder, req)
+	if status != http.StatusOK {
+		c.JSON(status, gin.H{"error": msg})
+		return
+	}
+	entries := make([]archive.Entry, 0, len(names))
+	for _, name := range names {
+		entry, err := archive.NewEntry(root, name)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found: " + name})
+			return
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No files to archive"})
+		return
+	}
+
+	size, exact, err := archive.Size(req.Format, entries)
+	if err != nil {
+		logger.Error("failed to size archive", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create archive"})
+		return
+	}
+
+	filename := archiveName(req) + "." + req.Format
+	logger = logger.With("archive", filename, "files", len(entries))
+	c.Header("Content-Type", archive.ContentType(req.Format))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	c.Header("X-Estimated-Size", strconv.FormatInt(size, 10))
+	if exact {
+		c.Header("Content-Length", strconv.FormatInt(size, 10))
+	}
+	c.Status(http.StatusOK)
+
+	w := &countingWriter{w: c.Writer}
+	err = archive.Write(c.Request.Context(), w, root, req.Format, entries)
+	metrics.BytesServed.Add(float64(w.n))
+	switch {
+	case err != nil && c.Request.Context().Err() != nil:
+		logger.Info("archive cancelled by client", "bytes", w.n)
+		metrics.ArchivesServed.WithLabelValues(req.Format, "cancelled").Inc()
+	case err != nil:
+		// The status is sent already; the client sees a truncated archive
+		logger.Error("failed to write archive", "bytes", w.n, "error", err)
+		metrics.ArchivesServed.WithLabelValues(req.Format, "failed").Inc()
+	default:
+		logger.Info("archive served", "bytes", w.n)
+		metrics.ArchivesServed.WithLabelValues(req.Format, "completed").Inc()
+	}
+}
+
+// archiveNames returns the file paths an archive request selects, in
+// request order without repeats. status is http.StatusOK or the error status
+// to respond with, described by msg.
+func archiveNames(folder string, req ArchiveRequest) (names []string, status int, msg string) {
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	addJobFiles := func(files []string) {
+		for _, file := range files {
+			// Files removed since the job finished are left out
+			if _, err := utils.ResolvePath(folder, file); err == nil {
+				add(file)
+			}
+		}
+	}
+
+	for _, file := range req.Files {
+		name, err := utils.CleanFilePath(file)
+		if err == nil {
+			_, err = utils.ResolvePath(folder, name)
+		}
+		if errors.Is(err, utils.ErrInvalidPath) {
+			return nil, http.StatusBadRequest, "Invalid file path: " + file
+		}
+		add(name)
+	}
+
+	for _, dir := range req.Folders {
+		name, err := utils.CleanFolderPath(dir)
+		if err == nil && name != "" {
+			_, err = utils.ResolvePath(folder, name)
+		}
+		if errors.Is(err, utils.ErrInvalidPath) {
+			return nil, http.StatusBadRequest, "Invalid folder path: " + dir
+		}
+		if err != nil {
+			return nil, http.StatusNotFound, "Folder not found: " + dir
+		}
+		// Walking does not follow links, so it stays inside the folder
+		below, err := utils.ListFilesRecursive(filepath.Join(folder, filepath.FromSlash(name)))
+		if err != nil {
+			return nil, http.StatusNotFound, "Folder not found: " + dir
+		}
+		for _, file := range below {
+			add(path.Join(name, file))
+		}
+	}
+
+	if req.JobID != "" {
+		job, ok := jobs.Default.Get(req.JobID)
+		if !ok {
+			return nil, http.StatusNotFound, "Job not found"
+		}
+		addJobFiles(job.Files)
+	}
+
+	if req.BatchID != "" {
+		batch, ok := jobs.Default.BatchStatus(req.BatchID)
+		if !ok {
+			return nil, http.StatusNotFound, "Batch not found"
+		}
+		for _, result := range batch.Results {
+			addJobFiles(result.Files)
+		}
+	}
+
+	if req.SubscriptionID != "" {
+		found := false
+		list := jobs.Default.List()
+		// Oldest first, like the entries of a batch
+		for i := len(list) - 1; i >= 0; i-- {
+			if list[i].SubscriptionID == req.SubscriptionID {
+				found = true
+				addJobFiles(list[i].Files)
+			}
+		}
+		if !found {
+			return nil, http.StatusNotFound, "No jobs found for subscription"
+		}
+	}
+	return names, http.StatusOK, ""
+}
+
+// archiveName returns the file name of the archive without extension: the
+// requested one, the name of the only folder requested or "downloads"
+func archiveName(req ArchiveRequest) string {
+	switch {
+	case req.Name != "":
+		return utils.SanitizeFilename(req.Name)
+	case len(req.Folders) == 1 && len(req.Files) == 0 && req.JobID == "" && req.BatchID == "" && req.SubscriptionID == "":
+		if name, err := utils.CleanFolderPath(req.Folders[0]); err == nil && name != "" {
+			return path.Base(name)
+		}
+	}
+	return "downloads"
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
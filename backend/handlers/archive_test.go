@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"downloader/jobs"
+
+	"github.com/gin-gonic/gin"
+)
+
+func postArchive(router *gin.Engine, ctx context.Context, req ArchiveRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(req)
+	httpReq, _ := http.NewRequestWithContext(ctx, http.MethodPost, "/files/archive", bytes.NewReader(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httpReq)
+	return rec
+}
+
+func setupArchiveRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/files/archive", CreateArchive)
+	return router
+}
+
+func TestCreateArchive(t *testing.T) {
+	setupFilesFolder(t)
+	router := setupArchiveRouter()
+
+	rec := postArchive(router, context.Background(), ArchiveRequest{Folders: []string{"Channel"}, Files: []string{"Top.mp3", "Channel/2024/Talk.mp4"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if disposition := rec.Header().Get("Content-Disposition"); disposition != `attachment; filename="downloads.zip"` {
+		t.Errorf("unexpected Content-Disposition %q", disposition)
+	}
+	// notes.txt is deflated, so only an estimate is known
+	if rec.Header().Get("Content-Length") != "" || rec.Header().Get("X-Estimated-Size") == "" {
+		t.Errorf("expected only an estimated size, got headers %v", rec.Header())
+	}
+	reader, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatalf("invalid ZIP archive: %v", err)
+	}
+	var names []string
+	for _, file := range reader.File {
+		names = append(names, file.Name)
+	}
+	// Files in request order, then folders, each once
+	if !slices.Equal(names, []string{"Top.mp3", "Channel/2024/Talk.mp4", "Channel/notes.txt"}) {
+		t.Errorf("unexpected entries %v", names)
+	}
+
+	rec = postArchive(router, context.Background(), ArchiveRequest{Folders: []string{"Channel/2024"}, Format: "tar"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if disposition := rec.Header().Get("Content-Disposition"); disposition != `attachment; filename="2024.tar"` {
+		t.Errorf("unexpected Content-Disposition %q", disposition)
+	}
+	if length := rec.Header().Get("Content-Length"); length != strconv.Itoa(rec.Body.Len()) {
+		t.Errorf("Content-Length %s; wrote %d bytes", length, rec.Body.Len())
+	}
+	header, err := tar.NewReader(rec.Body).Next()
+	if err != nil || header.Name != "Channel/2024/Talk.mp4" {
+		t.Errorf("unexpected tar entry %v, %v", header, err)
+	}
+}
+
+func TestCreateArchive_JobFiles(t *testing.T) {
+	folder, _ := setupFilesFolder(t)
+	router := setupArchiveRouter()
+
+	// A manager recovering finished jobs of a batch and of a subscription
+	dir := t.TempDir()
+	store, err := jobs.OpenFileStore(filepath.Join(dir, "jobs.json"))
+	if err != nil {
+		t.Fatalf("OpenFileStore() = %v", err)
+	}
+	store.Save([]jobs.Job{
+		{ID: "job-1", BatchID: "batch-1", Status: jobs.StatusCompleted, Files: []string{"Top.mp3"}},
+		{ID: "job-2", BatchID: "batch-1", Status: jobs.StatusCompleted, Files: []string{"Channel/2024/Talk.mp4", "Deleted.mp4"}},
+		{ID: "job-3", SubscriptionID: "sub-1", Status: jobs.StatusCompleted, Files: []string{"Channel/notes.txt"}},
+	})
+	batches, _ := json.Marshal(map[string]jobs.Batch{
+		"batch-1": {ID: "batch-1", Entries: []jobs.BatchEntry{{URL: "https://example.com/1", JobID: "job-1"}, {URL: "https://example.com/2", JobID: "job-2"}}},
+	})
+	os.WriteFile(filepath.Join(dir, "batches.json"), batches, 0o644)
+
+	original := jobs.Default
+	jobs.Default = jobs.NewManager(1)
+	t.Cleanup(func() { jobs.Default = original })
+	jobs.Default.Folder = func() string { return folder }
+	jobs.Default.UseStore(store)
+	if _, err := jobs.Default.Recover(2, time.Hour); err != nil {
+		t.Fatalf("Recover() = %v", err)
+	}
+	if err := jobs.Default.Batches.Open(filepath.Join(dir, "batches.json")); err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		req    ArchiveRequest
+		status int
+		files  []string
+	}{
+		{"batch", ArchiveRequest{BatchID: "batch-1"}, http.StatusOK, []string{"Top.mp3", "Channel/2024/Talk.mp4"}},
+		{"subscription", ArchiveRequest{SubscriptionID: "sub-1"}, http.StatusOK, []string{"Channel/notes.txt"}},
+		{"batch and job", ArchiveRequest{BatchID: "batch-1", JobID: "job-3"}, http.StatusOK, []string{"Channel/notes.txt", "Top.mp3", "Channel/2024/Talk.mp4"}},
+		{"unknown batch", ArchiveRequest{BatchID: "missing"}, http.StatusNotFound, nil},
+		{"unknown subscription", ArchiveRequest{SubscriptionID: "missing"}, http.StatusNotFound, nil},
+	}
+
+	for _, test := range tests {
+		rec := postArchive(router, context.Background(), test.req)
+		if rec.Code != test.status {
+			t.Errorf("%s: expected %d, got %d: %s", test.name, test.status, rec.Code, rec.Body.String())
+			continue
+		}
+		if rec.Code != http.StatusOK {
+			continue
+		}
+		reader, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+		if err != nil {
+			t.Errorf("%s: invalid ZIP archive: %v", test.name, err)
+			continue
+		}
+		var names []string
+		for _, file := range reader.File {
+			names = append(names, file.Name)
+		}
+		if !slices.Equal(names, test.files) {
+			t.Errorf("%s: expected entries %v, got %v", test.name, test.files, names)
+		}
+	}
+}
+
+func TestCreateArchive_Rejects(t *testing.T) {
+	setupFilesFolder(t)
+	router := setupArchiveRouter()
+
+	tests := []struct {
+		name   string
+		req    ArchiveRequest
+		status int
+	}{
+		{"nothing requested", ArchiveRequest{}, http.StatusBadRequest},
+		{"unknown format", ArchiveRequest{Files: []string{"Top.mp3"}, Format: "rar"}, http.StatusBadRequest},
+		{"traversal", ArchiveRequest{Files: []string{"../outside/secret.txt"}}, http.StatusBadRequest},
+		{"link out of the folder", ArchiveRequest{Files: []string{"secret.mp4"}}, http.StatusBadRequest},
+		{"folder link out of the folder", ArchiveRequest{Folders: []string{"Escape"}}, http.StatusBadRequest},
+		{"staging folder", ArchiveRequest{Folders: []string{".staging-abc"}}, http.StatusBadRequest},
+		{"missing file", ArchiveRequest{Files: []string{"Missing.mp4"}}, http.StatusNotFound},
+		{"folder as file", ArchiveRequest{Files: []string{"Channel"}}, http.StatusNotFound},
+		{"missing folder", ArchiveRequest{Folders: []string{"Missing"}}, http.StatusNotFound},
+		{"unknown job", ArchiveRequest{JobID: "missing"}, http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		rec := postArchive(router, context.Background(), test.req)
+		if rec.Code != test.status {
+			t.Errorf("%s: expected %d, got %d: %s", test.name, test.status, rec.Code, rec.Body.String())
+		}
+		if bytes.Contains(rec.Body.Bytes(), []byte("secret")) && rec.Code == http.StatusOK {
+			t.Errorf("%s: archived a file outside the download folder", test.name)
+		}
+	}
+}
+
+func TestCreateArchive_ClientDisconnects(t *testing.T) {
+	setupFilesFolder(t)
+	router := setupArchiveRouter()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec := postArchive(router, ctx, ArchiveRequest{Folders: []string{"Channel"}, Format: "tar"})
+	if rec.Body.Len() != 0 {
+		t.Errorf("expected the archive to stop when the client disconnected, got %d bytes", rec.Body.Len())
+	}
+}
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"bytes"
+	"downloader/jobs"
+	"downloader/logging"
+	"downloader/utils"
+	"downloader/ytdlp"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBatchUpload bounds the body of POST /batch
+const maxBatchUpload = 1 << 20
+
+// BatchRequest is the JSON body of POST /batch. Options apply to every URL;
+// each of Entries is an object with a url and the options that differ.
+type BatchRequest struct {
+	URLs        []string          `json:"urls"`
+	Entries     []json.RawMessage `json:"entries"`
+	Options     ytdlp.Options     `json:"options"`
+	CallbackURL string            `json:"callbackUrl"`
+}
+
+// batchInput is a URL of a batch before it is checked
+type batchInput struct {
+	url  string
+	opts ytdlp.Options
+	err  error // set if the entry could not be read
+}
+
+// CreateBatch queues a download for each of many URLs, given as JSON or as
+// a plain-text or CSV upload, and responds with the batch at once. URLs that
+// are invalid or refused by the URL policy are reported in the batch rather
+// than failing it.
+func CreateBatch(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBatchUpload)
+	inputs, callback, err := readBatch(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(inputs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No URLs given"})
+		return
+	}
+	if limit := utils.EnvInt("BATCH_MAX_URLS", 100); len(inputs) > limit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("A batch may have at most %d URLs", limit)})
+		return
+	}
+	if !checkCallback(c, callback) || !acceptingJobs(c) {
+		return
+	}
+
+	entries := make([]jobs.BatchEntry, len(inputs))
+	for i, input := range inputs {
+		entries[i] = jobs.BatchEntry{URL: input.url, Options: input.opts}
+		if err := checkBatchEntry(c, input); err != nil {
+			entries[i].Error = err.Error()
+		}
+	}
+
+	batch := jobs.NewBatch(logging.User(c), callback, entries)
+	if err := jobs.Default.SubmitBatch(c.Request.Context(), batch); err != nil {
+		// The jobs run regardless; only a restart would lose the batch
+		logging.FromContext(c.Request.Context()).Warn("failed to save batch", "batch_id", batch.ID, "error", err)
+	}
+	status, _ := jobs.Default.BatchStatus(batch.ID)
+	c.JSON(http.StatusAccepted, status)
+}
+
+// GetBatch returns the progress of a batch and the result of each URL
+func GetBatch(c *gin.Context) {
+	status, ok := jobs.Default.BatchStatus(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Batch not found"})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// RetryBatch downloads the URLs of a batch whose jobs failed again
+func RetryBatch(c *gin.Context) {
+	if !acceptingJobs(c) {
+		return
+	}
+	id := c.Param("id")
+	retried, err := jobs.Default.RetryBatch(c.Request.Context(), id)
+	switch {
+	case errors.Is(err, jobs.ErrBatchNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Batch not found"})
+		return
+	case err != nil:
+		logging.FromContext(c.Request.Context()).Warn("failed to save batch", "batch_id", id, "error", err)
+	}
+	status, _ := jobs.Default.BatchStatus(id)
+	c.JSON(http.StatusAccepted, gin.H{"retried": retried, "batch": status})
+}
+
+// checkBatchEntry returns why input cannot be downloaded, or nil
+func checkBatchEntry(c *gin.Context, input batchInput) error {
+	if input.err != nil {
+		return input.err
+	}
+	if !utils.IsValidURL(input.url) {
+		return errors.New("invalid URL")
+	}
+	if err := input.opts.Validate(); err != nil {
+		return err
+	}
+	return URLPolicy.Check(c.Request.Context(), input.url)
+}
+
+// readBatch reads the URLs of a batch and the callback URL from the body:
+// JSON, a plain-text or CSV body or a multipart upload in the "file" field.
+// For uploads the shared options and callbackUrl come from the query, named
+// as for GET /download/stream.
+func readBatch(c *gin.Context) ([]batchInput, string, error) {
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if mediaType == "application/json" || mediaType == "" {
+		return readBatchJSON(c)
+	}
+
+	var defaults ytdlp.Options
+	if err := c.ShouldBindQuery(&defaults); err != nil {
+		return nil, "", errors.New("Invalid options in query")
+	}
+	callback := c.Query("callbackUrl")
+
+	var data []byte
+	var err error
+	csvUpload := mediaType == "text/csv"
+	switch mediaType {
+	case "multipart/form-data":
+		file, header, ferr := c.Request.FormFile("file")
+		if ferr != nil {
+			return nil, "", errors.New("Upload the URL list in the \"file\" field")
+		}
+		defer file.Close()
+		csvUpload = strings.EqualFold(filepath.Ext(header.Filename), ".csv") || header.Header.Get("Content-Type") == "text/csv"
+		data, err = io.ReadAll(file)
+	case "text/plain", "text/csv":
+		data, err = io.ReadAll(c.Request.Body)
+	default:
+		return nil, "", errors.New("Send JSON, text/plain, text/csv or multipart/form-data")
+	}
+	if err != nil {
+		return nil, "", errors.New("Failed to read the URL list")
+	}
+
+	if csvUpload {
+		inputs, err := parseBatchCSV(data, defaults)
+		return inputs, callback, err
+	}
+	return parseBatchText(data, defaults), callback, nil
+}
+
+func readBatchJSON(c *gin.Context) ([]batchInput, string, error) {
+	var req BatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return nil, "", errors.New("Invalid request body")
+	}
+	inputs := make([]batchInput, 0, len(req.URLs)+len(req.Entries))
+	for _, rawURL := range req.URLs {
+		inputs = append(inputs, batchInput{url: strings.TrimSpace(rawURL), opts: req.Options})
+	}
+	for _, raw := range req.Entries {
+		input := batchInput{opts: req.Options}
+		var entry struct {
+			URL string `json:"url"`
+		}
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			input.err = errors.New("invalid entry")
+		} else if err := json.Unmarshal(raw, &input.opts); err != nil {
+			input.err = errors.New("invalid options: " + err.Error())
+		}
+		input.url = strings.TrimSpace(entry.URL)
+		inputs = append(inputs, input)
+	}
+	return inputs, req.CallbackURL, nil
+}
+
+// parseBatchText reads one URL per line, skipping blank lines and lines
+// starting with #
+func parseBatchText(data []byte, defaults ytdlp.Options) []batchInput {
+	var inputs []batchInput
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		inputs = append(inputs, batchInput{url: line, opts: defaults})
+	}
+	return inputs
+}
+
+// parseBatchCSV reads a URL from the first column of each row. If the first
+// row is a header starting with "url", its other columns name the options
+// each row overrides, such as "format" or "resolution"; empty cells keep
+// the shared option.
+func parseBatchCSV(data []byte, defaults ytdlp.Options) ([]batchInput, error) {
+	// Spreadsheets often start their CSV exports with a byte order mark
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid CSV: %v", err)
+	}
+
+	var columns []string
+	if len(rows) > 0 && strings.EqualFold(strings.TrimSpace(rows[0][0]), "url") {
+		columns, rows = rows[0], rows[1:]
+		for _, column := range columns[1:] {
+			if err := setOption(&ytdlp.Options{}, strings.TrimSpace(column), ""); err != nil {
+				return nil, fmt.Errorf("Unknown CSV column %q", column)
+			}
+		}
+	}
+
+	var inputs []batchInput
+	for _, row := range rows {
+		input := batchInput{url: strings.TrimSpace(row[0]), opts: defaults}
+		if input.url == "" {
+			continue
+		}
+		for i := 1; i < len(row) && i < len(columns); i++ {
+			if value := strings.TrimSpace(row[i]); value != "" {
+				if err := setOption(&input.opts, strings.TrimSpace(columns[i]), value); err != nil {
+					input.err = fmt.Errorf("invalid %s %q", columns[i], value)
+					break
+				}
+			}
+		}
+		inputs = append(inputs, input)
+	}
+	return inputs, nil
+}
+
+// setOption sets the option with JSON name key to value, read as a string
+// or, for options that are not strings, as a JSON value such as 30, true or
+// ["en","de"]. An empty value only checks that the option exists.
+func setOption(opts *ytdlp.Options, key, value string) error {
+	decode := func(raw string) error {
+		decoder := json.NewDecoder(strings.NewReader("{" + strconv.Quote(key) + ":" + raw + "}"))
+		decoder.DisallowUnknownFields()
+		return decoder.Decode(opts)
+	}
+	if value == "" {
+		return decode("null")
+	}
+	if err := decode(strconv.Quote(value)); err == nil {
+		return nil
+	}
+	return decode(value)
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"downloader/jobs"
+	"downloader/ytdlp"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestBatchHelperProcess is not a real test: it stands in for a yt-dlp run
+// that fails when setupBatchRouter's fail is set
+func TestBatchHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") == "1" {
+		os.Exit(1)
+	}
+}
+
+// setupBatchRouter swaps in a job manager downloading to a temporary folder
+// and stubs yt-dlp with a process that exits at once, failing if fail is set
+func setupBatchRouter(t *testing.T, fail *bool) *gin.Engine {
+	folder := t.TempDir()
+	original, originalManager := ytdlp.Command, jobs.Default
+	ytdlp.Command = func(ctx context.Context, args ...string) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, os.Args[0], "-test.run=^TestBatchHelperProcess$")
+		if *fail {
+			cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1")
+		}
+		return cmd
+	}
+	jobs.Default = jobs.NewManager(2)
+	jobs.Default.Folder = func() string { return folder }
+	manager := jobs.Default
+	t.Cleanup(func() {
+		// Wait for the batch jobs before restoring what they use
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		manager.Shutdown(ctx)
+		ytdlp.Command, jobs.Default = original, originalManager
+	})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/batch", CreateBatch)
+	router.GET("/batch/:id", GetBatch)
+	router.POST("/batch/:id/retry", RetryBatch)
+	return router
+}
+
+func postBatch(router *gin.Engine, query, contentType string, body []byte) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(http.MethodPost, "/batch"+query, bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// waitForBatch polls the batch until every entry has finished
+func waitForBatch(t *testing.T, router *gin.Engine, id string) jobs.BatchStatus {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		req, _ := http.NewRequest(http.MethodGet, "/batch/"+id, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var status jobs.BatchStatus
+		json.Unmarshal(rec.Body.Bytes(), &status)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /batch/%s: %d %s", id, rec.Code, rec.Body.String())
+		}
+		if status.Done || time.Now().After(deadline) {
+			return status
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCreateBatch_JSON(t *testing.T) {
+	fail := false
+	router := setupBatchRouter(t, &fail)
+
+	body := `{
+		"urls": ["https://example.com/1", "not a url", "http://127.0.0.1/video"],
+		"entries": [
+			{"url": "https://example.com/2", "format": "audio", "audioCodec": "mp3"},
+			{"url": "https://example.com/3", "audioCodec": "wma"}
+		],
+		"options": {"format": "video", "resolution": "720"}
+	}`
+	rec := postBatch(router, "", "application/json", []byte(body))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var created jobs.BatchStatus
+	json.Unmarshal(rec.Body.Bytes(), &created)
+	if created.ID == "" || created.Total != 5 {
+		t.Fatalf("unexpected batch %s", rec.Body.String())
+	}
+
+	status := waitForBatch(t, router, created.ID)
+	want := []jobs.Status{jobs.StatusCompleted, jobs.StatusRejected, jobs.StatusRejected, jobs.StatusCompleted, jobs.StatusRejected}
+	for i, result := range status.Results {
+		if result.Status != want[i] {
+			t.Errorf("entry %d (%s): expected %s, got %s %q", i, result.URL, want[i], result.Status, result.Error)
+		}
+	}
+	if status.Counts[jobs.StatusCompleted] != 2 || status.Counts[jobs.StatusRejected] != 3 || status.Progress != 100 {
+		t.Errorf("unexpected aggregate %+v", status)
+	}
+
+	// Per-entry options override the shared ones
+	audio, _ := jobs.Default.Get(status.Results[3].JobID)
+	video, _ := jobs.Default.Get(status.Results[0].JobID)
+	if audio.Options.Format != "audio" || audio.BatchID != created.ID || video.Options.Format != "video" || video.Options.Resolution != "720" {
+		t.Errorf("unexpected options %+v and %+v", audio.Options, video.Options)
+	}
+}
+
+func TestCreateBatch_Uploads(t *testing.T) {
+	fail := false
+	router := setupBatchRouter(t, &fail)
+
+	var multipartBody bytes.Buffer
+	writer := multipart.NewWriter(&multipartBody)
+	part, _ := writer.CreateFormFile("file", "links.csv")
+	part.Write([]byte("url,resolution\nhttps://example.com/1,1080\nhttps://example.com/2,\n"))
+	writer.Close()
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		urls        int
+		resolution  string // of the first entry
+	}{
+		{"plain text", "text/plain", "# pasted links\nhttps://example.com/1\n\n  https://example.com/2  \n", 2, "480"},
+		{"CSV with overrides", "text/csv", "\ufeffurl,resolution,maxFps\nhttps://example.com/1,1080,30\nhttps://example.com/2,,\n", 2, "1080"},
+		{"CSV without header", "text/csv", "https://example.com/1\nhttps://example.com/2,ignored\n", 2, "480"},
+		{"multipart upload", writer.FormDataContentType(), multipartBody.String(), 2, "1080"},
+	}
+
+	for _, test := range tests {
+		rec := postBatch(router, "?format=video&resolution=480", test.contentType, []byte(test.body))
+		if rec.Code != http.StatusAccepted {
+			t.Errorf("%s: expected 202, got %d: %s", test.name, rec.Code, rec.Body.String())
+			continue
+		}
+		var created jobs.BatchStatus
+		json.Unmarshal(rec.Body.Bytes(), &created)
+		status := waitForBatch(t, router, created.ID)
+		if status.Total != test.urls || status.Counts[jobs.StatusCompleted] != test.urls {
+			t.Errorf("%s: expected %d completed downloads, got %+v", test.name, test.urls, status.Counts)
+			continue
+		}
+		first, _ := jobs.Default.Get(status.Results[0].JobID)
+		if first.Options.Resolution != test.resolution || first.Options.Format != "video" {
+			t.Errorf("%s: expected resolution %s, got %+v", test.name, test.resolution, first.Options)
+		}
+	}
+}
+
+func TestCreateBatch_Rejects(t *testing.T) {
+	fail := false
+	router := setupBatchRouter(t, &fail)
+	t.Setenv("BATCH_MAX_URLS", "2")
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"empty", "application/json", `{"options": {"format": "video"}}`, http.StatusBadRequest},
+		{"invalid JSON", "application/json", `{"urls": "https://example.com"}`, http.StatusBadRequest},
+		{"too many URLs", "text/plain", "https://example.com/1\nhttps://example.com/2\nhttps://example.com/3", http.StatusBadRequest},
+		{"unknown CSV column", "text/csv", "url,colour\nhttps://example.com/1,red", http.StatusBadRequest},
+		{"unsupported type", "application/xml", "<urls/>", http.StatusBadRequest},
+		{"multipart without file", "multipart/form-data; boundary=x", "--x--\r\n", http.StatusBadRequest},
+		{"private callback", "application/json", `{"urls": ["https://example.com/1"], "options": {"format": "video"}, "callbackUrl": "http://10.0.0.1/hook"}`, http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		if rec := postBatch(router, "", test.contentType, []byte(test.body)); rec.Code != test.status {
+			t.Errorf("%s: expected %d, got %d: %s", test.name, test.status, rec.Code, rec.Body.String())
+		}
+	}
+
+	for _, target := range []string{"/batch/missing", "/batch/missing/retry"} {
+		method := http.MethodGet
+		if strings.HasSuffix(target, "retry") {
+			method = http.MethodPost
+		}
+		req, _ := http.NewRequest(method, target, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s %s: expected 404, got %d", method, target, rec.Code)
+		}
+	}
+}
+
+func TestRetryBatch(t *testing.T) {
+	fail := true
+	router := setupBatchRouter(t, &fail)
+
+	rec := postBatch(router, "?format=audio", "text/plain", []byte("https://example.com/1\nhttps://example.com/2\nnot a url"))
+	var created jobs.BatchStatus
+	json.Unmarshal(rec.Body.Bytes(), &created)
+	status := waitForBatch(t, router, created.ID)
+	if status.Counts[jobs.StatusFailed] != 2 {
+		t.Fatalf("expected 2 failed downloads, got %+v", status.Counts)
+	}
+	failedJob := status.Results[0].JobID
+
+	fail = false
+	req, _ := http.NewRequest(http.MethodPost, "/batch/"+created.ID+"/retry", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	var retry struct {
+		Retried int `json:"retried"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &retry)
+	if rec.Code != http.StatusAccepted || retry.Retried != 2 {
+		t.Fatalf("expected 2 entries retried, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	status = waitForBatch(t, router, created.ID)
+	if status.Counts[jobs.StatusCompleted] != 2 || status.Counts[jobs.StatusRejected] != 1 {
+		t.Errorf("expected the retried downloads completed, got %+v", status.Counts)
+	}
+	if result := status.Results[0]; result.JobID == failedJob || result.Retries != 1 {
+		t.Errorf("expected a new job for the retried entry, got %+v", result)
+	}
+}
@@ -0,0 +1,13 @@
+package handlers
+
+import (
+	"downloader/ytdlp"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetCapabilities lists the download options the server accepts
+func GetCapabilities(c *gin.Context) {
+	c.JSON(http.StatusOK, ytdlp.Supported())
+}
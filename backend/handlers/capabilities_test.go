@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"downloader/ytdlp"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetCapabilities(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/capabilities", GetCapabilities)
+
+	req, _ := http.NewRequest(http.MethodGet, "/capabilities", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+
+	var body ytdlp.Capabilities
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	// Every advertised combination must pass validation
+	for _, codec := range body.Audio.Codecs {
+		opts := ytdlp.Options{Format: "audio", AudioCodec: codec.Name}
+		if err := opts.Validate(); err != nil {
+			t.Errorf("codec %s rejected: %v", codec.Name, err)
+		}
+		for _, bitrate := range codec.Bitrates {
+			opts := ytdlp.Options{Format: "audio", AudioCodec: codec.Name, AudioBitrate: bitrate}
+			if err := opts.Validate(); err != nil {
+				t.Errorf("%s at %s rejected: %v", codec.Name, bitrate, err)
+			}
+		}
+		for _, rate := range codec.SampleRates {
+			opts := ytdlp.Options{Format: "audio", AudioCodec: codec.Name, SampleRate: rate}
+			if err := opts.Validate(); err != nil {
+				t.Errorf("%s at %d Hz rejected: %v", codec.Name, rate, err)
+			}
+		}
+	}
+	if body.Audio.DefaultCodec != "mp3" || body.Audio.DefaultBitrate != "192K" {
+		t.Errorf("unexpected audio defaults %+v", body.Audio)
+	}
+}
@@ -1,25 +1,24 @@
 package handlers
 
 import (
-	"context"
+	"downloader/jobs"
+	"downloader/logging"
 	"downloader/utils"
-	"fmt"
-	"io"
-	"log"
+	"downloader/ytdlp"
+	"errors"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// DownloadRequest is the body of POST /download and the query of GET /download/stream
 type DownloadRequest struct {
-	URL         string `json:"url"`
-	Format      string `json:"format"`      // "video" or "audio"
-	Resolution  string `json:"resolution"`  // "360", "480", "720", "1080"
-	VideoFormat string `json:"videoFormat"` // "mp4", "webm", "mkv", "avi", "best"
+	URL string `json:"url" form:"url"`
+	// CallbackURL receives the job's webhook events
+	CallbackURL string `json:"callbackUrl" form:"callbackUrl"`
+	ytdlp.Options
 }
 
 func DownloadVideo(c *gin.Context) {
@@ -29,171 +28,107 @@ func DownloadVideo(c *gin.Context) {
 		return
 	}
 
-	if req.Format != "video" && req.Format != "audio" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format. Choose 'video' or 'audio'"})
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Get list of files before download to identify new files
-	downloadFolder := utils.GetDownloadFolder()
-	beforeFiles, err := utils.GetFileList(downloadFolder)
-	if err != nil {
-		log.Printf("Error getting file list before download: %v", err)
-	}
-
-	outputPath := filepath.Join(downloadFolder, "%(title)s.%(ext)s")
-	var args []string
-
-	if req.Format == "audio" {
-		args = []string{
-			"-f", "bestaudio/best",
-			"--extract-audio", "--audio-format", "mp3", "--audio-quality", "192K",
-			"--no-playlist", "--prefer-free-formats",
-			"--embed-metadata", "--add-metadata",
-			"-o", outputPath, req.URL,
-		}
-	} else {
-		format := utils.BuildVideoFormat(req.Resolution, req.VideoFormat)
-
-		args = []string{
-			"-f", format,
-			"--no-playlist", "--prefer-free-formats",
-			"--embed-metadata", "--add-metadata",
-		}
-
-		// Add merge format only for specific formats
-		if req.VideoFormat == "mp4" || req.VideoFormat == "mkv" || req.VideoFormat == "avi" {
-			args = append(args, "--merge-output-format", req.VideoFormat)
-		}
-
-		args = append(args, "-o", outputPath, req.URL)
+	if !enforceURLPolicy(c, req.URL) || !checkCallback(c, req.CallbackURL) || !acceptingJobs(c) {
+		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Second)
-	defer cancel()
+	job := jobs.NewJob(c.Request.Context(), req.URL, req.Options, logging.User(c))
+	job.CallbackURL = req.CallbackURL
+	runJob(c, job)
+}
 
-	cmd := exec.CommandContext(ctx, "yt-dlp", args...)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		log.Printf("yt-dlp error: %v\nOutput: %s", err, output)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Download failed"})
+// runJob runs job to completion and responds with the file it produced
+func runJob(c *gin.Context, job *jobs.Job) {
+	err := jobs.Default.Run(c.Request.Context(), job, nil)
+	switch {
+	case errors.Is(err, jobs.ErrShuttingDown), errors.Is(err, jobs.ErrInterrupted):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error(), "jobId": job.ID})
 		return
-	}
-
-	// Get list of files after download to identify the new file
-	afterFiles, err := utils.GetFileList(downloadFolder)
-	if err != nil {
-		log.Printf("Error getting file list after download: %v", err)
-		c.JSON(http.StatusOK, gin.H{"message": "Download completed"})
+	case errors.Is(err, ytdlp.ErrRange):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "jobId": job.ID})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Download failed", "jobId": job.ID})
 		return
 	}
 
-	// Find the newly downloaded file
-	newFiles := utils.FindNewFiles(beforeFiles, afterFiles)
-	if len(newFiles) == 0 {
-		c.JSON(http.StatusOK, gin.H{"message": "Download completed"})
+	if len(job.Files) == 0 {
+		c.JSON(http.StatusOK, gin.H{"message": "Download completed", "jobId": job.ID})
 		return
 	}
 
 	// Return information about the downloaded file
-	downloadedFile := newFiles[0] // Take the first new file
-	fileInfo, err := os.Stat(filepath.Join(downloadFolder, downloadedFile))
+	downloadedFile := primaryFile(job.Files)
+	fileInfo, err := os.Stat(filepath.Join(jobs.Default.Folder(), downloadedFile))
 	if err != nil {
-		log.Printf("Error getting file info: %v", err)
-		c.JSON(http.StatusOK, gin.H{"message": "Download completed"})
+		job.Logger().Warn("failed to stat downloaded file", "file", downloadedFile, "error", err)
+		c.JSON(http.StatusOK, gin.H{"message": "Download completed", "jobId": job.ID})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"message":     "Download completed",
+		"jobId":       job.ID,
 		"filename":    downloadedFile,
 		"size":        fileInfo.Size(),
-		"downloadUrl": fmt.Sprintf("/files/%s", downloadedFile),
-	})
-}
-
-// ServeFile serves a downloaded file to the client
-func ServeFile(c *gin.Context) {
-	filename := c.Param("filename")
-	if filename == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Filename is required"})
-		return
+		"downloadUrl": utils.FileURL(downloadedFile),
 	}
-
-	// Sanitize filename to prevent directory traversal
-	filename = filepath.Base(filename)
-	filePath := filepath.Join(utils.GetDownloadFolder(), filename)
-
-	// Check if file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
-		return
+	if subs := subtitleFiles(job.Files, jobs.Default.Folder()); len(subs) > 0 {
+		response["subtitles"] = subs
 	}
-
-	// Open the file
-	file, err := os.Open(filePath)
-	if err != nil {
-		log.Printf("Error opening file %s: %v", filePath, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open file"})
-		return
+	if len(job.Groups) > 0 {
+		response["groups"] = job.Groups
 	}
-	defer file.Close()
-
-	// Get file info for content length
-	fileInfo, err := file.Stat()
-	if err != nil {
-		log.Printf("Error getting file info %s: %v", filePath, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get file info"})
-		return
+	if job.SponsorBlock != nil {
+		response["sponsorBlock"] = job.SponsorBlock
 	}
-
-	// Set appropriate headers
-	c.Header("Content-Description", "File Transfer")
-	c.Header("Content-Transfer-Encoding", "binary")
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
-	c.Header("Content-Length", fmt.Sprintf("%d", fileInfo.Size()))
-
-	// Set content type based on file extension
-	c.Header("Content-Type", utils.GetContentType(filename))
-
-	// Stream the file to the client
-	_, err = io.Copy(c.Writer, file)
-	if err != nil {
-		log.Printf("Error streaming file %s: %v", filePath, err)
-		return
+	if job.Replaces != "" {
+		response["replaces"] = job.Replaces
 	}
-}
-
-// ListFiles returns a list of all downloaded files
-func ListFiles(c *gin.Context) {
-	downloadFolder := utils.GetDownloadFolder()
-
-	// Read directory contents
-	entries, err := os.ReadDir(downloadFolder)
-	if err != nil {
-		log.Printf("Error reading download folder: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read download folder"})
-		return
+	if job.Duplicate != nil {
+		response["duplicate"] = job.Duplicate
 	}
+	if md, ok := jobs.Default.Library.Get(downloadedFile); ok && md.Loudness != nil {
+		response["loudness"] = md.Loudness
+	}
+	c.JSON(http.StatusOK, response)
+}
 
-	var files []utils.FileInfo
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue // Skip directories
+// primaryFile returns the first media file of a job, skipping sidecar files
+// such as subtitles and thumbnails
+func primaryFile(files []string) string {
+	for _, name := range files {
+		if fileType := utils.GetFileType(name); fileType != "subtitle" && fileType != "image" {
+			return name
 		}
+	}
+	return files[0]
+}
 
-		// Create file info using utility function
-		fileInfo, err := utils.CreateFileInfo(entry.Name(), downloadFolder)
-		if err != nil {
-			log.Printf("Error getting file info for %s: %v", entry.Name(), err)
+// subtitleFiles returns file info for the subtitle files among files
+func subtitleFiles(files []string, folder string) []utils.FileInfo {
+	var subs []utils.FileInfo
+	for _, name := range files {
+		if utils.GetFileType(name) != "subtitle" {
 			continue
 		}
-
-		files = append(files, *fileInfo)
+		if info, err := utils.CreateFileInfo(name, folder); err == nil {
+			subs = append(subs, *info)
+		}
 	}
+	return subs
+}
 
-	c.JSON(http.StatusOK, gin.H{
-		"files": files,
-		"count": len(files),
-	})
+// acceptingJobs writes a 503 and returns false once shutdown has begun
+func acceptingJobs(c *gin.Context) bool {
+	if jobs.Default.Draining() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": jobs.ErrShuttingDown.Error()})
+		return false
+	}
+	return true
 }
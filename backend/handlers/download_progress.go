@@ -1,109 +1,81 @@
 package handlers
 
 import (
-	"bufio"
-	"context"
+	"downloader/jobs"
+	"downloader/logging"
+	"downloader/metrics"
 	"downloader/utils"
+	"encoding/json"
 	"fmt"
 	"net/http"
-	"os/exec"
-	"path/filepath"
-	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 func DownloadWithProgress(c *gin.Context) {
-	url := c.Query("url")
-	format := c.Query("format")
-	resolution := c.Query("resolution")
-	videoFormat := c.Query("videoFormat")
-
-	if url == "" || !utils.IsValidURL(url) || (format != "video" && format != "audio") {
+	var req DownloadRequest
+	if err := c.ShouldBindQuery(&req); err != nil || req.URL == "" || !utils.IsValidURL(req.URL) || req.Validate() != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing or invalid url/format"})
 		return
 	}
 
+	if !enforceURLPolicy(c, req.URL) || !checkCallback(c, req.CallbackURL) || !acceptingJobs(c) {
+		return
+	}
+
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
 	c.Header("Connection", "keep-alive")
 
-	// Get list of files before download to identify new files
-	downloadFolder := utils.GetDownloadFolder()
-	beforeFiles, _ := utils.GetFileList(downloadFolder)
-
-	var args []string
-	outputPath := filepath.Join(downloadFolder, "%(title)s.%(ext)s")
-
-	if format == "audio" {
-		args = []string{
-			"-f", "bestaudio/best",
-			"--extract-audio", "--audio-format", "mp3", "--audio-quality", "192K",
-			"--no-playlist", "--prefer-free-formats",
-			"--embed-metadata", "--add-metadata",
-			"-o", outputPath,
-			"--progress-template", "download:%(progress._percent_str)s (%(progress.eta)s remaining)",
-			url,
-		}
-	} else {
-		formatStr := utils.BuildVideoFormat(resolution, videoFormat)
-		args = []string{
-			"-f", formatStr,
-			"--no-playlist", "--prefer-free-formats",
-			"--embed-metadata", "--add-metadata",
+	metrics.ActiveSSEConnections.Inc()
+	defer metrics.ActiveSSEConnections.Dec()
+
+	job := jobs.NewJob(c.Request.Context(), req.URL, req.Options, logging.User(c))
+	job.CallbackURL = req.CallbackURL
+	writeEvent(c, "job", fmt.Sprintf(`{"jobId":%q}`, job.ID))
+
+	lines := make(chan string, 64)
+	done := make(chan error, 1)
+	go func() {
+		done <- jobs.Default.Run(c.Request.Context(), job, func(line string) { lines <- line })
+	}()
+
+	shutdown := jobs.Default.ShuttingDown()
+	var runErr error
+loop:
+	for {
+		select {
+		case line := <-lines:
+			writeEvent(c, "", line)
+		case <-shutdown:
+			writeEvent(c, "shutdown", "Server is shutting down; running downloads get a grace period and are resumed after restart")
+			shutdown = nil
+		case runErr = <-done:
+			break loop
 		}
-
-		// Add merge format only for specific formats
-		if videoFormat == "mp4" || videoFormat == "mkv" || videoFormat == "avi" {
-			args = append(args, "--merge-output-format", videoFormat)
-		}
-
-		args = append(args,
-			"-o", outputPath,
-			"--progress-template", "download:%(progress._percent_str)s (%(progress.eta)s remaining)",
-			url,
-		)
 	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "yt-dlp", args...)
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start process"})
-		return
-	}
-
-	if err := cmd.Start(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start download"})
-		return
+	// Run has returned, so every line it produced is already buffered
+	for len(lines) > 0 {
+		writeEvent(c, "", <-lines)
 	}
 
-	scanner := bufio.NewScanner(stderr)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if len(line) > 0 {
-			if _, err := c.Writer.WriteString(fmt.Sprintf("data: %s\n\n", line)); err == nil {
-				c.Writer.Flush()
-			}
-		}
+	if runErr != nil {
+		data, _ := json.Marshal(gin.H{"error": runErr.Error(), "jobId": job.ID})
+		writeEvent(c, "error", string(data))
+	} else if len(job.Files) > 0 {
+		downloadedFile := primaryFile(job.Files)
+		data, _ := json.Marshal(gin.H{"filename": downloadedFile, "downloadUrl": utils.FileURL(downloadedFile)})
+		writeEvent(c, "file", string(data))
 	}
 
-	cmd.Wait()
+	writeEvent(c, "done", "completed")
+}
 
-	// Get list of files after download to identify the new file
-	afterFiles, err := utils.GetFileList(downloadFolder)
-	if err == nil {
-		// Find the newly downloaded file
-		newFiles := utils.FindNewFiles(beforeFiles, afterFiles)
-		if len(newFiles) > 0 {
-			downloadedFile := newFiles[0]
-			c.Writer.WriteString(fmt.Sprintf("event: file\ndata: {\"filename\":\"%s\",\"downloadUrl\":\"/files/%s\"}\n\n", downloadedFile, downloadedFile))
-			c.Writer.Flush()
-		}
+// writeEvent writes one Server-Sent Event, using the default event type when name is empty
+func writeEvent(c *gin.Context, name, data string) {
+	if name != "" {
+		c.Writer.WriteString(fmt.Sprintf("event: %s\n", name))
 	}
-
-	c.Writer.WriteString("event: done\ndata: completed\n\n")
+	c.Writer.WriteString(fmt.Sprintf("data: %s\n\n", data))
 	c.Writer.Flush()
 }
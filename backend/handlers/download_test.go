@@ -47,3 +47,21 @@ func TestDownloadVideo_InvalidFormat(t *testing.T) {
 		t.Errorf("expected status 400, got %d", rec.Code)
 	}
 }
+
+func TestDownloadVideo_InvalidSubtitleOptions(t *testing.T) {
+	router := setupDownloadRouter()
+
+	reqBody := bytes.NewBufferString(`{"url":"https://example.com","format":"video","videoFormat":"avi","subtitleLangs":["en"],"embedSubtitles":true}`)
+	req, _ := http.NewRequest(http.MethodPost, "/download", reqBody)
+	req.Header.Set("Content-Type", "application/json")
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", rec.Code)
+	}
+	if !bytes.Contains(rec.Body.Bytes(), []byte("cannot be embedded in avi")) {
+		t.Errorf("expected embed error, got %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"downloader/jobs"
+	"downloader/library"
+	"downloader/logging"
+	"downloader/utils"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fileEntry is one file of GET /files with the metadata recorded for it
+type fileEntry struct {
+	utils.FileInfo
+	Metadata *library.Metadata `json:"metadata,omitempty"`
+	// MetadataPending is set for audio and video files not probed since they
+	// last changed; their media metadata is missing or outdated until then
+	MetadataPending bool `json:"metadataPending,omitempty"`
+}
+
+// newFileEntry describes the file name in folder from the recorded metadata.
+// Audio and video files whose size or modification time changed since they
+// were last probed are marked pending and probed in the background, so
+// listing never waits for ffprobe.
+func newFileEntry(folder, name string) (fileEntry, error) {
+	fileInfo, err := utils.CreateFileInfo(name, folder)
+	if err != nil {
+		return fileEntry{}, err
+	}
+	file := fileEntry{FileInfo: *fileInfo}
+
+	index := jobs.Default.Library
+	if index.Stale(folder, name) {
+		file.MetadataPending = true
+		index.ProbeLater(folder, name)
+	}
+
+	if md, ok := index.Get(name); ok {
+		file.Metadata = &md
+		if md.Thumbnail != "" && file.ThumbnailURL == "" {
+			if _, err := os.Stat(filepath.Join(folder, md.Thumbnail)); err == nil {
+				file.ThumbnailURL = utils.FileURL(md.Thumbnail)
+			}
+		}
+	}
+	return file, nil
+}
+
+// GetFileInfo returns one downloaded file with its recorded metadata,
+// including the duration, container, codecs, resolution and bitrate ffprobe
+// reported for audio and video files
+func GetFileInfo(c *gin.Context) {
+	filename, ok := fileParam(c)
+	if !ok {
+		return
+	}
+	folder := utils.GetDownloadFolder()
+
+	if stat, err := os.Stat(filepath.Join(folder, filename)); err != nil || stat.IsDir() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	file, err := newFileEntry(folder, filename)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("failed to get file info", "file", filename, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get file info"})
+		return
+	}
+	c.JSON(http.StatusOK, file)
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"downloader/jobs"
+	"downloader/library"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetFileInfo(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	folder := filepath.Join(home, "Downloads")
+	os.MkdirAll(folder, 0o755)
+	os.WriteFile(filepath.Join(folder, "talk.en.vtt"), []byte("WEBVTT\n"), 0o644)
+
+	jobs.Default.Library.Update("talk.en.vtt", func(md *library.Metadata) {
+		md.Source = &library.Source{URL: "https://example.com/watch?v=abc", Title: "Talk"}
+	})
+	t.Cleanup(func() { jobs.Default.Library.Remove("talk.en.vtt") })
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/files/:filename/info", GetFileInfo)
+
+	req, _ := http.NewRequest(http.MethodGet, "/files/talk.en.vtt/info", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var file fileEntry
+	if err := json.Unmarshal(rec.Body.Bytes(), &file); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if file.Name != "talk.en.vtt" || file.Type != "subtitle" || file.Language != "en" {
+		t.Errorf("unexpected file %+v", file.FileInfo)
+	}
+	if file.Metadata == nil || file.Metadata.Source == nil || file.Metadata.Source.Title != "Talk" || file.Metadata.Media != nil {
+		t.Errorf("unexpected metadata %+v", file.Metadata)
+	}
+
+	if file.MetadataPending {
+		t.Error("expected a subtitle file not to wait for a probe")
+	}
+
+	// An unprobed video is listed at once, its probe left to the background
+	os.WriteFile(filepath.Join(folder, "talk.mp4"), []byte("video"), 0o644)
+	t.Cleanup(func() { jobs.Default.Library.Remove("talk.mp4") })
+	req, _ = http.NewRequest(http.MethodGet, "/files/talk.mp4/info", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	file = fileEntry{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &file); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if rec.Code != http.StatusOK || !file.MetadataPending {
+		t.Errorf("expected the video marked pending, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "/files/missing.mp4/info", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing file, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"downloader/jobs"
+	"downloader/logging"
+	"downloader/metrics"
+	"downloader/utils"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ServeFile serves a downloaded file to the client
+func ServeFile(c *gin.Context) {
+	filename, ok := fileParam(c)
+	if !ok {
+		return
+	}
+	serveFile(c, filename)
+}
+
+// serveFile streams the file filename below the download folder, honouring
+// Range and conditional requests so that players can seek and downloads can
+// resume. It responds with 404 if the file does not exist.
+func serveFile(c *gin.Context, filename string) {
+	logger := logging.FromContext(c.Request.Context()).With("file", filename)
+
+	root, err := os.OpenRoot(utils.GetDownloadFolder())
+	if err != nil {
+		logger.Error("failed to open download folder", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open file"})
+		return
+	}
+	defer root.Close()
+
+	// Opening through root cannot follow a link out of the download folder,
+	// even one swapped in after the path was checked
+	file, err := root.Open(filepath.FromSlash(filename))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		logger.Error("failed to stat file", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get file info"})
+		return
+	}
+	if !fileInfo.Mode().IsRegular() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	// Set appropriate headers; ServeContent adds Content-Length and Content-Range
+	c.Header("Content-Description", "File Transfer")
+	c.Header("Content-Transfer-Encoding", "binary")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", path.Base(filename)))
+	c.Header("Content-Type", utils.GetContentType(filename))
+
+	w := &countingWriter{w: c.Writer}
+	http.ServeContent(responseWriter{c.Writer, w}, c.Request, path.Base(filename), fileInfo.ModTime(), file)
+	metrics.BytesServed.Add(float64(w.n))
+	if c.Request.Context().Err() != nil {
+		logger.Warn("file streaming interrupted", "bytes", w.n)
+		return
+	}
+	if c.Request.Method == http.MethodGet {
+		metrics.FilesServed.Inc()
+	}
+}
+
+// responseWriter is a gin response writer whose body goes through w
+type responseWriter struct {
+	gin.ResponseWriter
+	w io.Writer
+}
+
+func (r responseWriter) Write(p []byte) (int, error) {
+	return r.w.Write(p)
+}
+
+// fileParam returns the file path of the request, relative to the download
+// folder. It writes a 400 and returns false for a path outside the folder,
+// including one that leaves it through a symbolic link.
+func fileParam(c *gin.Context) (string, bool) {
+	filename, err := utils.CleanFilePath(c.Param("filename"))
+	if err == nil {
+		_, err = utils.ResolvePath(jobs.Default.Folder(), filename)
+	}
+	if errors.Is(err, utils.ErrInvalidPath) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file path"})
+		return "", false
+	}
+	// A missing file is left to the handler to report
+	return filename, true
+}
+
+// folderParam returns the folder path of the request's path query parameter,
+// "" for the download folder itself. It writes a 400 and returns false for a
+// path outside the folder.
+func folderParam(c *gin.Context, name string) (string, bool) {
+	folder, err := utils.CleanFolderPath(name)
+	if err == nil && folder != "" {
+		_, err = utils.ResolvePath(utils.GetDownloadFolder(), folder)
+	}
+	if errors.Is(err, utils.ErrInvalidPath) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid folder path"})
+		return "", false
+	}
+	return folder, true
+}
+
+// ListFiles lists a folder of the download folder: its subfolders, its files
+// and the breadcrumbs leading to it. path selects the folder, the download
+// folder by default; recursive=true lists the files of its subfolders too.
+func ListFiles(c *gin.Context) {
+	folder, ok := folderParam(c, c.Query("path"))
+	if !ok {
+		return
+	}
+	downloadFolder := utils.GetDownloadFolder()
+
+	logger := logging.FromContext(c.Request.Context()).With("folder", folder)
+
+	root, err := os.OpenRoot(downloadFolder)
+	if err != nil {
+		logger.Error("failed to read download folder", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read download folder"})
+		return
+	}
+	defer root.Close()
+
+	folders, names, err := utils.ReadFolder(root, folder)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, utils.ErrNotFolder) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+		return
+	}
+	if err != nil {
+		logger.Error("failed to read folder", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read download folder"})
+		return
+	}
+
+	if c.Query("recursive") == "true" {
+		// Walking does not follow links, so it stays inside the folder
+		below, err := utils.ListFilesRecursive(filepath.Join(downloadFolder, filepath.FromSlash(folder)))
+		if err != nil {
+			logger.Error("failed to read folder", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read download folder"})
+			return
+		}
+		names = names[:0]
+		for _, name := range below {
+			names = append(names, path.Join(folder, name))
+		}
+	}
+
+	files := []fileEntry{}
+	for _, name := range names {
+		file, err := newFileEntry(downloadFolder, name)
+		if err != nil {
+			logger.Warn("failed to get file info", "file", name, "error", err)
+			continue
+		}
+		files = append(files, file)
+	}
+	if folders == nil {
+		folders = []utils.FolderInfo{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"path":        folder,
+		"breadcrumbs": utils.Breadcrumbs(filepath.Base(downloadFolder), folder),
+		"folders":     folders,
+		"files":       files,
+		"count":       len(files),
+	})
+}
+
+// FolderRequest is the body of POST /folders
+type FolderRequest struct {
+	Path string `json:"path"`
+}
+
+// CreateFolder creates a folder below the download folder, with any missing
+// parents. Creating a folder that exists succeeds.
+func CreateFolder(c *gin.Context) {
+	var req FolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	folder, ok := folderParam(c, req.Path)
+	if !ok {
+		return
+	}
+	if folder == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Folder path is required"})
+		return
+	}
+
+	logger := logging.FromContext(c.Request.Context()).With("folder", folder)
+
+	root, err := os.OpenRoot(utils.GetDownloadFolder())
+	if err != nil {
+		logger.Error("failed to open download folder", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create folder"})
+		return
+	}
+	defer root.Close()
+
+	err = utils.MkdirAllIn(root, folder)
+	switch {
+	case errors.Is(err, utils.ErrNotFolder):
+		c.JSON(http.StatusConflict, gin.H{"error": "A file of that name exists"})
+		return
+	case err != nil:
+		// Includes links on the way that lead outside the download folder
+		logger.Error("failed to create folder", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create folder"})
+		return
+	}
+
+	logger.Info("created folder")
+	c.JSON(http.StatusCreated, gin.H{
+		"path":      folder,
+		"browseUrl": utils.FolderURL(folder),
+	})
+}
+
+// DeleteFolder deletes a folder below the download folder. A folder that is
+// not empty is only deleted with recursive=true, which deletes its files and
+// forgets their metadata. A link to a folder is removed without touching
+// the folder it points to.
+func DeleteFolder(c *gin.Context) {
+	folder, ok := folderParam(c, c.Query("path"))
+	if !ok {
+		return
+	}
+	if folder == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The download folder itself cannot be deleted"})
+		return
+	}
+
+	logger := logging.FromContext(c.Request.Context()).With("folder", folder)
+
+	root, err := os.OpenRoot(utils.GetDownloadFolder())
+	if err != nil {
+		logger.Error("failed to open download folder", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete folder"})
+		return
+	}
+	defer root.Close()
+
+	name := filepath.FromSlash(folder)
+	info, err := root.Lstat(name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+		return
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		if target, err := root.Stat(name); err != nil || !target.IsDir() {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+			return
+		}
+		if err := root.Remove(name); err != nil {
+			logger.Error("failed to remove folder link", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete folder"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"path": folder, "removed": []string{}})
+		return
+	}
+	if !info.IsDir() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+		return
+	}
+
+	if c.Query("recursive") != "true" {
+		if err := root.Remove(name); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "Folder is not empty; use recursive=true to delete its files too"})
+			return
+		}
+		logger.Info("deleted folder")
+		c.JSON(http.StatusOK, gin.H{"path": folder, "removed": []string{}})
+		return
+	}
+
+	removed, err := utils.RemoveAllIn(root, folder)
+	for _, file := range removed {
+		if err := jobs.Default.Library.Remove(file); err != nil {
+			logger.Warn("failed to forget file", "file", file, "error", err)
+		}
+	}
+	if err != nil {
+		logger.Error("failed to delete folder", "removed", len(removed), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete folder", "removed": removed})
+		return
+	}
+
+	logger.Info("deleted folder", "files", len(removed))
+	if removed == nil {
+		removed = []string{}
+	}
+	c.JSON(http.StatusOK, gin.H{"path": folder, "removed": removed})
+}
@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupFilesFolder creates a download folder below a temporary HOME holding
+// a nested file, a staging folder and links leading outside the folder, and
+// returns the download folder and the folder outside it
+func setupFilesFolder(t *testing.T) (string, string) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	folder := filepath.Join(home, "Downloads")
+	outside := filepath.Join(home, "outside")
+	for _, dir := range []string{filepath.Join(folder, "Channel", "2024"), filepath.Join(folder, ".staging-abc"), outside} {
+		os.MkdirAll(dir, 0o755)
+	}
+	os.WriteFile(filepath.Join(folder, "Channel", "2024", "Talk.mp4"), []byte("video data"), 0o644)
+	os.WriteFile(filepath.Join(folder, "Channel", "notes.txt"), []byte("notes"), 0o644)
+	os.WriteFile(filepath.Join(folder, "Top.mp3"), []byte("audio data"), 0o644)
+	os.WriteFile(filepath.Join(folder, ".staging-abc", "Partial.mp4"), []byte("partial"), 0o644)
+	os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o644)
+
+	// Links out of the download folder, and one staying inside it
+	os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(folder, "secret.mp4"))
+	os.Symlink(outside, filepath.Join(folder, "Escape"))
+	os.Symlink("Channel", filepath.Join(folder, "Alias"))
+	return folder, outside
+}
+
+func setupFilesRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.UseRawPath = true
+	router.GET("/files", ListFiles)
+	router.GET("/files/:filename", ServeFile)
+	router.POST("/folders", CreateFolder)
+	router.DELETE("/folders", DeleteFolder)
+	return router
+}
+
+func TestServeFile_NestedPaths(t *testing.T) {
+	setupFilesFolder(t)
+	router := setupFilesRouter()
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/files/Channel%2F2024%2FTalk.mp4", http.StatusOK},
+		{"/files/Alias%2F2024%2FTalk.mp4", http.StatusOK},
+		{"/files/Channel%2F2024%2FMissing.mp4", http.StatusNotFound},
+		{"/files/Channel", http.StatusNotFound},
+		{"/files/..%2Foutside%2Fsecret.txt", http.StatusBadRequest},
+		{"/files/Channel%2F..%2F..%2Foutside%2Fsecret.txt", http.StatusBadRequest},
+		{"/files/%2Fetc%2Fpasswd", http.StatusBadRequest},
+		{"/files/.staging-abc%2FPartial.mp4", http.StatusBadRequest},
+		{"/files/secret.mp4", http.StatusBadRequest},
+		{"/files/Escape%2Fsecret.txt", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest(http.MethodGet, test.path, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("GET %s: expected %d, got %d: %s", test.path, test.status, rec.Code, rec.Body.String())
+		}
+		if bytes.Contains(rec.Body.Bytes(), []byte("secret")) {
+			t.Errorf("GET %s: served a file outside the download folder", test.path)
+		}
+		if test.status == http.StatusOK {
+			if rec.Body.String() != "video data" {
+				t.Errorf("GET %s: unexpected body %q", test.path, rec.Body.String())
+			}
+			if disposition := rec.Header().Get("Content-Disposition"); disposition != `attachment; filename="Talk.mp4"` {
+				t.Errorf("GET %s: unexpected Content-Disposition %q", test.path, disposition)
+			}
+		}
+	}
+}
+
+func TestServeFile_Range(t *testing.T) {
+	setupFilesFolder(t)
+	router := setupFilesRouter()
+
+	tests := []struct {
+		rng    string
+		status int
+		body   string
+	}{
+		{"bytes=0-4", http.StatusPartialContent, "video"},
+		{"bytes=6-", http.StatusPartialContent, "data"},
+		{"bytes=100-", http.StatusRequestedRangeNotSatisfiable, ""},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest(http.MethodGet, "/files/Channel%2F2024%2FTalk.mp4", nil)
+		req.Header.Set("Range", test.rng)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("Range %s: expected %d, got %d", test.rng, test.status, rec.Code)
+		}
+		if test.body != "" && rec.Body.String() != test.body {
+			t.Errorf("Range %s: expected %q, got %q", test.rng, test.body, rec.Body.String())
+		}
+	}
+}
+
+// listing is the response of GET /files
+type listing struct {
+	Path        string `json:"path"`
+	Breadcrumbs []struct {
+		Name string `json:"name"`
+		Path string `json:"path"`
+	} `json:"breadcrumbs"`
+	Folders []struct {
+		Name      string `json:"name"`
+		Path      string `json:"path"`
+		BrowseURL string `json:"browseUrl"`
+	} `json:"folders"`
+	Files []fileEntry `json:"files"`
+	Count int         `json:"count"`
+}
+
+func (l listing) folderNames() []string {
+	var names []string
+	for _, folder := range l.Folders {
+		names = append(names, folder.Name)
+	}
+	return names
+}
+
+func (l listing) fileNames() []string {
+	var names []string
+	for _, file := range l.Files {
+		names = append(names, file.Name)
+	}
+	return names
+}
+
+func TestListFiles_Folders(t *testing.T) {
+	setupFilesFolder(t)
+	router := setupFilesRouter()
+
+	get := func(query string) (int, listing) {
+		req, _ := http.NewRequest(http.MethodGet, "/files"+query, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var result listing
+		json.Unmarshal(rec.Body.Bytes(), &result)
+		return rec.Code, result
+	}
+
+	status, root := get("")
+	if status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	// The staging folder and the links leading outside are left out
+	if folders := root.folderNames(); !slices.Equal(folders, []string{"Alias", "Channel"}) {
+		t.Errorf("unexpected folders %v", folders)
+	}
+	if files := root.fileNames(); !slices.Equal(files, []string{"Top.mp3"}) {
+		t.Errorf("unexpected files %v", files)
+	}
+	if len(root.Breadcrumbs) != 1 || root.Breadcrumbs[0].Name != "Downloads" || root.Breadcrumbs[0].Path != "" {
+		t.Errorf("unexpected breadcrumbs %+v", root.Breadcrumbs)
+	}
+
+	status, nested := get("?path=Channel/2024")
+	if status != http.StatusOK || nested.Path != "Channel/2024" {
+		t.Fatalf("expected 200 for Channel/2024, got %d %q", status, nested.Path)
+	}
+	if files := nested.fileNames(); !slices.Equal(files, []string{"Channel/2024/Talk.mp4"}) {
+		t.Errorf("unexpected files %v", files)
+	}
+	if len(nested.Files) == 1 && nested.Files[0].DownloadURL != "/files/Channel%2F2024%2FTalk.mp4" {
+		t.Errorf("unexpected download URL %q", nested.Files[0].DownloadURL)
+	}
+	var crumbs []string
+	for _, crumb := range nested.Breadcrumbs {
+		crumbs = append(crumbs, crumb.Name+"="+crumb.Path)
+	}
+	if !slices.Equal(crumbs, []string{"Downloads=", "Channel=Channel", "2024=Channel/2024"}) {
+		t.Errorf("unexpected breadcrumbs %v", crumbs)
+	}
+
+	status, channel := get("?path=Channel")
+	if status != http.StatusOK || len(channel.Folders) != 1 || channel.Folders[0].BrowseURL != "/files?path=Channel%2F2024" {
+		t.Errorf("unexpected folders %+v", channel.Folders)
+	}
+
+	status, all := get("?path=Channel&recursive=true")
+	if files := all.fileNames(); status != http.StatusOK || !slices.Equal(files, []string{"Channel/2024/Talk.mp4", "Channel/notes.txt"}) {
+		t.Errorf("unexpected recursive files %v", files)
+	}
+
+	tests := []struct {
+		query  string
+		status int
+	}{
+		{"?path=Missing", http.StatusNotFound},
+		{"?path=Top.mp3", http.StatusNotFound},
+		{"?path=..", http.StatusBadRequest},
+		{"?path=Channel/../..", http.StatusBadRequest},
+		{"?path=.staging-abc", http.StatusBadRequest},
+		{"?path=Escape", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		if status, _ := get(test.query); status != test.status {
+			t.Errorf("GET /files%s: expected %d, got %d", test.query, test.status, status)
+		}
+	}
+}
+
+func TestCreateFolder(t *testing.T) {
+	folder, outside := setupFilesFolder(t)
+	router := setupFilesRouter()
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"Music/Live", http.StatusCreated},
+		{"Music/Live", http.StatusCreated},
+		{"Alias/New", http.StatusCreated},
+		{"Top.mp3", http.StatusConflict},
+		{"", http.StatusBadRequest},
+		{"../Evil", http.StatusBadRequest},
+		{"/tmp/Evil", http.StatusBadRequest},
+		{".hidden", http.StatusBadRequest},
+		{"Escape/Evil", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		body, _ := json.Marshal(FolderRequest{Path: test.path})
+		req, _ := http.NewRequest(http.MethodPost, "/folders", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("POST /folders %q: expected %d, got %d: %s", test.path, test.status, rec.Code, rec.Body.String())
+		}
+	}
+
+	for _, dir := range []string{"Music/Live", "Channel/New"} {
+		if info, err := os.Stat(filepath.Join(folder, dir)); err != nil || !info.IsDir() {
+			t.Errorf("expected %s to be created", dir)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outside, "Evil")); !os.IsNotExist(err) {
+		t.Error("expected nothing to be created outside the download folder")
+	}
+}
+
+func TestDeleteFolder(t *testing.T) {
+	folder, outside := setupFilesFolder(t)
+	router := setupFilesRouter()
+	os.MkdirAll(filepath.Join(folder, "Empty"), 0o755)
+	// A link inside the deleted folder must not take its target along
+	os.Symlink(outside, filepath.Join(folder, "Channel", "Link"))
+
+	tests := []struct {
+		query  string
+		status int
+	}{
+		{"?path=Empty", http.StatusOK},
+		{"?path=Channel", http.StatusConflict},
+		{"?path=Missing", http.StatusNotFound},
+		{"?path=Top.mp3", http.StatusNotFound},
+		{"", http.StatusBadRequest},
+		{"?path=..", http.StatusBadRequest},
+		{"?path=.staging-abc&recursive=true", http.StatusBadRequest},
+		{"?path=Escape&recursive=true", http.StatusBadRequest},
+		{"?path=Alias&recursive=true", http.StatusOK},
+		{"?path=Channel&recursive=true", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest(http.MethodDelete, "/folders"+test.query, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("DELETE /folders%s: expected %d, got %d: %s", test.query, test.status, rec.Code, rec.Body.String())
+		}
+	}
+
+	for _, name := range []string{"Empty", "Alias", "Channel"} {
+		if _, err := os.Lstat(filepath.Join(folder, name)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be deleted", name)
+		}
+	}
+	for _, path := range []string{filepath.Join(outside, "secret.txt"), filepath.Join(folder, ".staging-abc", "Partial.mp4"), filepath.Join(folder, "Top.mp3")} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected %s to be kept", path)
+		}
+	}
+}
@@ -1,11 +1,25 @@
 package handlers
 
 import (
+	"downloader/health"
+	"downloader/jobs"
+	"downloader/utils"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// Readiness holds the dependency checks reported by /readyz
+var Readiness = health.NewRegistry(
+	health.BinaryCheck("yt-dlp", "--version"),
+	health.BinaryCheck("ffmpeg", "-version"),
+	health.DownloadFolderCheck(utils.GetDownloadFolder, uint64(utils.EnvInt("READY_MIN_FREE_MB", 500))<<20),
+	health.WorkerPoolCheck(jobs.Default, utils.EnvInt("READY_MAX_QUEUED", 20)),
+)
+
+var startedAt = time.Now()
+
 func HealthCheck(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -13,3 +27,23 @@ func HealthCheck(c *gin.Context) {
 		"message": "The server was successfully connected",
 	})
 }
+
+// Liveness reports that the process is up and serving requests. It does not
+// check dependencies, so a missing binary never gets the process restarted.
+func Liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":        health.StatusOK,
+		"uptimeSeconds": int64(time.Since(startedAt).Seconds()),
+	})
+}
+
+// Ready runs every readiness check and returns 503 if any of them fails
+func Ready(c *gin.Context) {
+	report := Readiness.Run(c.Request.Context(), 5*time.Second)
+
+	status := http.StatusOK
+	if report.Status == health.StatusFail {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
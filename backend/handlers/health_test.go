@@ -1,8 +1,11 @@
 package handlers
 
 import (
+	"context"
+	"downloader/health"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
@@ -31,3 +34,59 @@ func TestHealthCheck(t *testing.T) {
 		t.Errorf("expected body %s, got %s", expectedBody, rec.Body.String())
 	}
 }
+
+func TestLiveness(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.GET("/healthz", Liveness)
+
+	req, _ := http.NewRequest(http.MethodGet, "/healthz", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", rec.Code)
+	}
+}
+
+func TestReady(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	original := Readiness
+	defer func() { Readiness = original }()
+
+	check := func(name string, status health.Status) health.Check {
+		return health.Check{Name: name, Run: func(ctx context.Context) health.Result {
+			return health.Result{Status: status, Message: name + " " + string(status)}
+		}}
+	}
+
+	tests := []struct {
+		name           string
+		registry       *health.Registry
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"ready", health.NewRegistry(check("yt-dlp", health.StatusOK)), http.StatusOK, `"status":"ok"`},
+		{"degraded", health.NewRegistry(check("worker_pool", health.StatusWarn)), http.StatusOK, `"status":"warn"`},
+		{"not ready", health.NewRegistry(check("yt-dlp", health.StatusOK), check("ffmpeg", health.StatusFail)), http.StatusServiceUnavailable, `"message":"ffmpeg fail"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Readiness = tt.registry
+			router := gin.Default()
+			router.GET("/readyz", Ready)
+
+			req, _ := http.NewRequest(http.MethodGet, "/readyz", nil)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.expectedBody) {
+				t.Errorf("expected body to contain %s, got %s", tt.expectedBody, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"downloader/jobs"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListJobs returns recent and in-flight download jobs, newest first
+func ListJobs(c *gin.Context) {
+	list := jobs.Default.List()
+	for i := range list {
+		list[i] = list[i].Redacted()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"jobs":  list,
+		"count": len(list),
+	})
+}
+
+// GetJob returns a single download job
+func GetJob(c *gin.Context) {
+	job, ok := jobs.Default.Get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job.Redacted())
+}
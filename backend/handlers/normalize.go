@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"downloader/ffmpeg"
+	"downloader/jobs"
+	"downloader/library"
+	"downloader/logging"
+	"downloader/ytdlp"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NormalizeFile normalizes the loudness of a file already in the download
+// folder, replacing it, and records the measurements with its metadata. It
+// runs as a job, waiting for a worker like downloads do.
+func NormalizeFile(c *gin.Context) {
+	filename, ok := fileParam(c)
+	if !ok {
+		return
+	}
+
+	var settings ytdlp.LoudnessSettings
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&settings); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+	if err := settings.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	folder := jobs.Default.Folder()
+	if _, err := os.Stat(filepath.Join(folder, filename)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if !library.CanNormalize(filename) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only audio and video files can be normalized"})
+		return
+	}
+	if !acceptingJobs(c) {
+		return
+	}
+
+	job := jobs.NewJob(c.Request.Context(), "", ytdlp.Options{Normalize: true, LoudnessSettings: settings}, logging.User(c))
+	job.Normalizes = filename
+	err := jobs.Default.Run(c.Request.Context(), job, nil)
+	switch {
+	case errors.Is(err, jobs.ErrShuttingDown), errors.Is(err, jobs.ErrInterrupted):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error(), "jobId": job.ID})
+		return
+	case errors.Is(err, ffmpeg.ErrSilent):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "jobId": job.ID})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Normalization failed", "jobId": job.ID})
+		return
+	}
+
+	md, _ := jobs.Default.Library.Get(filename)
+	c.JSON(http.StatusOK, gin.H{"jobId": job.ID, "filename": filename, "loudness": md.Loudness})
+}
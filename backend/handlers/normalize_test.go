@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"bytes"
+	"downloader/jobs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNormalizeFile_Rejects(t *testing.T) {
+	folder := t.TempDir()
+	os.WriteFile(filepath.Join(folder, "notes.txt"), []byte("text"), 0o644)
+	os.WriteFile(filepath.Join(folder, "talk.mp3"), []byte("audio"), 0o644)
+
+	original := jobs.Default.Folder
+	jobs.Default.Folder = func() string { return folder }
+	t.Cleanup(func() { jobs.Default.Folder = original })
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/files/:filename/normalize", NormalizeFile)
+
+	tests := []struct {
+		name     string
+		file     string
+		body     string
+		expected int
+	}{
+		{"missing file", "missing.mp3", "", http.StatusNotFound},
+		{"not media", "notes.txt", "", http.StatusBadRequest},
+		{"target out of range", "talk.mp3", `{"targetLufs": -2}`, http.StatusBadRequest},
+		{"true peak out of range", "talk.mp3", `{"truePeak": 3}`, http.StatusBadRequest},
+		{"invalid body", "talk.mp3", `{"targetLufs": "loud"}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPost, "/files/"+tt.file+"/normalize", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expected {
+				t.Errorf("expected status %d, got %d: %s", tt.expected, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
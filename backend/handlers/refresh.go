@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"downloader/jobs"
+	"downloader/logging"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RefreshFile downloads a file again from the source recorded with it and
+// replaces it once the new download is complete. The body may override any of
+// the recorded download options, for example a higher resolution that has
+// become available; the rest are kept.
+func RefreshFile(c *gin.Context) {
+	filename, ok := fileParam(c)
+	if !ok {
+		return
+	}
+
+	folder := jobs.Default.Folder()
+	if _, err := os.Stat(filepath.Join(folder, filename)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	md, ok := jobs.Default.Library.Get(filename)
+	if !ok || md.Source == nil || md.Source.URL == "" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "No source is recorded for this file"})
+		return
+	}
+
+	// Fields in the body replace the recorded ones
+	opts := md.Source.Options
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&opts); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+	if err := opts.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !enforceURLPolicy(c, md.Source.URL) || !acceptingJobs(c) {
+		return
+	}
+
+	job := jobs.NewJob(c.Request.Context(), md.Source.URL, opts, logging.User(c))
+	job.Replaces = filename
+	runJob(c, job)
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"bytes"
+	"downloader/jobs"
+	"downloader/library"
+	"downloader/ytdlp"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRefreshFile_Rejects(t *testing.T) {
+	folder := t.TempDir()
+	os.WriteFile(filepath.Join(folder, "unknown.mp4"), []byte("video"), 0o644)
+	os.WriteFile(filepath.Join(folder, "talk.mp3"), []byte("audio"), 0o644)
+
+	original := jobs.Default.Folder
+	jobs.Default.Folder = func() string { return folder }
+	t.Cleanup(func() { jobs.Default.Folder = original })
+
+	jobs.Default.Library.Update("talk.mp3", func(md *library.Metadata) {
+		md.Source = &library.Source{URL: "https://www.youtube.com/watch?v=abc", Options: ytdlp.Options{Format: "audio"}}
+	})
+	t.Cleanup(func() { jobs.Default.Library.Remove("talk.mp3") })
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/files/:filename/refresh", RefreshFile)
+
+	tests := []struct {
+		name     string
+		file     string
+		body     string
+		expected int
+	}{
+		{"missing file", "missing.mp4", "", http.StatusNotFound},
+		{"no source", "unknown.mp4", "", http.StatusUnprocessableEntity},
+		{"invalid body", "talk.mp3", `{"format": 1}`, http.StatusBadRequest},
+		{"invalid override", "talk.mp3", `{"audioCodec": "wma"}`, http.StatusBadRequest},
+		{"override conflicts with recorded options", "talk.mp3", `{"preset": "small"}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPost, "/files/"+tt.file+"/refresh", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expected {
+				t.Errorf("expected status %d, got %d: %s", tt.expected, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
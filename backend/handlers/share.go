@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"downloader/logging"
+	"downloader/shares"
+	"downloader/utils"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ShareRequest is the body of POST /files/:filename/share
+type ShareRequest struct {
+	ExpiresIn    string `json:"expiresIn"`    // Go duration such as "72h"; SHARE_DEFAULT_TTL if empty
+	MaxDownloads int    `json:"maxDownloads"` // 0 for no limit
+	Password     string `json:"password"`     // empty for none
+}
+
+// shareLink is a share link as the API reports it, without the password hash
+type shareLink struct {
+	ID                string    `json:"id"`
+	File              string    `json:"file"`
+	URL               string    `json:"url"`
+	CreatedBy         string    `json:"createdBy"`
+	CreatedAt         time.Time `json:"createdAt"`
+	ExpiresAt         time.Time `json:"expiresAt"`
+	MaxDownloads      int       `json:"maxDownloads,omitempty"`
+	Downloads         int       `json:"downloads"`
+	PasswordProtected bool      `json:"passwordProtected"`
+}
+
+func newShareLink(c *gin.Context, share shares.Share) shareLink {
+	return shareLink{
+		ID:                share.ID,
+		File:              share.File,
+		URL:               publicURL(c) + shares.Default.Path(share),
+		CreatedBy:         share.CreatedBy,
+		CreatedAt:         share.CreatedAt,
+		ExpiresAt:         share.ExpiresAt,
+		MaxDownloads:      share.MaxDownloads,
+		Downloads:         share.Downloads,
+		PasswordProtected: share.Protected(),
+	}
+}
+
+// publicURL returns PUBLIC_URL, or the scheme and host the request was sent
+// to, for links handed to people outside
+func publicURL(c *gin.Context) string {
+	if base := os.Getenv("PUBLIC_URL"); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto == "https" || proto == "http" {
+		scheme = proto
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// CreateShare creates a signed link to a downloaded file that works without
+// API access until it expires or reaches its download limit
+func CreateShare(c *gin.Context) {
+	filename, ok := fileParam(c)
+	if !ok {
+		return
+	}
+	var req ShareRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+
+	ttl := utils.EnvDuration("SHARE_DEFAULT_TTL", 24*time.Hour)
+	if req.ExpiresIn != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.ExpiresIn); err != nil || ttl <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expiresIn must be a positive duration such as \"72h\""})
+			return
+		}
+	}
+	if maxTTL := utils.EnvDuration("SHARE_MAX_TTL", 30*24*time.Hour); ttl > maxTTL {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expiresIn may be at most " + maxTTL.String()})
+		return
+	}
+	if req.MaxDownloads < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "maxDownloads must not be negative"})
+		return
+	}
+
+	if stat, err := os.Stat(filepath.Join(utils.GetDownloadFolder(), filepath.FromSlash(filename))); err != nil || !stat.Mode().IsRegular() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	logger := logging.FromContext(c.Request.Context()).With("file", filename)
+	share, err := shares.Default.Create(filename, logging.User(c), ttl, req.MaxDownloads, req.Password)
+	if err != nil {
+		logger.Error("failed to save share link", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create share link"})
+		return
+	}
+	logger.Info("created share link", "share_id", share.ID, "expires_at", share.ExpiresAt, "max_downloads", share.MaxDownloads, "password", share.Protected())
+	c.JSON(http.StatusCreated, newShareLink(c, share))
+}
+
+// ListShares returns the share links that can still be used
+func ListShares(c *gin.Context) {
+	list, err := shares.Default.List()
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Warn("failed to save pruned share links", "error", err)
+	}
+	links := make([]shareLink, 0, len(list))
+	for _, share := range list {
+		links = append(links, newShareLink(c, share))
+	}
+	c.JSON(http.StatusOK, gin.H{"shares": links, "count": len(links)})
+}
+
+// RevokeShare makes a share link stop working
+func RevokeShare(c *gin.Context) {
+	id := c.Param("id")
+	err := shares.Default.Revoke(id)
+	switch {
+	case errors.Is(err, shares.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		return
+	case err != nil:
+		logging.FromContext(c.Request.Context()).Error("failed to save revoked share link", "share_id", id, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke share link"})
+		return
+	}
+	logging.FromContext(c.Request.Context()).Info("revoked share link", "share_id", id)
+	c.JSON(http.StatusOK, gin.H{"id": id, "revoked": true})
+}
+
+// shareClaimWindow is how long a download of a share link may be continued
+// with range requests without counting again
+const shareClaimWindow = time.Hour
+
+// GetShared serves the file of a share link to anyone holding the link. A
+// protected link takes its password as the password of HTTP Basic
+// authentication, so browsers ask for it, or in X-Share-Password. Every GET
+// counts as a download, whatever range it asks for, unless it carries the
+// claim token an earlier one returned in the share_claim cookie and the
+// X-Share-Claim header. A token continues a download once and is replaced by
+// the next in the response. HEAD requests send no content and do not count.
+func GetShared(c *gin.Context) {
+	password := c.GetHeader("X-Share-Password")
+	if _, basic, ok := c.Request.BasicAuth(); ok {
+		password = basic
+	}
+	claim := c.GetHeader("X-Share-Claim")
+	if cookie, err := c.Cookie("share_claim"); claim == "" && err == nil {
+		claim = cookie
+	}
+
+	share, err := shares.Default.Verify(c.Param("id"), c.Query("expires"), c.Query("signature"), password)
+	claimed := false
+	if err == nil || errors.Is(err, shares.ErrExhausted) {
+		if c.Request.Method == http.MethodGet {
+			var next string
+			var until time.Time
+			if next, until, claimed = shares.Default.Redeem(share, claim); claimed {
+				setShareClaim(c, share, next, until)
+			}
+		} else {
+			claimed = shares.Default.Claimed(share, claim)
+		}
+	}
+	switch {
+	case claimed:
+	case errors.Is(err, shares.ErrPassword):
+		c.Header("WWW-Authenticate", `Basic realm="Shared file", charset="UTF-8"`)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Password required"})
+		return
+	case errors.Is(err, shares.ErrExpired), errors.Is(err, shares.ErrExhausted):
+		c.JSON(http.StatusGone, gin.H{"error": "Share link is no longer valid"})
+		return
+	case err != nil:
+		// Unknown and forged links look the same
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		return
+	}
+
+	logger := logging.FromContext(c.Request.Context()).With("share_id", share.ID, "file", share.File)
+	if _, err := utils.ResolvePath(utils.GetDownloadFolder(), share.File); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	if c.Request.Method == http.MethodGet && !claimed {
+		if share, err = shares.Default.Claim(share.ID); errors.Is(err, shares.ErrExhausted) {
+			c.JSON(http.StatusGone, gin.H{"error": "Share link is no longer valid"})
+			return
+		} else if err != nil {
+			logger.Warn("failed to count share link download", "error", err)
+		} else {
+			until := time.Now().Add(shareClaimWindow)
+			if until.After(share.ExpiresAt) {
+				until = share.ExpiresAt
+			}
+			setShareClaim(c, share, shares.Default.ClaimToken(share, until), until)
+		}
+		logger.Info("share link used", "downloads", share.Downloads)
+	}
+	serveFile(c, share.File)
+}
+
+// setShareClaim returns a claim token valid until the given time in the
+// X-Share-Claim header and the share_claim cookie
+func setShareClaim(c *gin.Context, share shares.Share, token string, until time.Time) {
+	c.Header("X-Share-Claim", token)
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     "share_claim",
+		Value:    token,
+		Path:     "/shared/" + share.ID,
+		Expires:  until,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"bytes"
+	"downloader/shares"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupShareRouter(t *testing.T) *gin.Engine {
+	setupFilesFolder(t)
+	previous := shares.Default
+	shares.Default = shares.NewStore()
+	t.Cleanup(func() { shares.Default = previous })
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.UseRawPath = true
+	router.POST("/files/:filename/share", CreateShare)
+	router.GET("/shares", ListShares)
+	router.DELETE("/shares/:id", RevokeShare)
+	router.GET("/shared/:id", GetShared)
+	router.HEAD("/shared/:id", GetShared)
+	return router
+}
+
+// createShare creates a share link to file and returns the response
+func createShare(router *gin.Engine, file, body string) (*httptest.ResponseRecorder, shareLink) {
+	req, _ := http.NewRequest(http.MethodPost, "/files/"+file+"/share", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	var link shareLink
+	json.Unmarshal(rec.Body.Bytes(), &link)
+	return rec, link
+}
+
+// getShared requests a share link's URL with the given headers
+func getShared(t *testing.T, router *gin.Engine, method, link string, headers map[string]string) *httptest.ResponseRecorder {
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("invalid share URL %q: %v", link, err)
+	}
+	req, _ := http.NewRequest(method, u.RequestURI(), nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCreateShare(t *testing.T) {
+	router := setupShareRouter(t)
+	t.Setenv("SHARE_MAX_TTL", "48h")
+
+	tests := []struct {
+		name   string
+		file   string
+		body   string
+		status int
+	}{
+		{"default expiry", "Top.mp3", "", http.StatusCreated},
+		{"nested file", "Channel%2F2024%2FTalk.mp4", `{"expiresIn":"2h","maxDownloads":3,"password":"hunter2"}`, http.StatusCreated},
+		{"missing file", "Missing.mp4", "", http.StatusNotFound},
+		{"folder", "Channel", "", http.StatusNotFound},
+		{"traversal", "..%2Foutside%2Fsecret.txt", "", http.StatusBadRequest},
+		{"link outside", "secret.mp4", "", http.StatusBadRequest},
+		{"invalid expiry", "Top.mp3", `{"expiresIn":"soon"}`, http.StatusBadRequest},
+		{"expiry over maximum", "Top.mp3", `{"expiresIn":"72h"}`, http.StatusBadRequest},
+		{"negative limit", "Top.mp3", `{"maxDownloads":-1}`, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		rec, link := createShare(router, test.file, test.body)
+		if rec.Code != test.status {
+			t.Errorf("%s: expected %d, got %d: %s", test.name, test.status, rec.Code, rec.Body.String())
+			continue
+		}
+		if rec.Code == http.StatusCreated && (link.ID == "" || link.URL == "") {
+			t.Errorf("%s: expected a link, got %s", test.name, rec.Body.String())
+		}
+	}
+
+	_, link := createShare(router, "Channel%2F2024%2FTalk.mp4", `{"password":"hunter2"}`)
+	if link.File != "Channel/2024/Talk.mp4" || !link.PasswordProtected {
+		t.Errorf("unexpected link %+v", link)
+	}
+	if bytes.Contains([]byte(link.URL), []byte("hunter2")) {
+		t.Error("expected the password to stay out of the link")
+	}
+}
+
+func TestGetShared(t *testing.T) {
+	router := setupShareRouter(t)
+	_, link := createShare(router, "Top.mp3", `{"maxDownloads":2}`)
+
+	// A download counts once; continuing it with its claim token does not
+	rec := getShared(t, router, http.MethodGet, link.URL, map[string]string{"Range": "bytes=0-4"})
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "audio" {
+		t.Fatalf("expected the first 5 bytes, got %d: %q", rec.Code, rec.Body.String())
+	}
+	claim := rec.Header().Get("X-Share-Claim")
+	if claim == "" || !strings.Contains(rec.Header().Get("Set-Cookie"), "share_claim="+claim) {
+		t.Fatalf("expected a claim token in the header and a cookie, got %v", rec.Header())
+	}
+	rec = getShared(t, router, http.MethodGet, link.URL, map[string]string{"Range": "bytes=5-", "X-Share-Claim": claim})
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != " data" {
+		t.Fatalf("expected the remaining bytes, got %d: %q", rec.Code, rec.Body.String())
+	}
+	// The token is used up and replaced
+	if next := rec.Header().Get("X-Share-Claim"); next == "" || next == claim {
+		t.Errorf("expected a new claim token, got %q", next)
+	}
+	if rec := getShared(t, router, http.MethodHead, link.URL, nil); rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("HEAD: expected 200 without content, got %d: %q", rec.Code, rec.Body.String())
+	}
+
+	rec = getShared(t, router, http.MethodGet, link.URL, nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "audio data" {
+		t.Fatalf("expected the whole file, got %d: %q", rec.Code, rec.Body.String())
+	}
+	last := rec.Header().Get("X-Share-Claim")
+	if rec := getShared(t, router, http.MethodGet, link.URL, nil); rec.Code != http.StatusGone {
+		t.Errorf("expected 410 after the download limit, got %d", rec.Code)
+	}
+	// The last download can still be resumed, from the cookie too
+	rec = getShared(t, router, http.MethodGet, link.URL, map[string]string{"Range": "bytes=6-", "Cookie": "share_claim=" + last})
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "data" {
+		t.Errorf("expected the claimed download resumed, got %d: %q", rec.Code, rec.Body.String())
+	}
+	// but not twice with the same token
+	rec = getShared(t, router, http.MethodGet, link.URL, map[string]string{"Range": "bytes=6-", "Cookie": "share_claim=" + last})
+	if rec.Code != http.StatusGone {
+		t.Errorf("expected a used claim token rejected, got %d", rec.Code)
+	}
+
+	// Forged links
+	u, _ := url.Parse(link.URL)
+	query := u.Query()
+	query.Set("signature", "forged")
+	u.RawQuery = query.Encode()
+	if rec := getShared(t, router, http.MethodGet, u.String(), nil); rec.Code != http.StatusNotFound {
+		t.Errorf("forged signature: expected 404, got %d", rec.Code)
+	}
+	if rec := getShared(t, router, http.MethodGet, "/shared/0123456789abcdef", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown link: expected 404, got %d", rec.Code)
+	}
+}
+
+func TestGetShared_RangesCount(t *testing.T) {
+	tests := []struct {
+		name  string
+		rng   string
+		claim string
+	}{
+		{"suffix range", "bytes=-999999999", ""},
+		{"multiple ranges", "bytes=1-,0-0", ""},
+		{"range past the start", "bytes=1-", ""},
+		{"forged claim", "bytes=5-", "1.9999999999.forged"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router := setupShareRouter(t)
+			_, link := createShare(router, "Top.mp3", `{"maxDownloads":1}`)
+
+			headers := map[string]string{"Range": test.rng}
+			if test.claim != "" {
+				headers["X-Share-Claim"] = test.claim
+			}
+			if rec := getShared(t, router, http.MethodGet, link.URL, headers); rec.Code != http.StatusPartialContent {
+				t.Fatalf("expected 206, got %d", rec.Code)
+			}
+			if rec := getShared(t, router, http.MethodGet, link.URL, headers); rec.Code != http.StatusGone {
+				t.Errorf("expected the request counted and the link used up, got %d", rec.Code)
+			}
+		})
+	}
+}
+
+func TestGetShared_Password(t *testing.T) {
+	router := setupShareRouter(t)
+	_, link := createShare(router, "Channel%2F2024%2FTalk.mp4", `{"password":"hunter2"}`)
+
+	rec := getShared(t, router, http.MethodGet, link.URL, nil)
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("no password: expected 401 with a challenge, got %d", rec.Code)
+	}
+	if rec := getShared(t, router, http.MethodGet, link.URL, map[string]string{"X-Share-Password": "wrong"}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: expected 401, got %d", rec.Code)
+	}
+	if rec := getShared(t, router, http.MethodGet, link.URL, map[string]string{"X-Share-Password": "hunter2"}); rec.Code != http.StatusOK || rec.Body.String() != "video data" {
+		t.Errorf("header password: expected the file, got %d", rec.Code)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth("", "hunter2")
+	rec = getShared(t, router, http.MethodGet, link.URL, map[string]string{"Authorization": req.Header.Get("Authorization")})
+	if rec.Code != http.StatusOK {
+		t.Errorf("basic auth password: expected 200, got %d", rec.Code)
+	}
+}
+
+func TestListAndRevokeShares(t *testing.T) {
+	router := setupShareRouter(t)
+	_, first := createShare(router, "Top.mp3", "")
+	_, second := createShare(router, "Channel%2F2024%2FTalk.mp4", `{"password":"hunter2"}`)
+
+	list := func() []shareLink {
+		req, _ := http.NewRequest(http.MethodGet, "/shares", nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var resp struct {
+			Shares []shareLink `json:"shares"`
+			Count  int         `json:"count"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if bytes.Contains(rec.Body.Bytes(), []byte("passwordHash")) {
+			t.Error("expected password hashes to stay private")
+		}
+		return resp.Shares
+	}
+	if links := list(); len(links) != 2 {
+		t.Fatalf("expected 2 links, got %+v", links)
+	}
+
+	revoke := func(id string) int {
+		req, _ := http.NewRequest(http.MethodDelete, "/shares/"+id, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if status := revoke(first.ID); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if status := revoke(first.ID); status != http.StatusNotFound {
+		t.Errorf("revoking again: expected 404, got %d", status)
+	}
+	if links := list(); len(links) != 1 || links[0].ID != second.ID {
+		t.Errorf("expected only the second link left, got %+v", links)
+	}
+	if rec := getShared(t, router, http.MethodGet, first.URL, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("revoked link: expected 404, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"downloader/jobs"
+	"downloader/logging"
+	"downloader/subscriptions"
+	"downloader/utils"
+	"downloader/ytdlp"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SubscriptionRequest is the body of POST /subscriptions and PUT
+// /subscriptions/:id. PUT only changes the fields given.
+type SubscriptionRequest struct {
+	Name     string                `json:"name"`
+	URL      string                `json:"url"`
+	Options  ytdlp.Options         `json:"options"`
+	Interval string                `json:"interval"`
+	Cron     string                `json:"cron"`
+	Filters  subscriptions.Filters `json:"filters"`
+	MaxItems int                   `json:"maxItems"`
+	Enabled  bool                  `json:"enabled"` // true if left out of POST
+}
+
+// CreateSubscription subscribes to a channel or playlist, whose new entries
+// are then downloaded on its schedule
+func CreateSubscription(c *gin.Context) {
+	req := SubscriptionRequest{Enabled: true}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	sub, ok := checkSubscription(c, req)
+	if !ok {
+		return
+	}
+	sub.User = logging.User(c)
+
+	logger := logging.FromContext(c.Request.Context())
+	sub, err := subscriptions.Default.Store.Create(sub)
+	if err != nil {
+		logger.Error("failed to save subscription", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save subscription"})
+		return
+	}
+	logger.Info("created subscription", "subscription_id", sub.ID, "url", sub.URL, "next_run_at", sub.NextRunAt)
+	c.JSON(http.StatusCreated, sub)
+}
+
+// ListSubscriptions returns every subscription with its last run
+func ListSubscriptions(c *gin.Context) {
+	list := subscriptions.Default.Store.List()
+	c.JSON(http.StatusOK, gin.H{"subscriptions": list, "count": len(list)})
+}
+
+// GetSubscription returns a single subscription
+func GetSubscription(c *gin.Context) {
+	sub, ok := subscriptions.Default.Store.Get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+		return
+	}
+	c.JSON(http.StatusOK, sub)
+}
+
+// UpdateSubscription changes the settings of a subscription and schedules
+// its next run afresh
+func UpdateSubscription(c *gin.Context) {
+	current, ok := subscriptions.Default.Store.Get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+		return
+	}
+	req := SubscriptionRequest{
+		Name:     current.Name,
+		URL:      current.URL,
+		Options:  current.Options,
+		Interval: current.Interval,
+		Cron:     current.Cron,
+		Filters:  current.Filters,
+		MaxItems: current.MaxItems,
+		Enabled:  current.Enabled,
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	sub, ok := checkSubscription(c, req)
+	if !ok {
+		return
+	}
+	sub.ID = current.ID
+
+	logger := logging.FromContext(c.Request.Context()).With("subscription_id", sub.ID)
+	sub, err := subscriptions.Default.Store.Update(sub)
+	switch {
+	case errors.Is(err, subscriptions.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+		return
+	case err != nil:
+		logger.Error("failed to save subscription", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save subscription"})
+		return
+	}
+	logger.Info("updated subscription", "enabled", sub.Enabled, "next_run_at", sub.NextRunAt)
+	c.JSON(http.StatusOK, sub)
+}
+
+// DeleteSubscription removes a subscription and its runs. Downloads it has
+// started go on.
+func DeleteSubscription(c *gin.Context) {
+	id := c.Param("id")
+	err := subscriptions.Default.Store.Delete(id)
+	switch {
+	case errors.Is(err, subscriptions.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+		return
+	case err != nil:
+		logging.FromContext(c.Request.Context()).Error("failed to save deleted subscription", "subscription_id", id, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete subscription"})
+		return
+	}
+	logging.FromContext(c.Request.Context()).Info("deleted subscription", "subscription_id", id)
+	c.JSON(http.StatusOK, gin.H{"id": id, "deleted": true})
+}
+
+// SyncSubscription starts a run of a subscription now, whether or not it is
+// enabled, and returns the run as it starts
+func SyncSubscription(c *gin.Context) {
+	if !acceptingJobs(c) {
+		return
+	}
+	run, err := subscriptions.Default.Sync(c.Param("id"))
+	switch {
+	case errors.Is(err, subscriptions.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+		return
+	case errors.Is(err, subscriptions.ErrSyncing):
+		c.JSON(http.StatusConflict, gin.H{"error": "Subscription is already syncing"})
+		return
+	case errors.Is(err, jobs.ErrShuttingDown):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sync"})
+		return
+	}
+	c.JSON(http.StatusAccepted, run)
+}
+
+// ListSubscriptionRuns returns the latest runs of a subscription, newest first
+func ListSubscriptionRuns(c *gin.Context) {
+	runs, ok := subscriptions.Default.Store.Runs(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+		return
+	}
+	if runs == nil {
+		runs = []subscriptions.Run{}
+	}
+	c.JSON(http.StatusOK, gin.H{"runs": runs, "count": len(runs)})
+}
+
+// checkSubscription validates req and returns the subscription it describes,
+// or writes a 400 or 403 and returns false
+func checkSubscription(c *gin.Context, req SubscriptionRequest) (subscriptions.Subscription, bool) {
+	if !utils.IsValidURL(req.URL) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid URL"})
+		return subscriptions.Subscription{}, false
+	}
+	sub := subscriptions.Subscription{
+		Name:     req.Name,
+		URL:      req.URL,
+		Options:  req.Options,
+		Interval: req.Interval,
+		Cron:     req.Cron,
+		Filters:  req.Filters,
+		MaxItems: req.MaxItems,
+		Enabled:  req.Enabled,
+	}
+	if err := sub.Validate(subscriptions.Default.MinInterval); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return subscriptions.Subscription{}, false
+	}
+	if !enforceURLPolicy(c, req.URL) {
+		return subscriptions.Subscription{}, false
+	}
+	return sub, true
+}
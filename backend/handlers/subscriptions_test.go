@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"downloader/jobs"
+	"downloader/subscriptions"
+	"downloader/ytdlp"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupSubscriptionRouter swaps in a scheduler with an empty store whose
+// listings fail at once
+func setupSubscriptionRouter(t *testing.T) *gin.Engine {
+	original, originalCommand := subscriptions.Default, ytdlp.Command
+	ytdlp.Command = func(ctx context.Context, args ...string) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, os.Args[0], "-test.run=^TestBatchHelperProcess$")
+		cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1")
+		return cmd
+	}
+	scheduler := subscriptions.NewScheduler(subscriptions.NewStore(), jobs.NewManager(1))
+	scheduler.Policy = URLPolicy
+	subscriptions.Default = scheduler
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		scheduler.Shutdown(ctx)
+		subscriptions.Default, ytdlp.Command = original, originalCommand
+	})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/subscriptions", CreateSubscription)
+	router.GET("/subscriptions", ListSubscriptions)
+	router.GET("/subscriptions/:id", GetSubscription)
+	router.PUT("/subscriptions/:id", UpdateSubscription)
+	router.DELETE("/subscriptions/:id", DeleteSubscription)
+	router.POST("/subscriptions/:id/sync", SyncSubscription)
+	router.GET("/subscriptions/:id/runs", ListSubscriptionRuns)
+	return router
+}
+
+func serveSubscriptions(router *gin.Engine, method, target, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, target, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCreateSubscription(t *testing.T) {
+	router := setupSubscriptionRouter(t)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		err    string
+	}{
+		{"invalid JSON", `{"url": 5}`, http.StatusBadRequest, "Invalid request body"},
+		{"invalid URL", `{"url": "channel", "interval": "6h", "options": {"format": "audio"}}`, http.StatusBadRequest, "Invalid URL"},
+		{"no schedule", `{"url": "https://www.youtube.com/@channel", "options": {"format": "audio"}}`, http.StatusBadRequest, "either interval or cron"},
+		{"short interval", `{"url": "https://www.youtube.com/@channel", "interval": "1m", "options": {"format": "audio"}}`, http.StatusBadRequest, "at least"},
+		{"bad cron", `{"url": "https://www.youtube.com/@channel", "cron": "0 25 * * *", "options": {"format": "audio"}}`, http.StatusBadRequest, "invalid hour"},
+		{"bad filter", `{"url": "https://www.youtube.com/@channel", "interval": "6h", "options": {"format": "audio"}, "filters": {"titleMatch": "["}}`, http.StatusBadRequest, "titleMatch"},
+		{"bad options", `{"url": "https://www.youtube.com/@channel", "interval": "6h", "options": {"format": "audio", "audioCodec": "wma"}}`, http.StatusBadRequest, ""},
+		{"private URL", `{"url": "https://intranet.example/feed", "interval": "6h", "options": {"format": "audio"}}`, http.StatusForbidden, "private_address"},
+	}
+	for _, test := range tests {
+		rec := serveSubscriptions(router, http.MethodPost, "/subscriptions", test.body)
+		if rec.Code != test.status || !strings.Contains(rec.Body.String(), test.err) {
+			t.Errorf("%s: expected %d with %q, got %d: %s", test.name, test.status, test.err, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := serveSubscriptions(router, http.MethodPost, "/subscriptions", `{
+		"name": "Sessions",
+		"url": "https://www.youtube.com/@channel/videos",
+		"options": {"format": "audio", "audioCodec": "mp3"},
+		"cron": "30 6 * * *",
+		"filters": {"after": "2025-07-01", "minDuration": 60, "titleExclude": "#shorts"},
+		"maxItems": 5
+	}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var sub subscriptions.Subscription
+	json.Unmarshal(rec.Body.Bytes(), &sub)
+	if sub.ID == "" || !sub.Enabled || sub.NextRunAt.IsZero() || sub.NextRunAt.Minute() != 30 || sub.Filters.MinDuration != 60 || sub.User == "" {
+		t.Errorf("unexpected subscription %s", rec.Body.String())
+	}
+
+	rec = serveSubscriptions(router, http.MethodGet, "/subscriptions", "")
+	var list struct {
+		Subscriptions []subscriptions.Subscription `json:"subscriptions"`
+		Count         int                          `json:"count"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &list)
+	if list.Count != 1 || list.Subscriptions[0].ID != sub.ID {
+		t.Errorf("expected the subscription listed, got %s", rec.Body.String())
+	}
+}
+
+func TestUpdateAndDeleteSubscription(t *testing.T) {
+	router := setupSubscriptionRouter(t)
+	rec := serveSubscriptions(router, http.MethodPost, "/subscriptions", `{"url": "https://www.youtube.com/playlist?list=PL1", "interval": "6h", "options": {"format": "audio"}, "maxItems": 3}`)
+	var sub subscriptions.Subscription
+	json.Unmarshal(rec.Body.Bytes(), &sub)
+
+	// Fields left out keep their values
+	rec = serveSubscriptions(router, http.MethodPut, "/subscriptions/"+sub.ID, `{"enabled": false, "filters": {"titleMatch": "live"}}`)
+	var updated subscriptions.Subscription
+	json.Unmarshal(rec.Body.Bytes(), &updated)
+	if rec.Code != http.StatusOK || updated.Enabled || !updated.NextRunAt.IsZero() || updated.MaxItems != 3 || updated.Interval != "6h" || updated.Filters.TitleMatch != "live" {
+		t.Errorf("unexpected update %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := serveSubscriptions(router, http.MethodPut, "/subscriptions/"+sub.ID, `{"cron": "@daily"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for both interval and cron, got %d", rec.Code)
+	}
+	if rec := serveSubscriptions(router, http.MethodGet, "/subscriptions/"+sub.ID, ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"titleMatch":"live"`) {
+		t.Errorf("expected the update kept, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := serveSubscriptions(router, http.MethodDelete, "/subscriptions/"+sub.ID, ""); rec.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", rec.Code)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		if rec := serveSubscriptions(router, method, "/subscriptions/"+sub.ID, `{}`); rec.Code != http.StatusNotFound {
+			t.Errorf("%s after delete: expected 404, got %d", method, rec.Code)
+		}
+	}
+}
+
+func TestSyncSubscription(t *testing.T) {
+	router := setupSubscriptionRouter(t)
+	rec := serveSubscriptions(router, http.MethodPost, "/subscriptions", `{"url": "https://www.youtube.com/@channel", "interval": "6h", "options": {"format": "audio"}, "enabled": false}`)
+	var sub subscriptions.Subscription
+	json.Unmarshal(rec.Body.Bytes(), &sub)
+
+	rec = serveSubscriptions(router, http.MethodPost, "/subscriptions/"+sub.ID+"/sync", "")
+	var run subscriptions.Run
+	json.Unmarshal(rec.Body.Bytes(), &run)
+	if rec.Code != http.StatusAccepted || run.ID == "" || run.Trigger != subscriptions.TriggerManual {
+		t.Fatalf("expected 202 with the run, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// The fake listing fails, which the run records
+	deadline := time.Now().Add(10 * time.Second)
+	var runs struct {
+		Runs  []subscriptions.Run `json:"runs"`
+		Count int                 `json:"count"`
+	}
+	for {
+		rec = serveSubscriptions(router, http.MethodGet, "/subscriptions/"+sub.ID+"/runs", "")
+		json.Unmarshal(rec.Body.Bytes(), &runs)
+		if runs.Count > 0 && runs.Runs[0].Status != subscriptions.RunRunning || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if runs.Count != 1 || runs.Runs[0].ID != run.ID || runs.Runs[0].Status != subscriptions.RunFailed || runs.Runs[0].Error == "" {
+		t.Errorf("expected a failed run, got %s", rec.Body.String())
+	}
+
+	if rec := serveSubscriptions(router, http.MethodPost, "/subscriptions/missing/sync", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown subscription, got %d", rec.Code)
+	}
+	if rec := serveSubscriptions(router, http.MethodGet, "/subscriptions/missing/runs", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for the runs of an unknown subscription, got %d", rec.Code)
+	}
+}
@@ -2,11 +2,11 @@ package handlers
 
 import (
 	"context"
+	"downloader/logging"
 	"downloader/utils"
+	"downloader/ytdlp"
 	"encoding/json"
-	"log"
 	"net/http"
-	"os/exec"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -17,8 +17,7 @@ type ThumbnailRequest struct {
 }
 
 var YTDLPCommand = func(ctx context.Context, url string) ([]byte, error) {
-	cmd := exec.CommandContext(ctx, "yt-dlp", "-J", url)
-	return cmd.Output()
+	return ytdlp.Command(ctx, "-J", url).Output()
 }
 
 func GetThumbnail(c *gin.Context) {
@@ -28,12 +27,16 @@ func GetThumbnail(c *gin.Context) {
 		return
 	}
 
+	if !enforceURLPolicy(c, req.URL) {
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancel()
 
 	output, err := YTDLPCommand(ctx, req.URL)
 	if err != nil {
-		log.Printf("yt-dlp error: %v", err)
+		logging.FromContext(c.Request.Context()).Error("yt-dlp metadata lookup failed", "error", err, "url", req.URL)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch video info"})
 		return
 	}
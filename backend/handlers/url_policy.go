@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"downloader/utils"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// URLPolicy is applied to every user-supplied media URL before yt-dlp sees it
+var URLPolicy = utils.NewURLPolicyFromEnv()
+
+// enforceURLPolicy writes a 403 with the policy error code and returns false
+// when rawURL is rejected
+func enforceURLPolicy(c *gin.Context, rawURL string) bool {
+	err := URLPolicy.Check(c.Request.Context(), rawURL)
+	if err == nil {
+		return true
+	}
+
+	var policyErr *utils.PolicyError
+	if errors.As(err, &policyErr) {
+		c.JSON(http.StatusForbidden, gin.H{"error": policyErr.Error(), "code": policyErr.Code})
+		return false
+	}
+
+	c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "code": utils.PolicyInvalidURL})
+	return false
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeResolver resolves every host to a fixed public address unless listed
+type fakeResolver map[string]string
+
+func (r fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ip, ok := r[host]
+	if !ok {
+		ip = "93.184.216.34"
+	}
+	if ip == "" {
+		return nil, errors.New("no such host")
+	}
+	return []net.IPAddr{{IP: net.ParseIP(ip)}}, nil
+}
+
+func init() {
+	// Keep handler tests independent of real DNS
+	URLPolicy.Resolver = fakeResolver{"intranet.example": "10.0.0.5"}
+}
+
+func TestURLPolicyRejections(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.POST("/thumbnail", GetThumbnail)
+	router.GET("/download/stream", DownloadWithProgress)
+
+	tests := []struct {
+		name         string
+		method       string
+		target       string
+		body         string
+		expectedCode string
+	}{
+		{"Thumbnail loopback", http.MethodPost, "/thumbnail", `{"url":"http://127.0.0.1/video"}`, `"code":"private_address"`},
+		{"Thumbnail ftp", http.MethodPost, "/thumbnail", `{"url":"ftp://example.com/video"}`, `"code":"scheme_not_allowed"`},
+		{"Stream intranet", http.MethodGet, "/download/stream?format=video&url=http://intranet.example/v", "", `"code":"private_address"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("expected status 403, got %d", rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.expectedCode) {
+				t.Errorf("expected body to contain %s, got %s", tt.expectedCode, rec.Body.String())
+			}
+		})
+	}
+}
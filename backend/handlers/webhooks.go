@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"downloader/utils"
+	"downloader/webhooks"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// checkCallback writes an error and returns false unless rawURL, if given,
+// can receive a job's webhook events. Callback URLs are held to the same
+// policy as media URLs, so they cannot reach internal services.
+func checkCallback(c *gin.Context, rawURL string) bool {
+	if rawURL == "" {
+		return true
+	}
+	if !utils.IsValidURL(rawURL) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid callbackUrl"})
+		return false
+	}
+	return enforceURLPolicy(c, rawURL)
+}
+
+// ListWebhookDeliveries returns the webhook delivery log, newest first,
+// optionally only for one job or in one status
+func ListWebhookDeliveries(c *gin.Context) {
+	status := c.Query("status")
+	if status != "" && !slices.Contains([]string{webhooks.StatusPending, webhooks.StatusDelivered, webhooks.StatusFailed}, status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, delivered or failed"})
+		return
+	}
+	list := webhooks.Default.Deliveries(c.Query("jobId"), status)
+	c.JSON(http.StatusOK, gin.H{
+		"deliveries": list,
+		"count":      len(list),
+	})
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"downloader/webhooks"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDownloadVideo_CallbackURL(t *testing.T) {
+	router := setupDownloadRouter()
+
+	tests := []struct {
+		name     string
+		callback string
+		status   int
+		body     string
+	}{
+		{"not a URL", "hooks", http.StatusBadRequest, "Invalid callbackUrl"},
+		{"loopback", "http://127.0.0.1:8080/hook", http.StatusForbidden, `"code":"private_address"`},
+		{"intranet", "https://intranet.example/hook", http.StatusForbidden, `"code":"private_address"`},
+		{"ftp", "ftp://hooks.example.com/hook", http.StatusForbidden, `"code":"scheme_not_allowed"`},
+	}
+
+	for _, test := range tests {
+		body := `{"url":"https://example.com/watch","format":"video","callbackUrl":"` + test.callback + `"}`
+		req, _ := http.NewRequest(http.MethodPost, "/download", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != test.status || !strings.Contains(rec.Body.String(), test.body) {
+			t.Errorf("%s: expected %d with %s, got %d: %s", test.name, test.status, test.body, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestListWebhookDeliveries(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(webhooks.HeaderEvent) == webhooks.EventFailed {
+			w.WriteHeader(http.StatusGone)
+		}
+	}))
+	defer receiver.Close()
+
+	previous := webhooks.Default
+	webhooks.Default = webhooks.NewNotifier()
+	webhooks.Default.URLs = []string{receiver.URL}
+	t.Cleanup(func() { webhooks.Default = previous })
+
+	webhooks.Default.Notify(webhooks.EventQueued, "job1", "", nil)
+	webhooks.Default.Notify(webhooks.EventFailed, "job1", "", nil)
+	webhooks.Default.Notify(webhooks.EventQueued, "job2", "", nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	webhooks.Default.Shutdown(ctx)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/webhooks/deliveries", ListWebhookDeliveries)
+
+	tests := []struct {
+		query  string
+		status int
+		count  int
+	}{
+		{"", http.StatusOK, 3},
+		{"?jobId=job1", http.StatusOK, 2},
+		{"?jobId=job1&status=failed", http.StatusOK, 1},
+		{"?status=delivered", http.StatusOK, 2},
+		{"?jobId=missing", http.StatusOK, 0},
+		{"?status=lost", http.StatusBadRequest, 0},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest(http.MethodGet, "/webhooks/deliveries"+test.query, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%s: expected %d, got %d", test.query, test.status, rec.Code)
+			continue
+		}
+		var resp struct {
+			Deliveries []webhooks.Delivery `json:"deliveries"`
+			Count      int                 `json:"count"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if resp.Count != test.count || len(resp.Deliveries) != test.count {
+			t.Errorf("%s: expected %d deliveries, got %d", test.query, test.count, resp.Count)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// RunVersion runs a binary's version command and returns its combined output.
+// It is a variable so tests can stub out real processes.
+var RunVersion = func(ctx context.Context, name string, args ...string) (string, error) {
+	if _, err := exec.LookPath(name); err != nil {
+		return "", err
+	}
+	output, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	return string(output), err
+}
+
+// BinaryCheck fails when name is not on PATH or its version command fails,
+// and reports the first line of the version output
+func BinaryCheck(name string, versionArgs ...string) Check {
+	return Check{
+		Name: name,
+		Run: func(ctx context.Context) Result {
+			output, err := RunVersion(ctx, name, versionArgs...)
+			if err != nil {
+				return Result{Status: StatusFail, Message: fmt.Sprintf("%s is not available: %v", name, err)}
+			}
+
+			version := strings.TrimSpace(strings.SplitN(output, "\n", 2)[0])
+			return Result{Status: StatusOK, Details: map[string]any{"version": version}}
+		},
+	}
+}
+
+// DownloadFolderCheck fails when the folder returned by dir is missing, not
+// writable, or has less than minFreeBytes available
+func DownloadFolderCheck(dir func() string, minFreeBytes uint64) Check {
+	return Check{
+		Name: "download_folder",
+		Run: func(ctx context.Context) Result {
+			path := dir()
+			details := map[string]any{"path": path}
+
+			probe, err := os.CreateTemp(path, ".readyz-*")
+			if err != nil {
+				return Result{Status: StatusFail, Message: fmt.Sprintf("download folder is not writable: %v", err), Details: details}
+			}
+			probe.Close()
+			os.Remove(probe.Name())
+
+			free, err := FreeBytes(path)
+			if err != nil {
+				return Result{Status: StatusWarn, Message: fmt.Sprintf("cannot determine free space: %v", err), Details: details}
+			}
+			details["freeBytes"] = free
+			details["minFreeBytes"] = minFreeBytes
+
+			if free < minFreeBytes {
+				return Result{Status: StatusFail, Message: "not enough free space in download folder", Details: details}
+			}
+			return Result{Status: StatusOK, Details: details}
+		},
+	}
+}
+
+// Pool reports worker pool usage; *jobs.Manager satisfies it
+type Pool interface {
+	Queued() int
+	Running() int
+	Capacity() int
+}
+
+// WorkerPoolCheck warns when every worker is busy and fails once more than
+// maxQueued jobs are waiting
+func WorkerPoolCheck(pool Pool, maxQueued int) Check {
+	return Check{
+		Name: "worker_pool",
+		Run: func(ctx context.Context) Result {
+			queued, running, capacity := pool.Queued(), pool.Running(), pool.Capacity()
+			details := map[string]any{
+				"queued":    queued,
+				"running":   running,
+				"capacity":  capacity,
+				"maxQueued": maxQueued,
+			}
+
+			switch {
+			case queued > maxQueued:
+				return Result{Status: StatusFail, Message: "download queue is full", Details: details}
+			case running >= capacity:
+				return Result{Status: StatusWarn, Message: "all workers are busy", Details: details}
+			default:
+				return Result{Status: StatusOK, Details: details}
+			}
+		},
+	}
+}
+
+// Pinger is implemented by stores that can verify their own connectivity
+type Pinger interface {
+	Ping() error
+}
+
+// StoreCheck fails when store cannot be reached
+func StoreCheck(name string, store Pinger) Check {
+	return Check{
+		Name: name,
+		Run: func(ctx context.Context) Result {
+			if err := store.Ping(); err != nil {
+				return Result{Status: StatusFail, Message: fmt.Sprintf("store is unavailable: %v", err)}
+			}
+			return Result{Status: StatusOK}
+		},
+	}
+}
@@ -0,0 +1,19 @@
+//go:build windows
+
+package health
+
+import "golang.org/x/sys/windows"
+
+// FreeBytes returns the space available to the current user on the volume holding path
+func FreeBytes(path string) (uint64, error) {
+	dir, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var free uint64
+	if err := windows.GetDiskFreeSpaceEx(dir, &free, nil, nil); err != nil {
+		return 0, err
+	}
+	return free, nil
+}
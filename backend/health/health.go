@@ -0,0 +1,108 @@
+package health
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status is the outcome of a single check or of a whole report
+type Status string
+
+const (
+	StatusOK   Status = "ok"
+	StatusWarn Status = "warn" // degraded but still able to serve
+	StatusFail Status = "fail"
+)
+
+// Result is the outcome of one check
+type Result struct {
+	Name       string         `json:"name"`
+	Status     Status         `json:"status"`
+	Message    string         `json:"message,omitempty"`
+	Details    map[string]any `json:"details,omitempty"`
+	DurationMs int64          `json:"durationMs"`
+}
+
+// Check inspects one dependency
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) Result
+}
+
+// Report is the combined outcome of every registered check
+type Report struct {
+	Status Status   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+// Registry holds the checks run for a readiness report
+type Registry struct {
+	mu     sync.RWMutex
+	checks []Check
+}
+
+// NewRegistry creates a Registry with the given checks
+func NewRegistry(checks ...Check) *Registry {
+	return &Registry{checks: checks}
+}
+
+// Add registers another check
+func (r *Registry) Add(check Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks = append(r.checks, check)
+}
+
+// Run executes every check concurrently, each bounded by timeout, and returns
+// the results sorted by name. The report fails if any check fails and warns
+// if any check warns.
+func (r *Registry) Run(ctx context.Context, timeout time.Duration) Report {
+	r.mu.RLock()
+	checks := append([]Check(nil), r.checks...)
+	r.mu.RUnlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = runCheck(ctx, check, timeout)
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+
+	report := Report{Status: StatusOK, Checks: results}
+	for _, result := range results {
+		switch {
+		case result.Status == StatusFail:
+			report.Status = StatusFail
+		case result.Status == StatusWarn && report.Status == StatusOK:
+			report.Status = StatusWarn
+		}
+	}
+	return report
+}
+
+func runCheck(ctx context.Context, check Check, timeout time.Duration) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan Result, 1)
+	go func() { done <- check.Run(ctx) }()
+
+	var result Result
+	select {
+	case result = <-done:
+	case <-ctx.Done():
+		result = Result{Status: StatusFail, Message: "check timed out"}
+	}
+	result.Name = check.Name
+	result.DurationMs = time.Since(start).Milliseconds()
+	return result
+}
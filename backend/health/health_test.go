@@ -0,0 +1,127 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func staticCheck(name string, status Status) Check {
+	return Check{Name: name, Run: func(ctx context.Context) Result { return Result{Status: status} }}
+}
+
+func TestRegistryRunAggregatesStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		checks   []Check
+		expected Status
+	}{
+		{"all ok", []Check{staticCheck("a", StatusOK), staticCheck("b", StatusOK)}, StatusOK},
+		{"one warn", []Check{staticCheck("a", StatusOK), staticCheck("b", StatusWarn)}, StatusWarn},
+		{"fail wins", []Check{staticCheck("a", StatusWarn), staticCheck("b", StatusFail)}, StatusFail},
+		{"no checks", nil, StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := NewRegistry(tt.checks...).Run(context.Background(), time.Second)
+			if report.Status != tt.expected {
+				t.Errorf("expected status %s, got %s", tt.expected, report.Status)
+			}
+			if len(report.Checks) != len(tt.checks) {
+				t.Errorf("expected %d results, got %d", len(tt.checks), len(report.Checks))
+			}
+		})
+	}
+}
+
+func TestRegistryRunTimesOutSlowChecks(t *testing.T) {
+	registry := NewRegistry(Check{Name: "slow", Run: func(ctx context.Context) Result {
+		time.Sleep(time.Second)
+		return Result{Status: StatusOK}
+	}})
+	registry.Add(staticCheck("fast", StatusOK))
+
+	report := registry.Run(context.Background(), 10*time.Millisecond)
+	if report.Status != StatusFail {
+		t.Fatalf("expected status fail, got %s", report.Status)
+	}
+	if report.Checks[0].Name != "fast" || report.Checks[1].Name != "slow" {
+		t.Errorf("expected results sorted by name, got %+v", report.Checks)
+	}
+	if report.Checks[1].Message != "check timed out" {
+		t.Errorf("expected timeout message, got %q", report.Checks[1].Message)
+	}
+}
+
+func TestBinaryCheck(t *testing.T) {
+	original := RunVersion
+	defer func() { RunVersion = original }()
+
+	RunVersion = func(ctx context.Context, name string, args ...string) (string, error) {
+		if name == "ffmpeg" {
+			return "", errors.New("executable file not found in $PATH")
+		}
+		return "2025.06.30\n", nil
+	}
+
+	result := BinaryCheck("yt-dlp", "--version").Run(context.Background())
+	if result.Status != StatusOK || result.Details["version"] != "2025.06.30" {
+		t.Errorf("expected ok with version, got %+v", result)
+	}
+
+	result = BinaryCheck("ffmpeg", "-version").Run(context.Background())
+	if result.Status != StatusFail {
+		t.Errorf("expected missing binary to fail, got %+v", result)
+	}
+}
+
+func TestDownloadFolderCheck(t *testing.T) {
+	dir := t.TempDir()
+
+	result := DownloadFolderCheck(func() string { return dir }, 0).Run(context.Background())
+	if result.Status != StatusOK {
+		t.Errorf("expected writable folder to pass, got %+v", result)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected write probe to be removed, found %d entries", len(entries))
+	}
+
+	result = DownloadFolderCheck(func() string { return dir }, 1<<62).Run(context.Background())
+	if result.Status != StatusFail || result.Message != "not enough free space in download folder" {
+		t.Errorf("expected low free space to fail, got %+v", result)
+	}
+
+	missing := filepath.Join(dir, "missing")
+	result = DownloadFolderCheck(func() string { return missing }, 0).Run(context.Background())
+	if result.Status != StatusFail {
+		t.Errorf("expected missing folder to fail, got %+v", result)
+	}
+}
+
+type fakePool struct{ queued, running, capacity int }
+
+func (p fakePool) Queued() int   { return p.queued }
+func (p fakePool) Running() int  { return p.running }
+func (p fakePool) Capacity() int { return p.capacity }
+
+func TestWorkerPoolCheck(t *testing.T) {
+	tests := []struct {
+		pool     fakePool
+		expected Status
+	}{
+		{fakePool{queued: 0, running: 1, capacity: 3}, StatusOK},
+		{fakePool{queued: 2, running: 3, capacity: 3}, StatusWarn},
+		{fakePool{queued: 11, running: 3, capacity: 3}, StatusFail},
+	}
+
+	for _, test := range tests {
+		result := WorkerPoolCheck(test.pool, 10).Run(context.Background())
+		if result.Status != test.expected {
+			t.Errorf("WorkerPoolCheck(%+v) = %s; want %s", test.pool, result.Status, test.expected)
+		}
+	}
+}
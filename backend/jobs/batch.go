@@ -0,0 +1,296 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"downloader/logging"
+	"downloader/utils"
+	"downloader/ytdlp"
+)
+
+// ErrBatchNotFound is returned for unknown batch IDs
+var ErrBatchNotFound = errors.New("batch not found")
+
+// maxBatches is how many batches are kept for GET /batch/:id
+const maxBatches = 200
+
+// Result states of a batch entry besides the job statuses
+const (
+	// StatusRejected marks an entry whose URL or options were refused, so
+	// it has no job
+	StatusRejected Status = "rejected"
+	// StatusUnknown marks an entry whose job is no longer kept
+	StatusUnknown Status = "unknown"
+)
+
+// BatchEntry is one URL of a batch with the options it is downloaded with
+type BatchEntry struct {
+	URL     string        `json:"url"`
+	Options ytdlp.Options `json:"options"`
+	JobID   string        `json:"jobId,omitempty"` // the latest job; none if rejected
+	Error   string        `json:"error,omitempty"` // why the entry was rejected
+	Retries int           `json:"retries,omitempty"`
+}
+
+// Batch is a set of downloads requested together
+type Batch struct {
+	ID          string       `json:"id"`
+	User        string       `json:"user"`
+	CallbackURL string       `json:"callbackUrl,omitempty"`
+	CreatedAt   time.Time    `json:"createdAt"`
+	Entries     []BatchEntry `json:"entries"`
+}
+
+// BatchResult is the outcome of one entry of a batch so far
+type BatchResult struct {
+	Index   int      `json:"index"`
+	URL     string   `json:"url"`
+	JobID   string   `json:"jobId,omitempty"`
+	Status  Status   `json:"status"`
+	Error   string   `json:"error,omitempty"`
+	Files   []string `json:"files,omitempty"`
+	Bytes   int64    `json:"bytes"`
+	Retries int      `json:"retries,omitempty"`
+}
+
+// BatchStatus sums up the progress of a batch
+type BatchStatus struct {
+	ID        string         `json:"id"`
+	User      string         `json:"user"`
+	CreatedAt time.Time      `json:"createdAt"`
+	Total     int            `json:"total"`
+	Counts    map[Status]int `json:"counts"`
+	// Finished counts the entries that will not change without a retry;
+	// Progress is their share of Total in percent
+	Finished int           `json:"finished"`
+	Progress float64       `json:"progress"`
+	Done     bool          `json:"done"`
+	Bytes    int64         `json:"bytes"`
+	Results  []BatchResult `json:"results"`
+}
+
+// Batches keeps batches, persisted as a single JSON file. A store without a
+// path only keeps them in memory.
+type Batches struct {
+	mu      sync.Mutex
+	path    string
+	batches map[string]*Batch
+}
+
+// NewBatches returns an empty in-memory batch store
+func NewBatches() *Batches {
+	return &Batches{batches: make(map[string]*Batch)}
+}
+
+// Open loads the batches saved at path, if any, and saves every later change
+// there. The parent folder is created if needed.
+func (b *Batches) Open(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	batches := make(map[string]*Batch)
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(data, &batches); err != nil {
+			return err
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.path = path
+	b.batches = batches
+	return nil
+}
+
+// Get returns a copy of the batch id
+func (b *Batches) Get(id string) (Batch, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	batch, ok := b.batches[id]
+	if !ok {
+		return Batch{}, false
+	}
+	c := *batch
+	c.Entries = append([]BatchEntry(nil), batch.Entries...)
+	return c, true
+}
+
+// add records batch, dropping the oldest beyond maxBatches, and saves
+func (b *Batches) add(batch *Batch) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.batches[batch.ID] = batch
+	if len(b.batches) > maxBatches {
+		list := make([]*Batch, 0, len(b.batches))
+		for _, batch := range b.batches {
+			list = append(list, batch)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+		for _, old := range list[:len(list)-maxBatches] {
+			delete(b.batches, old.ID)
+		}
+	}
+	return b.save()
+}
+
+// update applies fn to the batch id and saves
+func (b *Batches) update(id string, fn func(*Batch)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	batch, ok := b.batches[id]
+	if !ok {
+		return ErrBatchNotFound
+	}
+	fn(batch)
+	return b.save()
+}
+
+// save writes the batches to the store's path; callers hold mu
+func (b *Batches) save() error {
+	if b.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(b.batches, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(b.path, data)
+}
+
+// NewBatch returns a batch of entries requested by user; entries with an
+// Error are recorded but not downloaded
+func NewBatch(user, callbackURL string, entries []BatchEntry) *Batch {
+	return &Batch{
+		ID:          logging.NewID(),
+		User:        user,
+		CallbackURL: callbackURL,
+		CreatedAt:   time.Now(),
+		Entries:     entries,
+	}
+}
+
+// SubmitBatch records batch and queues a job for each of its accepted
+// entries. The jobs run in the background, as many at a time as the workers
+// allow; ctx only carries the logger and trace of the request.
+func (m *Manager) SubmitBatch(ctx context.Context, batch *Batch) error {
+	var queued []*Job
+	for i := range batch.Entries {
+		entry := &batch.Entries[i]
+		if entry.Error != "" {
+			continue
+		}
+		if job := m.batchJob(ctx, batch, entry); job != nil {
+			queued = append(queued, job)
+		} else {
+			entry.Error = ErrShuttingDown.Error()
+		}
+	}
+	err := m.Batches.add(batch)
+	logging.FromContext(ctx).Info("batch submitted", "batch_id", batch.ID, "entries", len(batch.Entries), "jobs", len(queued))
+	m.runBatch(queued)
+	return err
+}
+
+// RetryBatch queues a new job for every entry of the batch id whose job
+// failed and returns how many were queued
+func (m *Manager) RetryBatch(ctx context.Context, id string) (int, error) {
+	var queued []*Job
+	err := m.Batches.update(id, func(batch *Batch) {
+		for i := range batch.Entries {
+			entry := &batch.Entries[i]
+			if entry.JobID == "" {
+				continue
+			}
+			if job, ok := m.Get(entry.JobID); !ok || job.Status != StatusFailed {
+				continue
+			}
+			if job := m.batchJob(ctx, batch, entry); job != nil {
+				queued = append(queued, job)
+				entry.Retries++
+			}
+		}
+	})
+	if errors.Is(err, ErrBatchNotFound) {
+		return 0, err
+	}
+	logging.FromContext(ctx).Info("batch retried", "batch_id", id, "jobs", len(queued))
+	m.runBatch(queued)
+	return len(queued), err
+}
+
+// runBatch runs jobs registered by batchJob in the background
+func (m *Manager) runBatch(jobs []*Job) {
+	for _, job := range jobs {
+		go m.run(context.Background(), job, nil)
+	}
+}
+
+// batchJob creates and registers the job downloading entry, so that it is
+// listed before it runs, and records it as the entry's latest job. It
+// returns nil once shutdown has begun.
+func (m *Manager) batchJob(ctx context.Context, batch *Batch, entry *BatchEntry) *Job {
+	job := NewJob(ctx, entry.URL, entry.Options, batch.User)
+	job.BatchID = batch.ID
+	job.CallbackURL = batch.CallbackURL
+	if !m.add(job) {
+		job.endSpan(ErrShuttingDown)
+		return nil
+	}
+	entry.JobID = job.ID
+	return job
+}
+
+// BatchStatus returns the progress of the batch id and the outcome of each
+// of its entries
+func (m *Manager) BatchStatus(id string) (BatchStatus, bool) {
+	batch, ok := m.Batches.Get(id)
+	if !ok {
+		return BatchStatus{}, false
+	}
+
+	status := BatchStatus{
+		ID:        batch.ID,
+		User:      batch.User,
+		CreatedAt: batch.CreatedAt,
+		Total:     len(batch.Entries),
+		Counts:    make(map[Status]int),
+		Results:   make([]BatchResult, 0, len(batch.Entries)),
+	}
+	for i, entry := range batch.Entries {
+		result := BatchResult{Index: i, URL: entry.URL, JobID: entry.JobID, Error: entry.Error, Retries: entry.Retries}
+		if entry.Error != "" {
+			result.Status = StatusRejected
+		} else if job, ok := m.Get(entry.JobID); ok {
+			result.Status = job.Status
+			result.Error = job.Error
+			result.Files = job.Files
+			result.Bytes = job.Bytes
+		} else {
+			result.Status = StatusUnknown
+		}
+
+		status.Counts[result.Status]++
+		status.Bytes += result.Bytes
+		if result.Status.Finished() || result.Status == StatusRejected || result.Status == StatusUnknown {
+			status.Finished++
+		}
+		status.Results = append(status.Results, result)
+	}
+	status.Done = status.Finished == status.Total
+	if status.Total > 0 {
+		status.Progress = float64(status.Finished) * 100 / float64(status.Total)
+	}
+	return status, true
+}
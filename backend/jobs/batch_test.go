@@ -0,0 +1,90 @@
+package jobs
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitForBatch polls the batch id until every entry has finished
+func waitForBatch(t *testing.T, m *Manager, id string) BatchStatus {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		status, ok := m.BatchStatus(id)
+		if !ok {
+			t.Fatalf("batch %s not found", id)
+		}
+		if status.Done || time.Now().After(deadline) {
+			return status
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBatchesOpenPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "batches.json")
+	batches := NewBatches()
+	if err := batches.Open(path); err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	batch := NewBatch("tester", "", []BatchEntry{{URL: "https://example.com/a", Options: videoOptions}})
+	if err := batches.add(batch); err != nil {
+		t.Fatalf("add() = %v", err)
+	}
+	if err := batches.update(batch.ID, func(b *Batch) { b.Entries[0].JobID = "job1" }); err != nil {
+		t.Fatalf("update() = %v", err)
+	}
+	if err := batches.update("missing", func(*Batch) {}); err != ErrBatchNotFound {
+		t.Errorf("update(missing) = %v; want %v", err, ErrBatchNotFound)
+	}
+
+	reopened := NewBatches()
+	if err := reopened.Open(path); err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	saved, ok := reopened.Get(batch.ID)
+	if !ok || saved.User != "tester" || len(saved.Entries) != 1 || saved.Entries[0].JobID != "job1" {
+		t.Errorf("unexpected reopened batch %+v", saved)
+	}
+}
+
+func TestManagerSubmitAndRetryBatch(t *testing.T) {
+	useFakeYTDLP(t, "fail")
+	m, _ := newTestManager(t, 2)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		m.Shutdown(ctx)
+	})
+
+	batch := NewBatch("tester", "", []BatchEntry{
+		{URL: "https://example.com/a", Options: videoOptions},
+		{URL: "not a url", Error: "Invalid URL"},
+		{URL: "https://example.com/b", Options: videoOptions},
+	})
+	if err := m.SubmitBatch(context.Background(), batch); err != nil {
+		t.Fatalf("SubmitBatch() = %v", err)
+	}
+	status := waitForBatch(t, m, batch.ID)
+	if status.Counts[StatusFailed] != 2 || status.Counts[StatusRejected] != 1 || status.Progress != 100 {
+		t.Fatalf("unexpected batch status %+v", status)
+	}
+	if job, _ := m.Get(status.Results[0].JobID); job.BatchID != batch.ID || job.User != "tester" {
+		t.Errorf("expected the job tied to its batch, got %+v", job)
+	}
+
+	useFakeYTDLP(t, "ok")
+	retried, err := m.RetryBatch(context.Background(), batch.ID)
+	if err != nil || retried != 2 {
+		t.Fatalf("RetryBatch() = %d, %v; want 2 entries retried", retried, err)
+	}
+	status = waitForBatch(t, m, batch.ID)
+	if status.Counts[StatusCompleted] != 2 || status.Results[2].Retries != 1 || len(status.Results[2].Files) == 0 {
+		t.Errorf("expected the retried entries completed, got %+v", status)
+	}
+
+	if _, err := m.RetryBatch(context.Background(), "missing"); err != ErrBatchNotFound {
+		t.Errorf("RetryBatch(missing) = %v; want %v", err, ErrBatchNotFound)
+	}
+}
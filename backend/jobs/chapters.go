@@ -0,0 +1,181 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"downloader/ffmpeg"
+	"downloader/tracing"
+	"downloader/utils"
+	"downloader/ytdlp"
+)
+
+// chapterTrack is one file produced by splitChapters
+type chapterTrack struct {
+	Number   int
+	Title    string
+	File     string
+	Duration time.Duration
+}
+
+// splitChapters replaces each downloaded media file with one file per chapter,
+// named "<name> - 01 - <chapter>.<ext>" and tagged with the chapter title and
+// track number, plus the video title as album for audio. The chapter files,
+// and the CUE sheet and M3U playlist when requested, form one group. Media
+// without chapters is kept whole.
+func splitChapters(ctx context.Context, job *Job, folder string, files []string, info *ytdlp.Info) ([]string, []FileGroup, error) {
+	logger := job.Logger()
+	if info == nil || len(info.Chapters) == 0 {
+		logger.Warn("media has no chapters, keeping it whole")
+		return files, nil, nil
+	}
+
+	ctx, span := tracing.Tracer().Start(ctx, "ffmpeg.split_chapters")
+	var err error
+	defer func() { tracing.EndSpan(span, err) }()
+
+	var result []string
+	var groups []FileGroup
+	for _, name := range files {
+		fileType := utils.GetFileType(name)
+		if fileType != "video" && fileType != "audio" {
+			result = append(result, name)
+			continue
+		}
+
+		var tracks []chapterTrack
+		if tracks, err = splitFile(ctx, job, folder, name, fileType, info); err != nil {
+			return nil, nil, err
+		}
+		group := FileGroup{Title: info.Title}
+		for _, track := range tracks {
+			group.Files = append(group.Files, track.File)
+		}
+
+		base := strings.TrimSuffix(name, filepath.Ext(name))
+		if job.Options.CueSheet {
+			cue := base + ".cue"
+			if err = os.WriteFile(filepath.Join(folder, cue), []byte(cueSheet(info, tracks)), 0o644); err != nil {
+				return nil, nil, err
+			}
+			group.Files = append(group.Files, cue)
+		}
+		if job.Options.M3U {
+			playlist := base + ".m3u"
+			if err = os.WriteFile(filepath.Join(folder, playlist), []byte(m3uPlaylist(tracks)), 0o644); err != nil {
+				return nil, nil, err
+			}
+			group.Files = append(group.Files, playlist)
+		}
+
+		if err := os.Remove(filepath.Join(folder, name)); err != nil {
+			logger.Warn("failed to remove unsplit download", "file", name, "error", err)
+		}
+		result = append(result, group.Files...)
+		groups = append(groups, group)
+	}
+	logger.Info("split into chapters", "chapters", len(info.Chapters), "files", len(result))
+	return result, groups, nil
+}
+
+// splitFile cuts one media file into its chapters. On failure the chapter
+// files already written are removed.
+func splitFile(ctx context.Context, job *Job, folder, name, fileType string, info *ytdlp.Info) ([]chapterTrack, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	total := len(info.Chapters)
+	width := len(strconv.Itoa(total))
+	if width < 2 {
+		width = 2
+	}
+
+	var tracks []chapterTrack
+	for i, chapter := range info.Chapters {
+		number := i + 1
+		title := strings.TrimSpace(chapter.Title)
+		if title == "" {
+			title = fmt.Sprintf("Chapter %d", number)
+		}
+		track := chapterTrack{
+			Number:   number,
+			Title:    title,
+			File:     path.Join(path.Dir(name), utils.SanitizeFilename(fmt.Sprintf("%s - %0*d - %s", path.Base(base), width, number, title))+ext),
+			Duration: time.Duration((chapter.EndTime - chapter.StartTime) * float64(time.Second)),
+		}
+
+		tags := map[string]string{
+			"title": title,
+			"track": fmt.Sprintf("%d/%d", number, total),
+		}
+		if fileType == "audio" {
+			tags["album"] = info.Title
+			if info.Uploader != "" {
+				tags["artist"] = info.Uploader
+				tags["album_artist"] = info.Uploader
+			}
+		}
+
+		start := time.Duration(chapter.StartTime * float64(time.Second))
+		end := time.Duration(chapter.EndTime * float64(time.Second))
+		if err := ffmpeg.TrimTagged(ctx, filepath.Join(folder, name), filepath.Join(folder, track.File), start, end, false, tags); err != nil {
+			job.Logger().Error("failed to extract chapter", "chapter", title, "error", err)
+			for _, written := range append(tracks, track) {
+				os.Remove(filepath.Join(folder, written.File))
+			}
+			return nil, err
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks, nil
+}
+
+// cueSheet describes the audio chapter files as the tracks of one album, each
+// track in its own file
+func cueSheet(info *ytdlp.Info, tracks []chapterTrack) string {
+	var b strings.Builder
+	if info.Uploader != "" {
+		fmt.Fprintf(&b, "PERFORMER %s\n", cueQuote(info.Uploader))
+	}
+	fmt.Fprintf(&b, "TITLE %s\n", cueQuote(info.Title))
+	for _, track := range tracks {
+		fmt.Fprintf(&b, "FILE %s %s\n", cueQuote(path.Base(track.File)), cueFileType(track.File))
+		fmt.Fprintf(&b, "  TRACK %02d AUDIO\n", track.Number)
+		fmt.Fprintf(&b, "    TITLE %s\n", cueQuote(track.Title))
+		b.WriteString("    INDEX 01 00:00:00\n")
+	}
+	return b.String()
+}
+
+func cueQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "'") + `"`
+}
+
+func cueFileType(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".mp3":
+		return "MP3"
+	case ".wav":
+		return "WAVE"
+	case ".aiff":
+		return "AIFF"
+	default:
+		// Players decode any supported file listed as WAVE
+		return "WAVE"
+	}
+}
+
+// m3uPlaylist lists the chapter files in order with their durations and titles
+func m3uPlaylist(tracks []chapterTrack) string {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	for _, track := range tracks {
+		fmt.Fprintf(&b, "#EXTINF:%d,%s\n%s\n", int(track.Duration.Round(time.Second)/time.Second), track.Title, path.Base(track.File))
+	}
+	return b.String()
+}
@@ -0,0 +1,36 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+
+	"downloader/ytdlp"
+)
+
+func TestCueSheet(t *testing.T) {
+	info := &ytdlp.Info{Title: `Live "Set"`, Uploader: "DJ Test"}
+	tracks := []chapterTrack{
+		{Number: 1, Title: "Opening", File: "Set - 01 - Opening.mp3", Duration: 90 * time.Second},
+		{Number: 2, Title: "Closing", File: "Set - 02 - Closing.flac", Duration: 30 * time.Second},
+	}
+
+	expected := `PERFORMER "DJ Test"
+TITLE "Live 'Set'"
+FILE "Set - 01 - Opening.mp3" MP3
+  TRACK 01 AUDIO
+    TITLE "Opening"
+    INDEX 01 00:00:00
+FILE "Set - 02 - Closing.flac" WAVE
+  TRACK 02 AUDIO
+    TITLE "Closing"
+    INDEX 01 00:00:00
+`
+	if result := cueSheet(info, tracks); result != expected {
+		t.Errorf("cueSheet() =\n%s\nwant\n%s", result, expected)
+	}
+
+	playlist := "#EXTM3U\n#EXTINF:90,Opening\nSet - 01 - Opening.mp3\n#EXTINF:30,Closing\nSet - 02 - Closing.flac\n"
+	if result := m3uPlaylist(tracks); result != playlist {
+		t.Errorf("m3uPlaylist() =\n%s\nwant\n%s", result, playlist)
+	}
+}
@@ -0,0 +1,135 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"downloader/ffmpeg"
+	"downloader/subtitles"
+	"downloader/tracing"
+	"downloader/utils"
+	"downloader/ytdlp"
+)
+
+// downloadClip downloads only the requested range using yt-dlp's section
+// downloading. If that fails, it downloads the whole media and trims it with
+// ffmpeg instead. Output names carry the range, e.g. "Title [1m30s-2m00s].mp4",
+// and are written below staging. reuse lets yt-dlp skip a clip downloaded
+// before.
+func (m *Manager) downloadClip(ctx context.Context, job *Job, folder, staging string, reuse *reuseCheck, onLine func(string)) ([]string, *ytdlp.Info, error) {
+	logger := job.Logger()
+	opts := job.Options
+
+	var chapters []ytdlp.Chapter
+	if opts.NeedsChapters() {
+		info, err := m.fetchInfo(ctx, job.URL)
+		if err != nil {
+			logger.Error("failed to fetch chapters", "error", err)
+			return nil, nil, err
+		}
+		chapters = info.Chapters
+	}
+	clip, err := opts.ClipRange(chapters)
+	if err != nil {
+		return nil, nil, err
+	}
+	logger.Info("downloading clip", "range", clip.Label(), "accurate", opts.AccurateCut())
+
+	template := opts.OutputTemplate(" ["+clip.Label()+"]", job.Playlist)
+	args := append(opts.Args(filepath.Join(folder, staging, template)), clip.SectionArgs(opts.AccurateCut())...)
+	files, info, err := m.download(ctx, job, folder, append(args, reuse.Args()...), reuse.Observe(onLine))
+	if err == nil || ctx.Err() != nil || errors.Is(err, context.DeadlineExceeded) {
+		return files, info, err
+	}
+
+	logger.Warn("section download failed, trimming the full download instead", "error", err)
+	// The full download would neither resume nor remove the section's files
+	if err := m.restage(job, staging); err != nil {
+		return nil, nil, err
+	}
+	full := opts.OutputTemplate(" ["+clip.Label()+"].full", job.Playlist)
+	files, info, err = m.download(ctx, job, folder, opts.Args(filepath.Join(folder, staging, full)), onLine)
+	if err != nil {
+		return nil, nil, err
+	}
+	post, cancel := context.WithTimeout(ctx, m.PostprocessTimeout)
+	defer cancel()
+	files, err = trimFiles(post, job, folder, files, clip)
+	return files, info, err
+}
+
+// trimFiles cuts each downloaded media file to clip, replacing the ".full"
+// download with the trimmed file. Subtitle cues are cropped to clip the same
+// way, and subtitles in a format that cannot be parsed are dropped rather
+// than left out of sync. Other files, such as thumbnails, are renamed.
+func trimFiles(ctx context.Context, job *Job, folder string, files []string, clip ytdlp.Range) ([]string, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ffmpeg.trim")
+	var err error
+	defer func() { tracing.EndSpan(span, err) }()
+
+	label := "[" + clip.Label() + "]"
+	trimmed := make([]string, 0, len(files))
+	for _, name := range files {
+		target := strings.Replace(name, label+".full", label, 1)
+		source := filepath.Join(folder, name)
+
+		fileType := utils.GetFileType(name)
+		if fileType == "subtitle" {
+			if cropErr := cropSubtitles(source, filepath.Join(folder, target), clip); cropErr != nil {
+				job.Logger().Warn("dropping subtitles that cannot be cropped to the clip", "file", name, "error", cropErr)
+				os.Remove(source)
+				continue
+			}
+			trimmed = append(trimmed, target)
+			continue
+		}
+		if fileType != "video" && fileType != "audio" {
+			if err := os.Rename(source, filepath.Join(folder, target)); err != nil {
+				job.Logger().Warn("failed to rename file", "file", name, "error", err)
+				target = name
+			}
+			trimmed = append(trimmed, target)
+			continue
+		}
+
+		if err = ffmpeg.Trim(ctx, source, filepath.Join(folder, target), clip.Start, clip.End, job.Options.AccurateCut()); err != nil {
+			job.Logger().Error("ffmpeg trim failed", "file", name, "error", err)
+			os.Remove(filepath.Join(folder, target))
+			return nil, err
+		}
+		if err := os.Remove(source); err != nil {
+			job.Logger().Warn("failed to remove full download", "file", name, "error", err)
+		}
+		trimmed = append(trimmed, target)
+	}
+	return trimmed, nil
+}
+
+// cropSubtitles writes the cues of source shown during clip to target, timed
+// from the start of the clip, and removes source
+func cropSubtitles(source, target string, clip ytdlp.Range) error {
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(source)), ".")
+	if !subtitles.IsSupported(format) {
+		return fmt.Errorf("unsupported subtitle format %q", format)
+	}
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	cues, err := subtitles.Parse(data, format)
+	if err != nil {
+		return err
+	}
+	out, err := subtitles.Format(subtitles.Crop(cues, clip.Start, clip.End), format)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(target, out, 0o644); err != nil {
+		return err
+	}
+	return os.Remove(source)
+}
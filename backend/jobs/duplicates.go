@@ -0,0 +1,206 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"downloader/library"
+	"downloader/ytdlp"
+)
+
+// Duplicate describes files already in the download folder that a job's
+// download matched
+type Duplicate struct {
+	// Match is "archive" for an earlier download of the same video with the
+	// same options, or "content" for identical files from another URL
+	Match  string   `json:"match"`
+	Files  []string `json:"files"`
+	Reused bool     `json:"reused"` // the job returned Files instead of its own download
+}
+
+// reuseCheck lets yt-dlp skip a video downloaded before with the same
+// options, so finding an earlier download costs no metadata request: the
+// archive keys of such downloads are handed to yt-dlp as its
+// --download-archive, and the key of the video is read back from its output.
+// A nil reuseCheck adds nothing and finds nothing.
+type reuseCheck struct {
+	folder   string
+	previous map[string][]string // files of earlier downloads, by archive key
+	archive  string              // the --download-archive file
+	printed  string              // where yt-dlp prints the key once it extracted the video
+
+	mu      sync.Mutex
+	skipped string // key of the video yt-dlp announced as archived before extracting it
+}
+
+// newReuseCheck prepares the check for job, or returns nil if no earlier
+// download with its options is left in the download folder
+func (m *Manager) newReuseCheck(job *Job) (*reuseCheck, error) {
+	if m.Library == nil {
+		return nil, nil
+	}
+	folder := m.Folder()
+	previous := make(map[string][]string)
+	for _, name := range m.Library.Find(func(name string, md library.Metadata) bool {
+		source := md.Source
+		return source != nil && source.VideoID != "" && source.Options.SameDownload(job.Options)
+	}) {
+		if len(existing(folder, []string{name})) == 0 {
+			continue
+		}
+		md, _ := m.Library.Get(name)
+		key := library.ArchiveKey(md.Source.Extractor, md.Source.VideoID)
+		previous[key] = append(previous[key], name)
+	}
+	if len(previous) == 0 {
+		return nil, nil
+	}
+
+	r := &reuseCheck{folder: folder, previous: previous}
+	archive, err := os.CreateTemp("", "downloader-archive-*.txt")
+	if err != nil {
+		return nil, err
+	}
+	r.archive = archive.Name()
+	for key := range previous {
+		fmt.Fprintln(archive, key)
+	}
+	if err := archive.Close(); err != nil {
+		r.Close()
+		return nil, err
+	}
+	printed, err := os.CreateTemp("", "downloader-key-*.txt")
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	r.printed = printed.Name()
+	printed.Close()
+	return r, nil
+}
+
+// Args returns the yt-dlp arguments of the check
+func (r *reuseCheck) Args() []string {
+	if r == nil {
+		return nil
+	}
+	return []string{
+		"--download-archive", r.archive,
+		"--print-to-file", "pre_process:%(extractor_key)s %(id)s", r.printed,
+	}
+}
+
+// Observe returns onLine, noting the lines in which yt-dlp skips a video it
+// found in the archive before extracting it
+func (r *reuseCheck) Observe(onLine func(string)) func(string) {
+	if r == nil {
+		return onLine
+	}
+	return func(line string) {
+		if extractor, id := ytdlp.ArchivedVideo(line); id != "" {
+			r.mu.Lock()
+			r.skipped = library.ArchiveKey(extractor, id)
+			r.mu.Unlock()
+		}
+		if onLine != nil {
+			onLine(line)
+		}
+	}
+}
+
+// Files returns the files of the earlier download if yt-dlp skipped the video
+// for it, or nil if yt-dlp downloaded it
+func (r *reuseCheck) Files() []string {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	key := r.skipped
+	r.mu.Unlock()
+	if key == "" {
+		if data, err := os.ReadFile(r.printed); err == nil {
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+			if extractor, id, ok := strings.Cut(lines[len(lines)-1], " "); ok {
+				key = library.ArchiveKey(extractor, id)
+			}
+		}
+	}
+	return existing(r.folder, r.previous[key])
+}
+
+// Close removes the check's temporary files
+func (r *reuseCheck) Close() {
+	if r == nil {
+		return
+	}
+	os.Remove(r.archive)
+	if r.printed != "" {
+		os.Remove(r.printed)
+	}
+}
+
+// identicalFiles returns the files in the download folder, other than files,
+// with the same content as the audio and video files among files. all reports
+// whether every one of them has such a copy.
+func (m *Manager) identicalFiles(ctx context.Context, files []string) (matches []string, all bool) {
+	if m.Library == nil {
+		return nil, false
+	}
+	all = true
+	for _, name := range files {
+		if !library.IsMedia(name) {
+			continue
+		}
+		md, _ := m.Library.Get(name)
+		if md.Media == nil {
+			all = false
+			continue
+		}
+		hash := md.Media.SHA256
+		candidates := m.Library.Find(func(other string, md library.Metadata) bool {
+			return md.Media != nil && md.Media.SHA256 == hash && !slices.Contains(files, other)
+		})
+		// The recorded hash is stale if the candidate changed since
+		var same []string
+		for _, other := range candidates {
+			if media, _, err := m.Library.Probe(ctx, m.Folder(), other); err == nil && media.SHA256 == hash {
+				same = append(same, other)
+			}
+		}
+		if len(same) == 0 {
+			all = false
+		}
+		matches = append(matches, same...)
+	}
+	return matches, all && len(matches) > 0
+}
+
+// reuseIdentical drops the job's own files in favour of the identical files
+// already in the download folder
+func (m *Manager) reuseIdentical(job *Job, files []string) {
+	folder := m.Folder()
+	for _, name := range files {
+		if err := os.Remove(filepath.Join(folder, name)); err != nil {
+			job.Logger().Warn("failed to remove duplicate file", "file", name, "error", err)
+		}
+		if err := m.Library.Remove(name); err != nil {
+			job.Logger().Warn("failed to forget duplicate file", "file", name, "error", err)
+		}
+	}
+}
+
+// existing returns the names that exist in folder
+func existing(folder string, names []string) []string {
+	var found []string
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(folder, name)); err == nil {
+			found = append(found, name)
+		}
+	}
+	return found
+}
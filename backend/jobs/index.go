@@ -0,0 +1,39 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"downloader/library"
+	"downloader/ytdlp"
+)
+
+// indexFiles records where each file of a finished job came from and probes
+// the audio and video files for the library index. Failures only lose
+// metadata, so they are logged rather than failing the job.
+func (m *Manager) indexFiles(ctx context.Context, job *Job, folder string, files []string, info *ytdlp.Info) {
+	if m.Library == nil {
+		return
+	}
+	logger := job.Logger()
+
+	source := &library.Source{URL: job.URL, Options: job.Options, JobID: job.ID, DownloadedAt: time.Now().UTC()}
+	if info != nil {
+		source.Title = info.Title
+		source.Extractor = info.Extractor
+		source.VideoID = info.ID
+		source.FormatID = info.FormatID
+		source.Format = info.Format
+	}
+	for _, name := range files {
+		if err := m.Library.Update(name, func(md *library.Metadata) { md.Source = source }); err != nil {
+			logger.Warn("failed to record file source", "file", name, "error", err)
+		}
+		if !library.IsMedia(name) {
+			continue
+		}
+		if _, _, err := m.Library.Probe(ctx, folder, name); err != nil {
+			logger.Warn("failed to probe file", "file", name, "error", err)
+		}
+	}
+}
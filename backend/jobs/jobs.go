@@ -0,0 +1,181 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"downloader/logging"
+	"downloader/sponsorblock"
+	"downloader/tracing"
+	"downloader/utils"
+	"downloader/ytdlp"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Status is the lifecycle state of a download job
+type Status string
+
+const (
+	StatusQueued      Status = "queued"
+	StatusRunning     Status = "running"
+	StatusCompleted   Status = "completed"
+	StatusFailed      Status = "failed"
+	StatusInterrupted Status = "interrupted" // stopped by a server shutdown, resumable
+)
+
+// Finished reports whether the status is terminal
+func (s Status) Finished() bool {
+	return s == StatusCompleted || s == StatusFailed
+}
+
+// Job describes a single yt-dlp run requested by a client
+type Job struct {
+	ID         string        `json:"id"`
+	URL        string        `json:"url"`
+	Host       string        `json:"host"`
+	Options    ytdlp.Options `json:"options"`
+	User       string        `json:"user"`
+	Status     Status        `json:"status"`
+	Error      string        `json:"error,omitempty"`
+	Files      []string      `json:"files,omitempty"`
+	Groups     []FileGroup   `json:"groups,omitempty"`
+	Bytes      int64         `json:"bytes"`
+	Attempts   int           `json:"attempts"`
+	CreatedAt  time.Time     `json:"createdAt"`
+	StartedAt  time.Time     `json:"startedAt,omitempty"`
+	FinishedAt time.Time     `json:"finishedAt,omitempty"`
+
+	// Duplicate reports files already downloaded that this download matched
+	Duplicate *Duplicate `json:"duplicate,omitempty"`
+
+	// Replaces is the file a refresh downloads again; the job downloads into
+	// a staging folder and only then moves its files over the old ones
+	Replaces string `json:"replaces,omitempty"`
+
+	// Normalizes is a file in the download folder whose loudness the job
+	// normalizes with the options' loudness settings, in place of a download
+	Normalizes string `json:"normalizes,omitempty"`
+
+	// SponsorBlock lists the segments cut out with sponsorBlockRemove
+	SponsorBlock *SponsorBlockResult `json:"sponsorBlock,omitempty"`
+
+	// BatchID is the batch the job was submitted with, if any
+	BatchID string `json:"batchId,omitempty"`
+
+	// SubscriptionID is the subscription whose sync started the job, if any
+	SubscriptionID string `json:"subscriptionId,omitempty"`
+
+	// Playlist is where a subscription listed the video, for the naming
+	// template's {playlist} and {index}
+	Playlist *ytdlp.PlaylistPosition `json:"playlist,omitempty"`
+
+	// CallbackURL receives the job's webhook events besides the configured
+	// webhooks. It is kept in full for persistence; API responses and webhook
+	// events carry it redacted.
+	CallbackURL string `json:"callbackUrl,omitempty"`
+
+	// Partials are the destinations yt-dlp announced while downloading, kept so
+	// their .part files can be removed if the job is abandoned
+	Partials []string `json:"partials,omitempty"`
+
+	logger *slog.Logger
+	span   trace.Span
+}
+
+// FileGroup is a set of the job's files that belong together, such as the
+// per-chapter files split from one video
+type FileGroup struct {
+	Title string   `json:"title"`
+	Files []string `json:"files"`
+}
+
+// NewJob creates a queued job for rawURL on behalf of user. Its logger
+// extends the one carried by ctx with the job ID, URL host and user, and its
+// span is a child of the span in ctx.
+func NewJob(ctx context.Context, rawURL string, opts ytdlp.Options, user string) *Job {
+	host := ""
+	if parsed, err := url.Parse(rawURL); err == nil {
+		host = parsed.Hostname()
+	}
+
+	job := &Job{
+		ID:        logging.NewID(),
+		URL:       rawURL,
+		Host:      host,
+		Options:   opts,
+		User:      user,
+		Status:    StatusQueued,
+		CreatedAt: time.Now(),
+	}
+	job.instrument(ctx, logging.FromContext(ctx))
+	job.logger.Info("job queued", "format", opts.Format)
+	return job
+}
+
+// instrument attaches the job's correlation logger and starts its span
+func (j *Job) instrument(ctx context.Context, base *slog.Logger) {
+	j.logger = base.With("job_id", j.ID, "url_host", j.Host, "user", j.User)
+	_, j.span = tracing.Tracer().Start(ctx, "job",
+		trace.WithAttributes(
+			tracing.Attr("job.id", j.ID),
+			tracing.Attr("job.format", j.Options.Format),
+			tracing.Attr("url.host", j.Host),
+			tracing.Attr("enduser.id", j.User),
+		),
+	)
+}
+
+// TraceContext returns ctx carrying the job's span, for starting child spans
+func (j *Job) TraceContext(ctx context.Context) context.Context {
+	if j.span == nil {
+		return ctx
+	}
+	return trace.ContextWithSpan(ctx, j.span)
+}
+
+// Logger returns the logger tagged with this job's correlation fields
+func (j *Job) Logger() *slog.Logger {
+	if j.logger == nil {
+		return slog.Default().With("job_id", j.ID, "url_host", j.Host, "user", j.User)
+	}
+	return j.logger
+}
+
+func (j *Job) endSpan(err error) {
+	if j.span != nil {
+		j.span.SetAttributes(tracing.Attr("job.status", string(j.Status)))
+		tracing.EndSpan(j.span, err)
+	}
+}
+
+// Redacted returns j with its callback URL redacted, for showing the job to
+// clients and webhook endpoints
+func (j Job) Redacted() Job {
+	if j.CallbackURL != "" {
+		j.CallbackURL = utils.RedactURL(j.CallbackURL)
+	}
+	return j
+}
+
+// snapshot returns a copy safe to hand out while the job keeps running
+func (j *Job) snapshot() Job {
+	c := *j
+	c.Files = append([]string(nil), j.Files...)
+	c.Groups = append([]FileGroup(nil), j.Groups...)
+	c.Partials = append([]string(nil), j.Partials...)
+	if j.SponsorBlock != nil {
+		sb := *j.SponsorBlock
+		sb.Removed = append([]sponsorblock.Segment(nil), sb.Removed...)
+		c.SponsorBlock = &sb
+	}
+	if j.Duplicate != nil {
+		d := *j.Duplicate
+		d.Files = append([]string(nil), d.Files...)
+		c.Duplicate = &d
+	}
+	c.logger, c.span = nil, nil
+	return c
+}
@@ -0,0 +1,1396 @@
+package jobs
+
+import (
+	"context"
+	"downloader/ffmpeg"
+	"downloader/library"
+	"downloader/sponsorblock"
+	"downloader/utils"
+	"downloader/webhooks"
+	"downloader/ytdlp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var videoOptions = ytdlp.Options{Format: "video", VideoFormat: "mp4"}
+
+// TestHelperProcess is not a real test: it stands in for yt-dlp when a test
+// points ytdlp.Command at the test binary. FAKE_YTDLP_MODE selects whether it
+// succeeds, fails, hangs until killed or fails section downloads. With
+// FAKE_TOOL=ffmpeg or ffprobe it stands in for that tool instead.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+
+	args := os.Args
+	for i, arg := range args {
+		if arg == "--" {
+			args = args[i+1:]
+			break
+		}
+	}
+	switch os.Getenv("FAKE_TOOL") {
+	case "ffmpeg":
+		fakeFFmpeg(args)
+	case "ffprobe":
+		fakeFFprobe(args)
+	}
+
+	var output, printTo, printInfo, printKey, archive, subLangs, sections string
+	thumbnail := false
+	ext := "mp4"
+	for i := 0; i < len(args)-1; i++ {
+		switch args[i] {
+		case "-o":
+			output = args[i+1]
+		case "--print-to-file":
+			switch {
+			case args[i+1] == "after_move:filepath":
+				printTo = args[i+2]
+			case strings.HasPrefix(args[i+1], "pre_process:"):
+				printKey = args[i+2]
+			default:
+				printInfo = args[i+2]
+			}
+		case "--download-archive":
+			archive = args[i+1]
+		case "--extract-audio":
+			ext = "mp3"
+		case "--merge-output-format":
+			ext = args[i+1]
+		case "--sub-langs":
+			subLangs = args[i+1]
+		case "--download-sections":
+			sections = args[i+1]
+		case "--write-thumbnail":
+			thumbnail = true
+		case "--dump-single-json":
+			fmt.Println(fakeInfo)
+			os.Exit(0)
+		}
+	}
+
+	// Like yt-dlp, skip archived videos before extracting them if the URL
+	// tells their ID, otherwise once extracted
+	if archive != "" {
+		data, _ := os.ReadFile(archive)
+		archived := slices.Contains(strings.Split(string(data), "\n"), "youtube abc")
+		if archived && strings.Contains(args[len(args)-1], "feature=share") {
+			fmt.Println("[Youtube] abc: has already been recorded in the archive")
+			os.Exit(0)
+		}
+		os.WriteFile(printKey, []byte("Youtube abc\n"), 0o644)
+		if archived {
+			fmt.Println("[download] Fake Video has already been recorded in the archive")
+			os.Exit(0)
+		}
+	}
+
+	dest := fakeField.ReplaceAllStringFunc(output, func(field string) string {
+		name := fakeField.FindStringSubmatch(field)[1]
+		if name == "ext" {
+			return ext
+		}
+		return fakeFields[name]
+	})
+	os.MkdirAll(filepath.Dir(dest), 0o755)
+	fmt.Println("[youtube] abc: Downloading webpage")
+	fmt.Printf("[download] Destination: %s\n", dest)
+
+	switch os.Getenv("FAKE_YTDLP_MODE") {
+	case "fail":
+		fmt.Fprintln(os.Stderr, "ERROR: Video unavailable")
+		os.Exit(1)
+	case "hang":
+		os.WriteFile(dest+".part", []byte("partial"), 0o644)
+		time.Sleep(time.Minute)
+		os.Exit(1)
+	case "nosections":
+		if sections != "" {
+			os.WriteFile(dest+".part", []byte("partial"), 0o644)
+			fmt.Fprintln(os.Stderr, "ERROR: section downloads are not supported for this format")
+			os.Exit(1)
+		}
+	}
+	if sections != "" {
+		fmt.Printf("[info] Downloading sections %s\n", sections)
+	}
+
+	if subLangs != "" {
+		for _, lang := range strings.Split(subLangs, ",") {
+			sub := strings.TrimSuffix(dest, ".mp4") + "." + lang + ".vtt"
+			fmt.Printf("[info] Writing video subtitles to: %s\n", sub)
+			os.WriteFile(sub, []byte(fakeSubtitles), 0o644)
+		}
+	}
+
+	if thumbnail {
+		base := strings.TrimSuffix(dest, filepath.Ext(dest))
+		fmt.Printf("[info] Writing video thumbnail original to: %s.webp\n", base)
+		os.WriteFile(base+".jpg", []byte("jpeg"), 0o644)
+	}
+
+	os.WriteFile(dest, []byte("video data"), 0o644)
+	os.WriteFile(printTo, []byte(dest+"\n"), 0o644)
+	os.WriteFile(printInfo, []byte(fakeInfo+"\n"), 0o644)
+	os.Exit(0)
+}
+
+// fakeSubtitles are written for every requested subtitle language
+const fakeSubtitles = "WEBVTT\n\n00:00:01.000 --> 00:00:02.500\nHello\n\n00:01:35.000 --> 00:01:37.000\nInside\n\n00:01:59.000 --> 00:02:05.000\nAcross\n"
+
+// fakeField matches the output template fields the fake yt-dlp fills in
+var fakeField = regexp.MustCompile(`%\(([a-z_]+)[^)]*\)(?:\.\d+B|s)`)
+
+var fakeFields = map[string]string{
+	"title":       "Fake Video",
+	"id":          "abc",
+	"uploader":    "Fake Channel",
+	"upload_date": "2024",
+}
+
+const fakeInfo = `{"id":"abc","title":"Fake Video","format_id":"137+140","format":"137 - 1920x1080 (1080p)+140 - audio only","uploader":"Fake Channel","extractor_key":"Youtube","chapters":[{"title":"Intro","start_time":0,"end_time":30},{"title":"Main","start_time":30,"end_time":95.5}],"sponsorblock_chapters":[{"category":"sponsor","type":"skip","start_time":10,"end_time":40},{"category":"intro","type":"skip","start_time":0,"end_time":5},{"category":"poi_highlight","type":"poi","start_time":20,"end_time":20},{"category":"sponsor","type":"skip","start_time":30,"end_time":45.5}]}`
+
+// fakeFFmpeg copies the -i input to the output path, the last argument,
+// reporting progress or loudness first when asked to
+func fakeFFmpeg(args []string) {
+	var input string
+	for i := 0; i < len(args)-1; i++ {
+		switch {
+		case args[i] == "-i":
+			input = args[i+1]
+		case args[i] == "-progress":
+			fmt.Print("out_time_us=500000\nspeed=2.5x\nprogress=continue\nout_time_us=1000000\nspeed=2.5x\nprogress=end\n")
+		case strings.HasPrefix(args[i], "loudnorm="):
+			fmt.Fprint(os.Stderr, fakeLoudnorm)
+		}
+	}
+	if args[len(args)-1] == "-" {
+		os.Exit(0)
+	}
+	data, err := os.ReadFile(input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.WriteFile(args[len(args)-1], append([]byte("trimmed "), data...), 0o644)
+	os.Exit(0)
+}
+
+const fakeLoudnorm = `[Parsed_loudnorm_0 @ 0x1]
+{"input_i": "-27.61", "input_tp": "-4.47", "input_lra": "18.06", "input_thresh": "-39.20",
+"output_i": "-16.10", "output_tp": "-1.50", "output_lra": "14.78", "output_thresh": "-27.71",
+"normalization_type": "linear", "target_offset": "0.58"}
+`
+
+// fakeFFprobe describes mp4 files as H.264/AAC and anything else as VP9/Opus
+func fakeFFprobe(args []string) {
+	format, video, audio := "matroska,webm", "vp9", "opus"
+	if strings.HasSuffix(args[len(args)-1], ".mp4") {
+		format, video, audio = "mov,mp4,m4a,3gp,3g2,mj2", "h264", "aac"
+	}
+	fmt.Printf(`{"format":{"format_name":%q,"duration":"1.000000","bit_rate":"1500000"},"streams":[{"index":0,"codec_type":"video","codec_name":%q,"width":1280,"height":720},{"index":1,"codec_type":"audio","codec_name":%q}]}`, format, video, audio)
+	os.Exit(0)
+}
+
+// useFakeFFmpeg routes ffmpeg runs to TestHelperProcess and records their arguments
+func useFakeFFmpeg(t *testing.T) *[][]string {
+	original := ffmpeg.Command
+	t.Cleanup(func() { ffmpeg.Command = original })
+
+	var calls [][]string
+	ffmpeg.Command = func(ctx context.Context, args ...string) *exec.Cmd {
+		calls = append(calls, args)
+		cmd := exec.CommandContext(ctx, os.Args[0], append([]string{"-test.run=TestHelperProcess", "--"}, args...)...)
+		cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1", "FAKE_TOOL=ffmpeg")
+		return cmd
+	}
+	return &calls
+}
+
+// useFakeYTDLP routes yt-dlp runs to TestHelperProcess in the given mode.
+// ffprobe runs are faked as well since video downloads probe their output.
+func useFakeYTDLP(t *testing.T, mode string) {
+	original, originalProbe := ytdlp.Command, ffmpeg.ProbeCommand
+	t.Cleanup(func() { ytdlp.Command, ffmpeg.ProbeCommand = original, originalProbe })
+
+	ffmpeg.ProbeCommand = func(ctx context.Context, args ...string) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, os.Args[0], append([]string{"-test.run=TestHelperProcess", "--"}, args...)...)
+		cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1", "FAKE_TOOL=ffprobe")
+		return cmd
+	}
+
+	ytdlp.Command = func(ctx context.Context, args ...string) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, os.Args[0], append([]string{"-test.run=TestHelperProcess", "--"}, args...)...)
+		cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1", "FAKE_YTDLP_MODE="+mode)
+		return cmd
+	}
+}
+
+func newTestManager(t *testing.T, workers int) (*Manager, string) {
+	folder := t.TempDir()
+	m := NewManager(workers)
+	m.Folder = func() string { return folder }
+	m.Library = library.NewIndex()
+	m.Archive = library.NewArchive()
+	m.Webhooks = webhooks.NewNotifier()
+	return m, folder
+}
+
+func TestNewJob(t *testing.T) {
+	job := NewJob(context.Background(), "https://www.youtube.com/watch?v=abc", ytdlp.Options{Format: "audio"}, "tester")
+
+	if job.ID == "" {
+		t.Error("expected job ID to be set")
+	}
+	if job.Host != "www.youtube.com" {
+		t.Errorf("expected host www.youtube.com, got %s", job.Host)
+	}
+	if job.Status != StatusQueued {
+		t.Errorf("expected status %s, got %s", StatusQueued, job.Status)
+	}
+}
+
+func TestManagerLimitsConcurrency(t *testing.T) {
+	m := NewManager(1)
+
+	first := NewJob(context.Background(), "https://example.com/1", videoOptions, "tester")
+	if err := m.Start(context.Background(), first); err != nil {
+		t.Fatalf("Start() = %v", err)
+	}
+	if m.Running() != 1 {
+		t.Errorf("expected 1 running job, got %d", m.Running())
+	}
+
+	second := NewJob(context.Background(), "https://example.com/2", videoOptions, "tester")
+	started := make(chan error)
+	go func() { started <- m.Start(context.Background(), second) }()
+
+	// The second job has to wait for the first to release its worker
+	deadline := time.Now().Add(time.Second)
+	for m.Queued() != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if m.Queued() != 1 {
+		t.Fatalf("expected 1 queued job, got %d", m.Queued())
+	}
+
+	m.Finish(first, nil)
+	if err := <-started; err != nil {
+		t.Fatalf("second Start() = %v", err)
+	}
+	if second.Status != StatusRunning {
+		t.Errorf("expected second job running, got %s", second.Status)
+	}
+	if first.Status != StatusCompleted {
+		t.Errorf("expected first job completed, got %s", first.Status)
+	}
+
+	m.Finish(second, errors.New("yt-dlp exited with status 1"))
+	if second.Status != StatusFailed || second.Error == "" {
+		t.Errorf("expected second job failed with error, got %s %q", second.Status, second.Error)
+	}
+	if m.Running() != 0 || m.Queued() != 0 {
+		t.Errorf("expected idle manager, got %d running %d queued", m.Running(), m.Queued())
+	}
+}
+
+func TestManagerStartCancelled(t *testing.T) {
+	m := NewManager(1)
+	m.Start(context.Background(), NewJob(context.Background(), "https://example.com/1", videoOptions, "tester"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	job := NewJob(context.Background(), "https://example.com/2", videoOptions, "tester")
+	if err := m.Start(ctx, job); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if job.Status != StatusFailed {
+		t.Errorf("expected status %s, got %s", StatusFailed, job.Status)
+	}
+}
+
+func TestManagerRunRecordsFiles(t *testing.T) {
+	useFakeYTDLP(t, "ok")
+	m, _ := newTestManager(t, 1)
+
+	var lines []string
+	job := NewJob(context.Background(), "https://example.com/watch", videoOptions, "tester")
+	if err := m.Run(context.Background(), job, func(line string) { lines = append(lines, line) }); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+
+	saved, _ := m.Get(job.ID)
+	if saved.Status != StatusCompleted {
+		t.Errorf("expected status %s, got %s", StatusCompleted, saved.Status)
+	}
+	if len(saved.Files) != 1 || saved.Files[0] != "Fake Video.mp4" {
+		t.Errorf("expected files [Fake Video.mp4], got %v", saved.Files)
+	}
+	if saved.Bytes != int64(len("video data")) {
+		t.Errorf("expected %d bytes, got %d", len("video data"), saved.Bytes)
+	}
+	if len(saved.Partials) != 0 {
+		t.Errorf("expected partials to be cleared on completion, got %v", saved.Partials)
+	}
+	if len(lines) != 2 || !strings.HasPrefix(lines[1], "[download] Destination:") {
+		t.Errorf("expected output lines to reach the observer, got %v", lines)
+	}
+}
+
+func TestManagerRunConvertsSubtitles(t *testing.T) {
+	useFakeYTDLP(t, "ok")
+	m, folder := newTestManager(t, 1)
+
+	opts := videoOptions
+	opts.SubtitleLangs = []string{"en,de"}
+	opts.SubtitleFormat = "srt"
+	job := NewJob(context.Background(), "https://example.com/watch", opts, "tester")
+	if err := m.Run(context.Background(), job, nil); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+
+	saved, _ := m.Get(job.ID)
+	expected := []string{"Fake Video.mp4", "Fake Video.en.srt", "Fake Video.de.srt"}
+	if strings.Join(saved.Files, "|") != strings.Join(expected, "|") {
+		t.Fatalf("expected files %v, got %v", expected, saved.Files)
+	}
+
+	data, err := os.ReadFile(filepath.Join(folder, "Fake Video.en.srt"))
+	if err != nil || string(data) != "1\n00:00:01,000 --> 00:00:02,500\nHello\n\n2\n00:01:35,000 --> 00:01:37,000\nInside\n\n3\n00:01:59,000 --> 00:02:05,000\nAcross\n\n" {
+		t.Errorf("unexpected converted subtitles %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(folder, "Fake Video.en.vtt")); !os.IsNotExist(err) {
+		t.Error("expected the original VTT file to be removed")
+	}
+}
+
+func TestManagerRunClipUsesSections(t *testing.T) {
+	useFakeYTDLP(t, "ok")
+	calls := useFakeFFmpeg(t)
+	m, _ := newTestManager(t, 1)
+
+	opts := videoOptions
+	opts.Start, opts.End = "Main", "1:00"
+	var lines []string
+	job := NewJob(context.Background(), "https://example.com/watch", opts, "tester")
+	if err := m.Run(context.Background(), job, func(line string) { lines = append(lines, line) }); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+
+	saved, _ := m.Get(job.ID)
+	if len(saved.Files) != 1 || saved.Files[0] != "Fake Video [30s-1m00s].mp4" {
+		t.Errorf("expected the range in the file name, got %v", saved.Files)
+	}
+	if !strings.Contains(strings.Join(lines, "\n"), "Downloading sections *30-60") {
+		t.Errorf("expected the chapter to be resolved into a section, got %v", lines)
+	}
+	if len(*calls) != 0 {
+		t.Errorf("expected no ffmpeg trim, got %v", *calls)
+	}
+}
+
+func TestManagerRunClipFallsBackToTrim(t *testing.T) {
+	useFakeYTDLP(t, "nosections")
+	calls := useFakeFFmpeg(t)
+	m, folder := newTestManager(t, 1)
+
+	opts := videoOptions
+	opts.Start, opts.End, opts.Cut = "90", "120", "accurate"
+	job := NewJob(context.Background(), "https://example.com/watch", opts, "tester")
+	var leftover []string
+	onLine := func(line string) {
+		// The failed section download's partial file is gone once the full
+		// download starts
+		if strings.Contains(line, "Destination:") && strings.Contains(line, ".full") {
+			leftover, _ = filepath.Glob(filepath.Join(folder, stagingFolder(job), "*].mp4.part"))
+		}
+	}
+	if err := m.Run(context.Background(), job, onLine); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	if len(leftover) != 0 {
+		t.Errorf("expected the section download's partial files removed, found %v", leftover)
+	}
+
+	saved, _ := m.Get(job.ID)
+	if len(saved.Files) != 1 || saved.Files[0] != "Fake Video [1m30s-2m00s].mp4" {
+		t.Fatalf("expected the trimmed file, got %v", saved.Files)
+	}
+	if data, _ := os.ReadFile(filepath.Join(folder, saved.Files[0])); string(data) != "trimmed video data" {
+		t.Errorf("unexpected trimmed content %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(folder, "Fake Video [1m30s-2m00s].full.mp4")); !os.IsNotExist(err) {
+		t.Error("expected the full download to be removed")
+	}
+	if len(*calls) != 1 || !strings.Contains(strings.Join((*calls)[0], " "), "-ss 90 -t 30") {
+		t.Errorf("expected one accurate ffmpeg trim, got %v", *calls)
+	}
+}
+
+func TestManagerRunClipFallbackCropsSubtitles(t *testing.T) {
+	useFakeYTDLP(t, "nosections")
+	useFakeFFmpeg(t)
+	m, folder := newTestManager(t, 1)
+
+	opts := videoOptions
+	opts.Start, opts.End = "90", "120"
+	opts.SubtitleLangs = []string{"en"}
+	job := NewJob(context.Background(), "https://example.com/watch", opts, "tester")
+	if err := m.Run(context.Background(), job, nil); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+
+	saved, _ := m.Get(job.ID)
+	expected := []string{"Fake Video [1m30s-2m00s].mp4", "Fake Video [1m30s-2m00s].en.vtt"}
+	if strings.Join(saved.Files, "|") != strings.Join(expected, "|") {
+		t.Fatalf("expected files %v, got %v", expected, saved.Files)
+	}
+
+	// Cues before the clip are dropped and the rest timed from its start
+	data, err := os.ReadFile(filepath.Join(folder, saved.Files[1]))
+	if want := "WEBVTT\n\n00:00:05.000 --> 00:00:07.000\nInside\n\n00:00:29.000 --> 00:00:30.000\nAcross\n\n"; err != nil || string(data) != want {
+		t.Errorf("cropped subtitles = %q, %v; want %q", data, err, want)
+	}
+	if _, err := os.Stat(filepath.Join(folder, "Fake Video [1m30s-2m00s].full.en.vtt")); !os.IsNotExist(err) {
+		t.Error("expected the full subtitles to be removed")
+	}
+}
+
+func TestManagerRunSplitsChapters(t *testing.T) {
+	useFakeYTDLP(t, "ok")
+	calls := useFakeFFmpeg(t)
+	m, folder := newTestManager(t, 1)
+
+	opts := ytdlp.Options{Format: "audio", SplitChapters: true, CueSheet: true, M3U: true}
+	job := NewJob(context.Background(), "https://example.com/watch", opts, "tester")
+	if err := m.Run(context.Background(), job, nil); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+
+	saved, _ := m.Get(job.ID)
+	expected := []string{"Fake Video - 01 - Intro.mp3", "Fake Video - 02 - Main.mp3", "Fake Video.cue", "Fake Video.m3u"}
+	if strings.Join(saved.Files, "|") != strings.Join(expected, "|") {
+		t.Fatalf("expected files %v, got %v", expected, saved.Files)
+	}
+	if len(saved.Groups) != 1 || saved.Groups[0].Title != "Fake Video" || len(saved.Groups[0].Files) != 4 {
+		t.Errorf("expected one group with every file, got %+v", saved.Groups)
+	}
+	if _, err := os.Stat(filepath.Join(folder, "Fake Video.mp3")); !os.IsNotExist(err) {
+		t.Error("expected the unsplit download to be removed")
+	}
+
+	if len(*calls) != 2 {
+		t.Fatalf("expected one ffmpeg run per chapter, got %d", len(*calls))
+	}
+	second := strings.Join((*calls)[1], " ")
+	for _, want := range []string{"-ss 30 ", "-t 65.5", "-metadata album=Fake Video", "-metadata artist=Fake Channel", "-metadata title=Main", "-metadata track=2/2"} {
+		if !strings.Contains(second, want) {
+			t.Errorf("expected %q in ffmpeg args %s", want, second)
+		}
+	}
+
+	playlist, _ := os.ReadFile(filepath.Join(folder, "Fake Video.m3u"))
+	if !strings.Contains(string(playlist), "#EXTINF:66,Main\nFake Video - 02 - Main.mp3\n") {
+		t.Errorf("unexpected playlist:\n%s", playlist)
+	}
+}
+
+func TestManagerRunNormalizesLoudness(t *testing.T) {
+	useFakeYTDLP(t, "ok")
+	calls := useFakeFFmpeg(t)
+	m, folder := newTestManager(t, 1)
+
+	var lines []string
+	opts := ytdlp.Options{Format: "audio", Normalize: true, LoudnessSettings: ytdlp.LoudnessSettings{TargetLUFS: -16}}
+	job := NewJob(context.Background(), "https://example.com/watch", opts, "tester")
+	if err := m.Run(context.Background(), job, func(line string) { lines = append(lines, line) }); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(folder, "Fake Video.mp3")); string(data) != "trimmed video data" {
+		t.Errorf("expected the normalized file to replace the download, got %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(folder, "Fake Video.normalizing.mp3")); !os.IsNotExist(err) {
+		t.Error("expected the temporary output to be renamed")
+	}
+	if len(*calls) != 2 || !slices.Contains((*calls)[0], "null") || !strings.Contains(strings.Join((*calls)[1], " "), "measured_I=-27.61") {
+		t.Errorf("expected a measuring and a normalizing ffmpeg run, got %v", *calls)
+	}
+
+	md, ok := m.Library.Get("Fake Video.mp3")
+	if !ok || md.Loudness == nil {
+		t.Fatal("expected loudness to be recorded")
+	}
+	if md.Loudness.Before.Integrated != -27.61 || md.Loudness.After.Integrated != -16.1 || md.Loudness.Target.Integrated != -16 {
+		t.Errorf("unexpected loudness %+v", md.Loudness)
+	}
+	if !slices.Contains(lines, "normalize: Fake Video.mp3 -27.6 LUFS -> -16.1 LUFS") {
+		t.Errorf("expected a normalize line, got %v", lines)
+	}
+}
+
+func TestManagerRunNormalizesFile(t *testing.T) {
+	useFakeYTDLP(t, "ok")
+	calls := useFakeFFmpeg(t)
+	m, folder := newTestManager(t, 1)
+	os.WriteFile(filepath.Join(folder, "talk.mp3"), []byte("audio"), 0o644)
+
+	opts := ytdlp.Options{Normalize: true, LoudnessSettings: ytdlp.LoudnessSettings{TargetLUFS: -14}}
+	job := NewJob(context.Background(), "", opts, "tester")
+	job.Normalizes = "talk.mp3"
+	if err := m.Run(context.Background(), job, nil); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(folder, "talk.mp3")); string(data) != "trimmed audio" {
+		t.Errorf("expected the normalized file in place, got %q", data)
+	}
+	if len(*calls) != 2 {
+		t.Errorf("expected only the two loudnorm passes, got %v", *calls)
+	}
+	if saved, _ := m.Get(job.ID); saved.Status != StatusCompleted || !slices.Equal(saved.Files, []string{"talk.mp3"}) || saved.Normalizes != "talk.mp3" {
+		t.Errorf("unexpected job %+v", saved)
+	}
+	if md, _ := m.Library.Get("talk.mp3"); md.Loudness == nil || md.Loudness.Target.Integrated != -14 {
+		t.Errorf("expected loudness to be recorded, got %+v", md.Loudness)
+	}
+}
+
+func TestManagerRunIndexesFiles(t *testing.T) {
+	useFakeYTDLP(t, "ok")
+	m, _ := newTestManager(t, 1)
+
+	opts := ytdlp.Options{Format: "video", VideoFormat: "mp4", SubtitleLangs: []string{"en"}}
+	job := NewJob(context.Background(), "https://example.com/watch", opts, "tester")
+	if err := m.Run(context.Background(), job, nil); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+
+	md, ok := m.Library.Get("Fake Video.mp4")
+	if !ok || md.Media == nil {
+		t.Fatal("expected the video to be probed")
+	}
+	media := *md.Media
+	if media.Container != "mp4" || media.VideoCodec != "h264" || media.AudioCodec != "aac" || media.Width != 1280 || media.Height != 720 || media.Duration != 1 || media.Bitrate != 1500000 {
+		t.Errorf("unexpected media %+v", media)
+	}
+	source := md.Source
+	if source == nil || source.URL != "https://example.com/watch" || source.Title != "Fake Video" || source.Extractor != "Youtube" ||
+		source.VideoID != "abc" || source.FormatID != "137+140" || source.JobID != job.ID || source.DownloadedAt.IsZero() {
+		t.Errorf("unexpected source %+v", source)
+	}
+
+	subs, ok := m.Library.Get("Fake Video.en.vtt")
+	if !ok || subs.Media != nil || subs.Source == nil {
+		t.Errorf("expected only the source of the subtitles to be recorded, got %+v", subs)
+	}
+}
+
+func TestManagerRunReplacesFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		replaces string
+	}{
+		{"same name", "Fake Video.mp4"},
+		{"renamed", "Old Title.webm"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeYTDLP(t, "ok")
+			m, folder := newTestManager(t, 1)
+
+			os.WriteFile(filepath.Join(folder, tt.replaces), []byte("old data"), 0o644)
+			m.Library.Update(tt.replaces, func(md *library.Metadata) { md.Loudness = &library.Loudness{} })
+
+			job := NewJob(context.Background(), "https://example.com/watch", ytdlp.Options{Format: "video", VideoFormat: "mp4"}, "tester")
+			job.Replaces = tt.replaces
+			if err := m.Run(context.Background(), job, nil); err != nil {
+				t.Fatalf("Run() = %v", err)
+			}
+
+			saved, _ := m.Get(job.ID)
+			if !slices.Equal(saved.Files, []string{"Fake Video.mp4"}) {
+				t.Errorf("unexpected files %v", saved.Files)
+			}
+			if data, _ := os.ReadFile(filepath.Join(folder, "Fake Video.mp4")); string(data) != "video data" {
+				t.Errorf("expected the new download in the folder, got %q", data)
+			}
+			if tt.replaces != "Fake Video.mp4" {
+				if _, err := os.Stat(filepath.Join(folder, tt.replaces)); !os.IsNotExist(err) {
+					t.Error("expected the replaced file to be removed")
+				}
+				if _, ok := m.Library.Get(tt.replaces); ok {
+					t.Error("expected the replaced file to be forgotten")
+				}
+			}
+			if _, err := os.Stat(filepath.Join(folder, ".refresh-"+job.ID)); !os.IsNotExist(err) {
+				t.Error("expected the staging folder to be removed")
+			}
+
+			md, _ := m.Library.Get("Fake Video.mp4")
+			if md.Loudness != nil {
+				t.Error("expected the old loudness measurement to be dropped")
+			}
+			if md.Source == nil || md.Source.JobID != job.ID || md.Source.Options.VideoFormat != "mp4" {
+				t.Errorf("unexpected source %+v", md.Source)
+			}
+		})
+	}
+}
+
+func TestManagerRunReusesArchivedDownload(t *testing.T) {
+	useFakeYTDLP(t, "ok")
+	m, folder := newTestManager(t, 1)
+
+	first := NewJob(context.Background(), "https://example.com/watch", videoOptions, "tester")
+	if err := m.Run(context.Background(), first, nil); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	if !m.Archive.Has("youtube", "abc") {
+		t.Fatal("expected the download to be archived")
+	}
+	os.WriteFile(filepath.Join(folder, "Fake Video.mp4"), []byte("kept"), 0o644)
+
+	var lines []string
+	second := NewJob(context.Background(), "https://example.com/watch?feature=share", videoOptions, "tester")
+	if err := m.Run(context.Background(), second, func(line string) { lines = append(lines, line) }); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	saved, _ := m.Get(second.ID)
+	if !slices.Equal(saved.Files, []string{"Fake Video.mp4"}) || saved.Duplicate == nil || saved.Duplicate.Match != "archive" || !saved.Duplicate.Reused {
+		t.Errorf("expected the earlier download to be reused, got %v %+v", saved.Files, saved.Duplicate)
+	}
+	if data, _ := os.ReadFile(filepath.Join(folder, "Fake Video.mp4")); string(data) != "kept" {
+		t.Error("expected nothing to be downloaded")
+	}
+	if !slices.Contains(lines, "duplicate: reusing Fake Video.mp4") {
+		t.Errorf("expected a duplicate line, got %v", lines)
+	}
+
+	// yt-dlp may only recognize the video once it extracted it
+	third := NewJob(context.Background(), "https://example.com/watch", videoOptions, "tester")
+	if err := m.Run(context.Background(), third, nil); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	if saved, _ := m.Get(third.ID); saved.Duplicate == nil || !saved.Duplicate.Reused || !slices.Equal(saved.Files, []string{"Fake Video.mp4"}) {
+		t.Errorf("expected the earlier download to be reused, got %v %+v", saved.Files, saved.Duplicate)
+	}
+	if data, _ := os.ReadFile(filepath.Join(folder, "Fake Video.mp4")); string(data) != "kept" {
+		t.Error("expected nothing to be downloaded")
+	}
+
+	// Other options are a different download
+	audio := NewJob(context.Background(), "https://example.com/watch", ytdlp.Options{Format: "audio"}, "tester")
+	if err := m.Run(context.Background(), audio, nil); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	if saved, _ := m.Get(audio.ID); saved.Duplicate != nil || !slices.Equal(saved.Files, []string{"Fake Video.mp3"}) {
+		t.Errorf("expected a new download, got %v %+v", saved.Files, saved.Duplicate)
+	}
+}
+
+func TestManagerRunSavesCopies(t *testing.T) {
+	useFakeYTDLP(t, "ok")
+	m, folder := newTestManager(t, 1)
+
+	opts := ytdlp.Options{Format: "video", VideoFormat: "mp4", SubtitleLangs: []string{"en"}, DuplicatePolicy: "copy"}
+	var files [][]string
+	for i := 0; i < 3; i++ {
+		job := NewJob(context.Background(), "https://example.com/watch", opts, "tester")
+		if err := m.Run(context.Background(), job, nil); err != nil {
+			t.Fatalf("Run() = %v", err)
+		}
+		saved, _ := m.Get(job.ID)
+		files = append(files, saved.Files)
+	}
+
+	expected := [][]string{
+		{"Fake Video.mp4", "Fake Video.en.vtt"},
+		{"Fake Video (1).mp4", "Fake Video (1).en.vtt"},
+		{"Fake Video (2).mp4", "Fake Video (2).en.vtt"},
+	}
+	for i := range expected {
+		if !slices.Equal(files[i], expected[i]) {
+			t.Errorf("copy %d: expected %v, got %v", i, expected[i], files[i])
+		}
+		for _, name := range expected[i] {
+			if _, err := os.Stat(filepath.Join(folder, name)); err != nil {
+				t.Errorf("expected %s to exist", name)
+			}
+		}
+	}
+	if md, _ := m.Library.Get("Fake Video (2).mp4"); md.Media == nil || md.Source == nil {
+		t.Errorf("expected the copy to be indexed, got %+v", md)
+	}
+}
+
+func TestManagerRunReusesIdenticalContent(t *testing.T) {
+	useFakeYTDLP(t, "ok")
+	m, folder := newTestManager(t, 1)
+
+	// The same content downloaded earlier from another site
+	os.WriteFile(filepath.Join(folder, "Mirror.mp4"), []byte("video data"), 0o644)
+	if _, _, err := m.Library.Probe(context.Background(), folder, "Mirror.mp4"); err != nil {
+		t.Fatalf("Probe() = %v", err)
+	}
+
+	job := NewJob(context.Background(), "https://example.com/watch", videoOptions, "tester")
+	if err := m.Run(context.Background(), job, nil); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	saved, _ := m.Get(job.ID)
+	if !slices.Equal(saved.Files, []string{"Mirror.mp4"}) || saved.Duplicate == nil || saved.Duplicate.Match != "content" || !saved.Duplicate.Reused {
+		t.Errorf("expected the identical file to be reused, got %v %+v", saved.Files, saved.Duplicate)
+	}
+	if _, err := os.Stat(filepath.Join(folder, "Fake Video.mp4")); !os.IsNotExist(err) {
+		t.Error("expected the duplicate download to be removed")
+	}
+
+	// With the copy policy both are kept
+	opts := videoOptions
+	opts.DuplicatePolicy = "copy"
+	job = NewJob(context.Background(), "https://example.com/watch", opts, "tester")
+	if err := m.Run(context.Background(), job, nil); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	saved, _ = m.Get(job.ID)
+	if !slices.Equal(saved.Files, []string{"Fake Video.mp4"}) || saved.Duplicate == nil || saved.Duplicate.Reused {
+		t.Errorf("expected the copy to be kept and reported, got %v %+v", saved.Files, saved.Duplicate)
+	}
+}
+
+func TestManagerRunEmbedsThumbnail(t *testing.T) {
+	tests := []struct {
+		name     string
+		write    bool
+		expected []string
+	}{
+		{"embedded only", false, []string{"Fake Video.mp3"}},
+		{"embedded and kept", true, []string{"Fake Video.mp3", "Fake Video.jpg"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeYTDLP(t, "ok")
+			calls := useFakeFFmpeg(t)
+			m, folder := newTestManager(t, 1)
+
+			opts := ytdlp.Options{Format: "audio", EmbedThumbnail: true, SquareThumbnail: true, WriteThumbnail: tt.write}
+			job := NewJob(context.Background(), "https://example.com/watch", opts, "tester")
+			if err := m.Run(context.Background(), job, nil); err != nil {
+				t.Fatalf("Run() = %v", err)
+			}
+
+			saved, _ := m.Get(job.ID)
+			if strings.Join(saved.Files, "|") != strings.Join(tt.expected, "|") {
+				t.Errorf("expected files %v, got %v", tt.expected, saved.Files)
+			}
+			if _, err := os.Stat(filepath.Join(folder, "Fake Video.jpg")); os.IsNotExist(err) == tt.write {
+				t.Errorf("expected the thumbnail to exist: %v", tt.write)
+			}
+			if len(*calls) != 2 {
+				t.Fatalf("expected a crop and an embed, got %v", *calls)
+			}
+			if crop := strings.Join((*calls)[0], " "); !strings.Contains(crop, "crop=") || !strings.HasSuffix(crop, "Fake Video.square.jpg") {
+				t.Errorf("expected the thumbnail to be cropped first, got %s", crop)
+			}
+			if embed := strings.Join((*calls)[1], " "); !strings.Contains(embed, "-disposition:v:0 attached_pic") {
+				t.Errorf("expected the cover to be embedded, got %s", embed)
+			}
+		})
+	}
+}
+
+func TestManagerRunTranscodes(t *testing.T) {
+	useFakeYTDLP(t, "ok")
+	calls := useFakeFFmpeg(t)
+	m, folder := newTestManager(t, 1)
+
+	var lines []string
+	opts := ytdlp.Options{Format: "video", VideoFormat: "mov"}
+	job := NewJob(context.Background(), "https://example.com/watch", opts, "tester")
+	if err := m.Run(context.Background(), job, func(line string) { lines = append(lines, line) }); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+
+	saved, _ := m.Get(job.ID)
+	if len(saved.Files) != 1 || saved.Files[0] != "Fake Video.mov" {
+		t.Fatalf("expected the transcoded file, got %v", saved.Files)
+	}
+	if _, err := os.Stat(filepath.Join(folder, "Fake Video.mkv")); !os.IsNotExist(err) {
+		t.Error("expected the merged download to be removed")
+	}
+	// VP9/Opus cannot go into MOV, so both streams are re-encoded
+	if len(*calls) != 1 || !strings.Contains(strings.Join((*calls)[0], " "), "-c:v libx264") || !strings.Contains(strings.Join((*calls)[0], " "), "-c:a aac") {
+		t.Errorf("expected one re-encoding ffmpeg run, got %v", *calls)
+	}
+	if !slices.Contains(lines, "transcode: 50.0% (2.5x)") || !slices.Contains(lines, "transcode: 100.0% (2.5x)") {
+		t.Errorf("expected transcode progress lines, got %v", lines)
+	}
+}
+
+func TestManagerRunReportsRemovedSponsorSegments(t *testing.T) {
+	useFakeYTDLP(t, "ok")
+	m, _ := newTestManager(t, 1)
+
+	opts := videoOptions
+	opts.SponsorBlockMark = []string{"intro"}
+	opts.SponsorBlockRemove = []string{"sponsor"}
+	job := NewJob(context.Background(), "https://www.youtube.com/watch?v=abc", opts, "tester")
+	if err := m.Run(context.Background(), job, nil); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+
+	// The marked intro and the highlight point were not cut
+	saved, _ := m.Get(job.ID)
+	expected := []sponsorblock.Segment{
+		{Category: "sponsor", Start: 10, End: 40},
+		{Category: "sponsor", Start: 30, End: 45.5},
+	}
+	if saved.SponsorBlock == nil || !slices.Equal(saved.SponsorBlock.Removed, expected) {
+		t.Fatalf("expected removed segments %v, got %+v", expected, saved.SponsorBlock)
+	}
+	if saved.SponsorBlock.RemovedDuration != 35.5 {
+		t.Errorf("expected 35.5 seconds removed, got %v", saved.SponsorBlock.RemovedDuration)
+	}
+}
+
+func TestSponsorBlockResultRemovesAll(t *testing.T) {
+	job := NewJob(context.Background(), "https://www.youtube.com/watch?v=abc", ytdlp.Options{SponsorBlockRemove: []string{"all"}}, "tester")
+	info := &ytdlp.Info{Extractor: "Youtube", SponsorBlockChapters: []ytdlp.SponsorBlockChapter{
+		{Category: "outro", Type: "skip", StartTime: 50, EndTime: 60},
+		{Category: "poi_highlight", Type: "poi", StartTime: 20, EndTime: 20},
+		{Category: "chapter", Type: "chapter", StartTime: 0, EndTime: 10},
+		{Category: "selfpromo", Type: "skip", StartTime: 5, EndTime: 8},
+	}}
+
+	result := sponsorBlockResult(job, info)
+	expected := []sponsorblock.Segment{{Category: "selfpromo", Start: 5, End: 8}, {Category: "outro", Start: 50, End: 60}}
+	if result == nil || !slices.Equal(result.Removed, expected) || result.RemovedDuration != 13 {
+		t.Errorf("sponsorBlockResult() = %+v; want %v", result, expected)
+	}
+
+	info.Extractor = "Vimeo"
+	if result := sponsorBlockResult(job, info); result != nil {
+		t.Errorf("expected no result for other sites, got %+v", result)
+	}
+}
+
+func TestManagerRunFailure(t *testing.T) {
+	useFakeYTDLP(t, "fail")
+	m, _ := newTestManager(t, 1)
+
+	job := NewJob(context.Background(), "https://example.com/watch", videoOptions, "tester")
+	if err := m.Run(context.Background(), job, nil); err == nil {
+		t.Fatal("expected Run() to fail")
+	}
+	if saved, _ := m.Get(job.ID); saved.Status != StatusFailed {
+		t.Errorf("expected status %s, got %s", StatusFailed, saved.Status)
+	}
+}
+
+func TestManagerRunTimesOutYTDLP(t *testing.T) {
+	useFakeYTDLP(t, "hang")
+	m, _ := newTestManager(t, 1)
+	m.Timeout = 500 * time.Millisecond
+
+	job := NewJob(context.Background(), "https://example.com/watch", videoOptions, "tester")
+	err := m.Run(context.Background(), job, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the yt-dlp run to time out, got %v", err)
+	}
+	if saved, _ := m.Get(job.ID); saved.Status != StatusFailed || !strings.Contains(saved.Error, "yt-dlp timed out") {
+		t.Errorf("expected a failed job reporting the timeout, got %s %q", saved.Status, saved.Error)
+	}
+}
+
+// webhookEvent is the body of a webhook delivery about a job
+type webhookEvent struct {
+	Event string `json:"event"`
+	Data  struct {
+		Job   Job              `json:"job"`
+		Files []utils.FileInfo `json:"files"`
+	} `json:"data"`
+}
+
+// receiveWebhooks starts a local webhook endpoint and returns its URL and a
+// function returning the events it received, once every delivery has ended
+func receiveWebhooks(t *testing.T, m *Manager) (string, func() []webhookEvent) {
+	var mu sync.Mutex
+	var events []webhookEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event webhookEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("invalid webhook body: %v", err)
+		}
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL, func() []webhookEvent {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		m.Webhooks.Shutdown(ctx)
+		mu.Lock()
+		defer mu.Unlock()
+		// Deliveries run concurrently, so they may arrive out of order
+		slices.SortFunc(events, func(a, b webhookEvent) int {
+			return slices.Index(webhooks.Events, a.Event) - slices.Index(webhooks.Events, b.Event)
+		})
+		return events
+	}
+}
+
+func TestManagerRunSendsWebhooks(t *testing.T) {
+	useFakeYTDLP(t, "ok")
+	m, _ := newTestManager(t, 1)
+	callback, received := receiveWebhooks(t, m)
+
+	job := NewJob(context.Background(), "https://example.com/watch", videoOptions, "tester")
+	job.CallbackURL = callback + "/hook?token=s3cret"
+	if err := m.Run(context.Background(), job, nil); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+
+	events := received()
+	var names []string
+	for _, event := range events {
+		names = append(names, event.Event)
+	}
+	if !slices.Equal(names, []string{webhooks.EventQueued, webhooks.EventStarted, webhooks.EventCompleted}) {
+		t.Fatalf("unexpected events %v", names)
+	}
+	completed := events[2].Data
+	if completed.Job.ID != job.ID || completed.Job.Status != StatusCompleted {
+		t.Errorf("expected the completed job in the payload, got %+v", completed.Job)
+	}
+	if len(completed.Files) != 1 || completed.Files[0].Name != "Fake Video.mp4" || completed.Files[0].DownloadURL != "/files/Fake%20Video.mp4" || completed.Files[0].Size != int64(len("video data")) {
+		t.Errorf("expected file info of the download, got %+v", completed.Files)
+	}
+	if len(events[0].Data.Files) != 0 {
+		t.Errorf("expected no files before completion, got %+v", events[0].Data.Files)
+	}
+	if completed.Job.CallbackURL != callback+"/..." {
+		t.Errorf("expected the callback URL redacted in the payload, got %q", completed.Job.CallbackURL)
+	}
+	if saved, _ := m.Get(job.ID); saved.CallbackURL != job.CallbackURL {
+		t.Errorf("expected the job to keep its callback URL, got %q", saved.CallbackURL)
+	}
+}
+
+func TestManagerWebhooksFailedAndCancelled(t *testing.T) {
+	useFakeYTDLP(t, "fail")
+	m, _ := newTestManager(t, 1)
+	callback, received := receiveWebhooks(t, m)
+
+	failed := NewJob(context.Background(), "https://example.com/watch", videoOptions, "tester")
+	failed.CallbackURL = callback
+	m.Run(context.Background(), failed, nil)
+
+	// A queued job whose client goes away is cancelled
+	m.Start(context.Background(), NewJob(context.Background(), "https://example.com/1", videoOptions, "tester"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cancelled := NewJob(context.Background(), "https://example.com/2", videoOptions, "tester")
+	cancelled.CallbackURL = callback
+	m.Start(ctx, cancelled)
+
+	outcomes := map[string]string{}
+	for _, event := range received() {
+		if event.Event != webhooks.EventQueued && event.Event != webhooks.EventStarted {
+			outcomes[event.Data.Job.ID] = event.Event
+		}
+	}
+	if outcomes[failed.ID] != webhooks.EventFailed || outcomes[cancelled.ID] != webhooks.EventCancelled {
+		t.Errorf("expected failed and cancelled events, got %v", outcomes)
+	}
+}
+
+func TestManagerShutdownInterruptsJobs(t *testing.T) {
+	useFakeYTDLP(t, "hang")
+	m, folder := newTestManager(t, 1)
+	store, err := OpenFileStore(filepath.Join(t.TempDir(), "jobs.json"))
+	if err != nil {
+		t.Fatalf("OpenFileStore() = %v", err)
+	}
+	m.UseStore(store)
+	callback, received := receiveWebhooks(t, m)
+
+	running := NewJob(context.Background(), "https://example.com/running", videoOptions, "tester")
+	queued := NewJob(context.Background(), "https://example.com/queued", videoOptions, "tester")
+	running.CallbackURL, queued.CallbackURL = callback, callback
+	results := make(chan error, 2)
+	go func() { results <- m.Run(context.Background(), running, nil) }()
+
+	// Wait for the first job to hold the only worker and report its destination
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if job, _ := m.Get(running.ID); len(job.Partials) > 0 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	go func() { results <- m.Run(context.Background(), queued, nil) }()
+	for m.Queued() != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := m.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected drain to time out, got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-results; !errors.Is(err, ErrInterrupted) {
+			t.Errorf("expected ErrInterrupted, got %v", err)
+		}
+	}
+
+	if err := m.Run(context.Background(), NewJob(context.Background(), "https://example.com/late", videoOptions, "tester"), nil); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("expected new jobs to be refused, got %v", err)
+	}
+
+	saved, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	if len(saved) != 2 {
+		t.Fatalf("expected 2 saved jobs, got %d", len(saved))
+	}
+	for _, job := range saved {
+		if job.Status != StatusInterrupted {
+			t.Errorf("expected job %s to be saved as interrupted, got %s", job.URL, job.Status)
+		}
+		if job.URL == running.URL && (len(job.Partials) != 1 || job.Partials[0] != filepath.Join(folder, stagingFolder(&job), "Fake Video.mp4")) {
+			t.Errorf("expected running job to record its destination, got %v", job.Partials)
+		}
+	}
+
+	interrupted := map[string]bool{}
+	for _, event := range received() {
+		if event.Event == webhooks.EventInterrupted && event.Data.Job.Status == StatusInterrupted {
+			interrupted[event.Data.Job.ID] = true
+		}
+	}
+	if !interrupted[running.ID] || !interrupted[queued.ID] {
+		t.Errorf("expected interrupted events for the running and the queued job, got %v", interrupted)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	useFakeYTDLP(t, "ok")
+	m, folder := newTestManager(t, 2)
+	store, _ := OpenFileStore(filepath.Join(t.TempDir(), "jobs.json"))
+
+	abandoned := filepath.Join(folder, "Abandoned.mp4")
+	resumable := filepath.Join(folder, "Fake Video.mp4")
+	orphan := filepath.Join(folder, "Orphan.webm.part")
+	fresh := filepath.Join(folder, "Fresh.webm.part")
+	for _, path := range []string{abandoned + ".part", abandoned + ".part-Frag3", resumable + ".part", orphan, fresh} {
+		os.WriteFile(path, []byte("partial"), 0o644)
+	}
+	lost := filepath.Join(folder, stagingPrefix+"lost")
+	os.MkdirAll(lost, 0o755)
+	old := time.Now().Add(-48 * time.Hour)
+	for _, path := range []string{abandoned + ".part", resumable + ".part", orphan, lost} {
+		os.Chtimes(path, old, old)
+	}
+
+	store.Save([]Job{
+		{ID: "resume", URL: "https://example.com/a", Options: videoOptions, Status: StatusInterrupted, Attempts: 1, Partials: []string{resumable}},
+		{ID: "giveup", URL: "https://example.com/b", Options: videoOptions, Status: StatusRunning, Attempts: 2, Partials: []string{abandoned}},
+		{ID: "done", URL: "https://example.com/c", Options: videoOptions, Status: StatusCompleted, Attempts: 1},
+	})
+	m.UseStore(store)
+
+	result, err := m.Recover(2, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("Recover() = %v", err)
+	}
+	if result.Resumed != 1 || result.Failed != 1 || result.PartialsRemoved != 4 {
+		t.Errorf("unexpected recovery result %+v", result)
+	}
+
+	if _, err := os.Stat(fresh); err != nil {
+		t.Error("expected recent orphaned partial file to be kept")
+	}
+	for _, path := range []string{abandoned + ".part", abandoned + ".part-Frag3", orphan, lost} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed", filepath.Base(path))
+		}
+	}
+
+	if job, _ := m.Get("giveup"); job.Status != StatusFailed {
+		t.Errorf("expected exhausted job to be failed, got %s", job.Status)
+	}
+	if job, _ := m.Get("done"); job.Status != StatusCompleted {
+		t.Errorf("expected finished job to be kept as history, got %s", job.Status)
+	}
+
+	// The resumed job runs in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if job, _ := m.Get("resume"); job.Status == StatusCompleted {
+			if job.Attempts != 2 {
+				t.Errorf("expected resumed job on attempt 2, got %d", job.Attempts)
+			}
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("expected resumed job to complete")
+}
+
+func TestManagerRunNamesNestedFiles(t *testing.T) {
+	useFakeYTDLP(t, "ok")
+	m, folder := newTestManager(t, 1)
+
+	opts := ytdlp.Options{Format: "video", VideoFormat: "mp4", SubtitleLangs: []string{"en"}, Naming: "{uploader}/{year}/{title} [{id}]"}
+	job := NewJob(context.Background(), "https://example.com/watch", opts, "tester")
+	if err := m.Run(context.Background(), job, nil); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+
+	saved, _ := m.Get(job.ID)
+	expected := []string{"Fake Channel/2024/Fake Video [abc].mp4", "Fake Channel/2024/Fake Video [abc].en.vtt"}
+	if !slices.Equal(saved.Files, expected) {
+		t.Fatalf("expected %v, got %v", expected, saved.Files)
+	}
+	for _, name := range expected {
+		if _, err := os.Stat(filepath.Join(folder, name)); err != nil {
+			t.Errorf("expected %s to exist", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(folder, stagingFolder(job))); !os.IsNotExist(err) {
+		t.Error("expected the staging folder to be removed")
+	}
+	if md, _ := m.Library.Get(expected[0]); md.Media == nil || md.Source == nil {
+		t.Errorf("expected the placed file to be indexed, got %+v", md)
+	}
+}
+
+func TestManagerRunNamesByPlaylistPosition(t *testing.T) {
+	useFakeYTDLP(t, "ok")
+	m, folder := newTestManager(t, 1)
+
+	opts := ytdlp.Options{Format: "video", VideoFormat: "mp4", Naming: "{playlist}/{index} - {title}"}
+	job := NewJob(context.Background(), "https://example.com/watch", opts, "tester")
+	job.Playlist = &ytdlp.PlaylistPosition{Title: "Fake Channel - Videos", Index: 3}
+	if err := m.Run(context.Background(), job, nil); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+
+	saved, _ := m.Get(job.ID)
+	expected := []string{"Fake Channel - Videos/3 - Fake Video.mp4"}
+	if !slices.Equal(saved.Files, expected) {
+		t.Fatalf("expected %v, got %v", expected, saved.Files)
+	}
+	if _, err := os.Stat(filepath.Join(folder, expected[0])); err != nil {
+		t.Errorf("expected %s to exist", expected[0])
+	}
+}
+
+func TestManagerRunNameCollisions(t *testing.T) {
+	useFakeYTDLP(t, "ok")
+
+	tests := []struct {
+		name     string
+		policy   string
+		source   *library.Source
+		expected string
+	}{
+		{"unknown origin", "", nil, "Fake Video (1).mp4"},
+		{"another video", "", &library.Source{Extractor: "Youtube", VideoID: "other"}, "Fake Video (1).mp4"},
+		{"same video, other options", "", &library.Source{Extractor: "Youtube", VideoID: "abc", Options: ytdlp.Options{Format: "audio"}}, "Fake Video (1).mp4"},
+		{"same video, redownload", "redownload", &library.Source{Extractor: "Youtube", VideoID: "abc", Options: ytdlp.Options{Format: "audio"}}, "Fake Video.mp4"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, folder := newTestManager(t, 1)
+			os.WriteFile(filepath.Join(folder, "Fake Video.mp4"), []byte("existing"), 0o644)
+			if test.source != nil {
+				m.Library.Update("Fake Video.mp4", func(md *library.Metadata) { md.Source = test.source })
+			}
+
+			opts := videoOptions
+			opts.DuplicatePolicy = test.policy
+			job := NewJob(context.Background(), "https://example.com/watch", opts, "tester")
+			if err := m.Run(context.Background(), job, nil); err != nil {
+				t.Fatalf("Run() = %v", err)
+			}
+			saved, _ := m.Get(job.ID)
+			if !slices.Equal(saved.Files, []string{test.expected}) {
+				t.Fatalf("expected [%s], got %v", test.expected, saved.Files)
+			}
+			if test.expected != "Fake Video.mp4" {
+				if data, _ := os.ReadFile(filepath.Join(folder, "Fake Video.mp4")); string(data) != "existing" {
+					t.Errorf("expected the existing file to be kept, got %q", data)
+				}
+			}
+		})
+	}
+}
+
+func TestManagerPlacesConcurrentJobsUnderFreeNames(t *testing.T) {
+	const count = 32
+	m, folder := newTestManager(t, count)
+	sidecars := []string{".mp4", ".en.vtt", ".de.vtt", ".jpg"}
+
+	// Jobs of different videos with the same title finish together
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	placed := make([][]string, count)
+	for i := range placed {
+		job := NewJob(context.Background(), fmt.Sprintf("https://example.com/watch?v=%d", i), videoOptions, "tester")
+		staging, err := m.stage(job)
+		if err != nil {
+			t.Fatalf("stage() = %v", err)
+		}
+		var staged []string
+		for _, ext := range sidecars {
+			staged = append(staged, staging+"/Fake Video"+ext)
+			os.WriteFile(filepath.Join(folder, staged[len(staged)-1]), []byte(job.ID), 0o644)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			files, _, err := m.placeFiles(job, staged, nil, nil)
+			if err != nil {
+				t.Errorf("placeFiles() = %v", err)
+			}
+			placed[i] = files
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for _, files := range placed {
+		for _, name := range files {
+			if seen[name] {
+				t.Fatalf("expected every job placed under its own names, %s was given twice", name)
+			}
+			seen[name] = true
+		}
+	}
+	if len(seen) != count*len(sidecars) {
+		t.Errorf("expected %d files placed, got %d", count*len(sidecars), len(seen))
+	}
+}
+
+func TestManagerTrimsOldestFinishedJobs(t *testing.T) {
+	m, _ := newTestManager(t, 1)
+	start := time.Now()
+	running := &Job{ID: "running", Status: StatusRunning, CreatedAt: start.Add(-time.Hour)}
+	m.jobs[running.ID] = running
+	for i := 0; i < maxHistory+10; i++ {
+		job := &Job{ID: fmt.Sprintf("job-%03d", i), Status: StatusCompleted, CreatedAt: start.Add(time.Duration(i) * time.Second)}
+		m.jobs[job.ID] = job
+	}
+
+	m.mu.Lock()
+	m.trimLocked()
+	m.mu.Unlock()
+
+	if len(m.jobs) != maxHistory {
+		t.Fatalf("expected %d jobs kept, got %d", maxHistory, len(m.jobs))
+	}
+	if _, ok := m.jobs[running.ID]; !ok {
+		t.Error("expected the unfinished job to be kept")
+	}
+	for i := 0; i < 11; i++ {
+		if _, ok := m.jobs[fmt.Sprintf("job-%03d", i)]; ok {
+			t.Errorf("expected job-%03d, among the oldest, to be dropped", i)
+		}
+	}
+}
+
+// countingStore records how often the jobs were saved
+type countingStore struct {
+	mu    sync.Mutex
+	saves int
+	last  []Job
+}
+
+func (s *countingStore) Load() ([]Job, error) { return nil, nil }
+func (s *countingStore) Ping() error          { return nil }
+
+func (s *countingStore) Save(jobs []Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saves++
+	s.last = jobs
+	return nil
+}
+
+func (s *countingStore) count() (int, []Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saves, s.last
+}
+
+func TestManagerDefersSavesWithoutStatusChange(t *testing.T) {
+	defer func(delay time.Duration) { saveDelay = delay }(saveDelay)
+	saveDelay = 50 * time.Millisecond
+	m, _ := newTestManager(t, 1)
+	store := &countingStore{}
+	m.UseStore(store)
+
+	job := NewJob(context.Background(), "https://example.com/watch", videoOptions, "tester")
+	m.add(job)
+	if saves, _ := store.count(); saves != 1 {
+		t.Fatalf("expected a new job saved at once, got %d saves", saves)
+	}
+
+	// Destinations announced in a burst are saved together, later
+	for _, dest := range []string{"a.mp4", "b.m4a", "c.en.vtt"} {
+		m.update(job, func(j *Job) { j.Partials = append(j.Partials, dest) })
+	}
+	if saves, _ := store.count(); saves != 1 {
+		t.Fatalf("expected no save before saveDelay, got %d saves", saves)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if saves, _ := store.count(); saves > 1 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	saves, saved := store.count()
+	if saves != 2 || len(saved) != 1 || len(saved[0].Partials) != 3 {
+		t.Fatalf("expected one deferred save with every destination, got %d saves of %+v", saves, saved)
+	}
+
+	// A status change is saved at once and takes pending changes along
+	m.update(job, func(j *Job) { j.Partials = append(j.Partials, "d.jpg") })
+	m.update(job, func(j *Job) { j.Status = StatusRunning })
+	saves, saved = store.count()
+	if saves != 3 || saved[0].Status != StatusRunning || len(saved[0].Partials) != 4 {
+		t.Errorf("expected the status change saved at once, got %d saves of %+v", saves, saved)
+	}
+	time.Sleep(2 * saveDelay)
+	if saves, _ := store.count(); saves != 3 {
+		t.Errorf("expected the pending save to be dropped, got %d saves", saves)
+	}
+}
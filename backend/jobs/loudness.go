@@ -0,0 +1,57 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"downloader/ffmpeg"
+	"downloader/library"
+)
+
+// normalizeFiles normalizes the loudness of each audio and video file and
+// records the measurements in the manager's library index. Silent files are
+// left as they are.
+func (m *Manager) normalizeFiles(ctx context.Context, job *Job, folder string, files []string, onLine func(string)) error {
+	target := job.Options.LoudnessSettings.Target()
+	logger := job.Logger()
+
+	for _, name := range files {
+		if !library.CanNormalize(name) {
+			continue
+		}
+		loudness, err := m.Library.Normalize(ctx, folder, name, target)
+		if errors.Is(err, ffmpeg.ErrSilent) {
+			logger.Warn("skipping loudness normalization of silent file", "file", name)
+			continue
+		}
+		if err != nil {
+			logger.Error("loudness normalization failed", "file", name, "error", err)
+			return err
+		}
+
+		logger.Info("normalized loudness", "file", name, "before_lufs", loudness.Before.Integrated, "after_lufs", loudness.After.Integrated)
+		if onLine != nil {
+			onLine(fmt.Sprintf("normalize: %s %.1f LUFS -> %.1f LUFS", unstaged(job, name), loudness.Before.Integrated, loudness.After.Integrated))
+		}
+	}
+	return nil
+}
+
+// normalizeFile normalizes the loudness of the file a job was submitted for,
+// replacing it. Unlike downloads, a silent file fails the job.
+func (m *Manager) normalizeFile(ctx context.Context, job *Job, folder string, onLine func(string)) error {
+	name := job.Normalizes
+	loudness, err := m.Library.Normalize(ctx, folder, name, job.Options.LoudnessSettings.Target())
+	if err != nil {
+		job.Logger().Error("loudness normalization failed", "file", name, "error", err)
+		return err
+	}
+
+	job.Logger().Info("normalized loudness", "file", name, "before_lufs", loudness.Before.Integrated, "after_lufs", loudness.After.Integrated)
+	if onLine != nil {
+		onLine(fmt.Sprintf("normalize: %s %.1f LUFS -> %.1f LUFS", name, loudness.Before.Integrated, loudness.After.Integrated))
+	}
+	m.update(job, func(j *Job) { j.Files = []string{name} })
+	return nil
+}
@@ -0,0 +1,629 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"downloader/library"
+	"downloader/metrics"
+	"downloader/tracing"
+	"downloader/utils"
+	"downloader/webhooks"
+	"downloader/ytdlp"
+)
+
+// ErrShuttingDown is returned for jobs submitted after shutdown has begun
+var ErrShuttingDown = errors.New("server is shutting down")
+
+// ErrInterrupted is returned for jobs stopped by a shutdown; they are resumed on restart
+var ErrInterrupted = errors.New("download interrupted by server shutdown")
+
+// maxHistory is how many finished jobs are kept for GET /jobs
+const maxHistory = 500
+
+// saveDelay bounds how long a change that leaves the job's status alone, such
+// as a newly announced destination, may wait to be saved
+var saveDelay = time.Second
+
+// Manager runs jobs through its worker pool and records their lifecycle
+type Manager struct {
+	// Timeout bounds a single yt-dlp run
+	Timeout time.Duration
+	// PostprocessTimeout bounds the ffmpeg work on a job's files: trimming,
+	// transcoding, chapter splitting, normalization and thumbnails
+	PostprocessTimeout time.Duration
+	// Folder returns the download folder
+	Folder func() string
+	// Library records metadata about the files jobs produce
+	Library *library.Index
+	// Archive records the videos downloaded, to find duplicates
+	Archive *library.Archive
+	// Webhooks is told about every job's lifecycle
+	Webhooks *webhooks.Notifier
+	// Batches keeps the batches jobs were submitted in
+	Batches *Batches
+
+	pool
+	store Store
+
+	// placing serializes placeFiles, so two jobs finishing together never
+	// pick the same free name
+	placing sync.Mutex
+
+	// ctx is the parent of every yt-dlp process; cancelling it kills them all
+	ctx     context.Context
+	cancel  context.CancelFunc
+	drain   chan struct{} // closed when shutdown begins
+	running sync.WaitGroup
+
+	mu        sync.Mutex
+	draining  bool
+	jobs      map[string]*Job
+	saveTimer *time.Timer // pending save of changes that left every status alone
+}
+
+// NewManager creates a Manager running at most workers jobs at a time
+func NewManager(workers int) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		Timeout:            utils.EnvDuration("YTDLP_TIMEOUT", 300*time.Second),
+		PostprocessTimeout: utils.EnvDuration("FFMPEG_TIMEOUT", time.Hour),
+		Folder:             utils.GetDownloadFolder,
+		Library:            library.Default,
+		Archive:            library.DefaultArchive,
+		Webhooks:           webhooks.Default,
+		Batches:            NewBatches(),
+		pool:               newPool(workers),
+		ctx:                ctx,
+		cancel:             cancel,
+		drain:              make(chan struct{}),
+		jobs:               make(map[string]*Job),
+	}
+}
+
+// Default is the process-wide manager sized by MAX_CONCURRENT_DOWNLOADS
+var Default = NewManager(defaultWorkers())
+
+// UseStore makes the manager persist every job transition to store
+func (m *Manager) UseStore(store Store) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.store = store
+}
+
+// Store returns the store jobs are persisted to, or nil
+func (m *Manager) Store() Store {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.store
+}
+
+// Run queues job, waits for a worker, runs yt-dlp to completion and records the
+// outcome. Every output line is passed to onLine if it is not nil. ctx only
+// bounds the wait in the queue; once started the download is independent of it.
+func (m *Manager) Run(ctx context.Context, job *Job, onLine func(string)) error {
+	if !m.add(job) {
+		return ErrShuttingDown
+	}
+	return m.run(ctx, job, onLine)
+}
+
+// run is Run for a job add has registered already
+func (m *Manager) run(ctx context.Context, job *Job, onLine func(string)) error {
+	defer m.running.Done()
+	m.notify(job, webhooks.EventQueued)
+
+	if err := m.Start(ctx, job); err != nil {
+		return err
+	}
+	err := m.execute(job, onLine)
+	return m.Finish(job, err)
+}
+
+// Start blocks until a worker is free, then marks the job running. If ctx ends
+// or shutdown begins first, the job is marked failed or interrupted respectively.
+func (m *Manager) Start(ctx context.Context, job *Job) error {
+	m.setQueued(1)
+	defer m.setQueued(-1)
+
+	select {
+	case m.slots <- struct{}{}:
+	case <-m.drain:
+		m.update(job, func(j *Job) { j.Status = StatusInterrupted })
+		job.Logger().Warn("job interrupted while queued")
+		job.endSpan(ErrInterrupted)
+		m.notify(job, webhooks.EventInterrupted)
+		return ErrInterrupted
+	case <-ctx.Done():
+		m.update(job, func(j *Job) {
+			j.Status = StatusFailed
+			j.Error = ctx.Err().Error()
+			j.FinishedAt = time.Now()
+		})
+		job.Logger().Warn("job abandoned while queued", "error", ctx.Err())
+		job.endSpan(ctx.Err())
+		// The client went away or gave up waiting
+		if errors.Is(ctx.Err(), context.Canceled) {
+			m.notify(job, webhooks.EventCancelled)
+		} else {
+			m.notify(job, webhooks.EventFailed)
+		}
+		return ctx.Err()
+	}
+
+	m.update(job, func(j *Job) {
+		j.Status = StatusRunning
+		j.StartedAt = time.Now()
+		j.Attempts++
+	})
+	job.Logger().Info("job started", "attempt", job.Attempts, "queued_ms", job.StartedAt.Sub(job.CreatedAt).Milliseconds())
+	if job.span != nil {
+		job.span.AddEvent("worker acquired")
+	}
+	metrics.ActiveJobs.Inc()
+	metrics.JobsStarted.WithLabelValues(job.Options.Format, metrics.HostLabel(job.Host)).Inc()
+	m.notify(job, webhooks.EventStarted)
+	return nil
+}
+
+// Finish releases the job's worker and records its outcome. A run killed by
+// shutdown is recorded as interrupted and ErrInterrupted is returned in place of err.
+func (m *Manager) Finish(job *Job, err error) error {
+	m.release()
+	metrics.ActiveJobs.Dec()
+
+	if err != nil && m.ctx.Err() != nil {
+		err = ErrInterrupted
+		m.update(job, func(j *Job) { j.Status = StatusInterrupted })
+		job.Logger().Warn("job interrupted by shutdown")
+		job.endSpan(err)
+		m.notify(job, webhooks.EventInterrupted)
+		return err
+	}
+
+	now := time.Now()
+	duration := now.Sub(job.StartedAt)
+	host := metrics.HostLabel(job.Host)
+	format := job.Options.Format
+	m.update(job, func(j *Job) {
+		j.FinishedAt = now
+		j.Partials = nil
+		if err != nil {
+			j.Status = StatusFailed
+			j.Error = err.Error()
+		} else {
+			j.Status = StatusCompleted
+			j.Error = ""
+		}
+	})
+
+	if err != nil {
+		job.Logger().Error("job failed", "error", err, "duration_ms", duration.Milliseconds())
+		metrics.JobsFailed.WithLabelValues(format, host).Inc()
+		m.notify(job, webhooks.EventFailed)
+	} else {
+		job.Logger().Info("job completed", "files", job.Files, "bytes", job.Bytes, "duration_ms", duration.Milliseconds())
+		metrics.JobsCompleted.WithLabelValues(format, host).Inc()
+		metrics.BytesDownloaded.WithLabelValues(format, host).Add(float64(job.Bytes))
+		m.notify(job, webhooks.EventCompleted)
+	}
+	metrics.DownloadDuration.WithLabelValues(format, string(job.Status)).Observe(duration.Seconds())
+	job.endSpan(err)
+	return err
+}
+
+// execute runs yt-dlp for a started job, post-processes the download and
+// records the files it produced. A job normalizing a file runs only ffmpeg.
+func (m *Manager) execute(job *Job, onLine func(string)) (err error) {
+	logger := job.Logger()
+	folder := m.Folder()
+
+	ctx := job.TraceContext(m.ctx)
+	if job.Normalizes != "" {
+		post, cancel := context.WithTimeout(ctx, m.PostprocessTimeout)
+		defer cancel()
+		return m.normalizeFile(post, job, folder, onLine)
+	}
+
+	// yt-dlp skips a video downloaded before with the same options
+	policy := job.Options.Duplicates()
+	var reuse *reuseCheck
+	if job.Replaces == "" && policy == ytdlp.DuplicateReuse {
+		if reuse, err = m.newReuseCheck(job); err != nil {
+			return err
+		}
+		defer reuse.Close()
+	}
+
+	// Files are downloaded and processed in a staging folder, then placed
+	var staging string
+	if staging, err = m.stage(job); err != nil {
+		return err
+	}
+	defer m.unstage(job, staging, &err)
+
+	var files []string
+	var info *ytdlp.Info
+	if job.Options.Clipped() {
+		files, info, err = m.downloadClip(ctx, job, folder, staging, reuse, onLine)
+	} else {
+		output := filepath.Join(folder, staging, job.Options.OutputTemplate("", job.Playlist))
+		args := append(job.Options.Args(output), reuse.Args()...)
+		files, info, err = m.download(ctx, job, folder, args, reuse.Observe(onLine))
+	}
+	if err != nil {
+		return err
+	}
+	if previous := reuse.Files(); len(previous) > 0 {
+		logger.Info("reusing earlier download", "files", previous)
+		if onLine != nil {
+			onLine("duplicate: reusing " + strings.Join(previous, ", "))
+		}
+		m.update(job, func(j *Job) {
+			j.Files = previous
+			j.Duplicate = &Duplicate{Match: "archive", Files: previous, Reused: true}
+			j.Bytes = 0
+		})
+		return nil
+	}
+	sponsorBlock := sponsorBlockResult(job, info)
+
+	post, cancel := context.WithTimeout(ctx, m.PostprocessTimeout)
+	defer cancel()
+	if target := job.Options.TranscodeTarget(); target != "" {
+		if files, err = transcodeFiles(post, job, folder, files, target, onLine); err != nil {
+			return err
+		}
+	}
+
+	if job.Options.WantsSubtitles() {
+		files = convertSubtitles(logger, folder, files, job.Options.SubtitleTarget())
+	}
+
+	var groups []FileGroup
+	if job.Options.SplitChapters {
+		if files, groups, err = splitChapters(post, job, folder, files, info); err != nil {
+			return err
+		}
+	}
+
+	if job.Options.Normalize {
+		if err = m.normalizeFiles(post, job, folder, files, onLine); err != nil {
+			return err
+		}
+	}
+
+	if job.Options.WantsThumbnail() {
+		if files, err = m.processThumbnails(post, job, folder, files); err != nil {
+			return err
+		}
+	}
+
+	if files, groups, err = m.placeFiles(job, files, groups, info); err != nil {
+		return err
+	}
+	m.indexFiles(ctx, job, folder, files, info)
+
+	// The same content may have been downloaded from another URL
+	var duplicate *Duplicate
+	if identical, all := m.identicalFiles(ctx, files); len(identical) > 0 {
+		duplicate = &Duplicate{Match: "content", Files: identical}
+		if all && policy == ytdlp.DuplicateReuse && job.Replaces == "" {
+			logger.Info("download is identical to existing files", "files", files, "identical", identical)
+			m.reuseIdentical(job, files)
+			files, groups = identical, nil
+			duplicate.Reused = true
+			if onLine != nil {
+				onLine("duplicate: identical to " + strings.Join(identical, ", "))
+			}
+		}
+	}
+	if (duplicate == nil || !duplicate.Reused) && info != nil && m.Archive != nil {
+		if err := m.Archive.Add(info.Extractor, info.ID); err != nil {
+			logger.Warn("failed to record download in archive", "error", err)
+		}
+	}
+
+	m.update(job, func(j *Job) {
+		j.Files = files
+		j.Groups = groups
+		j.SponsorBlock = sponsorBlock
+		j.Duplicate = duplicate
+		j.Bytes = utils.TotalSize(folder, files)
+		if duplicate != nil && duplicate.Reused {
+			j.Bytes = 0
+		}
+	})
+	return nil
+}
+
+// fetchInfo fetches the metadata of url, bounded by Timeout like any other
+// yt-dlp run
+func (m *Manager) fetchInfo(ctx context.Context, url string) (*ytdlp.Info, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+	return ytdlp.FetchInfo(ctx, url)
+}
+
+// download runs yt-dlp with args plus the job's URL and returns the files it
+// produced, relative to folder, and the media's metadata if yt-dlp reported it
+func (m *Manager) download(ctx context.Context, job *Job, folder string, args []string, onLine func(string)) ([]string, *ytdlp.Info, error) {
+	logger := job.Logger()
+
+	// yt-dlp appends each final file path here once post-processing has moved it into place
+	printed, err := os.CreateTemp("", "downloader-files-*.txt")
+	if err != nil {
+		return nil, nil, err
+	}
+	printed.Close()
+	defer os.Remove(printed.Name())
+
+	// ...and the metadata of each downloaded video here
+	printedInfo, err := os.CreateTemp("", "downloader-info-*.json")
+	if err != nil {
+		return nil, nil, err
+	}
+	printedInfo.Close()
+	defer os.Remove(printedInfo.Name())
+
+	before, err := utils.GetFileList(folder)
+	if err != nil {
+		logger.Warn("failed to list download folder before download", "error", err)
+	}
+
+	args = append(args,
+		"--print-to-file", "after_move:filepath", printed.Name(),
+		"--print-to-file", "after_move:"+ytdlp.InfoTemplate, printedInfo.Name(),
+	)
+	if job.Attempts > 1 {
+		args = append(args, "--continue")
+	}
+	args = append(args, "--", job.URL)
+
+	// Subtitles and thumbnails are written beside the media file and are not
+	// part of the after_move output, so collect them from the log
+	var sidecars []string
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+	ctx, span := tracing.Tracer().Start(ctx, "yt-dlp")
+	stages := tracing.NewStageTracker(ctx)
+	observers := []func(string){stages.Line, func(line string) {
+		if dest := ytdlp.Destination(line); dest != "" {
+			m.update(job, func(j *Job) { j.Partials = append(j.Partials, dest) })
+		}
+		if sub := ytdlp.SubtitleFile(line); sub != "" {
+			sidecars = append(sidecars, sub)
+		}
+		if thumbnail := ytdlp.ThumbnailFile(line); thumbnail != "" {
+			sidecars = append(sidecars, thumbnail)
+		}
+	}}
+	if onLine != nil {
+		observers = append(observers, onLine)
+	}
+	output := newOutputLogger(logger, observers...)
+
+	err = ytdlp.Run(ctx, args, output.Line)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("yt-dlp timed out after %s: %w", m.Timeout, ctx.Err())
+	}
+	stages.End(err)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		logger.Error("yt-dlp failed", "error", err, "output_tail", output.Tail())
+		return nil, nil, err
+	}
+
+	files := readPrintedFiles(printed.Name(), folder)
+	if len(files) == 0 {
+		// Older yt-dlp builds or already-downloaded files print nothing; fall
+		// back to whatever appeared in the folder during the run
+		after, err := utils.GetFileList(folder)
+		if err != nil {
+			logger.Warn("failed to list download folder after download", "error", err)
+		}
+		files = utils.FindNewFiles(before, after)
+	}
+
+	var info *ytdlp.Info
+	if data, err := os.ReadFile(printedInfo.Name()); err == nil && len(data) > 0 {
+		if info, err = ytdlp.ParseInfo(data); err != nil {
+			logger.Warn("failed to read yt-dlp metadata", "error", err)
+		}
+	}
+	return appendExisting(files, folder, sidecars), info, nil
+}
+
+// readPrintedFiles returns the paths listed in a --print-to-file output,
+// relative to folder
+func readPrintedFiles(path, folder string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	var files []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if rel, err := filepath.Rel(folder, line); err == nil && !strings.HasPrefix(rel, "..") {
+			line = rel
+		}
+		files = append(files, filepath.ToSlash(line))
+	}
+	return files
+}
+
+// appendExisting adds the paths that still exist (embedding removes the
+// sidecar subtitle files) and are not already listed, relative to folder
+func appendExisting(files []string, folder string, paths []string) []string {
+	listed := make(map[string]bool)
+	for _, name := range files {
+		listed[name] = true
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		name := path
+		if rel, err := filepath.Rel(folder, path); err == nil && !strings.HasPrefix(rel, "..") {
+			name = filepath.ToSlash(rel)
+		}
+		if !listed[name] {
+			listed[name] = true
+			files = append(files, name)
+		}
+	}
+	return files
+}
+
+// Get returns a copy of the job with the given ID
+func (m *Manager) Get(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return job.snapshot(), true
+}
+
+// List returns copies of all known jobs, newest first
+func (m *Manager) List() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.listLocked()
+}
+
+func (m *Manager) listLocked() []Job {
+	list := make([]Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		list = append(list, job.snapshot())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	return list
+}
+
+// Draining reports whether shutdown has begun and new jobs are refused
+func (m *Manager) Draining() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.draining
+}
+
+// ShuttingDown returns a channel closed when shutdown begins
+func (m *Manager) ShuttingDown() <-chan struct{} {
+	return m.drain
+}
+
+// Shutdown stops accepting jobs, interrupts queued ones and waits for running
+// jobs to finish. If ctx ends first, the remaining yt-dlp processes are killed
+// and their jobs recorded as interrupted so Recover can resume them.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	if !m.draining {
+		m.draining = true
+		close(m.drain)
+	}
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		m.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// add registers job and counts it as in flight, unless shutdown has begun
+func (m *Manager) add(job *Job) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.draining {
+		return false
+	}
+	m.running.Add(1)
+	m.jobs[job.ID] = job
+	m.trimLocked()
+	m.saveLocked()
+	return true
+}
+
+// update applies change to job under the lock and persists the result. A
+// status change is saved at once, since recovery depends on it; other changes
+// are saved within saveDelay, together with whatever else changed meanwhile.
+func (m *Manager) update(job *Job, change func(*Job)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status := job.Status
+	change(job)
+	if job.Status != status {
+		m.saveLocked()
+	} else {
+		m.saveLaterLocked()
+	}
+}
+
+// trimLocked drops the oldest finished jobs beyond maxHistory
+func (m *Manager) trimLocked() {
+	if len(m.jobs) <= maxHistory {
+		return
+	}
+	var finished []*Job
+	for _, job := range m.jobs {
+		if job.Status.Finished() {
+			finished = append(finished, job)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].CreatedAt.Before(finished[j].CreatedAt) })
+	excess := min(len(m.jobs)-maxHistory, len(finished))
+	for _, job := range finished[:excess] {
+		delete(m.jobs, job.ID)
+	}
+}
+
+// saveLaterLocked schedules a save within saveDelay unless one is pending
+func (m *Manager) saveLaterLocked() {
+	if m.store == nil || m.saveTimer != nil {
+		return
+	}
+	m.saveTimer = time.AfterFunc(saveDelay, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.saveLocked()
+	})
+}
+
+// saveLocked writes every job to the store, including any pending changes
+func (m *Manager) saveLocked() {
+	if m.saveTimer != nil {
+		m.saveTimer.Stop()
+		m.saveTimer = nil
+	}
+	if m.store == nil {
+		return
+	}
+	if err := m.store.Save(m.listLocked()); err != nil {
+		// The job itself can carry on; only restart recovery is affected
+		slog.Error("failed to persist jobs", "error", err)
+	}
+}
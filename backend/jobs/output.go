@@ -0,0 +1,38 @@
+package jobs
+
+import "log/slog"
+
+// outputTailLines is how many trailing yt-dlp lines are attached to a failure log
+const outputTailLines = 20
+
+// outputLogger logs each line of yt-dlp output as its own debug record, passes
+// it on to observers and keeps the last few lines for the failure report
+type outputLogger struct {
+	logger    *slog.Logger
+	observers []func(string)
+	tail      []string
+}
+
+func newOutputLogger(logger *slog.Logger, observers ...func(string)) *outputLogger {
+	return &outputLogger{logger: logger, observers: observers}
+}
+
+// Line records a single line of output
+func (w *outputLogger) Line(line string) {
+	if line == "" {
+		return
+	}
+	w.logger.Debug("yt-dlp output", "line", line)
+	for _, observe := range w.observers {
+		observe(line)
+	}
+	w.tail = append(w.tail, line)
+	if len(w.tail) > outputTailLines {
+		w.tail = w.tail[len(w.tail)-outputTailLines:]
+	}
+}
+
+// Tail returns the most recent lines
+func (w *outputLogger) Tail() []string {
+	return w.tail
+}
@@ -0,0 +1,58 @@
+package jobs
+
+import (
+	"sync"
+
+	"downloader/metrics"
+	"downloader/utils"
+)
+
+// pool is the worker pool bounding how many jobs run at once. Jobs beyond
+// its capacity wait for a slot, counted as queued.
+type pool struct {
+	slots chan struct{}
+
+	mu     sync.Mutex
+	queued int
+}
+
+func newPool(workers int) pool {
+	if workers < 1 {
+		workers = 1
+	}
+	return pool{slots: make(chan struct{}, workers)}
+}
+
+// defaultWorkers is the size of the process-wide pool
+func defaultWorkers() int {
+	return utils.EnvInt("MAX_CONCURRENT_DOWNLOADS", 3)
+}
+
+// release frees the slot of a finished job
+func (p *pool) release() {
+	<-p.slots
+}
+
+// Queued returns the number of jobs waiting for a worker
+func (p *pool) Queued() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.queued
+}
+
+// Running returns the number of jobs holding a worker
+func (p *pool) Running() int {
+	return len(p.slots)
+}
+
+// Capacity returns the maximum number of concurrently running jobs
+func (p *pool) Capacity() int {
+	return cap(p.slots)
+}
+
+func (p *pool) setQueued(delta int) {
+	p.mu.Lock()
+	p.queued += delta
+	p.mu.Unlock()
+	metrics.QueueDepth.Add(float64(delta))
+}
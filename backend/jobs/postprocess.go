@@ -0,0 +1,50 @@
+package jobs
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"downloader/subtitles"
+	"downloader/utils"
+)
+
+// convertSubtitles rewrites the subtitle files among files into format and
+// returns the updated file list. Files already in format, or in a format that
+// cannot be parsed, are kept as they are.
+func convertSubtitles(logger *slog.Logger, folder string, files []string, format string) []string {
+	converted := make([]string, 0, len(files))
+	for _, name := range files {
+		from := strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+		if utils.GetFileType(name) != "subtitle" || from == format || !subtitles.IsSupported(from) {
+			converted = append(converted, name)
+			continue
+		}
+
+		target := strings.TrimSuffix(name, filepath.Ext(name)) + "." + format
+		if err := convertSubtitleFile(filepath.Join(folder, name), filepath.Join(folder, target), from, format); err != nil {
+			logger.Warn("failed to convert subtitles", "file", name, "format", format, "error", err)
+			converted = append(converted, name)
+			continue
+		}
+		logger.Debug("converted subtitles", "file", name, "to", target)
+		converted = append(converted, target)
+	}
+	return converted
+}
+
+func convertSubtitleFile(source, target, from, to string) error {
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	out, err := subtitles.Convert(data, from, to)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(target, out, 0o644); err != nil {
+		return err
+	}
+	return os.Remove(source)
+}
@@ -0,0 +1,175 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RecoveryResult summarizes what Recover did with the previous process's jobs
+type RecoveryResult struct {
+	Resumed         int
+	Failed          int
+	PartialsRemoved int
+}
+
+// Recover loads the jobs saved by the previous process. Unfinished jobs with
+// attempts left are resumed in the background, relying on yt-dlp to continue
+// their partial files; the rest are marked failed and their partial files
+// removed. Orphaned partial files and staging folders older than staleAfter
+// are removed as well.
+func (m *Manager) Recover(maxAttempts int, staleAfter time.Duration) (RecoveryResult, error) {
+	var result RecoveryResult
+
+	store := m.Store()
+	if store == nil {
+		return result, nil
+	}
+	saved, err := store.Load()
+	if err != nil {
+		return result, err
+	}
+
+	var resume []*Job
+	keep := make(map[string]bool)
+	m.mu.Lock()
+	for i := range saved {
+		job := &saved[i]
+		if !job.Status.Finished() {
+			job.instrument(context.Background(), slog.Default())
+			if job.Attempts < maxAttempts {
+				job.Status = StatusQueued
+				job.Logger().Info("resuming interrupted job", "attempt", job.Attempts+1)
+				resume = append(resume, job)
+				for _, dest := range job.Partials {
+					keep[dest] = true
+				}
+			} else {
+				job.Status = StatusFailed
+				job.Error = ErrInterrupted.Error()
+				job.FinishedAt = time.Now()
+				job.Logger().Warn("giving up on interrupted job", "attempts", job.Attempts)
+				job.endSpan(ErrInterrupted)
+				result.PartialsRemoved += removePartials(job.Partials)
+				job.Partials = nil
+				os.RemoveAll(filepath.Join(m.Folder(), stagingFolder(job)))
+				result.Failed++
+			}
+		}
+		m.jobs[job.ID] = job
+	}
+	m.saveLocked()
+	m.mu.Unlock()
+
+	result.PartialsRemoved += removeStalePartials(m.Folder(), staleAfter, keep)
+	result.PartialsRemoved += removeStaleStaging(m.Folder(), staleAfter, resume)
+
+	for _, job := range resume {
+		result.Resumed++
+		go m.Run(context.Background(), job, nil)
+	}
+	return result, nil
+}
+
+// partialSuffixes are the temporary files yt-dlp leaves beside a destination
+var partialSuffixes = []string{".part", ".ytdl", ".temp"}
+
+// isPartial reports whether name is one of yt-dlp's temporary download files
+func isPartial(name string) bool {
+	for _, suffix := range partialSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return strings.Contains(name, ".part-Frag")
+}
+
+// removePartials deletes the temporary files belonging to the given destinations
+func removePartials(destinations []string) int {
+	removed := 0
+	for _, dest := range destinations {
+		candidates := []string{}
+		for _, suffix := range partialSuffixes {
+			candidates = append(candidates, dest+suffix)
+		}
+		if frags, err := filepath.Glob(dest + ".part-Frag*"); err == nil {
+			candidates = append(candidates, frags...)
+		}
+		for _, path := range candidates {
+			if os.Remove(path) == nil {
+				removed++
+			}
+		}
+	}
+	return removed
+}
+
+// removeStalePartials deletes partial files in folder not modified within
+// staleAfter, except those belonging to the destinations in keep
+func removeStalePartials(folder string, staleAfter time.Duration, keep map[string]bool) int {
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		return 0
+	}
+
+	cutoff := time.Now().Add(-staleAfter)
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !isPartial(entry.Name()) {
+			continue
+		}
+		path := filepath.Join(folder, entry.Name())
+		if keptDestination(path, keep) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if os.Remove(path) == nil {
+			removed++
+		}
+	}
+	return removed
+}
+
+// removeStaleStaging deletes staging folders in folder not modified within
+// staleAfter, except those of the resumed jobs
+func removeStaleStaging(folder string, staleAfter time.Duration, resumed []*Job) int {
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		return 0
+	}
+
+	keep := make(map[string]bool, len(resumed))
+	for _, job := range resumed {
+		keep[stagingFolder(job)] = true
+	}
+	cutoff := time.Now().Add(-staleAfter)
+	removed := 0
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), stagingPrefix) || keep[entry.Name()] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if os.RemoveAll(filepath.Join(folder, entry.Name())) == nil {
+			removed++
+		}
+	}
+	return removed
+}
+
+func keptDestination(path string, keep map[string]bool) bool {
+	for dest := range keep {
+		if strings.HasPrefix(path, dest+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package jobs
+
+import (
+	"slices"
+	"sort"
+
+	"downloader/sponsorblock"
+	"downloader/ytdlp"
+)
+
+// SponsorBlockResult lists the SponsorBlock segments cut out of a download
+type SponsorBlockResult struct {
+	Removed         []sponsorblock.Segment `json:"removed"`
+	RemovedDuration float64                `json:"removedDuration"` // seconds, overlaps counted once
+}
+
+// sponsorBlockResult reports the segments yt-dlp cut out, taken from the
+// sponsorblock_chapters it recorded in info: the skip segments of the removed
+// categories. SponsorBlock only covers YouTube, so other sites report nothing.
+func sponsorBlockResult(job *Job, info *ytdlp.Info) *SponsorBlockResult {
+	categories := job.Options.SponsorBlockRemoved()
+	if len(categories) == 0 || info == nil || info.Extractor != "Youtube" {
+		return nil
+	}
+
+	result := &SponsorBlockResult{Removed: []sponsorblock.Segment{}}
+	for _, chapter := range info.SponsorBlockChapters {
+		if chapter.Type != "skip" || !removes(categories, chapter.Category) {
+			continue
+		}
+		result.Removed = append(result.Removed, sponsorblock.Segment{
+			Category: chapter.Category,
+			Start:    chapter.StartTime,
+			End:      chapter.EndTime,
+		})
+	}
+	sort.Slice(result.Removed, func(i, j int) bool { return result.Removed[i].Start < result.Removed[j].Start })
+	result.RemovedDuration = sponsorblock.TotalDuration(result.Removed)
+	job.Logger().Info("removed SponsorBlock segments", "count", len(result.Removed), "seconds", result.RemovedDuration)
+	return result
+}
+
+// removes reports whether removing categories cuts segments of category.
+// "all" covers every category that can be removed.
+func removes(categories []string, category string) bool {
+	if slices.Contains(categories, sponsorblock.All) {
+		return !slices.Contains(sponsorblock.Unremovable, category)
+	}
+	return slices.Contains(categories, category)
+}
@@ -0,0 +1,237 @@
+package jobs
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"downloader/library"
+	"downloader/utils"
+	"downloader/ytdlp"
+)
+
+// stagingPrefix starts the name of every staging folder. Like other dot
+// folders they are hidden from file listings.
+const stagingPrefix = ".staging-"
+
+// stagingFolder is the folder, relative to the download folder, where job
+// downloads and post-processes before its files are placed
+func stagingFolder(job *Job) string {
+	return stagingPrefix + job.ID
+}
+
+// unstaged returns the name a staged file will be placed under, for output
+// shown to the user
+func unstaged(job *Job, name string) string {
+	return strings.TrimPrefix(name, stagingFolder(job)+"/")
+}
+
+// stage creates the job's staging folder and returns its relative name. A
+// resumed job finds its partial files there again.
+func (m *Manager) stage(job *Job) (string, error) {
+	staging := stagingFolder(job)
+	return staging, os.MkdirAll(filepath.Join(m.Folder(), staging), 0o755)
+}
+
+// restage empties the job's staging folder for another attempt at its
+// download, forgetting the partial files it announced there
+func (m *Manager) restage(job *Job, staging string) error {
+	if err := os.RemoveAll(filepath.Join(m.Folder(), staging)); err != nil {
+		return err
+	}
+	m.update(job, func(j *Job) { j.Partials = nil })
+	return os.MkdirAll(filepath.Join(m.Folder(), staging), 0o755)
+}
+
+// unstage removes the staging folder once the job is done with it. It is kept
+// when shutdown interrupted the job, which resumes from it on the next start.
+func (m *Manager) unstage(job *Job, staging string, err *error) {
+	if *err != nil && m.ctx.Err() != nil {
+		return
+	}
+	if rmErr := os.RemoveAll(filepath.Join(m.Folder(), staging)); rmErr != nil {
+		job.Logger().Warn("failed to remove staging folder", "folder", staging, "error", rmErr)
+	}
+}
+
+// placeFiles moves the files a job produced in its staging folder to the same path
+// in the download folder, renaming the metadata recorded while processing
+// them. Each rename replaces an existing file at once, so readers see either
+// the old file or the new one. A name taken by a file that may not be
+// replaced (see replaceable) moves every file to the lowest free " (n)"
+// suffix, the same for the media and its sidecars. A refreshed file that now
+// has another name is removed. Jobs place their files one at a time, since a
+// name found free stays free only until another job moves a file there.
+func (m *Manager) placeFiles(job *Job, files []string, groups []FileGroup, info *ytdlp.Info) ([]string, []FileGroup, error) {
+	m.placing.Lock()
+	defer m.placing.Unlock()
+
+	folder := m.Folder()
+	targets := make([]string, len(files))
+	for i, name := range files {
+		targets[i] = unstaged(job, name)
+	}
+	targets = freeNames(folder, targets, func(name string) bool { return m.replaceable(job, name, info) })
+
+	placed := make(map[string]string, len(files))
+	renamed := make(map[string]string)
+	for i, name := range files {
+		placed[name] = targets[i]
+		if unstaged(job, name) != targets[i] {
+			renamed[path.Base(name)] = path.Base(targets[i])
+		}
+	}
+
+	for i, name := range files {
+		source := filepath.Join(folder, name)
+		if ext := filepath.Ext(name); len(renamed) > 0 && (ext == ".m3u" || ext == ".cue") {
+			if err := renameReferences(source, renamed); err != nil {
+				return nil, nil, err
+			}
+		}
+		target := filepath.Join(folder, targets[i])
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return nil, nil, err
+		}
+		if err := os.Rename(source, target); err != nil {
+			job.Logger().Error("failed to place file", "file", targets[i], "error", err)
+			return nil, nil, err
+		}
+		if m.Library != nil {
+			if err := m.placeMetadata(name, targets[i], placed); err != nil {
+				job.Logger().Warn("failed to move file metadata", "file", targets[i], "error", err)
+			}
+		}
+	}
+
+	for i := range groups {
+		for j, name := range groups[i].Files {
+			groups[i].Files[j] = placed[name]
+		}
+	}
+	if len(renamed) > 0 {
+		job.Logger().Info("names taken, saved with a suffix", "files", targets)
+	}
+	if job.Replaces != "" {
+		m.removeReplaced(job, targets)
+	}
+	return targets, groups, nil
+}
+
+// replaceable reports whether the job may overwrite the existing file name:
+// the file a refresh replaces, or a file of the same video when downloading
+// it again, with the same options unless the policy is redownload. Copies
+// never overwrite, and neither does a download of unknown origin.
+func (m *Manager) replaceable(job *Job, name string, info *ytdlp.Info) bool {
+	if name == job.Replaces {
+		return true
+	}
+	policy := job.Options.Duplicates()
+	if policy == ytdlp.DuplicateCopy || info == nil || m.Library == nil {
+		return false
+	}
+	md, _ := m.Library.Get(name)
+	source := md.Source
+	if source == nil || !strings.EqualFold(source.Extractor, info.Extractor) || source.VideoID != info.ID {
+		return false
+	}
+	return policy == ytdlp.DuplicateRedownload || job.Replaces != "" || source.Options.SameDownload(job.Options)
+}
+
+// placeMetadata moves what processing recorded about a staged file to its
+// place, replacing whatever was recorded about a file it overwrote
+func (m *Manager) placeMetadata(from, to string, placed map[string]string) error {
+	if _, ok := m.Library.Get(from); !ok {
+		return m.Library.Remove(to)
+	}
+	if err := m.Library.Rename(from, to); err != nil {
+		return err
+	}
+	return m.Library.Update(to, func(md *library.Metadata) {
+		if thumbnail, ok := placed[md.Thumbnail]; ok {
+			md.Thumbnail = thumbnail
+		}
+	})
+}
+
+// removeReplaced removes the file a refresh replaced if the refresh named it
+// differently, for example after switching container
+func (m *Manager) removeReplaced(job *Job, targets []string) {
+	job.Logger().Info("replaced file", "file", job.Replaces, "with", targets)
+	if slices.Contains(targets, job.Replaces) {
+		return
+	}
+	if err := os.Remove(filepath.Join(m.Folder(), job.Replaces)); err != nil && !os.IsNotExist(err) {
+		job.Logger().Warn("failed to remove replaced file", "file", job.Replaces, "error", err)
+	}
+	if m.Library != nil {
+		if err := m.Library.Remove(job.Replaces); err != nil {
+			job.Logger().Warn("failed to forget replaced file", "file", job.Replaces, "error", err)
+		}
+	}
+}
+
+// freeNames returns names for files that are free in folder or replaceable:
+// the names themselves if possible, otherwise with the lowest " (n)" suffix
+// that frees all of them
+func freeNames(folder string, files []string, replaceable func(string) bool) []string {
+	base := ""
+	if len(files) > 0 {
+		primary := primaryMedia(files)
+		base = strings.TrimSuffix(primary, filepath.Ext(primary))
+	}
+	for n := 0; ; n++ {
+		names := make([]string, len(files))
+		free := true
+		for i, name := range files {
+			names[i] = numbered(name, base, n)
+			if _, err := os.Stat(filepath.Join(folder, names[i])); err == nil && !replaceable(names[i]) {
+				free = false
+				break
+			}
+		}
+		if free {
+			return names
+		}
+	}
+}
+
+// numbered inserts " (n)" after base in name, or before the extension if
+// name does not start with base. n 0 leaves name unchanged.
+func numbered(name, base string, n int) string {
+	if n == 0 {
+		return name
+	}
+	suffix := fmt.Sprintf(" (%d)", n)
+	if base != "" && strings.HasPrefix(name, base) {
+		return base + suffix + name[len(base):]
+	}
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + suffix + ext
+}
+
+// primaryMedia returns the first audio or video file among files, or the first file
+func primaryMedia(files []string) string {
+	for _, name := range files {
+		if library.IsMedia(name) {
+			return name
+		}
+	}
+	return files[0]
+}
+
+// renameReferences rewrites the file names a playlist or CUE sheet refers to
+func renameReferences(path string, renamed map[string]string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	content := string(data)
+	for from, to := range renamed {
+		content = strings.ReplaceAll(content, from, to)
+	}
+	return utils.WriteFileAtomic(path, []byte(content))
+}
@@ -0,0 +1,72 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"downloader/utils"
+)
+
+// Store persists job records so unfinished jobs survive a restart
+type Store interface {
+	Load() ([]Job, error)
+	Save(jobs []Job) error
+	Ping() error
+}
+
+// FileStore keeps all job records in a single JSON file, replaced atomically on every save
+type FileStore struct {
+	path string
+}
+
+// OpenFileStore creates the parent folder of path if needed and checks it is writable
+func OpenFileStore(path string) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	store := &FileStore{path: path}
+	return store, store.Ping()
+}
+
+// Path returns the file the store writes to
+func (s *FileStore) Path() string {
+	return s.path
+}
+
+// Load returns the saved jobs, or none if nothing has been saved yet
+func (s *FileStore) Load() ([]Job, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []Job
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// Save replaces the saved jobs with jobs
+func (s *FileStore) Save(jobs []Job) error {
+	data, err := json.MarshalIndent(jobs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(s.path, data)
+}
+
+// Ping checks that the store's folder is still writable
+func (s *FileStore) Ping() error {
+	probe, err := os.CreateTemp(filepath.Dir(s.path), ".ping-*")
+	if err != nil {
+		return err
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
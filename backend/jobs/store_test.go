@@ -0,0 +1,53 @@
+package jobs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	store, err := OpenFileStore(filepath.Join(t.TempDir(), "state", "jobs.json"))
+	if err != nil {
+		t.Fatalf("OpenFileStore() = %v", err)
+	}
+
+	if jobs, err := store.Load(); err != nil || len(jobs) != 0 {
+		t.Fatalf("expected empty store, got %v, %v", jobs, err)
+	}
+
+	saved := []Job{
+		{ID: "a", URL: "https://example.com/a", Options: videoOptions, Status: StatusInterrupted, Partials: []string{"/dl/A.mp4"}},
+		{ID: "b", URL: "https://example.com/b", Status: StatusCompleted, Files: []string{"B.mp3"}},
+	}
+	if err := store.Save(saved); err != nil {
+		t.Fatalf("Save() = %v", err)
+	}
+
+	loaded, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	if len(loaded) != 2 || !reflect.DeepEqual(loaded[0].Options, videoOptions) || loaded[0].Partials[0] != "/dl/A.mp4" || loaded[1].Files[0] != "B.mp3" {
+		t.Errorf("unexpected loaded jobs %+v", loaded)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(store.Path()))
+	if len(entries) != 1 {
+		t.Errorf("expected only the store file to remain, found %d entries", len(entries))
+	}
+}
+
+func TestFileStorePingFailsWhenFolderIsGone(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	store, err := OpenFileStore(filepath.Join(dir, "jobs.json"))
+	if err != nil {
+		t.Fatalf("OpenFileStore() = %v", err)
+	}
+
+	os.RemoveAll(dir)
+	if err := store.Ping(); err == nil {
+		t.Error("expected Ping() to fail once the folder is removed")
+	}
+}
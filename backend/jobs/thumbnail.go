@@ -0,0 +1,123 @@
+package jobs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"downloader/ffmpeg"
+	"downloader/library"
+	"downloader/tracing"
+	"downloader/utils"
+)
+
+// processThumbnails finishes the thumbnails yt-dlp saved beside the media:
+// crops them square if asked, embeds them into each media file as cover art
+// and keeps them as sidecar images only with writeThumbnail. It runs last so
+// cover art survives transcoding, splitting and normalization.
+func (m *Manager) processThumbnails(ctx context.Context, job *Job, folder string, files []string) ([]string, error) {
+	var thumbnails, rest []string
+	for _, name := range files {
+		if utils.GetFileType(name) == "image" {
+			thumbnails = append(thumbnails, name)
+		} else {
+			rest = append(rest, name)
+		}
+	}
+	if len(thumbnails) == 0 {
+		job.Logger().Warn("no thumbnail was downloaded")
+		return files, nil
+	}
+
+	ctx, span := tracing.Tracer().Start(ctx, "ffmpeg.thumbnail")
+	var err error
+	defer func() { tracing.EndSpan(span, err) }()
+
+	opts := job.Options
+	if opts.SquareThumbnail {
+		for _, thumbnail := range thumbnails {
+			if err = squareThumbnail(ctx, folder, thumbnail); err != nil {
+				job.Logger().Error("failed to crop thumbnail", "file", thumbnail, "error", err)
+				return nil, err
+			}
+		}
+	}
+
+	for _, name := range rest {
+		fileType := utils.GetFileType(name)
+		if fileType != "video" && fileType != "audio" {
+			continue
+		}
+		thumbnail := thumbnailFor(name, thumbnails)
+
+		if opts.EmbedThumbnail && ffmpeg.SupportsCover(name) {
+			if err = embedCover(ctx, folder, name, thumbnail); err != nil {
+				job.Logger().Error("failed to embed thumbnail", "file", name, "error", err)
+				return nil, err
+			}
+		}
+		if opts.WriteThumbnail && m.Library != nil {
+			// Files split from the download do not share the thumbnail's name
+			if strings.TrimSuffix(name, filepath.Ext(name)) != strings.TrimSuffix(thumbnail, filepath.Ext(thumbnail)) {
+				if err := m.Library.Update(name, func(md *library.Metadata) { md.Thumbnail = thumbnail }); err != nil {
+					job.Logger().Warn("failed to record thumbnail", "file", name, "error", err)
+				}
+			}
+		}
+	}
+
+	if opts.WriteThumbnail {
+		return files, nil
+	}
+	for _, thumbnail := range thumbnails {
+		if err := os.Remove(filepath.Join(folder, thumbnail)); err != nil {
+			job.Logger().Warn("failed to remove embedded thumbnail", "file", thumbnail, "error", err)
+		}
+	}
+	return rest, nil
+}
+
+// thumbnailFor picks the thumbnail whose name is the longest prefix of the
+// media file's, so "Title.jpg" belongs to "Title - 01 - Intro.mp3"
+func thumbnailFor(media string, thumbnails []string) string {
+	best := thumbnails[0]
+	bestLen := -1
+	base := strings.TrimSuffix(media, filepath.Ext(media))
+	for _, thumbnail := range thumbnails {
+		prefix := strings.TrimSuffix(thumbnail, filepath.Ext(thumbnail))
+		if strings.HasPrefix(base, prefix) && len(prefix) > bestLen {
+			best, bestLen = thumbnail, len(prefix)
+		}
+	}
+	return best
+}
+
+// squareThumbnail crops the image name in folder to a centered square in place
+func squareThumbnail(ctx context.Context, folder, name string) error {
+	ext := filepath.Ext(name)
+	source := filepath.Join(folder, name)
+	output := filepath.Join(folder, strings.TrimSuffix(name, ext)+".square"+ext)
+	if err := ffmpeg.Run(ctx, ffmpeg.SquareCropArgs(source, output)); err != nil {
+		os.Remove(output)
+		return err
+	}
+	return os.Rename(output, source)
+}
+
+// embedCover replaces the media file name in folder with a copy carrying thumbnail
+func embedCover(ctx context.Context, folder, name, thumbnail string) error {
+	source := filepath.Join(folder, name)
+	probe, err := ffmpeg.Probe(ctx, source)
+	if err != nil {
+		return err
+	}
+
+	ext := filepath.Ext(name)
+	output := filepath.Join(folder, strings.TrimSuffix(name, ext)+".cover"+ext)
+	if err := ffmpeg.EmbedCover(ctx, source, filepath.Join(folder, thumbnail), output, probe); err != nil {
+		os.Remove(output)
+		return err
+	}
+	return os.Rename(output, source)
+}
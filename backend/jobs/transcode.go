@@ -0,0 +1,88 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"downloader/ffmpeg"
+	"downloader/tracing"
+	"downloader/utils"
+)
+
+// transcodeFiles converts each downloaded video to the requested container,
+// re-encoding only the streams it cannot hold unless a preset was chosen.
+// Files already in the right container with compatible codecs are left
+// alone. Progress is reported to onLine as "transcode: 42.0% (2.5x)".
+func transcodeFiles(ctx context.Context, job *Job, folder string, files []string, target string, onLine func(string)) ([]string, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ffmpeg.transcode")
+	var err error
+	defer func() { tracing.EndSpan(span, err) }()
+
+	container, ok := ffmpeg.FindContainer(target)
+	if !ok {
+		err = fmt.Errorf("unsupported container %q", target)
+		return nil, err
+	}
+	preset := job.Options.Preset
+	logger := job.Logger()
+
+	result := make([]string, 0, len(files))
+	for _, name := range files {
+		if utils.GetFileType(name) != "video" {
+			result = append(result, name)
+			continue
+		}
+		source := filepath.Join(folder, name)
+
+		var probe *ffmpeg.ProbeResult
+		if probe, err = ffmpeg.Probe(ctx, source); err != nil {
+			logger.Error("ffprobe failed", "file", name, "error", err)
+			return nil, err
+		}
+		ext := filepath.Ext(name)
+		sameContainer := strings.EqualFold(strings.TrimPrefix(ext, "."), container.Name)
+		if sameContainer && !ffmpeg.TranscodeNeeded(probe, container, preset) {
+			result = append(result, name)
+			continue
+		}
+
+		targetName := strings.TrimSuffix(name, ext) + "." + container.Name
+		output := filepath.Join(folder, targetName)
+		if sameContainer {
+			// ffmpeg cannot write over its input
+			output = filepath.Join(folder, strings.TrimSuffix(name, ext)+".transcoding."+container.Name)
+		}
+
+		logger.Info("transcoding", "file", name, "container", container.Name, "preset", preset)
+		args := ffmpeg.TranscodeArgs(source, output, container, preset, probe)
+		err = ffmpeg.RunWithProgress(ctx, args, probe.Duration(), func(p ffmpeg.Progress) {
+			if onLine == nil || p.Percent < 0 {
+				return
+			}
+			if p.Speed != "" {
+				onLine(fmt.Sprintf("transcode: %.1f%% (%s)", p.Percent, p.Speed))
+			} else {
+				onLine(fmt.Sprintf("transcode: %.1f%%", p.Percent))
+			}
+		})
+		if err != nil {
+			logger.Error("ffmpeg transcode failed", "file", name, "error", err)
+			os.Remove(output)
+			return nil, err
+		}
+
+		if sameContainer {
+			if err = os.Rename(output, source); err != nil {
+				os.Remove(output)
+				return nil, err
+			}
+		} else if err := os.Remove(source); err != nil {
+			logger.Warn("failed to remove original after transcoding", "file", name, "error", err)
+		}
+		result = append(result, targetName)
+	}
+	return result, nil
+}
@@ -0,0 +1,33 @@
+package jobs
+
+import (
+	"downloader/utils"
+	"downloader/webhooks"
+)
+
+// webhookData is the data of a job's webhook events
+type webhookData struct {
+	Job   Job              `json:"job"`
+	Files []utils.FileInfo `json:"files,omitempty"` // of completed jobs
+}
+
+// notify sends event about job to the configured webhooks and the job's
+// callback URL
+func (m *Manager) notify(job *Job, event string) {
+	if m.Webhooks == nil {
+		return
+	}
+	m.mu.Lock()
+	data := webhookData{Job: job.snapshot().Redacted()}
+	m.mu.Unlock()
+
+	if event == webhooks.EventCompleted {
+		folder := m.Folder()
+		for _, name := range data.Job.Files {
+			if info, err := utils.CreateFileInfo(name, folder); err == nil {
+				data.Files = append(data.Files, *info)
+			}
+		}
+	}
+	m.Webhooks.Notify(event, job.ID, job.CallbackURL, data)
+}
@@ -0,0 +1,108 @@
+package library
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Archive records which videos were downloaded, one "<extractor> <video ID>"
+// line each, in the format of yt-dlp's --download-archive, so the same file
+// can be shared with yt-dlp. An archive without a path only keeps entries in
+// memory.
+type Archive struct {
+	mu   sync.Mutex
+	path string
+	keys map[string]bool
+}
+
+// NewArchive returns an empty in-memory archive
+func NewArchive() *Archive {
+	return &Archive{keys: make(map[string]bool)}
+}
+
+// DefaultArchive is the process-wide archive; main points it at a file
+var DefaultArchive = NewArchive()
+
+// ArchiveKey returns the archive entry of a video: yt-dlp's extractor key in
+// lower case and the video ID
+func ArchiveKey(extractor, videoID string) string {
+	return strings.ToLower(extractor) + " " + videoID
+}
+
+// Open loads the archive at path, if any, and appends every later entry
+// there. The parent folder is created if needed.
+func (a *Archive) Open(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	keys := make(map[string]bool)
+	file, err := os.Open(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				keys[line] = true
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.path = path
+	a.keys = keys
+	return nil
+}
+
+// Has reports whether the video was recorded
+func (a *Archive) Has(extractor, videoID string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.keys[ArchiveKey(extractor, videoID)]
+}
+
+// Len returns the number of recorded videos
+func (a *Archive) Len() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.keys)
+}
+
+// Add records the video, appending it to the archive file if it is new
+func (a *Archive) Add(extractor, videoID string) error {
+	if extractor == "" || videoID == "" {
+		return nil
+	}
+	key := ArchiveKey(extractor, videoID)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.keys[key] {
+		return nil
+	}
+	a.keys[key] = true
+	if a.path == "" {
+		return nil
+	}
+
+	file, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(key + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,39 @@
+package library
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.txt")
+	// An archive yt-dlp wrote
+	os.WriteFile(path, []byte("youtube dQw4w9WgXcQ\n\nvimeo 76979871\n"), 0o644)
+
+	archive := NewArchive()
+	if err := archive.Open(path); err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	if !archive.Has("Youtube", "dQw4w9WgXcQ") || !archive.Has("Vimeo", "76979871") || archive.Len() != 2 {
+		t.Error("expected the existing entries to be loaded")
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := archive.Add("Youtube", "abc"); err != nil {
+			t.Fatalf("Add() = %v", err)
+		}
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != "youtube dQw4w9WgXcQ\n\nvimeo 76979871\nyoutube abc\n" {
+		t.Errorf("expected a single appended line, got %q", data)
+	}
+
+	reopened := NewArchive()
+	if err := reopened.Open(path); err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	if !reopened.Has("youtube", "abc") || reopened.Has("youtube", "other") {
+		t.Error("expected the added entry to survive a reload")
+	}
+}
@@ -0,0 +1,148 @@
+package library
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"downloader/utils"
+)
+
+// Metadata is what the server records about a file in the download folder
+// beyond what the filesystem knows
+type Metadata struct {
+	Media    *Media    `json:"media,omitempty"`
+	Source   *Source   `json:"source,omitempty"`
+	Loudness *Loudness `json:"loudness,omitempty"`
+	// Thumbnail is the sidecar image of the file, when it is not named after it
+	Thumbnail string `json:"thumbnail,omitempty"`
+}
+
+// Index keeps Metadata per file name, persisted as a single JSON file. An
+// index without a path only keeps metadata in memory.
+type Index struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]Metadata
+
+	pending map[string]string // folder of each file ProbeLater queued, by name
+	probing bool              // whether probePending runs
+}
+
+// NewIndex returns an empty in-memory index
+func NewIndex() *Index {
+	return &Index{entries: make(map[string]Metadata)}
+}
+
+// Default is the process-wide index; main points it at the data folder
+var Default = NewIndex()
+
+// Open loads the index saved at path, if any, and saves every later change
+// there. The parent folder is created if needed.
+func (i *Index) Open(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	entries := make(map[string]Metadata)
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return err
+		}
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.path = path
+	i.entries = entries
+	return nil
+}
+
+// Get returns the metadata recorded for name
+func (i *Index) Get(name string) (Metadata, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	md, ok := i.entries[name]
+	return md, ok
+}
+
+// Update applies fn to the metadata of name and saves the index
+func (i *Index) Update(name string, fn func(*Metadata)) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	md := i.entries[name]
+	fn(&md)
+	i.entries[name] = md
+	return i.save()
+}
+
+// Find returns the names, in order, whose metadata match satisfies
+func (i *Index) Find(match func(name string, md Metadata) bool) []string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	var names []string
+	for name, md := range i.entries {
+		if match(name, md) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Rename moves the metadata of oldName to newName and saves the index
+func (i *Index) Rename(oldName, newName string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	md, ok := i.entries[oldName]
+	if !ok {
+		return nil
+	}
+	delete(i.entries, oldName)
+	i.entries[newName] = md
+	return i.save()
+}
+
+// Remove forgets name and saves the index
+func (i *Index) Remove(name string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if _, ok := i.entries[name]; !ok {
+		return nil
+	}
+	delete(i.entries, name)
+	return i.save()
+}
+
+// forget removes name without saving the index
+func (i *Index) forget(name string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	delete(i.entries, name)
+}
+
+// flush saves the changes recorded without saving
+func (i *Index) flush() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.save()
+}
+
+// save writes the index to its path; callers hold mu
+func (i *Index) save() error {
+	if i.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(i.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(i.path, data)
+}
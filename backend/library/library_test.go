@@ -0,0 +1,49 @@
+package library
+
+import (
+	"path/filepath"
+	"testing"
+
+	"downloader/ffmpeg"
+)
+
+func TestIndexPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "library.json")
+
+	index := NewIndex()
+	if err := index.Open(path); err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	loudness := &Loudness{Target: ffmpeg.DefaultLoudnessTarget, Before: ffmpeg.Loudness{Integrated: -27.6}, After: ffmpeg.Loudness{Integrated: -16.1}}
+	if err := index.Update("a.mp3", func(md *Metadata) { md.Loudness = loudness }); err != nil {
+		t.Fatalf("Update() = %v", err)
+	}
+	if err := index.Update("b.mp3", func(md *Metadata) {}); err != nil {
+		t.Fatalf("Update() = %v", err)
+	}
+	if err := index.Remove("b.mp3"); err != nil {
+		t.Fatalf("Remove() = %v", err)
+	}
+
+	reopened := NewIndex()
+	if err := reopened.Open(path); err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	md, ok := reopened.Get("a.mp3")
+	if !ok || md.Loudness == nil || md.Loudness.After.Integrated != -16.1 {
+		t.Errorf("expected the loudness to survive a reload, got %+v", md)
+	}
+	if _, ok := reopened.Get("b.mp3"); ok {
+		t.Error("expected the removed entry to stay removed")
+	}
+}
+
+func TestIndexWithoutPathKeepsMemory(t *testing.T) {
+	index := NewIndex()
+	if err := index.Update("a.mp3", func(md *Metadata) { md.Loudness = &Loudness{} }); err != nil {
+		t.Fatalf("Update() = %v", err)
+	}
+	if md, ok := index.Get("a.mp3"); !ok || md.Loudness == nil {
+		t.Error("expected the entry to be kept in memory")
+	}
+}
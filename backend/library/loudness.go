@@ -0,0 +1,56 @@
+package library
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"downloader/ffmpeg"
+	"downloader/tracing"
+)
+
+// Loudness records a loudness normalization of a file
+type Loudness struct {
+	Target       ffmpeg.LoudnessTarget `json:"target"`
+	Before       ffmpeg.Loudness       `json:"before"`
+	After        ffmpeg.Loudness       `json:"after"`
+	NormalizedAt time.Time             `json:"normalizedAt"`
+}
+
+// Normalize normalizes the loudness of the audio or video file name in folder
+// to target, replacing the file, and records the measurements in the index
+func (i *Index) Normalize(ctx context.Context, folder, name string, target ffmpeg.LoudnessTarget) (*Loudness, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ffmpeg.loudnorm")
+	var err error
+	defer func() { tracing.EndSpan(span, err) }()
+
+	source := filepath.Join(folder, name)
+	var probe *ffmpeg.ProbeResult
+	if probe, err = ffmpeg.Probe(ctx, source); err != nil {
+		return nil, err
+	}
+
+	// ffmpeg cannot write over its input
+	ext := filepath.Ext(name)
+	output := filepath.Join(folder, strings.TrimSuffix(name, ext)+".normalizing"+ext)
+	before, after, err := ffmpeg.NormalizeLoudness(ctx, source, output, target, probe)
+	if err != nil {
+		os.Remove(output)
+		return nil, err
+	}
+	if err = os.Rename(output, source); err != nil {
+		os.Remove(output)
+		return nil, err
+	}
+
+	result := &Loudness{Target: target, Before: before, After: after, NormalizedAt: time.Now().UTC()}
+	err = i.Update(name, func(md *Metadata) { md.Loudness = result })
+	return result, err
+}
+
+// CanNormalize reports whether name is a file loudness normalization applies to
+func CanNormalize(name string) bool {
+	return IsMedia(name)
+}
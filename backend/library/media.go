@@ -0,0 +1,301 @@
+package library
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"downloader/ffmpeg"
+	"downloader/tracing"
+	"downloader/utils"
+)
+
+// Media is the technical metadata ffprobe reports for an audio or video file.
+// Size and ModTime identify the version of the file that was probed.
+type Media struct {
+	Duration   float64   `json:"duration"` // seconds
+	Container  string    `json:"container"`
+	VideoCodec string    `json:"videoCodec,omitempty"`
+	AudioCodec string    `json:"audioCodec,omitempty"`
+	Width      int       `json:"width,omitempty"`
+	Height     int       `json:"height,omitempty"`
+	Bitrate    int64     `json:"bitrate,omitempty"` // bits per second
+	SHA256     string    `json:"sha256"`            // of the file's content, to find identical downloads
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"modTime"`
+	ProbedAt   time.Time `json:"probedAt"`
+}
+
+// probe runs ffprobe; tests substitute it
+var probe = ffmpeg.Probe
+
+// IsMedia reports whether name is an audio or video file the index probes
+func IsMedia(name string) bool {
+	fileType := utils.GetFileType(name)
+	return fileType == "audio" || fileType == "video"
+}
+
+// matches reports whether m was probed from the file described by info.
+// Entries saved before content hashes were recorded are probed again.
+func (m *Media) matches(info os.FileInfo) bool {
+	return m != nil && m.SHA256 != "" && m.Size == info.Size() && m.ModTime.Equal(info.ModTime().UTC())
+}
+
+// Probe returns the technical metadata of the audio or video file name in
+// folder. The recorded metadata is returned as long as the file's size and
+// modification time are unchanged; otherwise the file is probed again and the
+// result recorded. probed reports whether ffprobe ran.
+func (i *Index) Probe(ctx context.Context, folder, name string) (media *Media, probed bool, err error) {
+	media, probed, err = i.probeFile(ctx, folder, name)
+	if err != nil || !probed {
+		return media, probed, err
+	}
+	return media, true, i.Update(name, func(md *Metadata) { md.Media = media })
+}
+
+// probeFile is Probe without recording a new result
+func (i *Index) probeFile(ctx context.Context, folder, name string) (media *Media, probed bool, err error) {
+	stat, err := os.Stat(filepath.Join(folder, name))
+	if err != nil {
+		return nil, false, err
+	}
+	if md, ok := i.Get(name); ok && md.Media.matches(stat) {
+		return md.Media, false, nil
+	}
+
+	path := filepath.Join(folder, name)
+	result, err := probe(ctx, path)
+	if err != nil {
+		return nil, false, err
+	}
+	media = newMedia(name, result, stat)
+	if media.SHA256, err = hashFile(path); err != nil {
+		return nil, false, err
+	}
+	return media, true, nil
+}
+
+// Stale reports whether the audio or video file name in folder changed since
+// it was last probed, or was never probed
+func (i *Index) Stale(folder, name string) bool {
+	if !IsMedia(name) {
+		return false
+	}
+	stat, err := os.Stat(filepath.Join(folder, name))
+	if err != nil {
+		return false
+	}
+	md, _ := i.Get(name)
+	return !md.Media.matches(stat)
+}
+
+// saveBatch is how many probe results Scan and the background prober record
+// before saving the index
+const saveBatch = 50
+
+// ProbeLater probes the file name in folder in the background, recording the
+// result like Probe. Files already waiting are not queued twice; failures are
+// logged.
+func (i *Index) ProbeLater(folder, name string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.pending == nil {
+		i.pending = make(map[string]string)
+	}
+	if _, ok := i.pending[name]; ok {
+		return
+	}
+	i.pending[name] = folder
+	if !i.probing {
+		i.probing = true
+		go i.probePending()
+	}
+}
+
+// probePending probes the files queued by ProbeLater until none are left
+func (i *Index) probePending() {
+	unsaved := 0
+	for {
+		i.mu.Lock()
+		var name, folder string
+		for name, folder = range i.pending {
+			break
+		}
+		if name == "" {
+			i.probing = false
+			var err error
+			if unsaved > 0 {
+				err = i.save()
+			}
+			i.mu.Unlock()
+			if err != nil {
+				slog.Error("failed to save library index", "error", err)
+			}
+			return
+		}
+		delete(i.pending, name)
+		i.mu.Unlock()
+
+		media, probed, err := i.probeFile(context.Background(), folder, name)
+		if err != nil {
+			slog.Warn("failed to probe file", "file", name, "error", err)
+			continue
+		}
+		if !probed {
+			continue
+		}
+		i.setMedia(name, media)
+		if unsaved++; unsaved >= saveBatch {
+			if err := i.flush(); err != nil {
+				slog.Error("failed to save library index", "error", err)
+			}
+			unsaved = 0
+		}
+	}
+}
+
+// setMedia records media for name without saving the index
+func (i *Index) setMedia(name string, media *Media) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	md := i.entries[name]
+	md.Media = media
+	i.entries[name] = md
+}
+
+// hashFile returns the hex SHA-256 of the file at path
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// newMedia converts what ffprobe reported about the file name
+func newMedia(name string, result *ffmpeg.ProbeResult, stat os.FileInfo) *Media {
+	media := &Media{
+		Duration:  result.Duration().Seconds(),
+		Container: container(result.Format.FormatName, name),
+		Size:      stat.Size(),
+		ModTime:   stat.ModTime().UTC(),
+		ProbedAt:  time.Now().UTC(),
+	}
+	media.VideoCodec, media.AudioCodec = result.Codecs()
+	if video := result.VideoStream(); video != nil {
+		media.Width, media.Height = video.Width, video.Height
+	}
+	media.Bitrate, _ = strconv.ParseInt(result.Format.BitRate, 10, 64)
+	return media
+}
+
+// container picks one name from ffprobe's format_name, which lists every
+// format sharing a demuxer ("mov,mp4,m4a,3gp,3g2,mj2"): the file's extension
+// if it is one of them, otherwise the first
+func container(formatName, name string) string {
+	formats := strings.Split(formatName, ",")
+	if ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), ".")); slices.Contains(formats, ext) {
+		return ext
+	}
+	return formats[0]
+}
+
+// ScanResult counts what Scan did
+type ScanResult struct {
+	Probed    int `json:"probed"`
+	Unchanged int `json:"unchanged"`
+	Failed    int `json:"failed"`
+	Removed   int `json:"removed"`
+}
+
+// Scan probes every audio and video file in folder and its subfolders whose size or
+// modification time changed since it was last probed, and forgets files that
+// are gone. Files ffprobe cannot read are counted and skipped. Jobs may index
+// files while a scan runs, so an entry is only forgotten once its file is
+// found missing. Results are saved every saveBatch probes and once at the end.
+func (i *Index) Scan(ctx context.Context, folder string) (ScanResult, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "library.scan")
+	var result ScanResult
+	var err error
+	defer func() { tracing.EndSpan(span, err) }()
+
+	names, err := utils.ListFilesRecursive(folder)
+	if err != nil {
+		return result, err
+	}
+	unsaved := 0
+	present := make(map[string]bool, len(names))
+	for _, name := range names {
+		present[name] = true
+		if !IsMedia(name) {
+			continue
+		}
+		if ctx.Err() != nil {
+			err = ctx.Err()
+			if unsaved > 0 {
+				i.flush()
+			}
+			return result, err
+		}
+
+		media, probed, probeErr := i.probeFile(ctx, folder, name)
+		switch {
+		case probeErr != nil:
+			result.Failed++
+		case probed:
+			result.Probed++
+			i.setMedia(name, media)
+			if unsaved++; unsaved >= saveBatch {
+				if err = i.flush(); err != nil {
+					return result, err
+				}
+				unsaved = 0
+			}
+		default:
+			result.Unchanged++
+		}
+	}
+
+	for _, name := range i.names() {
+		if present[name] {
+			continue
+		}
+		// The file may have been written after the listing
+		if _, statErr := os.Stat(filepath.Join(folder, name)); !errors.Is(statErr, os.ErrNotExist) {
+			continue
+		}
+		i.forget(name)
+		unsaved++
+		result.Removed++
+	}
+	if unsaved > 0 {
+		err = i.flush()
+	}
+	return result, err
+}
+
+// names returns the file names the index has metadata for
+func (i *Index) names() []string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	names := make([]string, 0, len(i.entries))
+	for name := range i.entries {
+		names = append(names, name)
+	}
+	return names
+}
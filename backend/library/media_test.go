@@ -0,0 +1,213 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"downloader/ffmpeg"
+)
+
+// useFakeProbe replaces ffprobe with a canned H.264/AAC result and counts its runs
+func useFakeProbe(t *testing.T) *int {
+	original := probe
+	t.Cleanup(func() { probe = original })
+
+	runs := 0
+	probe = func(ctx context.Context, path string) (*ffmpeg.ProbeResult, error) {
+		runs++
+		var result ffmpeg.ProbeResult
+		result.Format.FormatName = "mov,mp4,m4a,3gp,3g2,mj2"
+		result.Format.Duration = "212.5"
+		result.Format.BitRate = "2400000"
+		result.Streams = []ffmpeg.Stream{
+			{CodecType: "video", CodecName: "h264", Width: 1920, Height: 1080},
+			{CodecType: "audio", CodecName: "aac"},
+		}
+		return &result, nil
+	}
+	return &runs
+}
+
+func TestProbeReprobesChangedFiles(t *testing.T) {
+	runs := useFakeProbe(t)
+	folder := t.TempDir()
+	path := filepath.Join(folder, "video.mp4")
+	os.WriteFile(path, []byte("video"), 0o644)
+
+	index := NewIndex()
+	media, probed, err := index.Probe(context.Background(), folder, "video.mp4")
+	if err != nil || !probed {
+		t.Fatalf("Probe() = %v, %v; want a probe", probed, err)
+	}
+	if media.Container != "mp4" || media.VideoCodec != "h264" || media.AudioCodec != "aac" ||
+		media.Width != 1920 || media.Height != 1080 || media.Duration != 212.5 || media.Bitrate != 2400000 || media.Size != 5 {
+		t.Errorf("unexpected media %+v", media)
+	}
+
+	if _, probed, _ := index.Probe(context.Background(), folder, "video.mp4"); probed {
+		t.Error("expected an unchanged file not to be probed again")
+	}
+
+	// Same size, newer modification time
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	if _, probed, _ := index.Probe(context.Background(), folder, "video.mp4"); !probed {
+		t.Error("expected a touched file to be probed again")
+	}
+
+	os.WriteFile(path, []byte("longer video"), 0o644)
+	os.Chtimes(path, later, later)
+	if _, probed, _ := index.Probe(context.Background(), folder, "video.mp4"); !probed {
+		t.Error("expected a resized file to be probed again")
+	}
+	if *runs != 3 {
+		t.Errorf("expected 3 ffprobe runs, got %d", *runs)
+	}
+}
+
+func TestScan(t *testing.T) {
+	useFakeProbe(t)
+	folder := t.TempDir()
+	for _, name := range []string{"a.mp4", "b.mp3", "notes.txt"} {
+		os.WriteFile(filepath.Join(folder, name), []byte("data"), 0o644)
+	}
+	os.Mkdir(filepath.Join(folder, "sub.mp4"), 0o755)
+
+	index := NewIndex()
+	index.Update("gone.mp3", func(md *Metadata) { md.Source = &Source{URL: "https://example.com"} })
+	if _, _, err := index.Probe(context.Background(), folder, "a.mp4"); err != nil {
+		t.Fatalf("Probe() = %v", err)
+	}
+
+	result, err := index.Scan(context.Background(), folder)
+	if err != nil {
+		t.Fatalf("Scan() = %v", err)
+	}
+	if result != (ScanResult{Probed: 1, Unchanged: 1, Removed: 1}) {
+		t.Errorf("unexpected scan result %+v", result)
+	}
+	if md, _ := index.Get("b.mp3"); md.Media == nil || md.Media.Container != "mov" {
+		t.Errorf("expected b.mp3 to be probed, got %+v", md.Media)
+	}
+	if _, ok := index.Get("gone.mp3"); ok {
+		t.Error("expected the missing file to be forgotten")
+	}
+}
+
+func TestScanKeepsFilesIndexedMeanwhile(t *testing.T) {
+	useFakeProbe(t)
+	folder := t.TempDir()
+	os.WriteFile(filepath.Join(folder, "a.mp4"), []byte("data"), 0o644)
+
+	// A job finishes while the scan probes a.mp4, after the folder was listed
+	index := NewIndex()
+	fake := probe
+	probe = func(ctx context.Context, path string) (*ffmpeg.ProbeResult, error) {
+		if filepath.Base(path) == "a.mp4" {
+			os.WriteFile(filepath.Join(folder, "new.mp3"), []byte("data"), 0o644)
+			index.Update("new.mp3", func(md *Metadata) { md.Source = &Source{URL: "https://example.com"} })
+		}
+		return fake(ctx, path)
+	}
+
+	result, err := index.Scan(context.Background(), folder)
+	if err != nil {
+		t.Fatalf("Scan() = %v", err)
+	}
+	if result.Removed != 0 {
+		t.Errorf("expected nothing removed, got %+v", result)
+	}
+	if _, ok := index.Get("new.mp3"); !ok {
+		t.Error("expected the file indexed during the scan to be kept")
+	}
+}
+
+func TestScanSavesResults(t *testing.T) {
+	useFakeProbe(t)
+	folder := t.TempDir()
+	for i := 0; i < saveBatch+2; i++ {
+		os.WriteFile(filepath.Join(folder, fmt.Sprintf("%d.mp4", i)), []byte("data"), 0o644)
+	}
+
+	path := filepath.Join(t.TempDir(), "library.json")
+	index := NewIndex()
+	if err := index.Open(path); err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	if _, err := index.Scan(context.Background(), folder); err != nil {
+		t.Fatalf("Scan() = %v", err)
+	}
+
+	reopened := NewIndex()
+	if err := reopened.Open(path); err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	if len(reopened.names()) != saveBatch+2 {
+		t.Errorf("expected %d saved entries, got %d", saveBatch+2, len(reopened.names()))
+	}
+}
+
+func TestProbeLater(t *testing.T) {
+	useFakeProbe(t)
+	folder := t.TempDir()
+	os.WriteFile(filepath.Join(folder, "video.mp4"), []byte("video"), 0o644)
+	os.WriteFile(filepath.Join(folder, "notes.txt"), []byte("notes"), 0o644)
+
+	path := filepath.Join(t.TempDir(), "library.json")
+	index := NewIndex()
+	if err := index.Open(path); err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	if index.Stale(folder, "notes.txt") {
+		t.Error("expected other files never to be stale")
+	}
+	if !index.Stale(folder, "video.mp4") {
+		t.Fatal("expected an unprobed video to be stale")
+	}
+
+	index.ProbeLater(folder, "video.mp4")
+	index.ProbeLater(folder, "video.mp4")
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		index.mu.Lock()
+		probing := index.probing
+		index.mu.Unlock()
+		if !probing {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if index.Stale(folder, "video.mp4") {
+		t.Error("expected the video to be probed in the background")
+	}
+
+	reopened := NewIndex()
+	reopened.Open(path)
+	if md, _ := reopened.Get("video.mp4"); md.Media == nil || md.Media.VideoCodec != "h264" {
+		t.Errorf("expected the probe to be saved, got %+v", md.Media)
+	}
+}
+
+func TestContainer(t *testing.T) {
+	tests := []struct {
+		formatName string
+		name       string
+		expected   string
+	}{
+		{"mov,mp4,m4a,3gp,3g2,mj2", "video.MP4", "mp4"},
+		{"mov,mp4,m4a,3gp,3g2,mj2", "song.m4a", "m4a"},
+		{"matroska,webm", "video.webm", "webm"},
+		{"matroska,webm", "video.mkv", "matroska"},
+		{"mp3", "song.mp3", "mp3"},
+	}
+
+	for _, tt := range tests {
+		if got := container(tt.formatName, tt.name); got != tt.expected {
+			t.Errorf("container(%q, %q) = %q; want %q", tt.formatName, tt.name, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package library
+
+import (
+	"time"
+
+	"downloader/ytdlp"
+)
+
+// Source is where a downloaded file came from and how it was downloaded, so
+// it can be downloaded again
+type Source struct {
+	URL          string        `json:"url"`
+	Title        string        `json:"title,omitempty"`
+	Extractor    string        `json:"extractor,omitempty"` // yt-dlp extractor, e.g. "Youtube"
+	VideoID      string        `json:"videoId,omitempty"`
+	FormatID     string        `json:"formatId,omitempty"` // yt-dlp format IDs, e.g. "137+140"
+	Format       string        `json:"format,omitempty"`
+	Options      ytdlp.Options `json:"options"`
+	JobID        string        `json:"jobId,omitempty"`
+	DownloadedAt time.Time     `json:"downloadedAt"`
+}
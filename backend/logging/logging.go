@@ -0,0 +1,178 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader carries the correlation ID between clients, proxies and the server
+const RequestIDHeader = "X-Request-ID"
+
+// UserHeader identifies the caller when the server sits behind an authenticating proxy
+const UserHeader = "X-Forwarded-User"
+
+// AdminToken must be sent as "Authorization: Bearer <token>" to change the
+// log level. Changing it is disabled while ADMIN_TOKEN is unset.
+var AdminToken = os.Getenv("ADMIN_TOKEN")
+
+// Level is the minimum level of the default logger and can be changed at runtime
+var Level = new(slog.LevelVar)
+
+type contextKey struct{}
+
+// Setup installs the default slog logger. LOG_FORMAT selects "json" or "text"
+// output and LOG_LEVEL the initial level.
+func Setup() {
+	if lvl, err := ParseLevel(os.Getenv("LOG_LEVEL")); err == nil {
+		Level.Set(lvl)
+	}
+	slog.SetDefault(New(os.Stderr, os.Getenv("LOG_FORMAT")))
+}
+
+// New creates a logger writing to w in the given format, filtered by Level
+func New(w io.Writer, format string) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: Level}
+	if strings.EqualFold(format, "json") {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// ParseLevel converts "debug", "info", "warn" or "error" into a slog.Level
+func ParseLevel(value string) (slog.Level, error) {
+	var lvl slog.Level
+	err := lvl.UnmarshalText([]byte(strings.TrimSpace(value)))
+	return lvl, err
+}
+
+// WithContext returns a copy of ctx carrying logger
+func WithContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger stored in ctx, or the default logger
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// Middleware assigns every request an ID, honoring a valid incoming
+// X-Request-ID, echoes it in the response, attaches a request-scoped logger
+// to the request context and writes one access log line per request
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = NewID()
+		}
+		c.Header(RequestIDHeader, requestID)
+
+		logger := slog.Default().With("request_id", requestID)
+		if sc := trace.SpanContextFromContext(c.Request.Context()); sc.IsValid() {
+			logger = logger.With("trace_id", sc.TraceID().String())
+		}
+		c.Request = c.Request.WithContext(WithContext(c.Request.Context(), logger))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logger.Log(c.Request.Context(), level, "request completed",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", status,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"client_ip", c.ClientIP(),
+			"user", User(c),
+		)
+	}
+}
+
+// User returns the caller named by X-Forwarded-User, or "anonymous"
+func User(c *gin.Context) string {
+	if user := c.GetHeader(UserHeader); user != "" {
+		return user
+	}
+	return "anonymous"
+}
+
+// LevelHandler reports the current log level on GET and changes it on PUT
+// with a body such as {"level":"debug"} and the AdminToken
+func LevelHandler(c *gin.Context) {
+	if c.Request.Method == http.MethodPut {
+		if AdminToken == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Changing the log level is disabled; set ADMIN_TOKEN to enable it"})
+			return
+		}
+		if !authorizedAdmin(c.Request) {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing or invalid admin token"})
+			return
+		}
+
+		var body struct {
+			Level string `json:"level"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		lvl, err := ParseLevel(body.Level)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid level. Choose 'debug', 'info', 'warn' or 'error'"})
+			return
+		}
+		Level.Set(lvl)
+		FromContext(c.Request.Context()).Info("log level changed", "level", lvl.String())
+	}
+
+	c.JSON(http.StatusOK, gin.H{"level": strings.ToLower(Level.Level().String())})
+}
+
+// authorizedAdmin reports whether r carries the AdminToken as a bearer token
+func authorizedAdmin(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(AdminToken)) == 1
+}
+
+// NewID returns a random 16 character hex identifier
+func NewID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts short IDs made of URL-safe characters so a client
+// cannot inject arbitrary content into logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,174 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupLoggingRouter(buf *bytes.Buffer) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	slog.SetDefault(New(buf, "json"))
+
+	router := gin.New()
+	router.Use(Middleware())
+	router.GET("/ping", func(c *gin.Context) {
+		FromContext(c.Request.Context()).Info("handled")
+		c.Status(http.StatusNoContent)
+	})
+	router.GET("/admin/log-level", LevelHandler)
+	router.PUT("/admin/log-level", LevelHandler)
+	return router
+}
+
+func TestMiddlewareHonorsIncomingRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	router := setupLoggingRouter(&buf)
+
+	req, _ := http.NewRequest(http.MethodGet, "/ping", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	req.Header.Set(UserHeader, "alice")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get(RequestIDHeader); got != "abc-123" {
+		t.Errorf("expected response request ID abc-123, got %q", got)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 log lines, got %d: %s", len(lines), buf.String())
+	}
+	for _, line := range lines {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("log line is not JSON: %s", line)
+		}
+		if record["request_id"] != "abc-123" {
+			t.Errorf("expected request_id abc-123 in %s", line)
+		}
+	}
+	if !strings.Contains(lines[1], `"user":"alice"`) || !strings.Contains(lines[1], `"status":204`) {
+		t.Errorf("expected access log with user and status, got %s", lines[1])
+	}
+}
+
+func TestMiddlewareGeneratesRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+	}{
+		{"missing", ""},
+		{"injection", "bad id\n{\"level\":\"ERROR\"}"},
+		{"too long", strings.Repeat("a", 200)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			router := setupLoggingRouter(&buf)
+
+			req, _ := http.NewRequest(http.MethodGet, "/ping", nil)
+			if tt.incoming != "" {
+				req.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			got := rec.Header().Get(RequestIDHeader)
+			if len(got) != 16 || got == tt.incoming {
+				t.Errorf("expected a generated 16 character ID, got %q", got)
+			}
+		})
+	}
+}
+
+func TestLevelHandler(t *testing.T) {
+	var buf bytes.Buffer
+	router := setupLoggingRouter(&buf)
+	defer Level.Set(slog.LevelInfo)
+	defer func(token string) { AdminToken = token }(AdminToken)
+	AdminToken = "s3cret"
+
+	req, _ := http.NewRequest(http.MethodPut, "/admin/log-level", strings.NewReader(`{"level":"debug"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || Level.Level() != slog.LevelDebug {
+		t.Fatalf("expected level debug, got status %d level %s", rec.Code, Level.Level())
+	}
+
+	req, _ = http.NewRequest(http.MethodPut, "/admin/log-level", strings.NewReader(`{"level":"verbose"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for unknown level, got %d", rec.Code)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "/admin/log-level", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Body.String() != `{"level":"debug"}` {
+		t.Errorf("expected current level debug, got %s", rec.Body.String())
+	}
+}
+
+func TestLevelHandlerRequiresAdminToken(t *testing.T) {
+	var buf bytes.Buffer
+	router := setupLoggingRouter(&buf)
+	defer Level.Set(slog.LevelInfo)
+	defer func(token string) { AdminToken = token }(AdminToken)
+
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		expected      int
+	}{
+		{name: "disabled without a token", authorization: "Bearer anything", expected: http.StatusForbidden},
+		{name: "missing header", token: "s3cret", expected: http.StatusUnauthorized},
+		{name: "wrong token", token: "s3cret", authorization: "Bearer guess", expected: http.StatusUnauthorized},
+		{name: "wrong scheme", token: "s3cret", authorization: "Basic s3cret", expected: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			AdminToken = tt.token
+			req, _ := http.NewRequest(http.MethodPut, "/admin/log-level", strings.NewReader(`{"level":"error"}`))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expected {
+				t.Errorf("expected status %d, got %d", tt.expected, rec.Code)
+			}
+			if Level.Level() == slog.LevelError {
+				t.Error("expected the level unchanged")
+			}
+		})
+	}
+
+	// Reading the level needs no token
+	AdminToken = "s3cret"
+	req, _ := http.NewRequest(http.MethodGet, "/admin/log-level", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected GET to succeed, got %d", rec.Code)
+	}
+}
@@ -1,27 +1,203 @@
 package main
 
 import (
+	"context"
+	"downloader/handlers"
+	"downloader/health"
+	"downloader/jobs"
+	"downloader/library"
+	"downloader/logging"
 	"downloader/router"
+	"downloader/shares"
+	"downloader/subscriptions"
+	"downloader/tracing"
 	"downloader/utils"
-	"log"
+	"downloader/webhooks"
+	"downloader/ytdlp"
+	"errors"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
-	r := gin.Default()
+	logging.Setup()
+
+	shutdownTracing, err := tracing.Setup(context.Background())
+	if err != nil {
+		slog.Error("failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+	defer shutdownTracing(context.Background())
+
+	// Access logging is done by logging.Middleware, so skip gin's default logger
+	r := gin.New()
+	r.Use(gin.Recovery())
 
 	// Setup download folder if needed
 	downloadFolder := utils.GetDownloadFolder()
 	if _, err := os.Stat(downloadFolder); os.IsNotExist(err) {
 		if err := os.MkdirAll(downloadFolder, os.ModePerm); err != nil {
-			log.Printf("Failed to create download folder: %v\n", err)
+			slog.Error("failed to create download folder", "path", downloadFolder, "error", err)
 		}
 	}
 
+	// A bad OUTPUT_TEMPLATE would fail every download
+	if err := ytdlp.ValidateNamingTemplate(ytdlp.NamingTemplate()); err != nil {
+		slog.Error("invalid OUTPUT_TEMPLATE", "error", err)
+		os.Exit(1)
+	}
+
+	// A bad WEBHOOK_URLS or WEBHOOK_EVENTS would lose every notification
+	if err := webhooks.Default.Validate(); err != nil {
+		slog.Error("invalid webhook configuration", "error", err)
+		os.Exit(1)
+	}
+
+	// Persist jobs and pick up whatever the previous process left unfinished
+	store, err := jobs.OpenFileStore(filepath.Join(utils.GetDataFolder(), "jobs.json"))
+	if err != nil {
+		slog.Error("failed to open job store", "error", err)
+		os.Exit(1)
+	}
+	jobs.Default.UseStore(store)
+	handlers.Readiness.Add(health.StoreCheck("job_store", store))
+
+	// Batches group the jobs submitted together
+	if err := jobs.Default.Batches.Open(filepath.Join(utils.GetDataFolder(), "batches.json")); err != nil {
+		slog.Error("failed to open batch store", "error", err)
+		os.Exit(1)
+	}
+
+	// Metadata recorded about downloaded files, such as probe results and loudness
+	if err := library.Default.Open(filepath.Join(utils.GetDataFolder(), "library.json")); err != nil {
+		slog.Error("failed to open library index", "error", err)
+		os.Exit(1)
+	}
+
+	// Videos already downloaded, in yt-dlp's --download-archive format so the
+	// file can be shared with yt-dlp
+	archive := os.Getenv("DOWNLOAD_ARCHIVE")
+	if archive == "" {
+		archive = filepath.Join(utils.GetDataFolder(), "archive.txt")
+	}
+	if err := library.DefaultArchive.Open(archive); err != nil {
+		slog.Error("failed to open download archive", "path", archive, "error", err)
+		os.Exit(1)
+	}
+
+	// Share links and the secret signing them
+	if err := shares.Default.Open(filepath.Join(utils.GetDataFolder(), "shares.json"), os.Getenv("SHARE_SECRET")); err != nil {
+		slog.Error("failed to open share links", "error", err)
+		os.Exit(1)
+	}
+
+	// Channels and playlists synced on a schedule; their entries are held to
+	// the same URL policy as requests
+	if err := subscriptions.Default.Store.Open(filepath.Join(utils.GetDataFolder(), "subscriptions.json")); err != nil {
+		slog.Error("failed to open subscriptions", "error", err)
+		os.Exit(1)
+	}
+	subscriptions.Default.Policy = handlers.URLPolicy
+
+	// yt-dlp resolves hosts and follows redirects itself, so its connections
+	// go through a proxy that checks every address and media host it dials
+	if !handlers.URLPolicy.AllowPrivate || len(handlers.URLPolicy.MediaHosts) > 0 {
+		proxy, err := handlers.URLPolicy.StartProxy()
+		if err != nil {
+			slog.Error("failed to start URL policy proxy", "error", err)
+			os.Exit(1)
+		}
+		defer proxy.Close()
+		ytdlp.Proxy = proxy.URL()
+	}
+
+	recovered, err := jobs.Default.Recover(
+		utils.EnvInt("JOB_MAX_ATTEMPTS", 2),
+		utils.EnvDuration("PARTIAL_MAX_AGE", 24*time.Hour),
+	)
+	if err != nil {
+		slog.Error("failed to recover jobs", "store", store.Path(), "error", err)
+	} else {
+		slog.Info("job recovery finished",
+			"resumed", recovered.Resumed,
+			"failed", recovered.Failed,
+			"partials_removed", recovered.PartialsRemoved,
+		)
+	}
+
+	// Probe files added or changed while the server was down; listings queue
+	// any file the scan has not reached yet for a background probe
+	go func() {
+		scanned, err := library.Default.Scan(context.Background(), downloadFolder)
+		if err != nil {
+			slog.Error("library scan failed", "error", err)
+			return
+		}
+		slog.Info("library scan finished",
+			"probed", scanned.Probed,
+			"unchanged", scanned.Unchanged,
+			"failed", scanned.Failed,
+			"removed", scanned.Removed,
+		)
+	}()
+
+	// Resumed jobs are queued first, so the archive knows what they download
+	// before the first sync lists anything
+	subscriptions.Default.Start()
+
 	// Register routes
 	router.SetupRoutes(r)
 
-	r.Run(":5000")
+	srv := &http.Server{Addr: ":5000", Handler: r}
+	go func() {
+		slog.Info("server starting", "addr", srv.Addr, "download_folder", downloadFolder)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("server stopped", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	stop()
+
+	// Refuse new jobs but keep serving HTTP so SSE clients are told about the
+	// shutdown and running jobs can finish within the drain period
+	drain := utils.EnvDuration("SHUTDOWN_DRAIN_TIMEOUT", 60*time.Second)
+	slog.Info("shutting down", "drain_timeout", drain.String(), "running_jobs", jobs.Default.Running())
+
+	// Stop syncing first so no run queues jobs while they drain
+	syncCtx, cancelSync := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelSync()
+	if err := subscriptions.Default.Shutdown(syncCtx); err != nil {
+		slog.Warn("subscription runs cut off by shutdown", "error", err)
+	}
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	if err := jobs.Default.Shutdown(drainCtx); err != nil {
+		slog.Warn("drain period expired; unfinished jobs will resume on next start", "error", err)
+	}
+
+	// Send the events of jobs that finished while draining; retries are given up
+	webhookCtx, cancelWebhooks := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelWebhooks()
+	if err := webhooks.Default.Shutdown(webhookCtx); err != nil {
+		slog.Warn("webhook deliveries cut off by shutdown", "error", err)
+	}
+
+	httpCtx, cancelHTTP := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelHTTP()
+	if err := srv.Shutdown(httpCtx); err != nil {
+		slog.Error("HTTP server shutdown failed", "error", err)
+	}
+	slog.Info("shutdown complete")
 }
@@ -0,0 +1,176 @@
+package metrics
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds every downloader collector plus the Go runtime and process collectors
+var Registry = prometheus.NewRegistry()
+
+var (
+	JobsStarted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "downloader_jobs_started_total",
+		Help: "Download jobs that acquired a worker and started running.",
+	}, []string{"format", "host"})
+
+	JobsCompleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "downloader_jobs_completed_total",
+		Help: "Download jobs that finished successfully.",
+	}, []string{"format", "host"})
+
+	JobsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "downloader_jobs_failed_total",
+		Help: "Download jobs that finished with an error.",
+	}, []string{"format", "host"})
+
+	DownloadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "downloader_download_duration_seconds",
+		Help:    "Wall-clock time of a download job from start to finish.",
+		Buckets: []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800},
+	}, []string{"format", "status"})
+
+	BytesDownloaded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "downloader_bytes_downloaded_total",
+		Help: "Size of files produced by completed download jobs.",
+	}, []string{"format", "host"})
+
+	SpawnLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "downloader_ytdlp_spawn_seconds",
+		Help:    "Time taken to start a yt-dlp process.",
+		Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1},
+	})
+
+	QueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "downloader_queue_depth",
+		Help: "Download jobs waiting for a free worker.",
+	})
+
+	ActiveJobs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "downloader_active_jobs",
+		Help: "Download jobs currently running.",
+	})
+
+	ActiveSSEConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "downloader_sse_connections",
+		Help: "Open Server-Sent Events progress streams.",
+	})
+
+	FilesServed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "downloader_files_served_total",
+		Help: "Files streamed to clients by ServeFile.",
+	})
+
+	BytesServed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "downloader_bytes_served_total",
+		Help: "Bytes streamed to clients by ServeFile and CreateArchive.",
+	})
+
+	ArchivesServed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "downloader_archives_served_total",
+		Help: "ZIP and tar archives streamed to clients, by format and whether they completed, failed or were cancelled.",
+	}, []string{"format", "result"})
+
+	WebhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "downloader_webhook_deliveries_total",
+		Help: "Webhook deliveries by event and whether they were delivered or failed after their last attempt.",
+	}, []string{"event", "result"})
+
+	SubscriptionRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "downloader_subscription_runs_total",
+		Help: "Subscription sync runs by trigger and whether they completed, failed or were interrupted.",
+	}, []string{"trigger", "status"})
+
+	SubscriptionItems = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "downloader_subscription_items_total",
+		Help: "Entries seen by subscription sync runs, by whether they were downloaded, skipped or failed.",
+	}, []string{"result"})
+
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "downloader_http_request_duration_seconds",
+		Help:    "Latency of HTTP requests handled by the Gin engine.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		JobsStarted, JobsCompleted, JobsFailed,
+		DownloadDuration, BytesDownloaded, SpawnLatency,
+		QueueDepth, ActiveJobs, ActiveSSEConnections,
+		FilesServed, BytesServed, ArchivesServed, WebhookDeliveries,
+		SubscriptionRuns, SubscriptionItems, HTTPRequestDuration,
+	)
+}
+
+// Handler exposes the registry in the Prometheus text format
+func Handler() gin.HandlerFunc {
+	h := promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+	return gin.WrapH(h)
+}
+
+// Middleware records request latency per matched route. Unmatched paths are
+// grouped under "unmatched" to keep label cardinality bounded.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		HTTPRequestDuration.
+			WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+// hostLabels maps the sites most downloads come from to their host label.
+// Hosts are matched with their subdomains; every other host is "other", so
+// the label cannot grow with the URLs clients send.
+var hostLabels = map[string]string{
+	"youtube.com":          "youtube",
+	"youtu.be":             "youtube",
+	"youtube-nocookie.com": "youtube",
+	"vimeo.com":            "vimeo",
+	"soundcloud.com":       "soundcloud",
+	"twitch.tv":            "twitch",
+	"dailymotion.com":      "dailymotion",
+	"tiktok.com":           "tiktok",
+	"twitter.com":          "twitter",
+	"x.com":                "twitter",
+	"instagram.com":        "instagram",
+	"facebook.com":         "facebook",
+	"fb.watch":             "facebook",
+	"reddit.com":           "reddit",
+	"bandcamp.com":         "bandcamp",
+	"bilibili.com":         "bilibili",
+}
+
+// HostLabel turns a URL host into one of a fixed set of metric labels:
+// the site for well-known hosts, "other" for the rest and "unknown" if empty
+func HostLabel(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" {
+		return "unknown"
+	}
+	for domain := host; ; {
+		if label, ok := hostLabels[domain]; ok {
+			return label
+		}
+		_, parent, found := strings.Cut(domain, ".")
+		if !found {
+			return "other"
+		}
+		domain = parent
+	}
+}
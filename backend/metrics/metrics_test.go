@@ -0,0 +1,28 @@
+package metrics
+
+import "testing"
+
+func TestHostLabel(t *testing.T) {
+	tests := []struct {
+		host     string
+		expected string
+	}{
+		{"www.youtube.com", "youtube"},
+		{"music.youtube.com", "youtube"},
+		{"youtu.be", "youtube"},
+		{"VIMEO.COM.", "vimeo"},
+		{"x.com", "twitter"},
+		{"artist.bandcamp.com", "bandcamp"},
+		{"notyoutube.com", "other"},
+		{"youtube.com.example.net", "other"},
+		{"cdn-4521.example.org", "other"},
+		{"localhost", "other"},
+		{"", "unknown"},
+	}
+
+	for _, tt := range tests {
+		if got := HostLabel(tt.host); got != tt.expected {
+			t.Errorf("HostLabel(%q) = %q; want %q", tt.host, got, tt.expected)
+		}
+	}
+}
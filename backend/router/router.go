@@ -2,6 +2,9 @@ package router
 
 import (
 	"downloader/handlers"
+	"downloader/logging"
+	"downloader/metrics"
+	"downloader/tracing"
 	"os"
 
 	"github.com/gin-contrib/cors"
@@ -18,16 +21,56 @@ func SetupRoutes(r *gin.Engine) {
 	// CORS config
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{frontendOrigin},
-		AllowMethods:     []string{"GET", "POST"},
-		AllowHeaders:     []string{"Content-Type", "Content-Disposition", "Content-Length"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE"},
+		AllowHeaders:     []string{"Authorization", "Content-Type", "Content-Disposition", "Content-Length", "Range", "X-Share-Password", "X-Share-Claim", logging.RequestIDHeader, "traceparent", "tracestate"},
+		ExposeHeaders:    []string{logging.RequestIDHeader, "traceparent", "tracestate", "Content-Disposition", "X-Estimated-Size", "X-Share-Claim"},
 		AllowCredentials: true,
 	}))
 
+	// Tracing, request IDs, access logs and latency for every route below
+	r.Use(tracing.Middleware())
+	r.Use(logging.Middleware())
+	r.Use(metrics.Middleware())
+
+	// Nested file paths are sent with "/" escaped as %2F, so that they match
+	// one :filename segment
+	r.UseRawPath = true
+
 	// Routes
 	r.GET("/", handlers.HealthCheck)
+	r.GET("/healthz", handlers.Liveness)
+	r.GET("/readyz", handlers.Ready)
+	r.GET("/metrics", metrics.Handler())
+	r.GET("/admin/log-level", logging.LevelHandler)
+	r.PUT("/admin/log-level", logging.LevelHandler)
+	r.GET("/capabilities", handlers.GetCapabilities)
 	r.POST("/download", handlers.DownloadVideo)
 	r.POST("/thumbnail", handlers.GetThumbnail)
 	r.GET("/download/stream", handlers.DownloadWithProgress)
+	r.GET("/jobs", handlers.ListJobs)
+	r.GET("/jobs/:id", handlers.GetJob)
+	r.POST("/batch", handlers.CreateBatch)
+	r.GET("/batch/:id", handlers.GetBatch)
+	r.POST("/batch/:id/retry", handlers.RetryBatch)
+	r.POST("/subscriptions", handlers.CreateSubscription)
+	r.GET("/subscriptions", handlers.ListSubscriptions)
+	r.GET("/subscriptions/:id", handlers.GetSubscription)
+	r.PUT("/subscriptions/:id", handlers.UpdateSubscription)
+	r.DELETE("/subscriptions/:id", handlers.DeleteSubscription)
+	r.POST("/subscriptions/:id/sync", handlers.SyncSubscription)
+	r.GET("/subscriptions/:id/runs", handlers.ListSubscriptionRuns)
+	r.GET("/webhooks/deliveries", handlers.ListWebhookDeliveries)
 	r.GET("/files", handlers.ListFiles)
 	r.GET("/files/:filename", handlers.ServeFile)
+	r.POST("/files/archive", handlers.CreateArchive)
+	r.GET("/files/:filename/info", handlers.GetFileInfo)
+	r.POST("/files/:filename/normalize", handlers.NormalizeFile)
+	r.POST("/files/:filename/refresh", handlers.RefreshFile)
+	r.POST("/files/:filename/share", handlers.CreateShare)
+	r.GET("/shares", handlers.ListShares)
+	r.DELETE("/shares/:id", handlers.RevokeShare)
+	r.GET("/shared/:id", handlers.GetShared)
+	r.HEAD("/shared/:id", handlers.GetShared)
+	r.POST("/folders", handlers.CreateFolder)
+	r.DELETE("/folders", handlers.DeleteFolder)
 }
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
@@ -45,3 +46,27 @@ func TestCORSConfig(t *testing.T) {
 		t.Errorf("Expected CORS header to allow 'http://test-origin.com', got '%s'", allowedOrigin)
 	}
 }
+
+func TestMetricsRoute(t *testing.T) {
+	r := setupRouterForTest()
+
+	// Hit a route first so the latency histogram has a sample
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	req, _ := http.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	for _, name := range []string{
+		"downloader_http_request_duration_seconds_count{method=\"GET\",route=\"/\",status=\"200\"}",
+		"downloader_queue_depth",
+		"downloader_sse_connections",
+	} {
+		if !strings.Contains(rec.Body.String(), name) {
+			t.Errorf("Expected metrics output to contain %s", name)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+// Package shares keeps the links that give people without API access one
+// downloaded file. Links are signed with HMAC-SHA256, expire, and may limit
+// the number of downloads and require a password.
+package shares
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"downloader/logging"
+	"downloader/utils"
+)
+
+// Errors returned when a share link cannot be used
+var (
+	ErrNotFound  = errors.New("share link not found")
+	ErrSignature = errors.New("invalid share link signature")
+	ErrExpired   = errors.New("share link expired")
+	ErrExhausted = errors.New("share link download limit reached")
+	ErrPassword  = errors.New("share link password required or wrong")
+)
+
+// passwordIterations is the PBKDF2 work factor for share passwords
+const passwordIterations = 100_000
+
+// Share is one share link
+type Share struct {
+	ID           string    `json:"id"`
+	File         string    `json:"file"`
+	CreatedBy    string    `json:"createdBy"`
+	CreatedAt    time.Time `json:"createdAt"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	MaxDownloads int       `json:"maxDownloads,omitempty"` // 0 for no limit
+	Downloads    int       `json:"downloads"`
+	// PasswordSalt and PasswordHash are hex PBKDF2-SHA256 of the password
+	PasswordSalt string `json:"passwordSalt,omitempty"`
+	PasswordHash string `json:"passwordHash,omitempty"`
+}
+
+// Protected reports whether the link requires a password
+func (s Share) Protected() bool {
+	return s.PasswordHash != ""
+}
+
+// Active reports whether the link can still be used at now
+func (s Share) Active(now time.Time) bool {
+	return now.Before(s.ExpiresAt) && (s.MaxDownloads == 0 || s.Downloads < s.MaxDownloads)
+}
+
+// Store keeps share links, persisted as a single JSON file, and the secret
+// they are signed with. A store without a path only keeps links in memory.
+type Store struct {
+	mu     sync.Mutex
+	path   string
+	secret []byte
+	shares map[string]Share
+	// redeemed holds the claim tokens used up by Redeem, by their MAC, until
+	// they expire
+	redeemed map[string]time.Time
+}
+
+// NewStore returns an empty in-memory store signing with a random secret
+func NewStore() *Store {
+	return &Store{secret: randomBytes(32), shares: make(map[string]Share), redeemed: make(map[string]time.Time)}
+}
+
+// Default is the process-wide store; main points it at the data folder
+var Default = NewStore()
+
+// Open loads the links saved at path, if any, and saves every later change
+// there. Links are signed with secret; if it is empty, a secret kept beside
+// path is used, created on first use, so that links survive restarts.
+func (s *Store) Open(path, secret string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	key := []byte(secret)
+	if secret == "" {
+		var err error
+		if key, err = loadSecret(filepath.Join(filepath.Dir(path), "share-secret")); err != nil {
+			return err
+		}
+	}
+
+	shares := make(map[string]Share)
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(data, &shares); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = path
+	s.secret = key
+	s.shares = shares
+	return nil
+}
+
+// loadSecret reads the secret at path, creating a random one if there is none
+func loadSecret(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return hex.DecodeString(strings.TrimSpace(string(data)))
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	secret := randomBytes(32)
+	return secret, os.WriteFile(path, []byte(hex.EncodeToString(secret)+"\n"), 0o600)
+}
+
+// Create records a link to file that expires after ttl. maxDownloads 0 and an
+// empty password leave the link unlimited and open.
+func (s *Store) Create(file, user string, ttl time.Duration, maxDownloads int, password string) (Share, error) {
+	now := time.Now().UTC()
+	share := Share{
+		ID:           logging.NewID(),
+		File:         file,
+		CreatedBy:    user,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(ttl).Truncate(time.Second),
+		MaxDownloads: maxDownloads,
+	}
+	if password != "" {
+		salt := randomBytes(16)
+		share.PasswordSalt = hex.EncodeToString(salt)
+		share.PasswordHash = hashPassword(password, salt)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shares[share.ID] = share
+	return share, s.save()
+}
+
+// Signature returns the signature of a share's link, covering its ID, file
+// and expiry
+func (s *Store) Signature(share Share) string {
+	s.mu.Lock()
+	secret := s.secret
+	s.mu.Unlock()
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(share.ID + "\n" + share.File + "\n" + strconv.FormatInt(share.ExpiresAt.Unix(), 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Path returns the path of a share's link, relative to the server
+func (s *Store) Path(share Share) string {
+	return "/shared/" + share.ID + "?expires=" + strconv.FormatInt(share.ExpiresAt.Unix(), 10) + "&signature=" + s.Signature(share)
+}
+
+// Verify checks a link's signature and expiry and, for a protected link, the
+// password, and returns its share. Use Claim to count a download. A link that
+// reached its download limit is returned with ErrExhausted, so that a
+// download claimed before can go on (see Claimed).
+func (s *Store) Verify(id, expires, signature, password string) (Share, error) {
+	s.mu.Lock()
+	share, ok := s.shares[id]
+	s.mu.Unlock()
+	if !ok {
+		return Share{}, ErrNotFound
+	}
+	if expires != strconv.FormatInt(share.ExpiresAt.Unix(), 10) || !hmac.Equal([]byte(signature), []byte(s.Signature(share))) {
+		return Share{}, ErrSignature
+	}
+	now := time.Now()
+	if !now.Before(share.ExpiresAt) {
+		return Share{}, ErrExpired
+	}
+	if share.Protected() {
+		salt, _ := hex.DecodeString(share.PasswordSalt)
+		if subtle.ConstantTimeCompare([]byte(hashPassword(password, salt)), []byte(share.PasswordHash)) != 1 {
+			return Share{}, ErrPassword
+		}
+	}
+	if share.MaxDownloads > 0 && share.Downloads >= share.MaxDownloads {
+		return share, ErrExhausted
+	}
+	return share, nil
+}
+
+// Claim counts one download of the link id, failing once its limit is reached
+func (s *Store) Claim(id string) (Share, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	share, ok := s.shares[id]
+	if !ok {
+		return Share{}, ErrNotFound
+	}
+	if share.MaxDownloads > 0 && share.Downloads >= share.MaxDownloads {
+		return Share{}, ErrExhausted
+	}
+	share.Downloads++
+	s.shares[id] = share
+	return share, s.save()
+}
+
+// ClaimToken returns a token proving that the latest download of share was
+// claimed, valid until the given time. A request carrying it continues that
+// download without counting another; see Redeem.
+func (s *Store) ClaimToken(share Share, until time.Time) string {
+	return s.claimToken(share, strconv.Itoa(share.Downloads), strconv.FormatInt(until.Unix(), 10))
+}
+
+// claimToken signs a claim of the given download, valid until the given Unix
+// time; a random nonce sets apart the tokens of the same claim
+func (s *Store) claimToken(share Share, downloads, until string) string {
+	claim := downloads + "." + until + "." + hex.EncodeToString(randomBytes(8))
+	return claim + "." + s.claimMAC(share, claim)
+}
+
+// Claimed reports whether token is a valid, unexpired claim token of share
+// that was not redeemed yet
+func (s *Store) Claimed(share Share, token string) bool {
+	_, _, _, ok := s.parseClaim(share, token)
+	return ok
+}
+
+// Redeem uses up the claim token of share and returns the token that
+// replaces it, for the same download, and when both expire, so that each
+// token continues a download only once. It reports false for tokens Claimed
+// rejects. Used up tokens are only remembered in memory, until they expire.
+func (s *Store) Redeem(share Share, token string) (string, time.Time, bool) {
+	downloads, until, mac, ok := s.parseClaim(share, token)
+	if !ok {
+		return "", time.Time{}, false
+	}
+	unix, _ := strconv.ParseInt(until, 10, 64)
+	expiry := time.Unix(unix, 0)
+	s.mu.Lock()
+	now := time.Now()
+	for used, usedExpiry := range s.redeemed {
+		if !now.Before(usedExpiry) {
+			delete(s.redeemed, used)
+		}
+	}
+	// Checked again under the lock, for concurrent requests with one token
+	_, used := s.redeemed[mac]
+	if !used {
+		s.redeemed[mac] = expiry
+	}
+	s.mu.Unlock()
+	if used {
+		return "", time.Time{}, false
+	}
+	return s.claimToken(share, downloads, until), expiry, true
+}
+
+// parseClaim checks a claim token of share and returns its parts
+func (s *Store) parseClaim(share Share, token string) (downloads, until, mac string, ok bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 {
+		return "", "", "", false
+	}
+	downloads, until, mac = parts[0], parts[1], parts[3]
+	if n, err := strconv.Atoi(downloads); err != nil || n < 1 || n > share.Downloads {
+		return "", "", "", false
+	}
+	if expiry, err := strconv.ParseInt(until, 10, 64); err != nil || !time.Now().Before(time.Unix(expiry, 0)) {
+		return "", "", "", false
+	}
+	if !hmac.Equal([]byte(mac), []byte(s.claimMAC(share, strings.Join(parts[:3], ".")))) {
+		return "", "", "", false
+	}
+	s.mu.Lock()
+	_, used := s.redeemed[mac]
+	s.mu.Unlock()
+	return downloads, until, mac, !used
+}
+
+// claimMAC signs a claim of share, which a link's signature cannot stand for
+func (s *Store) claimMAC(share Share, claim string) string {
+	s.mu.Lock()
+	secret := s.secret
+	s.mu.Unlock()
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("claim\n" + share.ID + "\n" + claim))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// List returns the links that can still be used, newest first, and forgets
+// the rest
+func (s *Store) List() ([]Share, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var active []Share
+	pruned := false
+	for id, share := range s.shares {
+		if !share.Active(now) {
+			delete(s.shares, id)
+			pruned = true
+			continue
+		}
+		active = append(active, share)
+	}
+	slices.SortFunc(active, func(a, b Share) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	if pruned {
+		return active, s.save()
+	}
+	return active, nil
+}
+
+// Revoke removes the link id; it fails with ErrNotFound for unknown links
+func (s *Store) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.shares[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.shares, id)
+	return s.save()
+}
+
+// save writes the links to the store's path; callers hold mu
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.shares, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(s.path, data)
+}
+
+// hashPassword returns the hex PBKDF2-SHA256 of password with salt
+func hashPassword(password string, salt []byte) string {
+	key, _ := pbkdf2.Key(sha256.New, password, salt, passwordIterations, 32)
+	return hex.EncodeToString(key)
+}
+
+// randomBytes returns n bytes from the system's secure random source
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
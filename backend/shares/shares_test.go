@@ -0,0 +1,219 @@
+package shares
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// linkParams returns the expires and signature parameters of a share's link
+func linkParams(t *testing.T, store *Store, share Share) (string, string) {
+	u, err := url.Parse(store.Path(share))
+	if err != nil {
+		t.Fatalf("invalid link %q: %v", store.Path(share), err)
+	}
+	return u.Query().Get("expires"), u.Query().Get("signature")
+}
+
+func TestVerify(t *testing.T) {
+	store := NewStore()
+	share, err := store.Create("Channel/Talk.mp4", "alice", time.Hour, 0, "")
+	if err != nil {
+		t.Fatalf("Create() = %v", err)
+	}
+	expires, signature := linkParams(t, store, share)
+	later := strconv.FormatInt(share.ExpiresAt.Add(time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name      string
+		id        string
+		expires   string
+		signature string
+		want      error
+	}{
+		{"valid", share.ID, expires, signature, nil},
+		{"unknown", "0123456789abcdef", expires, signature, ErrNotFound},
+		{"tampered signature", share.ID, expires, signature[1:] + "A", ErrSignature},
+		{"missing signature", share.ID, expires, "", ErrSignature},
+		{"extended expiry", share.ID, later, signature, ErrSignature},
+	}
+
+	for _, test := range tests {
+		if _, err := store.Verify(test.id, test.expires, test.signature, ""); !errors.Is(err, test.want) {
+			t.Errorf("%s: Verify() = %v; want %v", test.name, err, test.want)
+		}
+	}
+
+	// Another secret signs differently
+	other := NewStore()
+	if other.Signature(share) == signature {
+		t.Error("expected signatures to depend on the secret")
+	}
+}
+
+func TestVerifyExpired(t *testing.T) {
+	store := NewStore()
+	share, _ := store.Create("Top.mp3", "alice", -time.Minute, 0, "")
+	expires, signature := linkParams(t, store, share)
+
+	if _, err := store.Verify(share.ID, expires, signature, ""); !errors.Is(err, ErrExpired) {
+		t.Errorf("Verify() = %v; want ErrExpired", err)
+	}
+}
+
+func TestClaimLimit(t *testing.T) {
+	store := NewStore()
+	share, _ := store.Create("Top.mp3", "alice", time.Hour, 2, "")
+	expires, signature := linkParams(t, store, share)
+
+	for i := 1; i <= 2; i++ {
+		if _, err := store.Verify(share.ID, expires, signature, ""); err != nil {
+			t.Fatalf("download %d: Verify() = %v", i, err)
+		}
+		claimed, err := store.Claim(share.ID)
+		if err != nil || claimed.Downloads != i {
+			t.Fatalf("download %d: Claim() = %d, %v", i, claimed.Downloads, err)
+		}
+	}
+	if _, err := store.Claim(share.ID); !errors.Is(err, ErrExhausted) {
+		t.Errorf("Claim() = %v; want ErrExhausted", err)
+	}
+	if _, err := store.Verify(share.ID, expires, signature, ""); !errors.Is(err, ErrExhausted) {
+		t.Errorf("Verify() = %v; want ErrExhausted", err)
+	}
+}
+
+func TestClaimToken(t *testing.T) {
+	store := NewStore()
+	share, _ := store.Create("Top.mp3", "alice", time.Hour, 1, "")
+	other, _ := store.Create("Other.mp3", "alice", time.Hour, 0, "")
+	other, _ = store.Claim(other.ID)
+	claimed, _ := store.Claim(share.ID)
+	expiry := time.Now().Add(time.Minute)
+	token := store.ClaimToken(claimed, expiry)
+
+	if !store.Claimed(claimed, token) {
+		t.Error("expected the claim token accepted")
+	}
+	tests := []struct {
+		name  string
+		share Share
+		token string
+	}{
+		{"empty", claimed, ""},
+		{"other link", other, token},
+		{"expired", claimed, store.ClaimToken(claimed, time.Now().Add(-time.Minute))},
+		{"unclaimed download", claimed, "2" + token[1:]},
+		{"forged", claimed, token[:len(token)-2] + "xx"},
+	}
+	for _, test := range tests {
+		if store.Claimed(test.share, test.token) {
+			t.Errorf("%s: expected the claim token rejected", test.name)
+		}
+	}
+
+	// The claimed download can go on once the limit is reached
+	expires, signature := linkParams(t, store, share)
+	if verified, err := store.Verify(share.ID, expires, signature, ""); !errors.Is(err, ErrExhausted) || !store.Claimed(verified, token) {
+		t.Errorf("Verify() = %+v, %v; want the exhausted link with its claims", verified, err)
+	}
+
+	// Each token continues the download once and hands on to the next
+	next, until, ok := store.Redeem(claimed, token)
+	if !ok || next == token || until.Unix() != expiry.Unix() {
+		t.Fatalf("Redeem() = %q, %v, %v; want a new token with the same expiry", next, until, ok)
+	}
+	if _, _, ok := store.Redeem(claimed, token); ok || store.Claimed(claimed, token) {
+		t.Error("expected a redeemed token rejected")
+	}
+	if !store.Claimed(claimed, next) {
+		t.Error("expected the replacing token accepted")
+	}
+	if _, _, ok := store.Redeem(other, next); ok {
+		t.Error("expected the token of another link rejected")
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	store := NewStore()
+	share, _ := store.Create("Top.mp3", "alice", time.Hour, 0, "hunter2")
+	expires, signature := linkParams(t, store, share)
+
+	if !share.Protected() || share.PasswordHash == "hunter2" {
+		t.Fatal("expected the password to be kept hashed")
+	}
+	for _, password := range []string{"", "hunter3"} {
+		if _, err := store.Verify(share.ID, expires, signature, password); !errors.Is(err, ErrPassword) {
+			t.Errorf("Verify(%q) = %v; want ErrPassword", password, err)
+		}
+	}
+	if _, err := store.Verify(share.ID, expires, signature, "hunter2"); err != nil {
+		t.Errorf("Verify() = %v; want the right password accepted", err)
+	}
+}
+
+func TestListAndRevoke(t *testing.T) {
+	store := NewStore()
+	first, _ := store.Create("First.mp4", "alice", time.Hour, 0, "")
+	second, _ := store.Create("Second.mp4", "bob", time.Hour, 0, "")
+	store.Create("Expired.mp4", "alice", -time.Minute, 0, "")
+	used, _ := store.Create("Used.mp4", "alice", time.Hour, 1, "")
+	store.Claim(used.ID)
+
+	list, err := store.List()
+	if err != nil {
+		t.Fatalf("List() = %v", err)
+	}
+	if len(list) != 2 || list[0].ID != second.ID || list[1].ID != first.ID {
+		t.Errorf("expected the two active links newest first, got %+v", list)
+	}
+
+	if err := store.Revoke(first.ID); err != nil {
+		t.Fatalf("Revoke() = %v", err)
+	}
+	if err := store.Revoke(first.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Revoke() again = %v; want ErrNotFound", err)
+	}
+	expires, signature := linkParams(t, store, first)
+	if _, err := store.Verify(first.ID, expires, signature, ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Verify() = %v; want a revoked link rejected", err)
+	}
+}
+
+func TestOpenPersists(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shares.json")
+
+	store := NewStore()
+	if err := store.Open(path, ""); err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	share, _ := store.Create("Top.mp3", "alice", time.Hour, 3, "hunter2")
+	store.Claim(share.ID)
+	expires, signature := linkParams(t, store, share)
+
+	if info, err := os.Stat(filepath.Join(dir, "share-secret")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("expected a private secret file, got %v, %v", info, err)
+	}
+
+	// The generated secret keeps links valid after a restart
+	reopened := NewStore()
+	if err := reopened.Open(path, ""); err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	loaded, err := reopened.Verify(share.ID, expires, signature, "hunter2")
+	if err != nil || loaded.Downloads != 1 {
+		t.Errorf("Verify() after reopening = %+v, %v", loaded, err)
+	}
+
+	// A configured secret replaces it
+	configured := NewStore()
+	configured.Open(path, "configured secret")
+	if _, err := configured.Verify(share.ID, expires, signature, "hunter2"); !errors.Is(err, ErrSignature) {
+		t.Errorf("Verify() with another secret = %v; want ErrSignature", err)
+	}
+}
@@ -0,0 +1,71 @@
+package sponsorblock
+
+import (
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// DefaultBaseURL is the public SponsorBlock API
+const DefaultBaseURL = "https://sponsor.ajay.app"
+
+// BaseURL returns the SponsorBlock API base URL: SPONSORBLOCK_API_URL if
+// set, otherwise the public API. yt-dlp is pointed at the same URL.
+func BaseURL() string {
+	if base := os.Getenv("SPONSORBLOCK_API_URL"); base != "" {
+		return strings.TrimRight(base, "/")
+	}
+	return DefaultBaseURL
+}
+
+// Categories are the segment categories SponsorBlock knows
+var Categories = []string{
+	"sponsor", "intro", "outro", "selfpromo", "preview", "filler",
+	"interaction", "music_offtopic", "poi_highlight", "chapter",
+}
+
+// Unremovable categories mark a point or name a range rather than skippable content
+var Unremovable = []string{"poi_highlight", "chapter"}
+
+// All selects every category, as in yt-dlp
+const All = "all"
+
+// IsCategory reports whether name is a known category or "all"
+func IsCategory(name string) bool {
+	return name == All || slices.Contains(Categories, name)
+}
+
+// Segment is one submitted range of a video, in seconds
+type Segment struct {
+	Category string  `json:"category"`
+	Start    float64 `json:"start"`
+	End      float64 `json:"end"`
+}
+
+// Duration is the length of the segment in seconds
+func (s Segment) Duration() float64 {
+	return s.End - s.Start
+}
+
+// TotalDuration returns how many seconds segments cover, counting overlapping
+// ranges once
+func TotalDuration(segments []Segment) float64 {
+	sorted := slices.Clone(segments)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+
+	var total, end float64
+	for i, s := range sorted {
+		start := s.Start
+		if i > 0 && start < end {
+			start = end
+		}
+		if s.End > start {
+			total += s.End - start
+		}
+		if i == 0 || s.End > end {
+			end = s.End
+		}
+	}
+	return total
+}
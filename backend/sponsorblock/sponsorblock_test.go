@@ -0,0 +1,21 @@
+package sponsorblock
+
+import "testing"
+
+func TestTotalDuration(t *testing.T) {
+	tests := []struct {
+		segments []Segment
+		expected float64
+	}{
+		{nil, 0},
+		{[]Segment{{Start: 10, End: 20}}, 10},
+		{[]Segment{{Start: 30, End: 45}, {Start: 10, End: 40}}, 35},
+		{[]Segment{{Start: 0, End: 50}, {Start: 10, End: 20}, {Start: 60, End: 61.5}}, 51.5},
+	}
+
+	for _, test := range tests {
+		if got := TotalDuration(test.segments); got != test.expected {
+			t.Errorf("TotalDuration(%v) = %v; want %v", test.segments, got, test.expected)
+		}
+	}
+}
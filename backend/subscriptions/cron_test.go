@@ -0,0 +1,63 @@
+package subscriptions
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	// A Thursday
+	from := time.Date(2025, time.July, 10, 16, 30, 20, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2025, time.July, 10, 16, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2025, time.July, 10, 16, 45, 0, 0, time.UTC)},
+		{"0 */6 * * *", time.Date(2025, time.July, 10, 18, 0, 0, 0, time.UTC)},
+		{"30 6 * * mon-fri", time.Date(2025, time.July, 11, 6, 30, 0, 0, time.UTC)},
+		{"0 9 * * 0", time.Date(2025, time.July, 13, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 7", time.Date(2025, time.July, 13, 9, 0, 0, 0, time.UTC)},
+		{"5/20 8-10 * * *", time.Date(2025, time.July, 11, 8, 5, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2025, time.July, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		// Both day fields restricted: either one matches
+		{"0 12 20 * fri", time.Date(2025, time.July, 11, 12, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2025, time.July, 11, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2025, time.July, 10, 17, 0, 0, 0, time.UTC)},
+		{"0 0 30 feb *", time.Time{}},
+	}
+
+	for _, test := range tests {
+		schedule, err := ParseCron(test.expr)
+		if err != nil {
+			t.Errorf("ParseCron(%q) = %v", test.expr, err)
+			continue
+		}
+		if got := schedule.Next(from); !got.Equal(test.want) {
+			t.Errorf("%q: Next() = %v; want %v", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestParseCronRejects(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"10-5 * * * *",
+		"* * * smarch *",
+		"@fortnightly",
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) succeeded; want an error", expr)
+		}
+	}
+}
@@ -0,0 +1,444 @@
+// Package subscriptions keeps channels and playlists that are synced on a
+// schedule: every run lists their entries and downloads those that are not in
+// the download archive yet and pass the subscription's filters.
+package subscriptions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"downloader/jobs"
+	"downloader/logging"
+	"downloader/utils"
+	"downloader/ytdlp"
+)
+
+// Errors returned for subscriptions that cannot be used
+var (
+	ErrNotFound = errors.New("subscription not found")
+	ErrSyncing  = errors.New("subscription is already syncing")
+)
+
+// maxRuns is how many runs are kept per subscription
+const maxRuns = 50
+
+// dateLayout is the layout of the date filters
+const dateLayout = "2006-01-02"
+
+// Filters select the entries of a channel or playlist that are downloaded.
+// An entry whose upload date or duration is unknown fails the filters on it.
+type Filters struct {
+	After        string  `json:"after,omitempty"`        // YYYY-MM-DD; uploaded on or after
+	Before       string  `json:"before,omitempty"`       // YYYY-MM-DD; uploaded before
+	MinDuration  float64 `json:"minDuration,omitempty"`  // seconds
+	MaxDuration  float64 `json:"maxDuration,omitempty"`  // seconds
+	TitleMatch   string  `json:"titleMatch,omitempty"`   // regular expression the title must match, ignoring case
+	TitleExclude string  `json:"titleExclude,omitempty"` // regular expression the title must not match, ignoring case
+}
+
+// Validate checks the dates, durations and expressions of the filters
+func (f Filters) Validate() error {
+	for name, date := range map[string]string{"after": f.After, "before": f.Before} {
+		if _, err := time.Parse(dateLayout, date); date != "" && err != nil {
+			return fmt.Errorf("%s must be a date such as 2025-07-10", name)
+		}
+	}
+	if f.MinDuration < 0 || f.MaxDuration < 0 {
+		return errors.New("durations must not be negative")
+	}
+	if f.MaxDuration > 0 && f.MaxDuration < f.MinDuration {
+		return errors.New("maxDuration must not be less than minDuration")
+	}
+	for name, expr := range map[string]string{"titleMatch": f.TitleMatch, "titleExclude": f.TitleExclude} {
+		if _, err := compileTitle(expr); err != nil {
+			return fmt.Errorf("%s is not a valid regular expression: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Lacks reports whether entry misses the upload date or duration the
+// filters check, which flat listings often leave out
+func (f Filters) Lacks(entry ytdlp.Entry) bool {
+	return (f.After != "" || f.Before != "") && entry.UploadDate == "" ||
+		(f.MinDuration > 0 || f.MaxDuration > 0) && entry.Duration <= 0
+}
+
+// Match reports whether entry passes the filters, which must be valid, and
+// if not, why
+func (f Filters) Match(entry ytdlp.Entry) (bool, string) {
+	if (f.After != "" || f.Before != "") && entry.UploadDate == "" {
+		return false, "upload date unknown"
+	}
+	// YYYYMMDD compares like the date it stands for
+	if f.After != "" && entry.UploadDate < compactDate(f.After) {
+		return false, "uploaded before " + f.After
+	}
+	if f.Before != "" && entry.UploadDate >= compactDate(f.Before) {
+		return false, "uploaded on or after " + f.Before
+	}
+	if (f.MinDuration > 0 || f.MaxDuration > 0) && entry.Duration <= 0 {
+		return false, "duration unknown"
+	}
+	if f.MinDuration > 0 && entry.Duration < f.MinDuration {
+		return false, "shorter than minDuration"
+	}
+	if f.MaxDuration > 0 && entry.Duration > f.MaxDuration {
+		return false, "longer than maxDuration"
+	}
+	if re, _ := compileTitle(f.TitleMatch); re != nil && !re.MatchString(entry.Title) {
+		return false, "title does not match titleMatch"
+	}
+	if re, _ := compileTitle(f.TitleExclude); re != nil && re.MatchString(entry.Title) {
+		return false, "title matches titleExclude"
+	}
+	return true, ""
+}
+
+// compileTitle compiles a title filter, ignoring case; empty ones are nil
+func compileTitle(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile("(?i)" + expr)
+}
+
+// compactDate turns a YYYY-MM-DD date into yt-dlp's YYYYMMDD
+func compactDate(date string) string {
+	t, _ := time.Parse(dateLayout, date)
+	return t.Format("20060102")
+}
+
+// Subscription is a channel or playlist synced on a schedule, given either
+// as an interval or as a cron expression
+type Subscription struct {
+	ID       string        `json:"id"`
+	Name     string        `json:"name,omitempty"`
+	URL      string        `json:"url"`
+	Options  ytdlp.Options `json:"options"`
+	Interval string        `json:"interval,omitempty"` // Go duration such as "6h"
+	Cron     string        `json:"cron,omitempty"`     // five-field cron expression such as "0 */6 * * *"
+	Filters  Filters       `json:"filters"`
+	MaxItems int           `json:"maxItems,omitempty"` // most downloads per run; 0 for no limit
+	Enabled  bool          `json:"enabled"`
+
+	User      string    `json:"user"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	NextRunAt time.Time `json:"nextRunAt,omitempty"` // zero while disabled
+	LastRun   *Run      `json:"lastRun,omitempty"`
+}
+
+// Validate checks the schedule, filters, limit and download options;
+// intervals must be at least minInterval
+func (s Subscription) Validate(minInterval time.Duration) error {
+	switch {
+	case s.Interval == "" && s.Cron == "":
+		return errors.New("either interval or cron is required")
+	case s.Interval != "" && s.Cron != "":
+		return errors.New("only one of interval and cron may be given")
+	case s.Interval != "":
+		interval, err := time.ParseDuration(s.Interval)
+		if err != nil || interval <= 0 {
+			return errors.New("interval must be a positive duration such as \"6h\"")
+		}
+		if interval < minInterval {
+			return fmt.Errorf("interval must be at least %s", minInterval)
+		}
+	default:
+		if _, err := ParseCron(s.Cron); err != nil {
+			return err
+		}
+	}
+	if s.MaxItems < 0 {
+		return errors.New("maxItems must not be negative")
+	}
+	if err := s.Filters.Validate(); err != nil {
+		return err
+	}
+	return s.Options.Validate()
+}
+
+// Next returns when a valid subscription is next due after t
+func (s Subscription) Next(t time.Time) time.Time {
+	if s.Cron != "" {
+		schedule, err := ParseCron(s.Cron)
+		if err != nil {
+			return time.Time{}
+		}
+		return schedule.Next(t)
+	}
+	interval, _ := time.ParseDuration(s.Interval)
+	return t.Add(interval)
+}
+
+// RunStatus is the state of a sync run
+type RunStatus string
+
+const (
+	RunRunning   RunStatus = "running"
+	RunCompleted RunStatus = "completed"
+	RunFailed    RunStatus = "failed" // the entries could not be listed
+	// RunInterrupted marks a run cut short by a shutdown; its downloads
+	// resume with the jobs
+	RunInterrupted RunStatus = "interrupted"
+)
+
+// What started a run
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// Run is one sync of a subscription
+type Run struct {
+	ID             string    `json:"id"`
+	SubscriptionID string    `json:"subscriptionId"`
+	Trigger        string    `json:"trigger"`
+	Status         RunStatus `json:"status"`
+	Error          string    `json:"error,omitempty"`
+	StartedAt      time.Time `json:"startedAt"`
+	FinishedAt     time.Time `json:"finishedAt,omitempty"`
+
+	Found   int `json:"found"`   // entries listed
+	New     int `json:"new"`     // entries downloaded
+	Skipped int `json:"skipped"` // entries in the archive, filtered out or not yet available
+	Failed  int `json:"failed"`  // entries whose download failed or was refused
+	// Remaining counts the entries maxItems left to later runs
+	Remaining int `json:"remaining"`
+
+	Items []RunItem `json:"items,omitempty"` // the entries downloads were attempted for
+}
+
+// RunItem is an entry a run attempted to download
+type RunItem struct {
+	VideoID string      `json:"videoId"`
+	Title   string      `json:"title"`
+	URL     string      `json:"url"`
+	JobID   string      `json:"jobId,omitempty"` // none if the URL was refused
+	Status  jobs.Status `json:"status"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// Store keeps subscriptions and their latest runs, persisted as a single JSON
+// file. A store without a path only keeps them in memory.
+type Store struct {
+	mu            sync.Mutex
+	path          string
+	subscriptions map[string]*Subscription
+	runs          map[string][]Run // newest first
+}
+
+// storeFile is the layout of the store's file
+type storeFile struct {
+	Subscriptions map[string]*Subscription `json:"subscriptions"`
+	Runs          map[string][]Run         `json:"runs"`
+}
+
+// NewStore returns an empty in-memory store
+func NewStore() *Store {
+	return &Store{subscriptions: make(map[string]*Subscription), runs: make(map[string][]Run)}
+}
+
+// Open loads the subscriptions saved at path, if any, and saves every later
+// change there. Runs the previous process left running are recorded as
+// interrupted. The parent folder is created if needed.
+func (s *Store) Open(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	file := storeFile{Subscriptions: make(map[string]*Subscription), Runs: make(map[string][]Run)}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(data, &file); err != nil {
+			return err
+		}
+	}
+	if file.Subscriptions == nil {
+		file.Subscriptions = make(map[string]*Subscription)
+	}
+	if file.Runs == nil {
+		file.Runs = make(map[string][]Run)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = path
+	s.subscriptions = file.Subscriptions
+	s.runs = file.Runs
+	for id, runs := range s.runs {
+		for i := range runs {
+			if runs[i].Status == RunRunning {
+				runs[i].Status = RunInterrupted
+			}
+		}
+		if sub, ok := s.subscriptions[id]; ok && len(runs) > 0 {
+			last := runs[0]
+			sub.LastRun = &last
+		}
+	}
+	return s.save()
+}
+
+// List returns every subscription, newest first
+func (s *Store) List() []Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Subscription, 0, len(s.subscriptions))
+	for _, sub := range s.subscriptions {
+		list = append(list, *sub)
+	}
+	slices.SortFunc(list, func(a, b Subscription) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return list
+}
+
+// Get returns the subscription id
+func (s *Store) Get(id string) (Subscription, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subscriptions[id]
+	if !ok {
+		return Subscription{}, false
+	}
+	return *sub, true
+}
+
+// Create records a validated subscription under a new ID and schedules its
+// first run
+func (s *Store) Create(sub Subscription) (Subscription, error) {
+	now := time.Now().UTC()
+	sub.ID = logging.NewID()
+	sub.CreatedAt = now
+	sub.UpdatedAt = now
+	sub.LastRun = nil
+	sub.NextRunAt = time.Time{}
+	if sub.Enabled {
+		// Cron expressions are in the server's time zone
+		sub.NextRunAt = sub.Next(time.Now())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscriptions[sub.ID] = &sub
+	return sub, s.save()
+}
+
+// Update replaces the settings of the validated subscription sub.ID, keeping
+// its owner, creation time and runs, and schedules its next run afresh
+func (s *Store) Update(sub Subscription) (Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.subscriptions[sub.ID]
+	if !ok {
+		return Subscription{}, ErrNotFound
+	}
+	now := time.Now().UTC()
+	sub.User = current.User
+	sub.CreatedAt = current.CreatedAt
+	sub.LastRun = current.LastRun
+	sub.UpdatedAt = now
+	sub.NextRunAt = time.Time{}
+	if sub.Enabled {
+		// Cron expressions are in the server's time zone
+		sub.NextRunAt = sub.Next(time.Now())
+	}
+	s.subscriptions[sub.ID] = &sub
+	return sub, s.save()
+}
+
+// Delete removes the subscription id and its runs
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subscriptions[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.subscriptions, id)
+	delete(s.runs, id)
+	return s.save()
+}
+
+// Runs returns the latest runs of the subscription id, newest first
+func (s *Store) Runs(id string) ([]Run, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subscriptions[id]; !ok {
+		return nil, false
+	}
+	return slices.Clone(s.runs[id]), true
+}
+
+// due returns the enabled subscriptions whose next run is at or before now
+func (s *Store) due(now time.Time) []Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []Subscription
+	for _, sub := range s.subscriptions {
+		if sub.Enabled && !sub.NextRunAt.IsZero() && !sub.NextRunAt.After(now) {
+			due = append(due, *sub)
+		}
+	}
+	slices.SortFunc(due, func(a, b Subscription) int { return a.NextRunAt.Compare(b.NextRunAt) })
+	return due
+}
+
+// reschedule sets when the subscription id is next due, from now
+func (s *Store) reschedule(id string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subscriptions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	sub.NextRunAt = sub.Next(now)
+	return s.save()
+}
+
+// record adds or updates run, keeping the latest maxRuns runs of its
+// subscription. Runs of deleted subscriptions are dropped.
+func (s *Store) record(run Run) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subscriptions[run.SubscriptionID]
+	if !ok {
+		return ErrNotFound
+	}
+	run.Items = slices.Clone(run.Items)
+	runs := s.runs[run.SubscriptionID]
+	if i := slices.IndexFunc(runs, func(r Run) bool { return r.ID == run.ID }); i >= 0 {
+		runs[i] = run
+	} else {
+		runs = append([]Run{run}, runs...)
+		if len(runs) > maxRuns {
+			runs = runs[:maxRuns]
+		}
+	}
+	s.runs[run.SubscriptionID] = runs
+	if runs[0].ID == run.ID {
+		sub.LastRun = &run
+	}
+	return s.save()
+}
+
+// save writes the store to its path; callers hold mu
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(storeFile{Subscriptions: s.subscriptions, Runs: s.runs}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(s.path, data)
+}
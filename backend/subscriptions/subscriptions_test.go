@@ -0,0 +1,181 @@
+package subscriptions
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"downloader/ytdlp"
+)
+
+var audioOptions = ytdlp.Options{Format: "audio", AudioCodec: "mp3"}
+
+func TestFiltersMatch(t *testing.T) {
+	filters := Filters{After: "2025-07-01", Before: "2025-08-01", MinDuration: 60, MaxDuration: 3600, TitleMatch: "live|session", TitleExclude: "#shorts"}
+	if err := filters.Validate(); err != nil {
+		t.Fatalf("Validate() = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		entry ytdlp.Entry
+		match bool
+	}{
+		{"matches", ytdlp.Entry{Title: "Live at the Roundhouse", UploadDate: "20250710", Duration: 600}, true},
+		{"first day", ytdlp.Entry{Title: "Studio Session", UploadDate: "20250701", Duration: 600}, true},
+		{"unknown date", ytdlp.Entry{Title: "Live", Duration: 600}, false},
+		{"unknown duration", ytdlp.Entry{Title: "Live", UploadDate: "20250710"}, false},
+		{"too old", ytdlp.Entry{Title: "Live", UploadDate: "20250630", Duration: 600}, false},
+		{"too new", ytdlp.Entry{Title: "Live", UploadDate: "20250801", Duration: 600}, false},
+		{"too short", ytdlp.Entry{Title: "Live", UploadDate: "20250710", Duration: 59}, false},
+		{"too long", ytdlp.Entry{Title: "Live", UploadDate: "20250710", Duration: 3601}, false},
+		{"title does not match", ytdlp.Entry{Title: "Interview", UploadDate: "20250710", Duration: 600}, false},
+		{"title excluded", ytdlp.Entry{Title: "Live teaser #Shorts", UploadDate: "20250710", Duration: 600}, false},
+	}
+
+	for _, test := range tests {
+		if ok, reason := filters.Match(test.entry); ok != test.match || ok != (reason == "") {
+			t.Errorf("%s: Match() = %v, %q; want %v", test.name, ok, reason, test.match)
+		}
+	}
+
+	// Unknown dates and durations only matter to the filters on them
+	titleOnly := Filters{TitleMatch: "live"}
+	if ok, reason := titleOnly.Match(ytdlp.Entry{Title: "LIVE"}); !ok || titleOnly.Lacks(ytdlp.Entry{Title: "LIVE"}) {
+		t.Errorf("title filter: Match() = %v, %q; want a match", ok, reason)
+	}
+	if !filters.Lacks(ytdlp.Entry{Title: "Live", UploadDate: "20250710"}) || filters.Lacks(ytdlp.Entry{UploadDate: "20250710", Duration: 600}) {
+		t.Error("expected Lacks() to report a missing duration only")
+	}
+}
+
+func TestSubscriptionValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		sub  Subscription
+		err  string
+	}{
+		{"interval", Subscription{Interval: "6h", Options: audioOptions}, ""},
+		{"cron", Subscription{Cron: "0 */6 * * *", Options: audioOptions, MaxItems: 5}, ""},
+		{"no schedule", Subscription{Options: audioOptions}, "either interval or cron"},
+		{"both schedules", Subscription{Interval: "6h", Cron: "@daily", Options: audioOptions}, "only one"},
+		{"bad interval", Subscription{Interval: "daily", Options: audioOptions}, "positive duration"},
+		{"short interval", Subscription{Interval: "5m", Options: audioOptions}, "at least 15m0s"},
+		{"bad cron", Subscription{Cron: "every day", Options: audioOptions}, "5 fields"},
+		{"negative maxItems", Subscription{Interval: "6h", Options: audioOptions, MaxItems: -1}, "maxItems"},
+		{"bad date", Subscription{Interval: "6h", Options: audioOptions, Filters: Filters{After: "10/07/2025"}}, "after must be a date"},
+		{"bad duration range", Subscription{Interval: "6h", Options: audioOptions, Filters: Filters{MinDuration: 600, MaxDuration: 60}}, "maxDuration"},
+		{"bad title", Subscription{Interval: "6h", Options: audioOptions, Filters: Filters{TitleExclude: "(shorts"}}, "titleExclude"},
+		{"bad options", Subscription{Interval: "6h", Options: ytdlp.Options{Format: "hologram"}}, "format"},
+	}
+
+	for _, test := range tests {
+		err := test.sub.Validate(15 * time.Minute)
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: Validate() = %v; want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "subscriptions.json")
+	store := NewStore()
+	if err := store.Open(path); err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+
+	before := time.Now()
+	sub, err := store.Create(Subscription{URL: "https://www.youtube.com/@channel", Options: audioOptions, Interval: "6h", Enabled: true, User: "tester"})
+	if err != nil {
+		t.Fatalf("Create() = %v", err)
+	}
+	if sub.ID == "" || sub.NextRunAt.Before(before.Add(6*time.Hour)) {
+		t.Errorf("expected the first run in 6h, got %+v", sub)
+	}
+	paused, _ := store.Create(Subscription{URL: "https://www.youtube.com/@other", Options: audioOptions, Cron: "@daily"})
+	if !paused.NextRunAt.IsZero() {
+		t.Errorf("expected no run scheduled while disabled, got %v", paused.NextRunAt)
+	}
+
+	running := Run{ID: "run1", SubscriptionID: sub.ID, Trigger: TriggerManual, Status: RunRunning}
+	if err := store.record(running); err != nil {
+		t.Fatalf("record() = %v", err)
+	}
+	if err := store.record(Run{ID: "run2", SubscriptionID: "missing"}); err != ErrNotFound {
+		t.Errorf("record() for a deleted subscription = %v; want %v", err, ErrNotFound)
+	}
+
+	reopened := NewStore()
+	if err := reopened.Open(path); err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	if list := reopened.List(); len(list) != 2 || list[0].ID != paused.ID {
+		t.Errorf("expected both subscriptions newest first, got %+v", list)
+	}
+	saved, _ := reopened.Get(sub.ID)
+	runs, _ := reopened.Runs(sub.ID)
+	if len(runs) != 1 || runs[0].Status != RunInterrupted || saved.LastRun == nil || saved.LastRun.Status != RunInterrupted {
+		t.Errorf("expected the running run recorded as interrupted, got %+v and %+v", runs, saved.LastRun)
+	}
+
+	if err := reopened.Delete(sub.ID); err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+	if _, ok := reopened.Runs(sub.ID); ok {
+		t.Error("expected the runs deleted with the subscription")
+	}
+	if err := reopened.Delete(sub.ID); err != ErrNotFound {
+		t.Errorf("Delete() twice = %v; want %v", err, ErrNotFound)
+	}
+}
+
+func TestStoreUpdateAndDue(t *testing.T) {
+	store := NewStore()
+	sub, _ := store.Create(Subscription{URL: "https://www.youtube.com/@channel", Options: audioOptions, Interval: "1h", User: "owner"})
+	if due := store.due(time.Now().Add(24 * time.Hour)); len(due) != 0 {
+		t.Errorf("expected a disabled subscription never due, got %+v", due)
+	}
+
+	sub.Enabled = true
+	sub.User = "someone else"
+	updated, err := store.Update(sub)
+	if err != nil {
+		t.Fatalf("Update() = %v", err)
+	}
+	if updated.User != "owner" || updated.NextRunAt.IsZero() || !updated.CreatedAt.Equal(sub.CreatedAt) {
+		t.Errorf("unexpected updated subscription %+v", updated)
+	}
+	if due := store.due(time.Now()); len(due) != 0 {
+		t.Errorf("expected nothing due yet, got %+v", due)
+	}
+	now := time.Now().Add(2 * time.Hour)
+	if due := store.due(now); len(due) != 1 {
+		t.Fatalf("expected the subscription due, got %+v", due)
+	}
+	store.reschedule(sub.ID, now)
+	if next, _ := store.Get(sub.ID); !next.NextRunAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("expected the next run an hour later, got %v", next.NextRunAt)
+	}
+
+	if _, err := store.Update(Subscription{ID: "missing"}); err != ErrNotFound {
+		t.Errorf("Update(missing) = %v; want %v", err, ErrNotFound)
+	}
+}
+
+func TestStoreKeepsLatestRuns(t *testing.T) {
+	store := NewStore()
+	sub, _ := store.Create(Subscription{URL: "https://www.youtube.com/@channel", Options: audioOptions, Interval: "1h"})
+	for i := range maxRuns + 5 {
+		store.record(Run{ID: string(rune('a' + i)), SubscriptionID: sub.ID, Status: RunRunning})
+	}
+	// Updating an older run keeps the order and the last run
+	store.record(Run{ID: string(rune('a' + 10)), SubscriptionID: sub.ID, Status: RunCompleted})
+
+	runs, _ := store.Runs(sub.ID)
+	saved, _ := store.Get(sub.ID)
+	last := string(rune('a' + maxRuns + 4))
+	if len(runs) != maxRuns || runs[0].ID != last || saved.LastRun.ID != last {
+		t.Errorf("expected the latest %d runs, newest %s first, got %d starting %s", maxRuns, last, len(runs), runs[0].ID)
+	}
+}
@@ -0,0 +1,333 @@
+package subscriptions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"downloader/jobs"
+	"downloader/library"
+	"downloader/logging"
+	"downloader/metrics"
+	"downloader/tracing"
+	"downloader/utils"
+	"downloader/ytdlp"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Scheduler syncs the subscriptions of a store when they are due and on
+// request, downloading new entries with a job manager
+type Scheduler struct {
+	Store *Store
+	Jobs  *jobs.Manager
+	// Archive tells which videos were downloaded already
+	Archive *library.Archive
+	// Policy is applied to every entry before it is downloaded
+	Policy *utils.URLPolicy
+	// Tick is how often due subscriptions are looked for
+	Tick time.Duration
+	// ScanLimit is how many entries of a channel or playlist a run lists
+	ScanLimit int
+	// MinInterval is the shortest interval a subscription may have
+	MinInterval time.Duration
+
+	// ctx is the parent of every run; cancelling it ends them all
+	ctx    context.Context
+	cancel context.CancelFunc
+	runs   sync.WaitGroup
+
+	mu      sync.Mutex
+	stopped bool
+	syncing map[string]bool
+}
+
+// NewScheduler returns a scheduler for store downloading with manager
+func NewScheduler(store *Store, manager *jobs.Manager) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{
+		Store:       store,
+		Jobs:        manager,
+		Archive:     library.DefaultArchive,
+		Policy:      utils.NewURLPolicyFromEnv(),
+		Tick:        time.Minute,
+		ScanLimit:   100,
+		MinInterval: 15 * time.Minute,
+		ctx:         ctx,
+		cancel:      cancel,
+		syncing:     make(map[string]bool),
+	}
+}
+
+// NewSchedulerFromEnv returns a scheduler for a new store downloading with
+// jobs.Default, configured by the SUBSCRIPTION_* environment variables
+func NewSchedulerFromEnv() *Scheduler {
+	s := NewScheduler(NewStore(), jobs.Default)
+	s.Tick = utils.EnvDuration("SUBSCRIPTION_CHECK_INTERVAL", s.Tick)
+	s.ScanLimit = utils.EnvInt("SUBSCRIPTION_SCAN_LIMIT", s.ScanLimit)
+	s.MinInterval = utils.EnvDuration("SUBSCRIPTION_MIN_INTERVAL", s.MinInterval)
+	return s
+}
+
+// Default is the process-wide scheduler; main opens its store and starts it
+var Default = NewSchedulerFromEnv()
+
+// Start looks for due subscriptions every Tick until Shutdown. A
+// subscription that fell due while the server was down runs once at start.
+func (s *Scheduler) Start() {
+	go func() {
+		ticker := time.NewTicker(s.Tick)
+		defer ticker.Stop()
+		for {
+			s.syncDue(time.Now())
+			select {
+			case <-ticker.C:
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Shutdown stops scheduling, cancels listings in progress and waits for the
+// runs to record how far they got. Their downloads are left to the job
+// manager's own shutdown.
+func (s *Scheduler) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.runs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Sync starts a run of the subscription id in the background and returns it
+// as it starts. It fails with ErrSyncing while the subscription is syncing.
+func (s *Scheduler) Sync(id string) (Run, error) {
+	sub, ok := s.Store.Get(id)
+	if !ok {
+		return Run{}, ErrNotFound
+	}
+	return s.start(sub, TriggerManual)
+}
+
+// syncDue starts a run of every subscription due at now and schedules its
+// next one
+func (s *Scheduler) syncDue(now time.Time) {
+	for _, sub := range s.Store.due(now) {
+		if err := s.Store.reschedule(sub.ID, now); err != nil && !errors.Is(err, ErrNotFound) {
+			slog.Warn("failed to save subscription schedule", "subscription_id", sub.ID, "error", err)
+		}
+		if _, err := s.start(sub, TriggerSchedule); err != nil && !errors.Is(err, ErrSyncing) {
+			slog.Warn("failed to start subscription sync", "subscription_id", sub.ID, "error", err)
+		}
+	}
+}
+
+// start records a new run of sub and runs it in the background
+func (s *Scheduler) start(sub Subscription, trigger string) (Run, error) {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return Run{}, jobs.ErrShuttingDown
+	}
+	if s.syncing[sub.ID] {
+		s.mu.Unlock()
+		return Run{}, ErrSyncing
+	}
+	s.syncing[sub.ID] = true
+	s.runs.Add(1)
+	s.mu.Unlock()
+
+	run := Run{
+		ID:             logging.NewID(),
+		SubscriptionID: sub.ID,
+		Trigger:        trigger,
+		Status:         RunRunning,
+		StartedAt:      time.Now().UTC(),
+	}
+	logger := slog.Default().With("subscription_id", sub.ID, "run_id", run.ID, "user", sub.User)
+	s.record(logger, run)
+
+	go func() {
+		defer s.runs.Done()
+		defer func() {
+			s.mu.Lock()
+			delete(s.syncing, sub.ID)
+			s.mu.Unlock()
+		}()
+		s.sync(logging.WithContext(s.ctx, logger), sub, run)
+	}()
+	return run, nil
+}
+
+// sync lists the entries of sub, downloads the new ones and records the
+// outcome in run
+func (s *Scheduler) sync(ctx context.Context, sub Subscription, run Run) {
+	ctx, span := tracing.Tracer().Start(ctx, "subscription.sync",
+		trace.WithAttributes(
+			tracing.Attr("subscription.id", sub.ID),
+			tracing.Attr("subscription.trigger", run.Trigger),
+		),
+	)
+	logger := logging.FromContext(ctx)
+	logger.Info("subscription sync started", "trigger", run.Trigger, "url", sub.URL)
+
+	err := s.download(ctx, sub, &run)
+	run.FinishedAt = time.Now().UTC()
+	switch {
+	case errors.Is(err, jobs.ErrInterrupted) || errors.Is(err, jobs.ErrShuttingDown) || s.ctx.Err() != nil:
+		run.Status = RunInterrupted
+	case err != nil:
+		run.Status = RunFailed
+		run.Error = err.Error()
+	default:
+		run.Status = RunCompleted
+	}
+	s.record(logger, run)
+
+	metrics.SubscriptionRuns.WithLabelValues(run.Trigger, string(run.Status)).Inc()
+	metrics.SubscriptionItems.WithLabelValues("new").Add(float64(run.New))
+	metrics.SubscriptionItems.WithLabelValues("skipped").Add(float64(run.Skipped))
+	metrics.SubscriptionItems.WithLabelValues("failed").Add(float64(run.Failed))
+	logger.Info("subscription sync finished",
+		"status", run.Status,
+		"found", run.Found,
+		"new", run.New,
+		"skipped", run.Skipped,
+		"failed", run.Failed,
+		"remaining", run.Remaining,
+		"error", run.Error,
+	)
+	tracing.EndSpan(span, err)
+}
+
+// download runs a job for every entry of sub that is new and passes its
+// filters, up to MaxItems, and waits for them, recording their outcome in run
+// as they finish
+func (s *Scheduler) download(ctx context.Context, sub Subscription, run *Run) error {
+	logger := logging.FromContext(ctx)
+	entries, err := ytdlp.ListEntries(ctx, sub.URL, s.ScanLimit)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("listing entries: %w", err)
+	}
+
+	run.Found = len(entries)
+	var selected []ytdlp.Entry
+	for _, entry := range entries {
+		if reason := s.skip(ctx, sub, &entry); reason != "" {
+			logger.Debug("subscription entry skipped", "video_id", entry.ID, "reason", reason)
+			run.Skipped++
+			continue
+		}
+		if sub.MaxItems > 0 && len(selected) >= sub.MaxItems {
+			run.Remaining++
+			continue
+		}
+		selected = append(selected, entry)
+	}
+
+	type result struct {
+		item int
+		err  error
+	}
+	results := make(chan result, len(selected))
+	pending := 0
+	for _, entry := range selected {
+		item := RunItem{VideoID: entry.ID, Title: entry.Title, URL: entry.URL, Status: jobs.StatusQueued}
+		if err := s.Policy.Check(ctx, entry.URL); err != nil {
+			item.Status = jobs.StatusFailed
+			item.Error = err.Error()
+			run.Failed++
+			run.Items = append(run.Items, item)
+			continue
+		}
+
+		job := jobs.NewJob(ctx, entry.URL, sub.Options, sub.User)
+		job.SubscriptionID = sub.ID
+		job.Playlist = entry.Position()
+		item.JobID = job.ID
+		run.Items = append(run.Items, item)
+		i := len(run.Items) - 1
+		pending++
+		// A cancelled run must not fail the queued jobs: the manager's
+		// shutdown interrupts them so they resume on restart
+		go func() { results <- result{i, s.Jobs.Run(context.WithoutCancel(ctx), job, nil)} }()
+	}
+	s.record(logger, *run)
+
+	var interrupted error
+	for ; pending > 0; pending-- {
+		select {
+		case r := <-results:
+			item := &run.Items[r.item]
+			switch {
+			case r.err == nil:
+				item.Status = jobs.StatusCompleted
+				run.New++
+			case errors.Is(r.err, jobs.ErrInterrupted) || errors.Is(r.err, jobs.ErrShuttingDown):
+				item.Status = jobs.StatusInterrupted
+				interrupted = r.err
+			default:
+				item.Status = jobs.StatusFailed
+				item.Error = r.err.Error()
+				run.Failed++
+			}
+			s.record(logger, *run)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return interrupted
+}
+
+// skip returns why entry is not downloaded, or "" if it is. An entry listed
+// without the upload date or duration the filters check has its full
+// metadata fetched first, if the URL policy allows.
+func (s *Scheduler) skip(ctx context.Context, sub Subscription, entry *ytdlp.Entry) string {
+	if s.Archive != nil && s.Archive.Has(entry.Extractor, entry.ID) {
+		return "already downloaded"
+	}
+	if entry.LiveStatus == "is_upcoming" || entry.LiveStatus == "is_live" {
+		return "not yet available"
+	}
+	if sub.Filters.Lacks(*entry) && s.Policy.Check(ctx, entry.URL) == nil {
+		info, err := ytdlp.FetchInfo(ctx, entry.URL)
+		if err != nil {
+			return "metadata unavailable: " + err.Error()
+		}
+		if entry.UploadDate == "" {
+			entry.UploadDate = info.UploadDate
+		}
+		if entry.Duration <= 0 {
+			entry.Duration = info.Duration
+		}
+	}
+	if ok, reason := sub.Filters.Match(*entry); !ok {
+		return reason
+	}
+	return ""
+}
+
+// record saves run, logging failures; runs of deleted subscriptions are dropped
+func (s *Scheduler) record(logger *slog.Logger, run Run) {
+	if err := s.Store.record(run); err != nil && !errors.Is(err, ErrNotFound) {
+		logger.Warn("failed to save subscription run", "error", err)
+	}
+}
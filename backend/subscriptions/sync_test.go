@@ -0,0 +1,260 @@
+package subscriptions
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"downloader/jobs"
+	"downloader/library"
+	"downloader/utils"
+	"downloader/webhooks"
+	"downloader/ytdlp"
+)
+
+// channelListing is what the fake yt-dlp lists for a channel, newest first
+const channelListing = `{"id":"new1","title":"Live Session","url":"https://www.youtube.com/watch?v=new1","ie_key":"Youtube","duration":600,"upload_date":"20250710","playlist_title":"Channel - Videos","playlist_index":1}
+{"id":"old","title":"Live Archive","url":"https://www.youtube.com/watch?v=old","ie_key":"Youtube","duration":600,"upload_date":"20250709"}
+{"id":"teaser","title":"Teaser","url":"https://www.youtube.com/watch?v=teaser","ie_key":"Youtube","duration":30,"upload_date":"20250708"}
+{"id":"premiere","title":"Premiere","url":"https://www.youtube.com/watch?v=premiere","ie_key":"Youtube","live_status":"is_upcoming"}
+{"id":"broken","title":"Broken Upload","url":"https://www.youtube.com/watch?v=broken","ie_key":"Youtube","duration":600,"upload_date":"20250707"}
+{"id":"ftp","title":"Mirror","url":"ftp://mirror.example.com/ftp","ie_key":"Generic","duration":600}
+{"id":"new2","title":"Live Again","url":"https://www.youtube.com/watch?v=new2","ie_key":"Youtube","duration":600,"upload_date":"20250705","playlist_title":"Channel - Videos","playlist_index":7}
+{"id":"new3","title":"Live Once More","url":"https://www.youtube.com/watch?v=new3","ie_key":"Youtube","duration":600,"upload_date":"20250704"}
+`
+
+// sparseListing is a listing without upload dates, whose entries' metadata
+// is in sparseInfo
+const sparseListing = `{"id":"recent","title":"Recent","url":"https://www.youtube.com/watch?v=recent","ie_key":"Youtube","duration":600}
+{"id":"ancient","title":"Ancient","url":"https://www.youtube.com/watch?v=ancient","ie_key":"Youtube","duration":600}
+{"id":"undated","title":"Undated","url":"https://www.youtube.com/watch?v=undated","ie_key":"Youtube","duration":600}
+{"id":"private","title":"Private","url":"https://www.youtube.com/watch?v=private","ie_key":"Youtube","duration":600}
+`
+
+var sparseInfo = map[string]string{
+	"recent":  `{"id":"recent","title":"Recent","extractor_key":"Youtube","duration":600,"upload_date":"20250710"}`,
+	"ancient": `{"id":"ancient","title":"Ancient","extractor_key":"Youtube","duration":600,"upload_date":"20200101"}`,
+	"undated": `{"id":"undated","title":"Undated","extractor_key":"Youtube","duration":600}`,
+}
+
+// TestHelperProcess is not a real test: it stands in for yt-dlp, listing
+// channelListing, or sparseListing in mode "sparse", and failing downloads
+// of URLs containing "broken"
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	args := os.Args
+	for i, arg := range args {
+		if arg == "--" {
+			args = args[i+1:]
+			break
+		}
+	}
+	switch {
+	case slices.Contains(args, "--flat-playlist"):
+		if os.Getenv("FAKE_YTDLP_MODE") == "slow" {
+			time.Sleep(10 * time.Second)
+		}
+		if os.Getenv("FAKE_YTDLP_MODE") == "fail" {
+			fmt.Fprintln(os.Stderr, "ERROR: [youtube:tab] channel does not exist")
+			os.Exit(1)
+		}
+		if os.Getenv("FAKE_YTDLP_MODE") == "sparse" {
+			fmt.Print(sparseListing)
+			break
+		}
+		fmt.Print(channelListing)
+	case slices.Contains(args, "--dump-single-json"):
+		_, id, _ := strings.Cut(args[len(args)-1], "v=")
+		info, ok := sparseInfo[id]
+		if !ok {
+			fmt.Fprintln(os.Stderr, "ERROR: Private video")
+			os.Exit(1)
+		}
+		fmt.Println(info)
+	case strings.Contains(args[len(args)-1], "broken"):
+		fmt.Fprintln(os.Stderr, "ERROR: video unavailable")
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func useFakeYTDLP(t *testing.T, mode string) {
+	original := ytdlp.Command
+	t.Cleanup(func() { ytdlp.Command = original })
+	ytdlp.Command = func(ctx context.Context, args ...string) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, os.Args[0], append([]string{"-test.run=TestHelperProcess", "--"}, args...)...)
+		cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1", "FAKE_YTDLP_MODE="+mode)
+		return cmd
+	}
+}
+
+// newTestScheduler returns a scheduler with its own job manager downloading
+// to a temporary folder and an archive holding the video "old"
+func newTestScheduler(t *testing.T) *Scheduler {
+	folder := t.TempDir()
+	manager := jobs.NewManager(2)
+	manager.Folder = func() string { return folder }
+	manager.Library = library.NewIndex()
+	manager.Archive = library.NewArchive()
+	manager.Webhooks = webhooks.NewNotifier()
+	manager.Archive.Add("Youtube", "old")
+
+	s := NewScheduler(NewStore(), manager)
+	s.Archive = manager.Archive
+	s.Policy = &utils.URLPolicy{AllowedSchemes: []string{"https"}, AllowPrivate: true}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+		manager.Shutdown(ctx)
+	})
+	return s
+}
+
+// waitForRun polls the runs of the subscription id until the latest one ends
+func waitForRun(t *testing.T, s *Scheduler, id string) Run {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		runs, _ := s.Store.Runs(id)
+		if len(runs) > 0 && runs[0].Status != RunRunning || time.Now().After(deadline) {
+			if len(runs) == 0 {
+				t.Fatal("no run recorded")
+			}
+			return runs[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSchedulerSync(t *testing.T) {
+	useFakeYTDLP(t, "ok")
+	s := newTestScheduler(t)
+	sub, _ := s.Store.Create(Subscription{
+		URL:      "https://www.youtube.com/@channel",
+		Options:  audioOptions,
+		Interval: "1h",
+		Filters:  Filters{MinDuration: 60},
+		MaxItems: 4,
+		User:     "tester",
+	})
+
+	started, err := s.Sync(sub.ID)
+	if err != nil || started.Status != RunRunning || started.Trigger != TriggerManual {
+		t.Fatalf("Sync() = %+v, %v", started, err)
+	}
+	run := waitForRun(t, s, sub.ID)
+
+	// old is archived, teaser too short and premiere not out yet; broken
+	// fails, ftp is refused and new3 is beyond maxItems
+	if run.ID != started.ID || run.Status != RunCompleted || run.Found != 8 || run.New != 2 || run.Skipped != 3 || run.Failed != 2 || run.Remaining != 1 {
+		t.Fatalf("unexpected run %+v", run)
+	}
+	var downloaded []string
+	for _, item := range run.Items {
+		if item.Status == jobs.StatusCompleted {
+			downloaded = append(downloaded, item.VideoID)
+			job, _ := s.Jobs.Get(item.JobID)
+			if job.SubscriptionID != sub.ID || job.User != "tester" || job.Options.AudioCodec != "mp3" {
+				t.Errorf("expected the job tied to its subscription, got %+v", job)
+			}
+			if job.Playlist == nil || job.Playlist.Title != "Channel - Videos" || job.Playlist.Index == 0 {
+				t.Errorf("expected the job to know its place in the listing, got %+v", job.Playlist)
+			}
+		}
+	}
+	if !slices.Equal(downloaded, []string{"new1", "new2"}) {
+		t.Errorf("expected new1 and new2 downloaded, got %v", downloaded)
+	}
+	if saved, _ := s.Store.Get(sub.ID); saved.LastRun == nil || saved.LastRun.ID != run.ID {
+		t.Errorf("expected the run recorded as the last one, got %+v", saved.LastRun)
+	}
+}
+
+func TestSchedulerSyncFetchesMissingMetadata(t *testing.T) {
+	useFakeYTDLP(t, "sparse")
+	s := newTestScheduler(t)
+	sub, _ := s.Store.Create(Subscription{URL: "https://www.youtube.com/@channel", Options: audioOptions, Interval: "1h", Filters: Filters{After: "2025-07-01"}})
+
+	s.Sync(sub.ID)
+	run := waitForRun(t, s, sub.ID)
+
+	// ancient is too old once fetched; undated and private stay unknown
+	if run.Status != RunCompleted || run.Found != 4 || run.New != 1 || run.Skipped != 3 {
+		t.Fatalf("unexpected run %+v", run)
+	}
+	if len(run.Items) != 1 || run.Items[0].VideoID != "recent" {
+		t.Errorf("expected only recent downloaded, got %+v", run.Items)
+	}
+}
+
+func TestSchedulerSyncListingFails(t *testing.T) {
+	useFakeYTDLP(t, "fail")
+	s := newTestScheduler(t)
+	sub, _ := s.Store.Create(Subscription{URL: "https://www.youtube.com/@gone", Options: audioOptions, Interval: "1h"})
+
+	s.Sync(sub.ID)
+	if run := waitForRun(t, s, sub.ID); run.Status != RunFailed || !strings.Contains(run.Error, "channel does not exist") {
+		t.Errorf("expected a failed run with yt-dlp's error, got %+v", run)
+	}
+}
+
+func TestSchedulerSyncsDue(t *testing.T) {
+	useFakeYTDLP(t, "ok")
+	s := newTestScheduler(t)
+	due, _ := s.Store.Create(Subscription{URL: "https://www.youtube.com/@channel", Options: audioOptions, Interval: "1h", Enabled: true})
+	paused, _ := s.Store.Create(Subscription{URL: "https://www.youtube.com/@paused", Options: audioOptions, Interval: "1h"})
+
+	now := time.Now().Add(2 * time.Hour)
+	s.syncDue(now)
+	if run := waitForRun(t, s, due.ID); run.Trigger != TriggerSchedule || run.Status != RunCompleted {
+		t.Errorf("expected a scheduled run, got %+v", run)
+	}
+	if saved, _ := s.Store.Get(due.ID); !saved.NextRunAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("expected the next run an hour later, got %v", saved.NextRunAt)
+	}
+	if runs, _ := s.Store.Runs(paused.ID); len(runs) != 0 {
+		t.Errorf("expected no run of a disabled subscription, got %+v", runs)
+	}
+}
+
+func TestSchedulerSyncRefusals(t *testing.T) {
+	useFakeYTDLP(t, "ok")
+	s := newTestScheduler(t)
+	sub, _ := s.Store.Create(Subscription{URL: "https://www.youtube.com/@channel", Options: audioOptions, Interval: "1h"})
+
+	if _, err := s.Sync("missing"); err != ErrNotFound {
+		t.Errorf("Sync(missing) = %v; want %v", err, ErrNotFound)
+	}
+	s.mu.Lock()
+	s.syncing[sub.ID] = true
+	s.mu.Unlock()
+	if _, err := s.Sync(sub.ID); err != ErrSyncing {
+		t.Errorf("Sync() while syncing = %v; want %v", err, ErrSyncing)
+	}
+}
+
+func TestSchedulerShutdownInterruptsRuns(t *testing.T) {
+	useFakeYTDLP(t, "slow")
+	s := newTestScheduler(t)
+	sub, _ := s.Store.Create(Subscription{URL: "https://www.youtube.com/@channel", Options: audioOptions, Interval: "1h"})
+	s.Sync(sub.ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+	if runs, _ := s.Store.Runs(sub.ID); runs[0].Status != RunInterrupted {
+		t.Errorf("expected the run interrupted, got %+v", runs[0])
+	}
+	if _, err := s.Sync(sub.ID); err != jobs.ErrShuttingDown {
+		t.Errorf("Sync() after Shutdown = %v; want %v", err, jobs.ErrShuttingDown)
+	}
+}
@@ -0,0 +1,348 @@
+package subtitles
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported subtitle formats, named by file extension
+const (
+	FormatVTT = "vtt"
+	FormatSRT = "srt"
+	FormatASS = "ass"
+)
+
+// Cue is one timed block of subtitle text. Lines are separated by "\n".
+type Cue struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// IsSupported reports whether format can be parsed and written
+func IsSupported(format string) bool {
+	switch strings.ToLower(format) {
+	case FormatVTT, FormatSRT, FormatASS:
+		return true
+	}
+	return false
+}
+
+// Convert parses data in the from format and writes it in the to format
+func Convert(data []byte, from, to string) ([]byte, error) {
+	cues, err := Parse(data, from)
+	if err != nil {
+		return nil, err
+	}
+	return Format(cues, to)
+}
+
+// Parse reads cues from data in the given format
+func Parse(data []byte, format string) ([]Cue, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+
+	switch strings.ToLower(format) {
+	case FormatVTT:
+		return parseVTT(string(data))
+	case FormatSRT:
+		return parseSRT(string(data))
+	case FormatASS:
+		return parseASS(string(data))
+	default:
+		return nil, fmt.Errorf("unsupported subtitle format %q", format)
+	}
+}
+
+// Format writes cues in the given format
+func Format(cues []Cue, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	switch strings.ToLower(format) {
+	case FormatVTT:
+		buf.WriteString("WEBVTT\n\n")
+		for _, cue := range cues {
+			fmt.Fprintf(&buf, "%s --> %s\n%s\n\n", vttTimestamp(cue.Start), vttTimestamp(cue.End), vttEscaper.Replace(cue.Text))
+		}
+	case FormatSRT:
+		for i, cue := range cues {
+			fmt.Fprintf(&buf, "%d\n%s --> %s\n%s\n\n", i+1, srtTimestamp(cue.Start), srtTimestamp(cue.End), cue.Text)
+		}
+	case FormatASS:
+		buf.WriteString(assHeader)
+		for _, cue := range cues {
+			text := strings.ReplaceAll(cue.Text, "\n", `\N`)
+			fmt.Fprintf(&buf, "Dialogue: 0,%s,%s,Default,,0,0,0,,%s\n", assTimestamp(cue.Start), assTimestamp(cue.End), text)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported subtitle format %q", format)
+	}
+	return buf.Bytes(), nil
+}
+
+// Crop keeps the cues shown between start and end, clipped to that range and
+// shifted to begin at zero. An end of zero means until the last cue.
+func Crop(cues []Cue, start, end time.Duration) []Cue {
+	var cropped []Cue
+	for _, cue := range cues {
+		if cue.End <= start || (end > 0 && cue.Start >= end) {
+			continue
+		}
+		cue.Start = max(cue.Start, start) - start
+		if end > 0 {
+			cue.End = min(cue.End, end)
+		}
+		cue.End -= start
+		cropped = append(cropped, cue)
+	}
+	return cropped
+}
+
+const assHeader = `[Script Info]
+ScriptType: v4.00+
+PlayResX: 1920
+PlayResY: 1080
+WrapStyle: 0
+ScaledBorderAndShadow: yes
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding
+Style: Default,Arial,64,&H00FFFFFF,&H000000FF,&H00000000,&H80000000,0,0,0,0,100,100,0,0,1,3,1,2,60,60,50,1
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+`
+
+var (
+	// Matches "00:01.000 --> 00:02.000" with optional hours and trailing cue settings
+	timingPattern = regexp.MustCompile(`^((?:\d+:)?\d{1,2}:\d{2}[.,]\d{1,3})\s+-->\s+((?:\d+:)?\d{1,2}:\d{2}[.,]\d{1,3})`)
+	// Inline markup: VTT <c>, <i>, <00:00:01.000> karaoke tags and SRT <font> tags
+	tagPattern = regexp.MustCompile(`<[^>]*>`)
+	// ASS override blocks such as {\an8} or {\i1}
+	assOverridePattern = regexp.MustCompile(`\{[^}]*\}`)
+)
+
+// parseVTT reads WebVTT, dropping NOTE/STYLE/REGION blocks and inline tags.
+// YouTube auto-generated captions repeat the previous cue's text as the first
+// line of the next cue to produce a rolling effect; those repeats are dropped.
+func parseVTT(data string) ([]Cue, error) {
+	blocks := splitBlocks(data)
+	if len(blocks) == 0 || !strings.HasPrefix(blocks[0][0], "WEBVTT") {
+		return nil, fmt.Errorf("missing WEBVTT header")
+	}
+
+	var cues []Cue
+	var previous []string
+	for _, block := range blocks[1:] {
+		cue, ok := parseCueBlock(block)
+		if !ok {
+			continue
+		}
+
+		lines := strings.Split(cue.Text, "\n")
+		for len(lines) > 0 && len(previous) > 0 && lines[0] == previous[len(previous)-1] {
+			lines = lines[1:]
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		previous = lines
+		cue.Text = strings.Join(lines, "\n")
+		cues = append(cues, cue)
+	}
+	return cues, nil
+}
+
+// parseSRT reads SubRip, where each block is an index, a timing line and text
+func parseSRT(data string) ([]Cue, error) {
+	var cues []Cue
+	for _, block := range splitBlocks(data) {
+		cue, ok := parseCueBlock(block)
+		if !ok {
+			continue
+		}
+		cues = append(cues, cue)
+	}
+	if len(cues) == 0 && strings.TrimSpace(data) != "" {
+		return nil, fmt.Errorf("no SRT cues found")
+	}
+	return cues, nil
+}
+
+// parseCueBlock finds the timing line in a VTT or SRT block; everything after
+// it is the cue text
+func parseCueBlock(block []string) (Cue, bool) {
+	for i, line := range block {
+		m := timingPattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		start, err1 := parseTimestamp(m[1])
+		end, err2 := parseTimestamp(m[2])
+		if err1 != nil || err2 != nil {
+			return Cue{}, false
+		}
+
+		var text []string
+		for _, t := range block[i+1:] {
+			if t = strings.TrimSpace(tagPattern.ReplaceAllString(t, "")); t != "" {
+				text = append(text, unescapeEntities(t))
+			}
+		}
+		if len(text) == 0 {
+			return Cue{}, false
+		}
+		return Cue{Start: start, End: end, Text: strings.Join(text, "\n")}, true
+	}
+	return Cue{}, false
+}
+
+// parseASS reads the Dialogue lines of the [Events] section, using its Format
+// line to locate the Start, End and Text fields
+func parseASS(data string) ([]Cue, error) {
+	var cues []Cue
+	inEvents := false
+	fields := []string{"layer", "start", "end", "style", "name", "marginl", "marginr", "marginv", "effect", "text"}
+
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			inEvents = strings.EqualFold(line, "[Events]")
+			continue
+		}
+		if !inEvents {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "Format":
+			fields = nil
+			for _, f := range strings.Split(value, ",") {
+				fields = append(fields, strings.ToLower(strings.TrimSpace(f)))
+			}
+		case "Dialogue":
+			// Text is always last and may itself contain commas
+			parts := strings.SplitN(strings.TrimSpace(value), ",", len(fields))
+			if len(parts) != len(fields) {
+				continue
+			}
+			var cue Cue
+			var err error
+			for i, field := range fields {
+				switch field {
+				case "start":
+					cue.Start, err = parseTimestamp(parts[i])
+				case "end":
+					cue.End, err = parseTimestamp(parts[i])
+				case "text":
+					text := assOverridePattern.ReplaceAllString(parts[i], "")
+					text = strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ").Replace(text)
+					cue.Text = strings.TrimSpace(text)
+				}
+				if err != nil {
+					break
+				}
+			}
+			if err == nil && cue.Text != "" {
+				cues = append(cues, cue)
+			}
+		}
+	}
+	if cues == nil && !strings.Contains(data, "[Events]") {
+		return nil, fmt.Errorf("missing [Events] section")
+	}
+	return cues, nil
+}
+
+// splitBlocks splits data into blank-line separated blocks of trimmed lines
+func splitBlocks(data string) [][]string {
+	var blocks [][]string
+	var current []string
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(line, " \t")
+		if line == "" {
+			if len(current) > 0 {
+				blocks = append(blocks, current)
+				current = nil
+			}
+			continue
+		}
+		current = append(current, line)
+	}
+	if len(current) > 0 {
+		blocks = append(blocks, current)
+	}
+	return blocks
+}
+
+// parseTimestamp accepts h:mm:ss.fff, mm:ss.fff, SRT's comma separator and
+// ASS's centiseconds (h:mm:ss.cc)
+func parseTimestamp(value string) (time.Duration, error) {
+	value = strings.Replace(strings.TrimSpace(value), ",", ".", 1)
+	clock, frac, _ := strings.Cut(value, ".")
+
+	parts := strings.Split(clock, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", value)
+	}
+	var total time.Duration
+	for _, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timestamp %q", value)
+		}
+		total = total*60 + time.Duration(n)*time.Second
+	}
+
+	if frac != "" {
+		for len(frac) < 3 {
+			frac += "0"
+		}
+		ms, err := strconv.Atoi(frac[:3])
+		if err != nil {
+			return 0, fmt.Errorf("invalid timestamp %q", value)
+		}
+		total += time.Duration(ms) * time.Millisecond
+	}
+	return total, nil
+}
+
+func splitDuration(d time.Duration) (h, m, s, ms int64) {
+	if d < 0 {
+		d = 0
+	}
+	total := d.Milliseconds()
+	return total / 3600000, total / 60000 % 60, total / 1000 % 60, total % 1000
+}
+
+func vttTimestamp(d time.Duration) string {
+	h, m, s, ms := splitDuration(d)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", h, m, s, ms)
+}
+
+func srtTimestamp(d time.Duration) string {
+	h, m, s, ms := splitDuration(d)
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", h, m, s, ms)
+}
+
+func assTimestamp(d time.Duration) string {
+	h, m, s, ms := splitDuration(d)
+	return fmt.Sprintf("%d:%02d:%02d.%02d", h, m, s, ms/10)
+}
+
+var vttEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+var entityReplacer = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&nbsp;", " ", "&lrm;", "", "&rlm;", "")
+
+func unescapeEntities(s string) string {
+	return entityReplacer.Replace(s)
+}
@@ -0,0 +1,232 @@
+package subtitles
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const sampleVTT = `WEBVTT
+Kind: captions
+Language: en
+
+NOTE This is a comment
+
+STYLE
+::cue { color: yellow }
+
+1
+00:00:01.000 --> 00:00:03.500 align:start position:0%
+Hello <c.colorE5E5E5>world</c>
+
+00:01:02.250 --> 00:01:04.000
+Tom &amp; Jerry
+<i>second line</i>
+`
+
+const sampleSRT = "1\r\n00:00:01,000 --> 00:00:03,500\r\nHello world\r\n\r\n2\r\n00:01:02,250 --> 00:01:04,000\r\nTom & Jerry\r\nsecond line\r\n"
+
+const sampleASS = `[Script Info]
+Title: sample
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize
+Style: Default,Arial,20
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+Dialogue: 0,0:00:01.00,0:00:03.50,Default,,0,0,0,,{\an8}Hello world
+Comment: 0,0:00:02.00,0:00:03.00,Default,,0,0,0,,not shown
+Dialogue: 0,0:01:02.25,0:01:04.00,Default,,0,0,0,,Tom & Jerry\Nsecond line
+`
+
+var expectedCues = []Cue{
+	{Start: time.Second, End: 3500 * time.Millisecond, Text: "Hello world"},
+	{Start: 62250 * time.Millisecond, End: 64 * time.Second, Text: "Tom & Jerry\nsecond line"},
+}
+
+func assertCues(t *testing.T, got []Cue) {
+	t.Helper()
+	if len(got) != len(expectedCues) {
+		t.Fatalf("expected %d cues, got %d: %+v", len(expectedCues), len(got), got)
+	}
+	for i := range expectedCues {
+		if got[i] != expectedCues[i] {
+			t.Errorf("cue %d = %+v; want %+v", i, got[i], expectedCues[i])
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		format string
+		data   string
+	}{
+		{FormatVTT, sampleVTT},
+		{FormatSRT, sampleSRT},
+		{FormatASS, sampleASS},
+	}
+
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			cues, err := Parse([]byte(test.data), test.format)
+			if err != nil {
+				t.Fatalf("Parse() = %v", err)
+			}
+			assertCues(t, cues)
+		})
+	}
+}
+
+func TestConvertRoundTrips(t *testing.T) {
+	formats := []string{FormatVTT, FormatSRT, FormatASS}
+	for _, from := range formats {
+		for _, to := range formats {
+			t.Run(from+"->"+to, func(t *testing.T) {
+				source, _ := Format(expectedCues, from)
+				converted, err := Convert(source, from, to)
+				if err != nil {
+					t.Fatalf("Convert() = %v", err)
+				}
+				cues, err := Parse(converted, to)
+				if err != nil {
+					t.Fatalf("Parse() of converted output = %v\n%s", err, converted)
+				}
+				assertCues(t, cues)
+			})
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{FormatSRT, "1\n00:00:01,000 --> 00:00:03,500\nHello world\n\n2\n00:01:02,250 --> 00:01:04,000\nTom & Jerry\nsecond line\n\n"},
+		{FormatVTT, "WEBVTT\n\n00:00:01.000 --> 00:00:03.500\nHello world\n\n00:01:02.250 --> 00:01:04.000\nTom &amp; Jerry\nsecond line\n\n"},
+	}
+
+	for _, test := range tests {
+		out, err := Format(expectedCues, test.format)
+		if err != nil {
+			t.Fatalf("Format(%s) = %v", test.format, err)
+		}
+		if string(out) != test.expected {
+			t.Errorf("Format(%s) =\n%q\nwant\n%q", test.format, out, test.expected)
+		}
+	}
+
+	out, _ := Format(expectedCues, FormatASS)
+	if !strings.Contains(string(out), `Dialogue: 0,0:01:02.25,0:01:04.00,Default,,0,0,0,,Tom & Jerry\Nsecond line`) {
+		t.Errorf("unexpected ASS output:\n%s", out)
+	}
+}
+
+func TestCrop(t *testing.T) {
+	cues := []Cue{
+		{Start: 1 * time.Second, End: 3 * time.Second, Text: "before"},
+		{Start: 9 * time.Second, End: 11 * time.Second, Text: "across start"},
+		{Start: 15 * time.Second, End: 16 * time.Second, Text: "inside"},
+		{Start: 19 * time.Second, End: 22 * time.Second, Text: "across end"},
+		{Start: 20 * time.Second, End: 21 * time.Second, Text: "at end"},
+	}
+
+	tests := []struct {
+		name       string
+		start, end time.Duration
+		expected   []Cue
+	}{
+		{
+			name:  "range",
+			start: 10 * time.Second, end: 20 * time.Second,
+			expected: []Cue{
+				{Start: 0, End: 1 * time.Second, Text: "across start"},
+				{Start: 5 * time.Second, End: 6 * time.Second, Text: "inside"},
+				{Start: 9 * time.Second, End: 10 * time.Second, Text: "across end"},
+			},
+		},
+		{
+			name:  "open end",
+			start: 16 * time.Second,
+			expected: []Cue{
+				{Start: 3 * time.Second, End: 6 * time.Second, Text: "across end"},
+				{Start: 4 * time.Second, End: 5 * time.Second, Text: "at end"},
+			},
+		},
+		{name: "nothing shown", start: 4 * time.Second, end: 8 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Crop(cues, tt.start, tt.end)
+			if len(got) != len(tt.expected) {
+				t.Fatalf("Crop() = %+v; want %+v", got, tt.expected)
+			}
+			for i := range got {
+				if got[i] != tt.expected[i] {
+					t.Errorf("cue %d = %+v; want %+v", i, got[i], tt.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseVTTDropsRollingAutoCaptionRepeats(t *testing.T) {
+	data := `WEBVTT
+
+00:00:00.000 --> 00:00:02.000
+<00:00:00.100><c>so</c><00:00:00.400><c> today</c>
+
+00:00:02.000 --> 00:00:02.010
+so today
+
+00:00:02.010 --> 00:00:04.000
+so today
+we are going to
+`
+	cues, err := Parse([]byte(data), FormatVTT)
+	if err != nil {
+		t.Fatalf("Parse() = %v", err)
+	}
+	if len(cues) != 2 || cues[0].Text != "so today" || cues[1].Text != "we are going to" {
+		t.Errorf("unexpected cues %+v", cues)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		format string
+		data   string
+	}{
+		{FormatVTT, "00:00:01.000 --> 00:00:02.000\nno header"},
+		{FormatSRT, "just some text"},
+		{FormatASS, "[Script Info]\nTitle: nothing"},
+		{"sub", "anything"},
+	}
+
+	for _, test := range tests {
+		if _, err := Parse([]byte(test.data), test.format); err == nil {
+			t.Errorf("Parse(%s) expected an error", test.format)
+		}
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+	}{
+		{"00:00:01.000", time.Second},
+		{"01:02.5", 62*time.Second + 500*time.Millisecond},
+		{"1:00:00,250", time.Hour + 250*time.Millisecond},
+		{"0:00:03.07", 3*time.Second + 70*time.Millisecond},
+	}
+
+	for _, test := range tests {
+		result, err := parseTimestamp(test.input)
+		if err != nil || result != test.expected {
+			t.Errorf("parseTimestamp(%q) = %v, %v; want %v", test.input, result, err, test.expected)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Stages of a yt-dlp run, as reported by the bracketed prefix of its output lines
+const (
+	StageExtraction     = "extraction"
+	StageDownloading    = "downloading"
+	StageMerging        = "merging"
+	StagePostProcessing = "post_processing"
+)
+
+// ClassifyStage maps a line of yt-dlp output to the stage it belongs to, or
+// "" when the line carries no stage information
+func ClassifyStage(line string) string {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "[") {
+		return ""
+	}
+	end := strings.IndexByte(line, ']')
+	if end < 0 {
+		return ""
+	}
+
+	switch tag := line[1:end]; tag {
+	case "download", "hlsnative", "dashsegments":
+		return StageDownloading
+	case "Merger", "VideoRemuxer":
+		return StageMerging
+	case "ExtractAudio", "VideoConvertor", "Metadata", "EmbedThumbnail", "EmbedSubtitle",
+		"FixupM3u8", "FixupM4a", "FixupStretched", "FixupTimestamp", "FixupDuration",
+		"ModifyChapters", "SplitChapters", "SponsorBlock", "ThumbnailsConvertor",
+		"SubtitlesConvertor", "MoveFiles", "Exec":
+		return StagePostProcessing
+	default:
+		// Extractor names such as [youtube] or [youtube:tab]
+		return StageExtraction
+	}
+}
+
+// StageTracker opens a child span for each yt-dlp stage as its output is read,
+// closing the previous stage when the next one begins
+type StageTracker struct {
+	ctx     context.Context
+	current string
+	span    trace.Span
+}
+
+// NewStageTracker creates a tracker whose stage spans are children of ctx's span
+func NewStageTracker(ctx context.Context) *StageTracker {
+	return &StageTracker{ctx: ctx}
+}
+
+// Line feeds one line of yt-dlp output to the tracker
+func (t *StageTracker) Line(line string) {
+	stage := ClassifyStage(line)
+	if stage == "" || stage == t.current {
+		return
+	}
+	if t.span != nil {
+		t.span.End()
+	}
+	t.current = stage
+	_, t.span = Tracer().Start(t.ctx, "yt-dlp "+stage, trace.WithAttributes(Attr("ytdlp.stage", stage)))
+}
+
+// End closes the open stage span, recording err on it
+func (t *StageTracker) End(err error) {
+	if t.span != nil {
+		EndSpan(t.span, err)
+		t.span = nil
+	}
+}
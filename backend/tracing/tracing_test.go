@@ -0,0 +1,162 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func useRecorder(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+	return recorder
+}
+
+func TestClassifyStage(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{"[youtube] dQw4w9WgXcQ: Downloading webpage", StageExtraction},
+		{"[youtube:tab] Extracting URL", StageExtraction},
+		{"[info] dQw4w9WgXcQ: Downloading 1 format(s): 137+140", StageExtraction},
+		{"[download]  42.0% of 10.00MiB at 1.00MiB/s ETA 00:06", StageDownloading},
+		{"[hlsnative] Downloading m3u8 manifest", StageDownloading},
+		{"[Merger] Merging formats into \"video.mp4\"", StageMerging},
+		{"[ExtractAudio] Destination: song.mp3", StagePostProcessing},
+		{"[Metadata] Adding metadata to \"video.mp4\"", StagePostProcessing},
+		{"  42.0% (00:06 remaining)", ""},
+		{"WARNING: something odd", ""},
+		{"[unterminated", ""},
+	}
+
+	for _, test := range tests {
+		if result := ClassifyStage(test.line); result != test.expected {
+			t.Errorf("ClassifyStage(%q) = %q; want %q", test.line, result, test.expected)
+		}
+	}
+}
+
+func TestStageTrackerCreatesSpanPerStage(t *testing.T) {
+	recorder := useRecorder(t)
+
+	ctx, parent := Tracer().Start(context.Background(), "yt-dlp")
+	tracker := NewStageTracker(ctx)
+	for _, line := range []string{
+		"[youtube] abc: Downloading webpage",
+		"[info] abc: Downloading 1 format(s)",
+		"[download]   1.0%",
+		"[download] 100.0%",
+		"[Merger] Merging formats",
+		"[Metadata] Adding metadata",
+		"Deleting original file",
+	} {
+		tracker.Line(line)
+	}
+	tracker.End(nil)
+	parent.End()
+
+	var names []string
+	for _, span := range recorder.Ended() {
+		if span.Name() != "yt-dlp" && span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("span %s is not a child of the yt-dlp span", span.Name())
+		}
+		names = append(names, span.Name())
+	}
+
+	expected := []string{"yt-dlp extraction", "yt-dlp downloading", "yt-dlp merging", "yt-dlp post_processing", "yt-dlp"}
+	if len(names) != len(expected) {
+		t.Fatalf("expected spans %v, got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("span %d = %s; want %s", i, names[i], expected[i])
+		}
+	}
+}
+
+func TestMiddlewareContinuesIncomingTrace(t *testing.T) {
+	recorder := useRecorder(t)
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(Middleware())
+	router.GET("/files/:filename", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	req, _ := http.NewRequest(http.MethodGet, "/files/a.mp4", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "GET /files/:filename" {
+		t.Errorf("expected span name to use the route, got %s", span.Name())
+	}
+	if span.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected incoming trace ID to be continued, got %s", span.SpanContext().TraceID())
+	}
+	if span.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("expected remote parent span, got %s", span.Parent().SpanID())
+	}
+}
+
+func TestOTLPExportToLocalCollector(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []*http.Request
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		requests = append(requests, r)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+
+	t.Setenv("OTEL_TRACES_EXPORTER", "otlp")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", collector.URL+"/v1/traces")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_INSECURE", "true")
+
+	shutdown, err := Setup(context.Background())
+	if err != nil {
+		t.Fatalf("Setup() = %v", err)
+	}
+
+	_, span := Tracer().Start(context.Background(), "job")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown() = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) == 0 {
+		t.Fatal("expected the collector to receive an export request")
+	}
+	if requests[0].URL.Path != "/v1/traces" || requests[0].Header.Get("Content-Type") != "application/x-protobuf" {
+		t.Errorf("unexpected export request %s %s", requests[0].URL.Path, requests[0].Header.Get("Content-Type"))
+	}
+}
+
+func TestSetupRejectsUnknownExporter(t *testing.T) {
+	t.Setenv("OTEL_TRACES_EXPORTER", "zipkin")
+	if _, err := Setup(context.Background()); err == nil {
+		t.Error("expected an error for an unknown exporter")
+	}
+}
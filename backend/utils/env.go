@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// EnvInt returns the integer value of the environment variable name, or def
+// when it is unset or not a valid integer
+func EnvInt(name string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return n
+	}
+	return def
+}
+
+// EnvDuration returns the duration value (e.g. "30s") of the environment
+// variable name, or def when it is unset or invalid
+func EnvDuration(name string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(name)); err == nil {
+		return d
+	}
+	return def
+}
@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEnvInt(t *testing.T) {
+	t.Setenv("TEST_ENV_INT", "7")
+	if got := EnvInt("TEST_ENV_INT", 3); got != 7 {
+		t.Errorf("EnvInt() = %d; want 7", got)
+	}
+
+	t.Setenv("TEST_ENV_INT", "seven")
+	if got := EnvInt("TEST_ENV_INT", 3); got != 3 {
+		t.Errorf("EnvInt() with invalid value = %d; want 3", got)
+	}
+}
+
+func TestEnvDuration(t *testing.T) {
+	t.Setenv("TEST_ENV_DURATION", "45s")
+	if got := EnvDuration("TEST_ENV_DURATION", time.Minute); got != 45*time.Second {
+		t.Errorf("EnvDuration() = %s; want 45s", got)
+	}
+
+	t.Setenv("TEST_ENV_DURATION", "")
+	if got := EnvDuration("TEST_ENV_DURATION", time.Minute); got != time.Minute {
+		t.Errorf("EnvDuration() with empty value = %s; want 1m", got)
+	}
+}
@@ -1,11 +1,15 @@
 package utils
 
 import (
-	"fmt"
+	"errors"
+	"io/fs"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 // FileInfo represents information about a downloaded file
@@ -15,6 +19,9 @@ type FileInfo struct {
 	ModTime     string `json:"modTime"`
 	DownloadURL string `json:"downloadUrl"`
 	Type        string `json:"type"`
+	Language    string `json:"language,omitempty"` // subtitle files only
+	// ThumbnailURL links the sidecar image of an audio or video file
+	ThumbnailURL string `json:"thumbnailUrl,omitempty"`
 }
 
 // GetFileList returns a list of filenames in the given directory
@@ -33,6 +40,64 @@ func GetFileList(dir string) ([]string, error) {
 	return files, nil
 }
 
+// ListFilesRecursive returns the files below dir as "/"-separated paths
+// relative to it, in lexical order. Folders whose names start with "." hold
+// the server's own state and unfinished downloads, and are skipped.
+func ListFilesRecursive(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if p != dir && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	return files, err
+}
+
+// ErrInvalidPath is returned for a file path outside the download folder
+var ErrInvalidPath = errors.New("invalid file path")
+
+// CleanFilePath checks a "/"-separated file path relative to the download
+// folder, as clients send it, and returns it cleaned. Absolute paths, ".."
+// and folders starting with "." are rejected, so the result always names a
+// file ListFilesRecursive could return.
+func CleanFilePath(name string) (string, error) {
+	if name == "" || strings.ContainsRune(name, 0) || path.IsAbs(name) {
+		return "", ErrInvalidPath
+	}
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		if segment == ".." || (i < len(segments)-1 && strings.HasPrefix(segment, ".")) {
+			return "", ErrInvalidPath
+		}
+	}
+	name = path.Clean(name)
+	if name == "." {
+		return "", ErrInvalidPath
+	}
+	return name, nil
+}
+
+// FileURL returns the download URL of the file path, with "/" escaped so
+// that nested paths stay one path segment
+func FileURL(name string) string {
+	return "/files/" + url.PathEscape(name)
+}
+
 // FindNewFiles returns files that are in 'after' but not in 'before'
 func FindNewFiles(before, after []string) []string {
 	beforeSet := make(map[string]bool)
@@ -55,8 +120,12 @@ func GetFileType(filename string) string {
 	switch ext {
 	case ".mp4", ".avi", ".mkv", ".mov", ".wmv", ".flv", ".webm", ".m4v", ".3gp", ".ogv":
 		return "video"
-	case ".mp3", ".wav", ".flac", ".aac", ".ogg", ".m4a", ".wma":
+	case ".mp3", ".wav", ".flac", ".aac", ".ogg", ".opus", ".m4a", ".wma":
 		return "audio"
+	case ".vtt", ".srt", ".ass", ".ssa":
+		return "subtitle"
+	case ".jpg", ".jpeg", ".png", ".webp":
+		return "image"
 	default:
 		return "unknown"
 	}
@@ -94,8 +163,22 @@ func GetContentType(filename string) string {
 		return "audio/aac"
 	case ".ogg":
 		return "audio/ogg"
+	case ".opus":
+		return "audio/opus"
 	case ".wma":
 		return "audio/x-ms-wma"
+	case ".vtt":
+		return "text/vtt"
+	case ".srt":
+		return "application/x-subrip"
+	case ".ass", ".ssa":
+		return "text/x-ssa"
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".png":
+		return "image/png"
+	case ".webp":
+		return "image/webp"
 	default:
 		return "application/octet-stream"
 	}
@@ -110,14 +193,78 @@ func CreateFileInfo(filename string, downloadFolder string) (*FileInfo, error) {
 	}
 
 	return &FileInfo{
-		Name:        filename,
-		Size:        info.Size(),
-		ModTime:     info.ModTime().Format(time.RFC3339),
-		DownloadURL: "/files/" + filename,
-		Type:        GetFileType(filename),
+		Name:         filename,
+		Size:         info.Size(),
+		ModTime:      info.ModTime().Format(time.RFC3339),
+		DownloadURL:  FileURL(filename),
+		Type:         GetFileType(filename),
+		Language:     SubtitleLanguage(filename),
+		ThumbnailURL: thumbnailURL(filename, downloadFolder),
 	}, nil
 }
 
+// thumbnailURL returns the download URL of the image beside an audio or
+// video file with the same base name, or "" if there is none
+func thumbnailURL(filename, downloadFolder string) string {
+	if fileType := GetFileType(filename); fileType != "video" && fileType != "audio" {
+		return ""
+	}
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+	for _, ext := range []string{".jpg", ".jpeg", ".png", ".webp"} {
+		if _, err := os.Stat(filepath.Join(downloadFolder, base+ext)); err == nil {
+			return FileURL(base + ext)
+		}
+	}
+	return ""
+}
+
+// maxNameLength bounds names produced by SanitizeFilename, in bytes
+const maxNameLength = 200
+
+// SanitizeFilename makes name safe as a single path element on every
+// platform: path separators, characters Windows rejects and control
+// characters become "_", surrounding spaces and dots are trimmed and the
+// result is cut to a length every filesystem accepts
+func SanitizeFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20 || r == 0x7f:
+			return '_'
+		case strings.ContainsRune(`/\:*?"<>|`, r):
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, " .")
+
+	if len(name) > maxNameLength {
+		// Cut on a rune boundary
+		cut := maxNameLength
+		for cut > 0 && !utf8.RuneStart(name[cut]) {
+			cut--
+		}
+		name = strings.TrimRight(name[:cut], " .")
+	}
+	if name == "" {
+		return "_"
+	}
+	return name
+}
+
+// SubtitleLanguage returns the language yt-dlp puts before a subtitle file's
+// extension ("Video.en-US.vtt" is "en-US"), or "" for other files
+func SubtitleLanguage(filename string) string {
+	if GetFileType(filename) != "subtitle" {
+		return ""
+	}
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+	lang := strings.TrimPrefix(filepath.Ext(base), ".")
+	if lang == "" || strings.ContainsAny(lang, " ") {
+		return ""
+	}
+	return lang
+}
+
 // CleanupOldFiles removes files older than the specified duration
 func CleanupOldFiles(downloadFolder string, maxAge time.Duration) error {
 	entries, err := os.ReadDir(downloadFolder)
@@ -147,68 +294,32 @@ func CleanupOldFiles(downloadFolder string, maxAge time.Duration) error {
 	return nil
 }
 
-// BuildVideoFormat constructs the yt-dlp format string based on resolution and video format preferences
-func BuildVideoFormat(resolution, videoFormat string) string {
-	// Handle video format preference with more specific selectors
-	var formatOptions []string
-
-	// Handle resolution preference
-	var heightFilter string
-	if resolution != "" {
-		heightFilter = fmt.Sprintf("[height<=%s]", resolution)
-	}
-
-	// Build format string based on preferences
-	switch videoFormat {
-	case "mp4":
-		if resolution != "" {
-			formatOptions = []string{
-				fmt.Sprintf("bestvideo[ext=mp4]%s+bestaudio[ext=m4a]/bestvideo[ext=mp4]%s+bestaudio/best[ext=mp4]%s", heightFilter, heightFilter, heightFilter),
-				fmt.Sprintf("bestvideo%s+bestaudio/best%s", heightFilter, heightFilter),
-			}
-		} else {
-			formatOptions = []string{
-				"bestvideo[ext=mp4]+bestaudio[ext=m4a]/bestvideo[ext=mp4]+bestaudio/best[ext=mp4]",
-				"bestvideo+bestaudio/best",
-			}
-		}
-	case "webm":
-		if resolution != "" {
-			formatOptions = []string{
-				fmt.Sprintf("bestvideo[ext=webm]%s+bestaudio[ext=webm]/best[ext=webm]%s", heightFilter, heightFilter),
-				fmt.Sprintf("bestvideo%s+bestaudio/best%s", heightFilter, heightFilter),
-			}
-		} else {
-			formatOptions = []string{
-				"bestvideo[ext=webm]+bestaudio[ext=webm]/best[ext=webm]",
-				"bestvideo+bestaudio/best",
-			}
-		}
-	case "mkv":
-		if resolution != "" {
-			formatOptions = []string{
-				fmt.Sprintf("bestvideo[ext=mkv]%s+bestaudio/best[ext=mkv]%s", heightFilter, heightFilter),
-				fmt.Sprintf("bestvideo%s+bestaudio/best%s", heightFilter, heightFilter),
-			}
-		} else {
-			formatOptions = []string{
-				"bestvideo[ext=mkv]+bestaudio/best[ext=mkv]",
-				"bestvideo+bestaudio/best",
-			}
-		}
-	default:
-		// For other formats or "best", use general approach
-		if resolution != "" {
-			formatOptions = []string{
-				fmt.Sprintf("bestvideo%s+bestaudio/best%s", heightFilter, heightFilter),
-			}
-		} else {
-			formatOptions = []string{
-				"bestvideo+bestaudio/best",
-			}
+// TotalSize returns the combined size of the named files in dir, skipping any that cannot be read
+func TotalSize(dir string, names []string) int64 {
+	var total int64
+	for _, name := range names {
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			total += info.Size()
 		}
 	}
+	return total
+}
 
-	// Join all options with fallbacks
-	return strings.Join(formatOptions, "/")
+// WriteFileAtomic writes data to a temporary file beside path and renames it
+// over path, so readers never see a partially written file
+func WriteFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
 }
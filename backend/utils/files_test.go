@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestGetFileType(t *testing.T) {
+	tests := []struct {
+		filename string
+		expected string
+	}{
+		{"video.MP4", "video"},
+		{"song.m4a", "audio"},
+		{"video.en.vtt", "subtitle"},
+		{"video.de.srt", "subtitle"},
+		{"video.ja.ass", "subtitle"},
+		{"cover.JPG", "image"},
+		{"notes.txt", "unknown"},
+	}
+
+	for _, test := range tests {
+		if result := GetFileType(test.filename); result != test.expected {
+			t.Errorf("GetFileType(%q) = %q; want %q", test.filename, result, test.expected)
+		}
+	}
+}
+
+func TestCreateFileInfoThumbnailURL(t *testing.T) {
+	folder := t.TempDir()
+	for _, name := range []string{"Song.mp3", "Song.jpg", "Other.mp4", "Song.en.vtt"} {
+		os.WriteFile(filepath.Join(folder, name), []byte("x"), 0o644)
+	}
+
+	tests := []struct {
+		filename string
+		expected string
+	}{
+		{"Song.mp3", "/files/Song.jpg"},
+		{"Other.mp4", ""},
+		{"Song.jpg", ""},
+		{"Song.en.vtt", ""},
+	}
+
+	for _, test := range tests {
+		info, err := CreateFileInfo(test.filename, folder)
+		if err != nil {
+			t.Fatalf("CreateFileInfo(%q) = %v", test.filename, err)
+		}
+		if info.ThumbnailURL != test.expected {
+			t.Errorf("CreateFileInfo(%q).ThumbnailURL = %q; want %q", test.filename, info.ThumbnailURL, test.expected)
+		}
+	}
+}
+
+func TestSubtitleLanguage(t *testing.T) {
+	tests := []struct {
+		filename string
+		expected string
+	}{
+		{"My Video.en.vtt", "en"},
+		{"My Video.en-US.srt", "en-US"},
+		{"My Video.pt-BR.ass", "pt-BR"},
+		{"subtitles.vtt", ""},
+		{"My Video.en.mp4", ""},
+		{"Vol. 2 of it.srt", ""},
+	}
+
+	for _, test := range tests {
+		if result := SubtitleLanguage(test.filename); result != test.expected {
+			t.Errorf("SubtitleLanguage(%q) = %q; want %q", test.filename, result, test.expected)
+		}
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	long := ""
+	for i := 0; i < 120; i++ {
+		long += "é"
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"Intro", "Intro"},
+		{"AC/DC: Live?", "AC_DC_ Live_"},
+		{`a\b*c"d<e>f|g`, "a_b_c_d_e_f_g"},
+		{"tab\there", "tab_here"},
+		{"  .hidden. ", "hidden"},
+		{"...", "_"},
+		{long, long[:200]},
+	}
+
+	for _, test := range tests {
+		if result := SanitizeFilename(test.input); result != test.expected {
+			t.Errorf("SanitizeFilename(%q) = %q; want %q", test.input, result, test.expected)
+		}
+	}
+}
+
+func TestCleanFilePath(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		valid    bool
+	}{
+		{"Video.mp4", "Video.mp4", true},
+		{"Channel/2024/Video.mp4", "Channel/2024/Video.mp4", true},
+		{"Channel//Video.mp4", "Channel/Video.mp4", true},
+		{".hack.mp4", ".hack.mp4", true},
+		{"", "", false},
+		{".", "", false},
+		{"../secret", "", false},
+		{"Channel/../../secret", "", false},
+		{"/etc/passwd", "", false},
+		{".downloader/library.json", "", false},
+		{".staging-abc/Video.mp4", "", false},
+	}
+
+	for _, test := range tests {
+		result, err := CleanFilePath(test.name)
+		if (err == nil) != test.valid || result != test.expected {
+			t.Errorf("CleanFilePath(%q) = %q, %v; want %q, valid=%v", test.name, result, err, test.expected, test.valid)
+		}
+	}
+}
+
+func TestListFilesRecursive(t *testing.T) {
+	folder := t.TempDir()
+	for _, name := range []string{"b.mp4", "Channel/2024/a.mp4", ".staging-abc/c.mp4", ".hidden.mp4"} {
+		os.MkdirAll(filepath.Join(folder, filepath.Dir(name)), 0o755)
+		os.WriteFile(filepath.Join(folder, name), []byte("x"), 0o644)
+	}
+
+	files, err := ListFilesRecursive(folder)
+	if err != nil {
+		t.Fatalf("ListFilesRecursive() = %v", err)
+	}
+	expected := []string{".hidden.mp4", "Channel/2024/a.mp4", "b.mp4"}
+	if !slices.Equal(files, expected) {
+		t.Errorf("ListFilesRecursive() = %v; want %v", files, expected)
+	}
+}
+
+func TestFileURL(t *testing.T) {
+	if url := FileURL("Channel/My Video.mp4"); url != "/files/Channel%2FMy%20Video.mp4" {
+		t.Errorf("FileURL() = %q", url)
+	}
+}
@@ -0,0 +1,225 @@
+package utils
+
+import (
+	"errors"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// FolderInfo represents a folder below the download folder
+type FolderInfo struct {
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	ModTime   string `json:"modTime"`
+	BrowseURL string `json:"browseUrl"`
+}
+
+// Breadcrumb is one folder on the way from the download folder to a folder
+type Breadcrumb struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// CleanFolderPath checks a "/"-separated folder path relative to the download
+// folder and returns it cleaned, with "" for the download folder itself.
+// Unlike file names, no folder may start with ".".
+func CleanFolderPath(name string) (string, error) {
+	name = strings.TrimSuffix(name, "/")
+	if name == "" || name == "." {
+		return "", nil
+	}
+	name, err := CleanFilePath(name)
+	if err != nil || strings.HasPrefix(path.Base(name), ".") {
+		return "", ErrInvalidPath
+	}
+	return name, nil
+}
+
+// ResolvePath returns the path of the file or folder name below folder after
+// checking that neither name nor any symbolic link on the way leads outside
+// folder. Escapes return ErrInvalidPath. For a missing file the folders that
+// exist on the way are checked and an error satisfying os.IsNotExist is
+// returned.
+func ResolvePath(folder, name string) (string, error) {
+	name, err := CleanFilePath(name)
+	if err != nil {
+		return "", err
+	}
+	resolved := filepath.Join(folder, filepath.FromSlash(name))
+	existing := resolved
+	for {
+		real, evalErr := filepath.EvalSymlinks(existing)
+		if evalErr == nil {
+			if !within(folder, real) {
+				return "", ErrInvalidPath
+			}
+			break
+		}
+		if !errors.Is(evalErr, fs.ErrNotExist) || existing == folder {
+			return "", evalErr
+		}
+		if err == nil {
+			err = evalErr
+		}
+		existing = filepath.Dir(existing)
+	}
+	return resolved, err
+}
+
+// within reports whether the resolved path target is folder or below it
+func within(folder, target string) bool {
+	root, err := filepath.EvalSymlinks(folder)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(root, target)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// FolderURL returns the URL listing the folder path
+func FolderURL(name string) string {
+	return "/files?path=" + url.QueryEscape(name)
+}
+
+// Breadcrumbs returns the folders from the download folder, named root, down
+// to the folder path
+func Breadcrumbs(root, name string) []Breadcrumb {
+	crumbs := []Breadcrumb{{Name: root, Path: ""}}
+	if name == "" {
+		return crumbs
+	}
+	for i, segment := range strings.Split(name, "/") {
+		crumbs = append(crumbs, Breadcrumb{Name: segment, Path: path.Join(crumbs[i].Path, segment)})
+	}
+	return crumbs
+}
+
+// ReadFolder lists the folder name below root: its subfolders, and its files
+// as paths relative to root, both in lexical order. Folders starting with "."
+// are skipped, as are symbolic links that lead outside root or to anything
+// but a file or folder.
+func ReadFolder(root *os.Root, name string) ([]FolderInfo, []string, error) {
+	dir, err := openFolder(root, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	entries, err := dir.ReadDir(-1)
+	dir.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var folders []FolderInfo
+	var files []string
+	for _, entry := range entries {
+		entryPath := path.Join(name, entry.Name())
+		// Stat through root follows links only as long as they stay inside it
+		info, err := root.Stat(rootName(entryPath))
+		if err != nil {
+			continue
+		}
+		switch {
+		case info.IsDir() && !strings.HasPrefix(entry.Name(), "."):
+			folders = append(folders, FolderInfo{
+				Name:      entry.Name(),
+				Path:      entryPath,
+				ModTime:   info.ModTime().Format(time.RFC3339),
+				BrowseURL: FolderURL(entryPath),
+			})
+		case info.Mode().IsRegular():
+			files = append(files, entryPath)
+		}
+	}
+	slices.SortFunc(folders, func(a, b FolderInfo) int { return strings.Compare(a.Name, b.Name) })
+	slices.Sort(files)
+	return folders, files, nil
+}
+
+// ErrNotFolder is returned for a folder path that names a file
+var ErrNotFolder = errors.New("not a folder")
+
+// openFolder opens the folder name below root, failing for files
+func openFolder(root *os.Root, name string) (*os.File, error) {
+	dir, err := root.Open(rootName(name))
+	if err != nil {
+		return nil, err
+	}
+	if info, err := dir.Stat(); err != nil || !info.IsDir() {
+		dir.Close()
+		if err == nil {
+			err = ErrNotFolder
+		}
+		return nil, err
+	}
+	return dir, nil
+}
+
+// MkdirAllIn creates the folder name below root with any missing parents.
+// Links on the way are followed only as long as they stay inside root.
+func MkdirAllIn(root *os.Root, name string) error {
+	current := ""
+	for _, segment := range strings.Split(name, "/") {
+		current = path.Join(current, segment)
+		err := root.Mkdir(rootName(current), 0o755)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+		if info, statErr := root.Stat(rootName(current)); statErr != nil {
+			return statErr
+		} else if !info.IsDir() {
+			return ErrNotFolder
+		}
+	}
+	return nil
+}
+
+// RemoveAllIn removes the folder name below root and everything in it,
+// returning the paths of the files removed. Links inside it are removed
+// without following them, so nothing outside the folder is touched.
+func RemoveAllIn(root *os.Root, name string) ([]string, error) {
+	dir, err := openFolder(root, name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := dir.ReadDir(-1)
+	dir.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, entry := range entries {
+		entryPath := path.Join(name, entry.Name())
+		if entry.IsDir() {
+			files, err := RemoveAllIn(root, entryPath)
+			removed = append(removed, files...)
+			if err != nil {
+				return removed, err
+			}
+			continue
+		}
+		if err := root.Remove(rootName(entryPath)); err != nil {
+			return removed, err
+		}
+		removed = append(removed, entryPath)
+	}
+	return removed, root.Remove(rootName(name))
+}
+
+// rootName converts a "/"-separated path to one os.Root accepts, with "."
+// for the root itself
+func rootName(name string) string {
+	if name == "" {
+		return "."
+	}
+	return filepath.FromSlash(name)
+}
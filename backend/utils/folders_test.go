@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCleanFolderPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		valid    bool
+	}{
+		{"", "", true},
+		{".", "", true},
+		{"Channel/2024/", "Channel/2024", true},
+		{"/", "", true},
+		{"/tmp", "", false},
+		{"..", "", false},
+		{"Channel/../..", "", false},
+		{".staging-abc", "", false},
+		{"Channel/.hidden", "", false},
+	}
+
+	for _, test := range tests {
+		result, err := CleanFolderPath(test.name)
+		if (err == nil) != test.valid || result != test.expected {
+			t.Errorf("CleanFolderPath(%q) = %q, %v; want %q, valid=%v", test.name, result, err, test.expected, test.valid)
+		}
+	}
+}
+
+func TestResolvePath(t *testing.T) {
+	dir := t.TempDir()
+	folder := filepath.Join(dir, "Downloads")
+	outside := filepath.Join(dir, "outside")
+	os.MkdirAll(filepath.Join(folder, "Channel"), 0o755)
+	os.MkdirAll(outside, 0o755)
+	os.WriteFile(filepath.Join(folder, "Channel", "Talk.mp4"), []byte("x"), 0o644)
+	os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("x"), 0o644)
+	os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(folder, "secret.mp4"))
+	os.Symlink(outside, filepath.Join(folder, "Escape"))
+	os.Symlink("Channel", filepath.Join(folder, "Alias"))
+
+	tests := []struct {
+		name    string
+		escapes bool
+		missing bool
+	}{
+		{"Channel/Talk.mp4", false, false},
+		{"Alias/Talk.mp4", false, false},
+		{"Channel/Missing.mp4", false, true},
+		{"Missing/Deeper/File.mp4", false, true},
+		{"secret.mp4", true, false},
+		{"Escape/secret.txt", true, false},
+		{"Escape/Missing/File.mp4", true, false},
+		{"../outside/secret.txt", true, false},
+	}
+
+	for _, test := range tests {
+		_, err := ResolvePath(folder, test.name)
+		if escapes := errors.Is(err, ErrInvalidPath); escapes != test.escapes {
+			t.Errorf("ResolvePath(%q) = %v; want escape=%v", test.name, err, test.escapes)
+		}
+		if missing := os.IsNotExist(err); missing != test.missing {
+			t.Errorf("ResolvePath(%q) = %v; want missing=%v", test.name, err, test.missing)
+		}
+	}
+}
+
+func TestBreadcrumbs(t *testing.T) {
+	crumbs := Breadcrumbs("Downloads", "Channel/2024")
+	expected := []Breadcrumb{{"Downloads", ""}, {"Channel", "Channel"}, {"2024", "Channel/2024"}}
+	if len(crumbs) != len(expected) {
+		t.Fatalf("Breadcrumbs() = %v; want %v", crumbs, expected)
+	}
+	for i := range expected {
+		if crumbs[i] != expected[i] {
+			t.Errorf("Breadcrumbs()[%d] = %v; want %v", i, crumbs[i], expected[i])
+		}
+	}
+}
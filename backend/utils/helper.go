@@ -29,3 +29,12 @@ var runtimeGOOS = detectGOOS()
 func detectGOOS() string {
 	return os.Getenv("GOOS_REAL") // unused during normal run, set only in tests
 }
+
+// GetDataFolder returns where the server keeps its own state (job records and
+// the like): DATA_DIR if set, otherwise a hidden folder inside the download folder
+func GetDataFolder() string {
+	if dir := os.Getenv("DATA_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(GetDownloadFolder(), ".downloader")
+}
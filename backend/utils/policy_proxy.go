@@ -0,0 +1,186 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PolicyProxy is an HTTP proxy on the loopback interface that enforces a
+// URLPolicy on every connection it opens. Programs that resolve hosts and
+// follow redirects on their own, such as yt-dlp, are pointed at it so that
+// neither DNS rebinding nor a redirect lets them reach an address Check
+// would have refused. Plain HTTP requests are forwarded and HTTPS is
+// tunnelled with CONNECT; other protocols never pass through it.
+type PolicyProxy struct {
+	policy   *URLPolicy
+	listener net.Listener
+	server   *http.Server
+	forward  *httputil.ReverseProxy
+
+	mu      sync.Mutex
+	tunnels map[net.Conn]struct{}
+}
+
+// StartProxy listens on a free loopback port and serves the proxy until Close
+func (p *URLPolicy) StartProxy() (*PolicyProxy, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil // the upstream address is what the policy checks
+	transport.DialContext = p.dialer().DialContext
+
+	proxy := &PolicyProxy{
+		policy:   p,
+		listener: listener,
+		tunnels:  make(map[net.Conn]struct{}),
+	}
+	proxy.forward = &httputil.ReverseProxy{
+		Rewrite:      func(*httputil.ProxyRequest) {},
+		Transport:    transport,
+		ErrorHandler: proxyError,
+	}
+	proxy.server = &http.Server{Handler: proxy, ReadHeaderTimeout: 30 * time.Second}
+	go proxy.server.Serve(listener)
+	return proxy, nil
+}
+
+// URL is the address to pass to a client's proxy setting
+func (x *PolicyProxy) URL() string {
+	return "http://" + x.listener.Addr().String()
+}
+
+// Close stops the proxy and cuts every open tunnel
+func (x *PolicyProxy) Close() error {
+	err := x.server.Close()
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for conn := range x.tunnels {
+		conn.Close()
+	}
+	return err
+}
+
+func (x *PolicyProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		x.tunnel(w, r)
+		return
+	}
+	if !r.URL.IsAbs() || r.URL.Hostname() == "" {
+		http.Error(w, "not a proxy request", http.StatusBadRequest)
+		return
+	}
+	if err := x.checkHost(r.URL.Hostname()); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	x.forward.ServeHTTP(w, r)
+}
+
+// tunnel dials the CONNECT target and copies bytes both ways until either
+// side closes
+func (x *PolicyProxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		http.Error(w, "invalid CONNECT target", http.StatusBadRequest)
+		return
+	}
+	if err := x.checkHost(host); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	upstream, err := x.policy.dialer().DialContext(ctx, "tcp", r.Host)
+	cancel()
+	if err != nil {
+		proxyError(w, r, err)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "tunnelling not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buffered, err := hijacker.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		client.Close()
+		upstream.Close()
+		return
+	}
+
+	x.track(client, upstream)
+	defer x.untrack(client, upstream)
+
+	done := make(chan struct{}, 2)
+	go func() {
+		// Bytes the client sent right after the CONNECT line may already be buffered
+		io.Copy(upstream, buffered)
+		upstream.Close()
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(client, upstream)
+		client.Close()
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
+
+// checkHost applies the rules that need no lookup. The allowlist alone is
+// left out, since a page on an allowed host routinely loads its media from
+// CDN hosts; with MediaHosts set, a host must match either list.
+func (x *PolicyProxy) checkHost(host string) error {
+	host = strings.ToLower(host)
+	if err := x.policy.checkDenied(host); err != nil {
+		return err
+	}
+	if len(x.policy.MediaHosts) > 0 && !matchAnyHost(x.policy.AllowHosts, host) && !matchAnyHost(x.policy.MediaHosts, host) {
+		return &PolicyError{Code: PolicyHostNotAllowed, Reason: fmt.Sprintf("host %q is not in the allowlist or the media allowlist", host)}
+	}
+	return nil
+}
+
+func (x *PolicyProxy) track(conns ...net.Conn) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, conn := range conns {
+		x.tunnels[conn] = struct{}{}
+	}
+}
+
+func (x *PolicyProxy) untrack(conns ...net.Conn) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, conn := range conns {
+		delete(x.tunnels, conn)
+	}
+}
+
+// proxyError answers 403 for connections the policy refused and 502 for any
+// other upstream failure
+func proxyError(w http.ResponseWriter, _ *http.Request, err error) {
+	var policyErr *PolicyError
+	if errors.As(err, &policyErr) {
+		http.Error(w, policyErr.Error(), http.StatusForbidden)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadGateway)
+}
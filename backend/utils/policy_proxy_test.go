@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// proxyClient returns a client sending every request through proxy
+func proxyClient(t *testing.T, proxy *PolicyProxy, tlsServer *httptest.Server) *http.Client {
+	t.Helper()
+	proxyURL, err := url.Parse(proxy.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := tlsServer.Client().Transport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+	return &http.Client{Transport: transport}
+}
+
+func startProxy(t *testing.T, policy *URLPolicy) *PolicyProxy {
+	t.Helper()
+	proxy, err := policy.StartProxy()
+	if err != nil {
+		t.Fatalf("StartProxy() = %v", err)
+	}
+	t.Cleanup(func() { proxy.Close() })
+	return proxy
+}
+
+func TestPolicyProxy(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secret"))
+	})
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+	secure := httptest.NewTLSServer(handler)
+	defer secure.Close()
+
+	// httptest servers listen on loopback, so only a policy allowing private
+	// destinations lets the proxy reach them
+	t.Run("forwards what the policy allows", func(t *testing.T) {
+		policy := newTestPolicy()
+		policy.AllowPrivate = true
+		client := proxyClient(t, startProxy(t, policy), secure)
+
+		for _, target := range []string{plain.URL, secure.URL} {
+			resp, err := client.Get(target)
+			if err != nil {
+				t.Fatalf("GET %s = %v", target, err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || string(body) != "secret" {
+				t.Errorf("GET %s = %d %q; want 200 \"secret\"", target, resp.StatusCode, body)
+			}
+		}
+	})
+
+	t.Run("refuses private addresses", func(t *testing.T) {
+		client := proxyClient(t, startProxy(t, newTestPolicy()), secure)
+
+		resp, err := client.Get(plain.URL)
+		if err != nil {
+			t.Fatalf("GET %s = %v", plain.URL, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("GET %s = %d; want 403", plain.URL, resp.StatusCode)
+		}
+
+		// A refused CONNECT fails the request itself
+		if resp, err := client.Get(secure.URL); err == nil {
+			resp.Body.Close()
+			t.Errorf("expected the tunnel to %s to be refused", secure.URL)
+		}
+	})
+
+	t.Run("refuses denied hosts", func(t *testing.T) {
+		policy := newTestPolicy()
+		policy.AllowPrivate = true
+		client := proxyClient(t, startProxy(t, policy), secure)
+
+		resp, err := client.Get("http://cdn.blocked.example/")
+		if err != nil {
+			t.Fatalf("GET = %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("GET denied host = %d; want 403", resp.StatusCode)
+		}
+	})
+
+	t.Run("limits hosts to the media allowlist", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			mediaHosts []string
+			status     int
+		}{
+			{"no media allowlist", nil, http.StatusOK},
+			{"matching media host", []string{"*.googlevideo.com", "127.0.0.1"}, http.StatusOK},
+			{"other media hosts", []string{"*.googlevideo.com"}, http.StatusForbidden},
+		}
+		for _, test := range tests {
+			policy := newTestPolicy()
+			policy.AllowPrivate = true
+			policy.AllowHosts = []string{"*.youtube.com"}
+			policy.MediaHosts = test.mediaHosts
+			client := proxyClient(t, startProxy(t, policy), secure)
+
+			resp, err := client.Get(plain.URL)
+			if err != nil {
+				t.Fatalf("%s: GET = %v", test.name, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != test.status {
+				t.Errorf("%s: GET %s = %d; want %d", test.name, plain.URL, resp.StatusCode, test.status)
+			}
+		}
+	})
+}
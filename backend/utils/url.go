@@ -6,3 +6,18 @@ func IsValidURL(rawURL string) bool {
 	parsed, err := url.ParseRequestURI(rawURL)
 	return err == nil && parsed.Scheme != "" && parsed.Host != ""
 }
+
+// RedactURL returns rawURL with its credentials, path and query replaced by
+// "...", for showing URLs such as webhook callbacks, which often carry a
+// token there, without giving the token away
+func RedactURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return "..."
+	}
+	redacted := parsed.Scheme + "://" + parsed.Host
+	if parsed.User != nil || (parsed.Path != "" && parsed.Path != "/") || parsed.RawQuery != "" || parsed.Fragment != "" {
+		redacted += "/..."
+	}
+	return redacted
+}
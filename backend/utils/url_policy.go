@@ -0,0 +1,273 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Policy error codes returned to clients when a URL is rejected
+const (
+	PolicyInvalidURL       = "invalid_url"
+	PolicySchemeNotAllowed = "scheme_not_allowed"
+	PolicyHostDenied       = "host_denied"
+	PolicyHostNotAllowed   = "host_not_allowed"
+	PolicyPrivateAddress   = "private_address"
+	PolicyUnresolvableHost = "unresolvable_host"
+)
+
+// PolicyError describes why a URL was rejected by a URLPolicy
+type PolicyError struct {
+	Code   string
+	Reason string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("URL rejected by policy: %s", e.Reason)
+}
+
+// Resolver looks up the IP addresses of a host; *net.Resolver satisfies it
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// URLPolicy decides which user-supplied URLs may be handed to yt-dlp or fetched natively
+type URLPolicy struct {
+	AllowedSchemes []string // e.g. "http", "https"
+	AllowHosts     []string // if non-empty, only matching hosts are accepted
+	MediaHosts     []string // if non-empty, the proxy also connects only to these or AllowHosts, e.g. CDN hosts
+	DenyHosts      []string // matching hosts are always rejected
+	AllowPrivate   bool     // permit loopback, private and link-local destinations
+	Resolver       Resolver
+}
+
+// NewURLPolicyFromEnv builds a URLPolicy from the URL_* environment variables
+func NewURLPolicyFromEnv() *URLPolicy {
+	schemes := splitList(os.Getenv("URL_ALLOWED_SCHEMES"))
+	if len(schemes) == 0 {
+		schemes = []string{"http", "https"}
+	}
+
+	return &URLPolicy{
+		AllowedSchemes: schemes,
+		AllowHosts:     splitList(os.Getenv("URL_ALLOWLIST")),
+		MediaHosts:     splitList(os.Getenv("URL_MEDIA_ALLOWLIST")),
+		DenyHosts:      splitList(os.Getenv("URL_DENYLIST")),
+		AllowPrivate:   os.Getenv("URL_ALLOW_PRIVATE") == "true",
+		Resolver:       net.DefaultResolver,
+	}
+}
+
+// Check validates the scheme and host of rawURL and, unless private destinations
+// are allowed, resolves the host and rejects internal addresses
+func (p *URLPolicy) Check(ctx context.Context, rawURL string) error {
+	if !IsValidURL(rawURL) {
+		return &PolicyError{Code: PolicyInvalidURL, Reason: "malformed URL"}
+	}
+
+	parsed, _ := url.Parse(rawURL)
+	if err := p.checkStatic(parsed); err != nil {
+		return err
+	}
+
+	if p.AllowPrivate {
+		return nil
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return p.CheckIP(addr)
+	}
+
+	addrs, err := p.resolver().LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return &PolicyError{Code: PolicyUnresolvableHost, Reason: fmt.Sprintf("cannot resolve host %q", host)}
+	}
+	for _, a := range addrs {
+		addr, ok := netip.AddrFromSlice(a.IP)
+		if !ok {
+			continue
+		}
+		if err := p.CheckIP(addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckIP rejects addresses in loopback, private, link-local and other
+// non-public ranges unless the policy allows private destinations
+func (p *URLPolicy) CheckIP(addr netip.Addr) error {
+	if p.AllowPrivate || !IsBlockedAddr(addr) {
+		return nil
+	}
+	return &PolicyError{Code: PolicyPrivateAddress, Reason: fmt.Sprintf("address %s is not publicly routable", addr)}
+}
+
+// HTTPClient returns an http.Client that enforces the policy on every
+// connection it dials and on every redirect it follows
+func (p *URLPolicy) HTTPClient(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = p.dialer().DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return p.checkStatic(req.URL)
+		},
+	}
+}
+
+// dialer returns a net.Dialer that checks the address of every connection
+// after the host is resolved, so DNS rebinding cannot slip past Check
+func (p *URLPolicy) dialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil {
+				return err
+			}
+			return p.CheckIP(addr)
+		},
+	}
+}
+
+// checkStatic applies the scheme and hostname rules that need no DNS lookup
+func (p *URLPolicy) checkStatic(u *url.URL) error {
+	scheme := strings.ToLower(u.Scheme)
+	if !containsFold(p.AllowedSchemes, scheme) {
+		return &PolicyError{Code: PolicySchemeNotAllowed, Reason: fmt.Sprintf("scheme %q is not allowed", scheme)}
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return &PolicyError{Code: PolicyInvalidURL, Reason: "missing host"}
+	}
+
+	if err := p.checkDenied(host); err != nil {
+		return err
+	}
+
+	if len(p.AllowHosts) > 0 && !matchAnyHost(p.AllowHosts, host) {
+		return &PolicyError{Code: PolicyHostNotAllowed, Reason: fmt.Sprintf("host %q is not in the allowlist", host)}
+	}
+
+	if !p.AllowPrivate && (host == "localhost" || strings.HasSuffix(host, ".localhost")) {
+		return &PolicyError{Code: PolicyPrivateAddress, Reason: fmt.Sprintf("host %q is local", host)}
+	}
+	return nil
+}
+
+// checkDenied rejects hosts matching DenyHosts
+func (p *URLPolicy) checkDenied(host string) error {
+	for _, pattern := range p.DenyHosts {
+		if MatchHostPattern(pattern, host) {
+			return &PolicyError{Code: PolicyHostDenied, Reason: fmt.Sprintf("host %q is denied", host)}
+		}
+	}
+	return nil
+}
+
+// matchAnyHost reports whether host matches one of patterns
+func matchAnyHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if MatchHostPattern(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *URLPolicy) resolver() Resolver {
+	if p.Resolver != nil {
+		return p.Resolver
+	}
+	return net.DefaultResolver
+}
+
+// MatchHostPattern reports whether host matches pattern. A leading "*." matches
+// the bare domain and any of its subdomains; other wildcards follow path.Match.
+func MatchHostPattern(pattern, host string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	host = strings.ToLower(host)
+	if pattern == "" {
+		return false
+	}
+
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok && !strings.ContainsAny(suffix, "*?[") {
+		return host == suffix || strings.HasSuffix(host, "."+suffix)
+	}
+
+	matched, err := path.Match(pattern, host)
+	return err == nil && matched
+}
+
+// IsBlockedAddr reports whether addr points at a loopback, private, link-local,
+// shared (CGNAT), multicast, unspecified or otherwise reserved destination
+func IsBlockedAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if addr.IsLoopback() ||
+		addr.IsPrivate() ||
+		addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() ||
+		addr.IsUnspecified() {
+		return true
+	}
+	for _, prefix := range reservedRanges {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// reservedRanges are the non-public ranges the netip predicates do not cover
+var reservedRanges = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "this network", reaches the local host on Linux
+	netip.MustParsePrefix("100.64.0.0/10"),  // shared address space (CGNAT)
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved, including broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64, which maps onto any IPv4 address
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local-use NAT64
+}
+
+// splitList splits a comma-separated environment value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
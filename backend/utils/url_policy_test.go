@@ -0,0 +1,197 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+type staticResolver map[string][]string
+
+func (r staticResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := r[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	var addrs []net.IPAddr
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, nil
+}
+
+func newTestPolicy() *URLPolicy {
+	return &URLPolicy{
+		AllowedSchemes: []string{"http", "https"},
+		DenyHosts:      []string{"*.blocked.example"},
+		Resolver: staticResolver{
+			"www.youtube.com":  {"142.250.74.110"},
+			"internal.corp":    {"10.0.0.12"},
+			"rebind.example":   {"93.184.216.34", "127.0.0.1"},
+			"metadata.example": {"169.254.169.254"},
+			"v6.example":       {"2001:4860:4860::8888"},
+			"v6local.example":  {"fd00::1"},
+		},
+	}
+}
+
+func TestURLPolicyCheck(t *testing.T) {
+	tests := []struct {
+		input string
+		code  string // empty means allowed
+	}{
+		{"https://www.youtube.com/watch?v=abc", ""},
+		{"https://v6.example/video", ""},
+		{"ftp://www.youtube.com/file", PolicySchemeNotAllowed},
+		{"file:///etc/passwd", PolicyInvalidURL},
+		{"not-a-url", PolicyInvalidURL},
+		{"http://localhost:8080/", PolicyPrivateAddress},
+		{"http://api.localhost/", PolicyPrivateAddress},
+		{"http://127.0.0.1/", PolicyPrivateAddress},
+		{"http://[::1]/", PolicyPrivateAddress},
+		{"http://[::ffff:10.0.0.1]/", PolicyPrivateAddress},
+		{"http://192.168.1.10/", PolicyPrivateAddress},
+		{"http://100.64.1.1/", PolicyPrivateAddress},
+		{"http://0.0.0.0/", PolicyPrivateAddress},
+		{"http://internal.corp/", PolicyPrivateAddress},
+		{"http://rebind.example/", PolicyPrivateAddress},
+		{"http://metadata.example/latest", PolicyPrivateAddress},
+		{"http://v6local.example/", PolicyPrivateAddress},
+		{"https://cdn.blocked.example/", PolicyHostDenied},
+		{"https://blocked.example/", PolicyHostDenied},
+		{"https://unknown.example/", PolicyUnresolvableHost},
+	}
+
+	policy := newTestPolicy()
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			err := policy.Check(context.Background(), test.input)
+			if test.code == "" {
+				if err != nil {
+					t.Fatalf("Check(%q) = %v; want nil", test.input, err)
+				}
+				return
+			}
+
+			var policyErr *PolicyError
+			if !errors.As(err, &policyErr) {
+				t.Fatalf("Check(%q) = %v; want PolicyError %s", test.input, err, test.code)
+			}
+			if policyErr.Code != test.code {
+				t.Errorf("Check(%q) code = %s; want %s", test.input, policyErr.Code, test.code)
+			}
+		})
+	}
+}
+
+func TestURLPolicyAllowlist(t *testing.T) {
+	policy := newTestPolicy()
+	policy.AllowHosts = []string{"*.youtube.com", "youtu.be"}
+
+	if err := policy.Check(context.Background(), "https://www.youtube.com/watch?v=abc"); err != nil {
+		t.Errorf("expected allowlisted host to pass, got %v", err)
+	}
+
+	err := policy.Check(context.Background(), "https://v6.example/video")
+	var policyErr *PolicyError
+	if !errors.As(err, &policyErr) || policyErr.Code != PolicyHostNotAllowed {
+		t.Errorf("expected %s, got %v", PolicyHostNotAllowed, err)
+	}
+}
+
+func TestURLPolicyAllowPrivate(t *testing.T) {
+	policy := newTestPolicy()
+	policy.AllowPrivate = true
+
+	if err := policy.Check(context.Background(), "http://127.0.0.1:9000/video"); err != nil {
+		t.Errorf("expected private address to pass when allowed, got %v", err)
+	}
+}
+
+func TestMatchHostPattern(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		host     string
+		expected bool
+	}{
+		{"youtube.com", "youtube.com", true},
+		{"youtube.com", "www.youtube.com", false},
+		{"*.youtube.com", "youtube.com", true},
+		{"*.youtube.com", "m.youtube.com", true},
+		{"*.youtube.com", "a.b.youtube.com", true},
+		{"*.youtube.com", "notyoutube.com", false},
+		{"*.YouTube.com", "WWW.youtube.COM", true},
+		{"cdn?.example.com", "cdn1.example.com", true},
+		{"cdn?.example.com", "cdn12.example.com", false},
+		{"", "example.com", false},
+	}
+
+	for _, test := range tests {
+		result := MatchHostPattern(test.pattern, test.host)
+		if result != test.expected {
+			t.Errorf("MatchHostPattern(%q, %q) = %v; want %v", test.pattern, test.host, result, test.expected)
+		}
+	}
+}
+
+func TestIsBlockedAddr(t *testing.T) {
+	tests := []struct {
+		addr     string
+		expected bool
+	}{
+		{"8.8.8.8", false},
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.0.1", true},
+		{"169.254.169.254", true},
+		{"100.64.0.1", true},
+		{"224.0.0.1", true},
+		{"::1", true},
+		{"fe80::1", true},
+		{"fc00::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"0.1.2.3", true},
+		{"192.0.0.170", true},
+		{"198.18.0.1", true},
+		{"198.19.255.255", true},
+		{"240.0.0.1", true},
+		{"255.255.255.255", true},
+		{"64:ff9b::a00:1", true},
+		{"64:ff9b::808:808", true},
+		{"198.20.0.1", false},
+		{"192.0.2.1", false},
+		{"2606:4700::1111", false},
+	}
+
+	for _, test := range tests {
+		result := IsBlockedAddr(netip.MustParseAddr(test.addr))
+		if result != test.expected {
+			t.Errorf("IsBlockedAddr(%s) = %v; want %v", test.addr, result, test.expected)
+		}
+	}
+}
+
+func TestURLPolicyHTTPClientBlocksRedirectToPrivate(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secret"))
+	}))
+	defer internal.Close()
+
+	policy := newTestPolicy()
+	client := policy.HTTPClient(0)
+
+	// httptest servers listen on loopback, so the dial itself must be refused
+	if _, err := client.Get(internal.URL); err == nil {
+		t.Fatal("expected dial to loopback to be rejected")
+	}
+
+	redirect, _ := http.NewRequest(http.MethodGet, "http://localhost/admin", nil)
+	if err := client.CheckRedirect(redirect, nil); err == nil {
+		t.Error("expected redirect to localhost to be rejected")
+	}
+}
@@ -0,0 +1,433 @@
+// Package webhooks posts job lifecycle events to HTTP endpoints: those
+// configured for the whole server and the callback URL of a single request.
+// Deliveries are signed with HMAC-SHA256 when a secret is configured, retried
+// with exponential backoff, sent to each endpoint in order and kept in a
+// delivery log.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"downloader/logging"
+	"downloader/metrics"
+	"downloader/utils"
+)
+
+// Job lifecycle events. EventCancelled covers only jobs whose client went
+// away while they were queued; jobs cut off by a shutdown, queued or running,
+// are EventInterrupted and resume on the next start.
+const (
+	EventQueued      = "job.queued"
+	EventStarted     = "job.started"
+	EventCompleted   = "job.completed"
+	EventFailed      = "job.failed"
+	EventCancelled   = "job.cancelled"
+	EventInterrupted = "job.interrupted"
+)
+
+// Events lists every event, in lifecycle order
+var Events = []string{EventQueued, EventStarted, EventCompleted, EventFailed, EventCancelled, EventInterrupted}
+
+// Delivery states
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// Request headers sent with every delivery
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// maxDeliveries is how many deliveries the log keeps
+const maxDeliveries = 500
+
+// maxRetryDelay caps the backoff between attempts
+const maxRetryDelay = time.Hour
+
+// Event is the JSON body of a delivery
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"event"`
+	CreatedAt time.Time `json:"createdAt"`
+	Data      any       `json:"data"`
+}
+
+// Delivery is one event sent to one endpoint, as the delivery log reports it
+type Delivery struct {
+	ID             string    `json:"id"`
+	EventID        string    `json:"eventId"`
+	Event          string    `json:"event"`
+	JobID          string    `json:"jobId"`
+	URL            string    `json:"url"`      // redacted, see utils.RedactURL
+	Callback       bool      `json:"callback"` // sent to the request's callbackUrl
+	Status         string    `json:"status"`
+	Attempts       int       `json:"attempts"`
+	ResponseStatus int       `json:"responseStatus,omitempty"` // of the last attempt
+	Error          string    `json:"error,omitempty"`          // of the last attempt
+	CreatedAt      time.Time `json:"createdAt"`
+	LastAttemptAt  time.Time `json:"lastAttemptAt,omitempty"`
+	NextAttemptAt  time.Time `json:"nextAttemptAt,omitempty"`
+
+	target string // the URL posted to
+}
+
+// Notifier sends events to webhook endpoints in the background
+type Notifier struct {
+	// URLs receive the events in Events
+	URLs   []string
+	Events []string
+	// Secret signs deliveries; none are signed if it is empty
+	Secret string
+	// MaxAttempts bounds the attempts per delivery; RetryDelay is the wait
+	// before the first retry, doubled for each one after
+	MaxAttempts int
+	RetryDelay  time.Duration
+	// Client posts to URLs; CallbackClient posts to callback URLs, which
+	// come from clients and so should be held to the URL policy
+	Client         *http.Client
+	CallbackClient *http.Client
+
+	// stop ends the waits between attempts; cancelling ctx ends attempts
+	stop     chan struct{}
+	stopOnce sync.Once
+	ctx      context.Context
+	cancel   context.CancelFunc
+	sending  sync.WaitGroup
+
+	mu         sync.Mutex
+	deliveries []*Delivery // oldest first
+	// queues holds the deliveries waiting for earlier ones to the same URL,
+	// by URL; a URL is present while its worker runs
+	queues map[string][]queuedDelivery
+}
+
+// queuedDelivery is a delivery waiting for its turn, with its body
+type queuedDelivery struct {
+	delivery *Delivery
+	body     []byte
+}
+
+// NewNotifier returns a notifier without endpoints, so it only sends to
+// callback URLs
+func NewNotifier() *Notifier {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Notifier{
+		Events:         Events,
+		MaxAttempts:    5,
+		RetryDelay:     2 * time.Second,
+		Client:         &http.Client{Timeout: 10 * time.Second},
+		CallbackClient: &http.Client{Timeout: 10 * time.Second},
+		stop:           make(chan struct{}),
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+}
+
+// NewNotifierFromEnv builds a notifier from the WEBHOOK_* environment
+// variables. Callback URLs are held to the URL_* policy.
+func NewNotifierFromEnv() *Notifier {
+	n := NewNotifier()
+	n.URLs = splitList(os.Getenv("WEBHOOK_URLS"))
+	if events := splitList(os.Getenv("WEBHOOK_EVENTS")); len(events) > 0 {
+		n.Events = events
+	}
+	n.Secret = os.Getenv("WEBHOOK_SECRET")
+	n.MaxAttempts = utils.EnvInt("WEBHOOK_MAX_ATTEMPTS", n.MaxAttempts)
+	n.RetryDelay = utils.EnvDuration("WEBHOOK_RETRY_DELAY", n.RetryDelay)
+	timeout := utils.EnvDuration("WEBHOOK_TIMEOUT", 10*time.Second)
+	n.Client = &http.Client{Timeout: timeout}
+	n.CallbackClient = utils.NewURLPolicyFromEnv().HTTPClient(timeout)
+	return n
+}
+
+// Default is the process-wide notifier configured by WEBHOOK_*
+var Default = NewNotifierFromEnv()
+
+// Validate checks the configured URLs and events
+func (n *Notifier) Validate() error {
+	for _, raw := range n.URLs {
+		if !utils.IsValidURL(raw) {
+			return fmt.Errorf("invalid webhook URL %q", raw)
+		}
+	}
+	for _, event := range n.Events {
+		if !slices.Contains(Events, event) {
+			return fmt.Errorf("unknown webhook event %q; use one of %v", event, Events)
+		}
+	}
+	return nil
+}
+
+// Notify sends event about job jobID with data to the URLs subscribed to
+// event and to callback, if not empty, which receives every event. It
+// returns at once; deliveries and their retries run in the background. Each
+// URL receives events in the order they were notified: a delivery waits
+// until the earlier ones to its URL were delivered or given up.
+func (n *Notifier) Notify(event, jobID, callback string, data any) {
+	var targets []string
+	if slices.Contains(n.Events, event) {
+		targets = append(targets, n.URLs...)
+	}
+	if callback != "" {
+		targets = append(targets, callback)
+	}
+	if len(targets) == 0 {
+		return
+	}
+
+	payload := Event{ID: logging.NewID(), Type: event, CreatedAt: time.Now().UTC(), Data: data}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("failed to encode webhook event", "event", event, "job_id", jobID, "error", err)
+		return
+	}
+
+	for i, target := range targets {
+		delivery := &Delivery{
+			ID:        logging.NewID(),
+			EventID:   payload.ID,
+			Event:     event,
+			JobID:     jobID,
+			URL:       utils.RedactURL(target),
+			target:    target,
+			Callback:  callback != "" && i == len(targets)-1,
+			Status:    StatusPending,
+			CreatedAt: payload.CreatedAt,
+		}
+		n.record(delivery)
+		n.enqueue(delivery, body)
+	}
+}
+
+// enqueue hands delivery to the worker of its URL, starting one if none runs
+func (n *Notifier) enqueue(delivery *Delivery, body []byte) {
+	n.sending.Add(1)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.queues == nil {
+		n.queues = make(map[string][]queuedDelivery)
+	}
+	queue, running := n.queues[delivery.target]
+	n.queues[delivery.target] = append(queue, queuedDelivery{delivery, body})
+	if !running {
+		go n.work(delivery.target)
+	}
+}
+
+// work delivers the deliveries queued for target one after the other until
+// none are left
+func (n *Notifier) work(target string) {
+	for {
+		n.mu.Lock()
+		queue := n.queues[target]
+		if len(queue) == 0 {
+			delete(n.queues, target)
+			n.mu.Unlock()
+			return
+		}
+		next := queue[0]
+		n.queues[target] = queue[1:]
+		n.mu.Unlock()
+
+		n.deliver(next.delivery, next.body)
+	}
+}
+
+// deliver posts body until the endpoint accepts it, the attempts run out or
+// the notifier shuts down
+func (n *Notifier) deliver(delivery *Delivery, body []byte) {
+	defer n.sending.Done()
+	logger := slog.Default().With("delivery_id", delivery.ID, "event", delivery.Event, "job_id", delivery.JobID, "url_host", host(delivery.target))
+	client := n.Client
+	if delivery.Callback {
+		client = n.CallbackClient
+	}
+
+	for attempt := 1; ; attempt++ {
+		status, err := n.post(client, delivery, body)
+		retry := err != nil && retryable(status) && attempt < n.MaxAttempts
+		delay := n.backoff(attempt)
+
+		n.mu.Lock()
+		delivery.Attempts = attempt
+		delivery.ResponseStatus = status
+		delivery.LastAttemptAt = time.Now().UTC()
+		delivery.NextAttemptAt = time.Time{}
+		delivery.Error = ""
+		switch {
+		case err == nil:
+			delivery.Status = StatusDelivered
+		case retry:
+			delivery.Error = err.Error()
+			delivery.NextAttemptAt = delivery.LastAttemptAt.Add(delay)
+		default:
+			delivery.Error = err.Error()
+			delivery.Status = StatusFailed
+		}
+		n.mu.Unlock()
+
+		switch {
+		case err == nil:
+			logger.Info("webhook delivered", "attempts", attempt, "status", status)
+			metrics.WebhookDeliveries.WithLabelValues(delivery.Event, StatusDelivered).Inc()
+			return
+		case !retry:
+			logger.Warn("webhook delivery failed", "attempts", attempt, "status", status, "error", err)
+			metrics.WebhookDeliveries.WithLabelValues(delivery.Event, StatusFailed).Inc()
+			return
+		}
+		logger.Debug("webhook delivery will be retried", "attempt", attempt, "status", status, "error", err, "delay", delay.String())
+
+		select {
+		case <-time.After(delay):
+		case <-n.stop:
+			n.mu.Lock()
+			delivery.Status = StatusFailed
+			delivery.NextAttemptAt = time.Time{}
+			n.mu.Unlock()
+			logger.Warn("webhook delivery abandoned by shutdown", "attempts", attempt)
+			metrics.WebhookDeliveries.WithLabelValues(delivery.Event, StatusFailed).Inc()
+			return
+		}
+	}
+}
+
+// post makes one attempt and returns the response status, if there was a
+// response, and an error unless the endpoint answered with 2xx
+func (n *Notifier) post(client *http.Client, delivery *Delivery, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(n.ctx, http.MethodPost, delivery.target, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "downloader-webhooks")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	if n.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(n.Secret, timestamp, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the X-Webhook-Signature of a delivery: "sha256=" and the hex
+// HMAC-SHA256 of its timestamp, a dot and its body
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff returns the wait after the given failed attempt
+func (n *Notifier) backoff(attempt int) time.Duration {
+	delay := n.RetryDelay
+	for i := 1; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// retryable reports whether an attempt that ended with status, 0 if there
+// was no response, is worth repeating. Other client errors would fail again.
+func retryable(status int) bool {
+	return status == 0 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+}
+
+// record adds delivery to the log, dropping the oldest beyond maxDeliveries
+func (n *Notifier) record(delivery *Delivery) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.deliveries = append(n.deliveries, delivery)
+	if len(n.deliveries) > maxDeliveries {
+		n.deliveries = slices.Delete(n.deliveries, 0, len(n.deliveries)-maxDeliveries)
+	}
+}
+
+// Deliveries returns copies of the logged deliveries, newest first, of job
+// jobID and in status if they are not empty
+func (n *Notifier) Deliveries(jobID, status string) []Delivery {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	list := make([]Delivery, 0, len(n.deliveries))
+	for i := len(n.deliveries) - 1; i >= 0; i-- {
+		delivery := n.deliveries[i]
+		if (jobID == "" || delivery.JobID == jobID) && (status == "" || delivery.Status == status) {
+			list = append(list, *delivery)
+		}
+	}
+	return list
+}
+
+// Shutdown stops retrying, recording deliveries waiting for a retry as
+// failed, and waits for attempts in progress to end. If ctx ends first, they
+// are cancelled.
+func (n *Notifier) Shutdown(ctx context.Context) error {
+	n.stopOnce.Do(func() { close(n.stop) })
+	done := make(chan struct{})
+	go func() {
+		n.sending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	n.cancel()
+	<-done
+	return ctx.Err()
+}
+
+// host returns the host of rawURL for logs, which should not carry the
+// tokens callback URLs often hold in their path or query
+func host(rawURL string) string {
+	if parsed, err := url.Parse(rawURL); err == nil {
+		return parsed.Host
+	}
+	return ""
+}
+
+// splitList splits a comma-separated setting, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,258 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver is a local webhook endpoint recording what it is sent
+type receiver struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+	statuses []int // responded in turn; 200 once they run out
+}
+
+func newReceiver(t *testing.T, statuses ...int) (*receiver, *httptest.Server) {
+	r := &receiver{statuses: statuses}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests = append(r.requests, req)
+		r.bodies = append(r.bodies, body)
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return r, server
+}
+
+func (r *receiver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+func newTestNotifier(urls ...string) *Notifier {
+	n := NewNotifier()
+	n.URLs = urls
+	n.RetryDelay = time.Millisecond
+	return n
+}
+
+func TestNotifySignsDeliveries(t *testing.T) {
+	r, server := newReceiver(t)
+	n := newTestNotifier(server.URL)
+	n.Secret = "s3cret"
+
+	n.Notify(EventCompleted, "job1", "", map[string]string{"file": "Talk.mp4"})
+	n.sending.Wait()
+
+	if r.count() != 1 {
+		t.Fatalf("expected 1 request, got %d", r.count())
+	}
+	req, body := r.requests[0], r.bodies[0]
+	if req.Header.Get(HeaderEvent) != EventCompleted || req.Header.Get(HeaderDelivery) == "" {
+		t.Errorf("unexpected headers %v", req.Header)
+	}
+	if got, want := req.Header.Get(HeaderSignature), Sign("s3cret", req.Header.Get(HeaderTimestamp), body); got != want {
+		t.Errorf("signature = %q; want %q", got, want)
+	}
+
+	var event struct {
+		ID    string            `json:"id"`
+		Event string            `json:"event"`
+		Data  map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		t.Fatalf("invalid body %s: %v", body, err)
+	}
+	if event.ID == "" || event.Event != EventCompleted || event.Data["file"] != "Talk.mp4" {
+		t.Errorf("unexpected event %s", body)
+	}
+
+	deliveries := n.Deliveries("job1", "")
+	if len(deliveries) != 1 || deliveries[0].Status != StatusDelivered || deliveries[0].Attempts != 1 || deliveries[0].EventID != event.ID {
+		t.Errorf("unexpected delivery log %+v", deliveries)
+	}
+}
+
+func TestNotifyWithoutSecret(t *testing.T) {
+	r, server := newReceiver(t)
+	n := newTestNotifier(server.URL)
+
+	n.Notify(EventQueued, "job1", "", nil)
+	n.sending.Wait()
+	if r.count() != 1 || r.requests[0].Header.Get(HeaderSignature) != "" {
+		t.Error("expected an unsigned delivery")
+	}
+}
+
+func TestNotifyRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		status   string
+		attempts int
+	}{
+		{"succeeds after server errors", []int{500, 503}, StatusDelivered, 3},
+		{"retries rate limits", []int{429}, StatusDelivered, 2},
+		{"gives up after max attempts", []int{500, 500, 500, 500}, StatusFailed, 3},
+		{"does not retry client errors", []int{404}, StatusFailed, 1},
+	}
+
+	for _, test := range tests {
+		r, server := newReceiver(t, test.statuses...)
+		n := newTestNotifier(server.URL)
+		n.MaxAttempts = 3
+
+		n.Notify(EventFailed, "job1", "", nil)
+		n.sending.Wait()
+
+		deliveries := n.Deliveries("", "")
+		if len(deliveries) != 1 {
+			t.Fatalf("%s: expected 1 delivery, got %d", test.name, len(deliveries))
+		}
+		delivery := deliveries[0]
+		if delivery.Status != test.status || delivery.Attempts != test.attempts || r.count() != test.attempts {
+			t.Errorf("%s: got %s after %d attempts (%d requests); want %s after %d", test.name, delivery.Status, delivery.Attempts, r.count(), test.status, test.attempts)
+		}
+		if test.status == StatusFailed && (delivery.Error == "" || delivery.ResponseStatus == 0) {
+			t.Errorf("%s: expected the last error recorded, got %+v", test.name, delivery)
+		}
+	}
+}
+
+func TestNotifyUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	n := newTestNotifier(server.URL)
+	n.MaxAttempts = 2
+
+	n.Notify(EventStarted, "job1", "", nil)
+	n.sending.Wait()
+	if deliveries := n.Deliveries("", StatusFailed); len(deliveries) != 1 || deliveries[0].Attempts != 2 {
+		t.Errorf("expected a failed delivery after 2 attempts, got %+v", deliveries)
+	}
+}
+
+func TestNotifyEventsAndCallback(t *testing.T) {
+	global, globalServer := newReceiver(t)
+	callback, callbackServer := newReceiver(t)
+	n := newTestNotifier(globalServer.URL)
+	n.Events = []string{EventCompleted, EventFailed}
+
+	for _, event := range []string{EventQueued, EventStarted, EventCompleted} {
+		n.Notify(event, "job1", callbackServer.URL+"/hook?token=s3cret", nil)
+	}
+	n.Notify(EventQueued, "job2", "", nil)
+	n.sending.Wait()
+
+	if global.count() != 1 || global.requests[0].Header.Get(HeaderEvent) != EventCompleted {
+		t.Errorf("expected only the completed event sent to the configured URL, got %d requests", global.count())
+	}
+	if callback.count() != 3 {
+		t.Errorf("expected every event sent to the callback, got %d", callback.count())
+	}
+
+	deliveries := n.Deliveries("job1", "")
+	if len(deliveries) != 4 {
+		t.Fatalf("expected 4 deliveries for job1, got %d", len(deliveries))
+	}
+	if !deliveries[0].Callback || deliveries[0].Event != EventCompleted || deliveries[1].Callback {
+		t.Errorf("expected the newest deliveries first, callbacks marked, got %+v", deliveries[:2])
+	}
+	if deliveries[0].URL != callbackServer.URL+"/..." {
+		t.Errorf("expected the callback URL redacted in the log, got %q", deliveries[0].URL)
+	}
+	if len(n.Deliveries("job2", "")) != 0 {
+		t.Error("expected no delivery for an event nobody subscribed to")
+	}
+}
+
+func TestNotifyKeepsOrderPerURL(t *testing.T) {
+	// The first event needs a retry, which the later ones wait for
+	r, server := newReceiver(t, 500)
+	n := newTestNotifier(server.URL)
+
+	events := []string{EventQueued, EventStarted, EventCompleted}
+	for _, event := range events {
+		n.Notify(event, "job1", "", nil)
+	}
+	n.sending.Wait()
+
+	var received []string
+	for _, req := range r.requests {
+		received = append(received, req.Header.Get(HeaderEvent))
+	}
+	want := append([]string{EventQueued}, events...)
+	if !slices.Equal(received, want) {
+		t.Errorf("received %v; want %v", received, want)
+	}
+}
+
+func TestShutdownAbandonsRetries(t *testing.T) {
+	_, server := newReceiver(t, 500, 500, 500)
+	n := newTestNotifier(server.URL)
+	n.RetryDelay = time.Hour
+
+	n.Notify(EventCompleted, "job1", "", nil)
+	deadline := time.Now().Add(time.Second)
+	for len(n.Deliveries("", "")) == 0 || n.Deliveries("", "")[0].Attempts == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("first attempt was not made")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := n.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+	if deliveries := n.Deliveries("", ""); deliveries[0].Status != StatusFailed || !deliveries[0].NextAttemptAt.IsZero() {
+		t.Errorf("expected the waiting retry given up, got %+v", deliveries[0])
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	n := NewNotifier()
+	n.RetryDelay = time.Second
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 20: maxRetryDelay, 100: maxRetryDelay} {
+		if got := n.backoff(attempt); got != want {
+			t.Errorf("backoff(%d) = %v; want %v", attempt, got, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		urls   []string
+		events []string
+		valid  bool
+	}{
+		{[]string{"https://hooks.example.com/downloads"}, Events, true},
+		{nil, []string{EventCompleted}, true},
+		{[]string{"hooks.example.com"}, Events, false},
+		{nil, []string{"job.done"}, false},
+	}
+
+	for _, test := range tests {
+		n := NewNotifier()
+		n.URLs, n.Events = test.urls, test.events
+		if err := n.Validate(); (err == nil) != test.valid {
+			t.Errorf("Validate(%v, %v) = %v; want valid %v", test.urls, test.events, err, test.valid)
+		}
+	}
+}
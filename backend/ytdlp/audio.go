@@ -0,0 +1,92 @@
+package ytdlp
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// audioCodec returns the requested codec, defaulting to mp3
+func (o Options) audioCodec() string {
+	if o.AudioCodec == "" {
+		return DefaultAudioCodec
+	}
+	return strings.ToLower(o.AudioCodec)
+}
+
+func (o Options) validateAudio() error {
+	if o.Format != "audio" {
+		if o.AudioCodec != "" || o.AudioBitrate != "" || o.AudioQuality != nil || o.SampleRate != 0 || o.Channels != 0 {
+			return errors.New("Audio options are only available for audio downloads")
+		}
+		return nil
+	}
+
+	codec, ok := FindAudioCodec(o.audioCodec())
+	if !ok {
+		return fmt.Errorf("Invalid audioCodec %q. See GET /capabilities", o.AudioCodec)
+	}
+	if codec.Name == CodecOriginal {
+		if o.AudioBitrate != "" || o.AudioQuality != nil || o.SampleRate != 0 || o.Channels != 0 {
+			return errors.New("audioCodec 'original' keeps the source stream and takes no other audio options")
+		}
+		return nil
+	}
+
+	if o.AudioBitrate != "" && o.AudioQuality != nil {
+		return errors.New("Choose either audioBitrate or audioQuality, not both")
+	}
+	if o.AudioBitrate != "" && !slices.Contains(codec.Bitrates, strings.ToUpper(o.AudioBitrate)) {
+		return fmt.Errorf("Invalid audioBitrate %q for %s. See GET /capabilities", o.AudioBitrate, codec.Name)
+	}
+	if o.AudioQuality != nil {
+		if !codec.VBR {
+			return fmt.Errorf("%s is lossless and takes no audioQuality", codec.Name)
+		}
+		if *o.AudioQuality < BestAudioQuality || *o.AudioQuality > WorstAudioQuality {
+			return fmt.Errorf("audioQuality must be between %d (best) and %d", BestAudioQuality, WorstAudioQuality)
+		}
+	}
+	if o.SampleRate != 0 && !slices.Contains(codec.SampleRates, o.SampleRate) {
+		return fmt.Errorf("Invalid sampleRate %d for %s. See GET /capabilities", o.SampleRate, codec.Name)
+	}
+	if o.Channels != 0 && !slices.Contains(AudioChannels, o.Channels) {
+		return fmt.Errorf("Invalid channels %d. Choose 1 (mono) or 2 (stereo)", o.Channels)
+	}
+	return nil
+}
+
+// audioArgs returns the yt-dlp arguments extracting audio as requested
+func (o Options) audioArgs() []string {
+	args := []string{"-f", o.AudioSelector(), "--extract-audio"}
+
+	codec, _ := FindAudioCodec(o.audioCodec())
+	if codec.Name == CodecOriginal {
+		// yt-dlp's "best" audio format remuxes the stream without re-encoding
+		return args
+	}
+	args = append(args, "--audio-format", codec.Name)
+
+	switch {
+	case o.AudioBitrate != "":
+		args = append(args, "--audio-quality", strings.ToUpper(o.AudioBitrate))
+	case o.AudioQuality != nil:
+		args = append(args, "--audio-quality", strconv.Itoa(*o.AudioQuality))
+	case !codec.Lossless:
+		args = append(args, "--audio-quality", DefaultAudioBitrate)
+	}
+
+	var ffmpegArgs []string
+	if o.SampleRate != 0 {
+		ffmpegArgs = append(ffmpegArgs, "-ar", strconv.Itoa(o.SampleRate))
+	}
+	if o.Channels != 0 {
+		ffmpegArgs = append(ffmpegArgs, "-ac", strconv.Itoa(o.Channels))
+	}
+	if len(ffmpegArgs) > 0 {
+		args = append(args, "--postprocessor-args", "ExtractAudio:"+strings.Join(ffmpegArgs, " "))
+	}
+	return args
+}
@@ -0,0 +1,187 @@
+package ytdlp
+
+import (
+	"downloader/ffmpeg"
+	"downloader/sponsorblock"
+	"downloader/subtitles"
+)
+
+// AudioCodec describes an audio output codec and the settings it accepts
+type AudioCodec struct {
+	Name        string   `json:"name"`
+	Extension   string   `json:"extension"`
+	Lossless    bool     `json:"lossless"`
+	Bitrates    []string `json:"bitrates,omitempty"` // constant bitrates for audioBitrate
+	VBR         bool     `json:"vbr"`                // accepts audioQuality
+	SampleRates []int    `json:"sampleRates,omitempty"`
+}
+
+// CodecOriginal keeps the source audio stream as downloaded, without re-encoding
+const CodecOriginal = "original"
+
+var (
+	lossyBitrates = []string{"64K", "96K", "128K", "160K", "192K", "256K", "320K"}
+	opusBitrates  = []string{"32K", "64K", "96K", "128K", "160K", "192K", "256K"}
+
+	mp3SampleRates      = []int{8000, 11025, 16000, 22050, 32000, 44100, 48000}
+	opusSampleRates     = []int{8000, 12000, 16000, 24000, 48000}
+	standardSampleRates = []int{8000, 11025, 16000, 22050, 32000, 44100, 48000, 88200, 96000}
+	losslessSampleRates = []int{8000, 11025, 16000, 22050, 32000, 44100, 48000, 88200, 96000, 176400, 192000}
+)
+
+// AudioCodecs are the audio codecs accepted as audioCodec
+var AudioCodecs = []AudioCodec{
+	{Name: "mp3", Extension: "mp3", Bitrates: lossyBitrates, VBR: true, SampleRates: mp3SampleRates},
+	{Name: "aac", Extension: "aac", Bitrates: lossyBitrates, VBR: true, SampleRates: standardSampleRates},
+	{Name: "m4a", Extension: "m4a", Bitrates: lossyBitrates, VBR: true, SampleRates: standardSampleRates},
+	{Name: "opus", Extension: "opus", Bitrates: opusBitrates, VBR: true, SampleRates: opusSampleRates},
+	{Name: "vorbis", Extension: "ogg", Bitrates: lossyBitrates, VBR: true, SampleRates: standardSampleRates},
+	{Name: "flac", Extension: "flac", Lossless: true, SampleRates: losslessSampleRates},
+	{Name: "wav", Extension: "wav", Lossless: true, SampleRates: losslessSampleRates},
+	{Name: CodecOriginal, Extension: "", Lossless: true},
+}
+
+// DefaultAudioCodec and DefaultAudioBitrate apply when a request sets neither
+const (
+	DefaultAudioCodec   = "mp3"
+	DefaultAudioBitrate = "192K"
+)
+
+// AudioChannels are the accepted channel counts
+var AudioChannels = []int{1, 2}
+
+// Range of audioQuality, yt-dlp's VBR scale: 0 is best, 10 is smallest
+const (
+	BestAudioQuality  = 0
+	WorstAudioQuality = 10
+)
+
+// Capabilities lists the options a download request may choose from. It is
+// served at GET /capabilities and is what Validate checks requests against.
+type Capabilities struct {
+	Formats      []string            `json:"formats"`
+	Video        VideoCapabilities   `json:"video"`
+	Audio        AudioCapabilities   `json:"audio"`
+	Subtitles    SubtitleOptions     `json:"subtitles"`
+	Cuts         []string            `json:"cuts"`
+	Loudness     LoudnessOptions     `json:"loudness"`
+	SponsorBlock SponsorBlockOptions `json:"sponsorBlock"`
+	Duplicates   []string            `json:"duplicatePolicies"`
+	Naming       NamingOptions       `json:"naming"`
+}
+
+// NamingOptions describes the naming templates requests may set
+type NamingOptions struct {
+	Default   string   `json:"default"`
+	Fields    []string `json:"fields"`
+	Rules     string   `json:"rules"`
+	MaxLength int      `json:"maxLength"`
+}
+
+// SponsorBlockOptions are the categories sponsorBlockMark and
+// sponsorBlockRemove accept, besides "all"
+type SponsorBlockOptions struct {
+	Categories  []string `json:"categories"`
+	Unremovable []string `json:"unremovable"` // may only be marked
+}
+
+// LoudnessOptions are the defaults and ranges for loudness normalization
+type LoudnessOptions struct {
+	Default         ffmpeg.LoudnessTarget `json:"default"`
+	TargetLUFSRange [2]float64            `json:"targetLufsRange"`
+	TruePeakRange   [2]float64            `json:"truePeakRange"`
+}
+
+// VideoCapabilities are the choices for video downloads
+type VideoCapabilities struct {
+	Containers    []string        `json:"containers"`
+	Presets       []ffmpeg.Preset `json:"presets"`
+	Resolutions   []Resolution    `json:"resolutions"`
+	Codecs        []string        `json:"codecs"`
+	DynamicRanges []string        `json:"dynamicRanges"`
+	MaxFPS        int             `json:"maxFps"`
+}
+
+// AudioCapabilities are the choices for audio downloads
+type AudioCapabilities struct {
+	Codecs         []AudioCodec `json:"codecs"`
+	DefaultCodec   string       `json:"defaultCodec"`
+	DefaultBitrate string       `json:"defaultBitrate"`
+	Quality        [2]int       `json:"quality"` // [best, worst] for audioQuality
+	Channels       []int        `json:"channels"`
+}
+
+// SubtitleOptions are the choices for subtitle downloads
+type SubtitleOptions struct {
+	Sources []string `json:"sources"`
+	Formats []string `json:"formats"`
+}
+
+// Supported returns the capabilities of this server
+func Supported() Capabilities {
+	return Capabilities{
+		Formats: []string{"video", "audio"},
+		Video: VideoCapabilities{
+			Containers:    containerNames(),
+			Presets:       ffmpeg.Presets,
+			Resolutions:   Resolutions,
+			Codecs:        videoCodecNames(),
+			DynamicRanges: DynamicRanges,
+			MaxFPS:        MaxFPSLimit,
+		},
+		Audio: AudioCapabilities{
+			Codecs:         AudioCodecs,
+			DefaultCodec:   DefaultAudioCodec,
+			DefaultBitrate: DefaultAudioBitrate,
+			Quality:        [2]int{BestAudioQuality, WorstAudioQuality},
+			Channels:       AudioChannels,
+		},
+		Subtitles: SubtitleOptions{
+			Sources: []string{"manual", "auto", "both"},
+			Formats: []string{subtitles.FormatVTT, subtitles.FormatSRT, subtitles.FormatASS},
+		},
+		Cuts: []string{"fast", "accurate"},
+		Loudness: LoudnessOptions{
+			Default:         ffmpeg.DefaultLoudnessTarget,
+			TargetLUFSRange: [2]float64{ffmpeg.MinIntegrated, ffmpeg.MaxIntegrated},
+			TruePeakRange:   [2]float64{ffmpeg.MinTruePeak, ffmpeg.MaxTruePeak},
+		},
+		SponsorBlock: SponsorBlockOptions{
+			Categories:  sponsorblock.Categories,
+			Unremovable: sponsorblock.Unremovable,
+		},
+		Duplicates: DuplicatePolicies,
+		Naming: NamingOptions{
+			Default:   NamingTemplate(),
+			Fields:    NamingFields(),
+			Rules:     FilenameRules(),
+			MaxLength: FilenameMaxLength(),
+		},
+	}
+}
+
+// FindAudioCodec returns the codec named name
+func FindAudioCodec(name string) (AudioCodec, bool) {
+	for _, codec := range AudioCodecs {
+		if codec.Name == name {
+			return codec, true
+		}
+	}
+	return AudioCodec{}, false
+}
+
+func videoCodecNames() []string {
+	names := make([]string, len(VideoCodecs))
+	for i, codec := range VideoCodecs {
+		names[i] = codec.Name
+	}
+	return names
+}
+
+func containerNames() []string {
+	names := []string{"best"}
+	for _, c := range ffmpeg.Containers {
+		names = append(names, c.Name)
+	}
+	return names
+}
@@ -0,0 +1,211 @@
+package ytdlp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrRange marks a start/end pair that cannot be turned into a clip
+var ErrRange = errors.New("invalid clip range")
+
+// Range is a time range of the media. An End of zero means until the end.
+type Range struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// maxChapterName bounds start/end values that are not timestamps
+const maxChapterName = 200
+
+// Clipped reports whether only part of the media is requested
+func (o Options) Clipped() bool {
+	return o.Start != "" || o.End != ""
+}
+
+// AccurateCut reports whether clips are cut exactly at the requested times,
+// re-encoding around the cut points, rather than at the nearest keyframes
+func (o Options) AccurateCut() bool {
+	return o.Cut == "accurate"
+}
+
+// NeedsChapters reports whether start or end names a chapter, so the media's
+// chapter list is needed to resolve the range
+func (o Options) NeedsChapters() bool {
+	for _, value := range []string{o.Start, o.End} {
+		if _, ok := ParseTimestamp(value); value != "" && !ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (o Options) validateClip() error {
+	switch o.Cut {
+	case "", "fast", "accurate":
+	default:
+		return errors.New("Invalid cut. Choose 'fast' or 'accurate'")
+	}
+	if len(o.Start) > maxChapterName || len(o.End) > maxChapterName {
+		return errors.New("start and end must be timestamps or chapter names")
+	}
+
+	start, startOK := ParseTimestamp(o.Start)
+	end, endOK := ParseTimestamp(o.End)
+	if startOK && endOK && o.End != "" && end <= start {
+		return errors.New("end must be after start")
+	}
+	return nil
+}
+
+// ClipRange resolves start and end against chapters. A chapter name as start
+// begins the clip at that chapter; as end it finishes the clip at the end of
+// that chapter. A chapter name given alone selects just that chapter.
+func (o Options) ClipRange(chapters []Chapter) (Range, error) {
+	var r Range
+
+	if o.Start != "" {
+		if ts, ok := ParseTimestamp(o.Start); ok {
+			r.Start = ts
+		} else {
+			chapter, err := findChapter(chapters, o.Start)
+			if err != nil {
+				return r, err
+			}
+			r.Start = seconds(chapter.StartTime)
+			if o.End == "" {
+				r.End = seconds(chapter.EndTime)
+			}
+		}
+	}
+
+	if o.End != "" {
+		if ts, ok := ParseTimestamp(o.End); ok {
+			r.End = ts
+		} else {
+			chapter, err := findChapter(chapters, o.End)
+			if err != nil {
+				return r, err
+			}
+			r.End = seconds(chapter.EndTime)
+		}
+	}
+
+	if r.End != 0 && r.End <= r.Start {
+		return r, fmt.Errorf("%w: end must be after start", ErrRange)
+	}
+	return r, nil
+}
+
+// findChapter matches name against chapter titles, case-insensitively:
+// an exact title first, otherwise the only title containing name
+func findChapter(chapters []Chapter, name string) (Chapter, error) {
+	if len(chapters) == 0 {
+		return Chapter{}, fmt.Errorf("%w: %q is not a timestamp and the media has no chapters", ErrRange, name)
+	}
+
+	var partial []Chapter
+	for _, chapter := range chapters {
+		if strings.EqualFold(chapter.Title, name) {
+			return chapter, nil
+		}
+		if strings.Contains(strings.ToLower(chapter.Title), strings.ToLower(name)) {
+			partial = append(partial, chapter)
+		}
+	}
+	switch len(partial) {
+	case 1:
+		return partial[0], nil
+	case 0:
+		return Chapter{}, fmt.Errorf("%w: no chapter named %q", ErrRange, name)
+	default:
+		return Chapter{}, fmt.Errorf("%w: %q matches %d chapters", ErrRange, name, len(partial))
+	}
+}
+
+// SectionArgs returns the yt-dlp arguments downloading only this range
+func (r Range) SectionArgs(accurate bool) []string {
+	end := "inf"
+	if r.End > 0 {
+		end = formatSeconds(r.End)
+	}
+	args := []string{"--download-sections", "*" + formatSeconds(r.Start) + "-" + end}
+	if accurate {
+		args = append(args, "--force-keyframes-at-cuts")
+	}
+	return args
+}
+
+// Label describes the range for file names, e.g. "1m30s-2m00s" or "1h05m00s-end"
+func (r Range) Label() string {
+	end := "end"
+	if r.End > 0 {
+		end = labelTime(r.End)
+	}
+	return labelTime(r.Start) + "-" + end
+}
+
+func labelTime(d time.Duration) string {
+	h := int(d / time.Hour)
+	m := int(d % time.Hour / time.Minute)
+	s := strconv.Itoa(int(d % time.Minute / time.Second))
+	if ms := d % time.Second / time.Millisecond; ms > 0 {
+		s += strings.TrimRight(fmt.Sprintf(".%03d", ms), "0")
+	}
+	switch {
+	case h > 0:
+		return fmt.Sprintf("%dh%02dm%ss", h, m, pad(s))
+	case m > 0:
+		return fmt.Sprintf("%dm%ss", m, pad(s))
+	default:
+		return s + "s"
+	}
+}
+
+// pad zero-pads the whole seconds of s to two digits
+func pad(s string) string {
+	if whole, _, _ := strings.Cut(s, "."); len(whole) < 2 {
+		return "0" + s
+	}
+	return s
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// ParseTimestamp accepts seconds ("90", "90.5") and clock times ("1:30",
+// "01:02:03.250"). It reports false for anything else, e.g. a chapter name.
+func ParseTimestamp(value string) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, true
+	}
+
+	parts := strings.Split(value, ":")
+	if len(parts) > 3 {
+		return 0, false
+	}
+	var total float64
+	for i, part := range parts {
+		last := i == len(parts)-1
+		if part == "" || strings.HasPrefix(part, "-") || strings.HasPrefix(part, "+") {
+			return 0, false
+		}
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || (!last && strings.Contains(part, ".")) {
+			return 0, false
+		}
+		if i > 0 && n >= 60 {
+			return 0, false
+		}
+		total = total*60 + n
+	}
+	return time.Duration(total * float64(time.Second)).Round(time.Millisecond), true
+}
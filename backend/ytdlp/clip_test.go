@@ -0,0 +1,125 @@
+package ytdlp
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"", 0, true},
+		{"90", 90 * time.Second, true},
+		{"90.5", 90*time.Second + 500*time.Millisecond, true},
+		{"1:30", 90 * time.Second, true},
+		{"01:02:03.250", time.Hour + 2*time.Minute + 3*time.Second + 250*time.Millisecond, true},
+		{"1:75", 0, false},
+		{"1.5:30", 0, false},
+		{"-5", 0, false},
+		{"1:2:3:4", 0, false},
+		{"Intro", 0, false},
+		{"Part 2", 0, false},
+	}
+
+	for _, test := range tests {
+		result, ok := ParseTimestamp(test.input)
+		if ok != test.ok || result != test.expected {
+			t.Errorf("ParseTimestamp(%q) = %v, %v; want %v, %v", test.input, result, ok, test.expected, test.ok)
+		}
+	}
+}
+
+func TestValidateClip(t *testing.T) {
+	tests := []struct {
+		opts  Options
+		valid bool
+	}{
+		{Options{Format: "video", Start: "1:30", End: "2:00"}, true},
+		{Options{Format: "video", Start: "1:30"}, true},
+		{Options{Format: "video", End: "45", Cut: "accurate"}, true},
+		{Options{Format: "video", Start: "Intro", End: "0:30"}, true},
+		{Options{Format: "video", Start: "2:00", End: "1:30"}, false},
+		{Options{Format: "video", Start: "2:00", End: "2:00"}, false},
+		{Options{Format: "video", Start: "1:00", Cut: "smart"}, false},
+		{Options{Format: "video", Start: strings.Repeat("x", 201)}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.opts.Validate(); (err == nil) != test.valid {
+			t.Errorf("Validate(%+v) = %v; want valid=%v", test.opts, err, test.valid)
+		}
+	}
+}
+
+func TestClipRange(t *testing.T) {
+	chapters := []Chapter{
+		{Title: "Intro", StartTime: 0, EndTime: 60},
+		{Title: "Part 1: Setup", StartTime: 60, EndTime: 600},
+		{Title: "Part 2: Results", StartTime: 600, EndTime: 1200.5},
+	}
+
+	tests := []struct {
+		name     string
+		opts     Options
+		expected Range
+		err      bool
+	}{
+		{"timestamps", Options{Start: "1:30", End: "2:00"}, Range{90 * time.Second, 120 * time.Second}, false},
+		{"open end", Options{Start: "1:30"}, Range{90 * time.Second, 0}, false},
+		{"single chapter", Options{Start: "intro"}, Range{0, time.Minute}, false},
+		{"chapter span", Options{Start: "Setup", End: "Results"}, Range{time.Minute, 1200*time.Second + 500*time.Millisecond}, false},
+		{"chapter to timestamp", Options{Start: "Part 2: Results", End: "15:00"}, Range{10 * time.Minute, 15 * time.Minute}, false},
+		{"ambiguous chapter", Options{Start: "Part"}, Range{}, true},
+		{"unknown chapter", Options{Start: "Outro"}, Range{}, true},
+		{"end before start", Options{Start: "Results", End: "Intro"}, Range{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.opts.ClipRange(chapters)
+			if tt.err {
+				if !errors.Is(err, ErrRange) {
+					t.Fatalf("expected ErrRange, got %v", err)
+				}
+				return
+			}
+			if err != nil || result != tt.expected {
+				t.Errorf("ClipRange() = %+v, %v; want %+v", result, err, tt.expected)
+			}
+		})
+	}
+
+	if _, err := (Options{Start: "Intro"}).ClipRange(nil); !errors.Is(err, ErrRange) {
+		t.Errorf("expected ErrRange without chapters, got %v", err)
+	}
+}
+
+func TestRangeLabelAndSections(t *testing.T) {
+	tests := []struct {
+		r        Range
+		label    string
+		sections string
+	}{
+		{Range{90 * time.Second, 120 * time.Second}, "1m30s-2m00s", "--download-sections *90-120"},
+		{Range{5500 * time.Millisecond, 0}, "5.5s-end", "--download-sections *5.5-inf"},
+		{Range{time.Hour + 5*time.Second, time.Hour + 35*time.Second}, "1h00m05s-1h00m35s", "--download-sections *3605-3635"},
+	}
+
+	for _, test := range tests {
+		if label := test.r.Label(); label != test.label {
+			t.Errorf("Label(%+v) = %q; want %q", test.r, label, test.label)
+		}
+		if args := strings.Join(test.r.SectionArgs(false), " "); args != test.sections {
+			t.Errorf("SectionArgs(%+v) = %q; want %q", test.r, args, test.sections)
+		}
+	}
+
+	if args := strings.Join(tests[0].r.SectionArgs(true), " "); !strings.HasSuffix(args, "--force-keyframes-at-cuts") {
+		t.Errorf("expected accurate cut to force keyframes, got %q", args)
+	}
+}
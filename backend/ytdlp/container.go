@@ -0,0 +1,56 @@
+package ytdlp
+
+import (
+	"errors"
+	"fmt"
+
+	"downloader/ffmpeg"
+)
+
+func (o Options) validateContainer() error {
+	if o.Format != "video" {
+		// Clients send their container selection with audio downloads too
+		if o.Preset != "" {
+			return errors.New("preset is only available for video downloads")
+		}
+		return nil
+	}
+
+	switch o.VideoFormat {
+	case "", "best":
+	default:
+		if _, ok := ffmpeg.FindContainer(o.VideoFormat); !ok {
+			return fmt.Errorf("Invalid videoFormat %q. See GET /capabilities", o.VideoFormat)
+		}
+	}
+	if o.Preset != "" && !validPreset(o.Preset) {
+		return fmt.Errorf("Invalid preset %q. See GET /capabilities", o.Preset)
+	}
+	return nil
+}
+
+func validPreset(name string) bool {
+	for _, p := range ffmpeg.Presets {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// TranscodeTarget returns the container the transcoding stage guarantees, or
+// "" when yt-dlp's output is kept as it is. A preset without a container
+// produces mp4.
+func (o Options) TranscodeTarget() string {
+	if o.Format != "video" {
+		return ""
+	}
+	switch o.VideoFormat {
+	case "", "best":
+		if o.Preset != "" {
+			return "mp4"
+		}
+		return ""
+	}
+	return o.VideoFormat
+}
@@ -0,0 +1,43 @@
+package ytdlp
+
+import "testing"
+
+func TestValidateContainer(t *testing.T) {
+	tests := []struct {
+		opts  Options
+		valid bool
+	}{
+		{Options{Format: "video", VideoFormat: "mov"}, true},
+		{Options{Format: "video", VideoFormat: "3gp", Preset: "small"}, true},
+		{Options{Format: "video", VideoFormat: "best", Preset: "archive"}, true},
+		{Options{Format: "video", VideoFormat: "wmv"}, false},
+		{Options{Format: "video", Preset: "tiny"}, false},
+		{Options{Format: "audio", VideoFormat: "mp4"}, true},
+		{Options{Format: "audio", Preset: "small"}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.opts.Validate(); (err == nil) != test.valid {
+			t.Errorf("Validate(%+v) = %v; want valid=%v", test.opts, err, test.valid)
+		}
+	}
+}
+
+func TestTranscodeTarget(t *testing.T) {
+	tests := []struct {
+		opts Options
+		want string
+	}{
+		{Options{Format: "video"}, ""},
+		{Options{Format: "video", VideoFormat: "best"}, ""},
+		{Options{Format: "video", Preset: "compatible"}, "mp4"},
+		{Options{Format: "video", VideoFormat: "avi"}, "avi"},
+		{Options{Format: "audio", VideoFormat: "mp4"}, ""},
+	}
+
+	for _, test := range tests {
+		if got := test.opts.TranscodeTarget(); got != test.want {
+			t.Errorf("TranscodeTarget(%+v) = %q; want %q", test.opts, got, test.want)
+		}
+	}
+}
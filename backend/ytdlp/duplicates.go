@@ -0,0 +1,43 @@
+package ytdlp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+// What to do when the requested video was downloaded before
+const (
+	DuplicateReuse      = "reuse"      // return the existing files without downloading (default)
+	DuplicateRedownload = "redownload" // download again, overwriting the existing files
+	DuplicateCopy       = "copy"       // download again into files with new names
+)
+
+// DuplicatePolicies lists the accepted duplicatePolicy values
+var DuplicatePolicies = []string{DuplicateReuse, DuplicateRedownload, DuplicateCopy}
+
+// Duplicates returns the duplicate policy, defaulting to reuse
+func (o Options) Duplicates() string {
+	if o.DuplicatePolicy == "" {
+		return DuplicateReuse
+	}
+	return o.DuplicatePolicy
+}
+
+func (o Options) validateDuplicates() error {
+	switch o.DuplicatePolicy {
+	case "", DuplicateReuse, DuplicateRedownload, DuplicateCopy:
+		return nil
+	}
+	return errors.New("Invalid duplicatePolicy. Choose 'reuse', 'redownload' or 'copy'")
+}
+
+// SameDownload reports whether o and other produce the same files, ignoring
+// the duplicate policy. They are compared as they are saved with a file's
+// source, so an empty list equals a missing one.
+func (o Options) SameDownload(other Options) bool {
+	o.DuplicatePolicy, other.DuplicatePolicy = "", ""
+	a, errA := json.Marshal(o)
+	b, errB := json.Marshal(other)
+	return errA == nil && errB == nil && bytes.Equal(a, b)
+}
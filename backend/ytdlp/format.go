@@ -0,0 +1,187 @@
+package ytdlp
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// VideoCodec is a preferable video codec and the yt-dlp filter matching it
+type VideoCodec struct {
+	Name   string `json:"name"`
+	filter string
+}
+
+// VideoCodecs are the codecs accepted as videoCodec
+var VideoCodecs = []VideoCodec{
+	{Name: "av1", filter: "[vcodec^=av01]"},
+	{Name: "vp9", filter: "[vcodec~='^vp0?9']"},
+	{Name: "h264", filter: "[vcodec~='^(avc1|h264)']"},
+	{Name: "hevc", filter: "[vcodec~='^(hvc1|hev1|h265|hevc)']"},
+}
+
+// Resolution is an accepted resolution and its maximum height
+type Resolution struct {
+	Name   string `json:"name"`
+	Height int    `json:"height"`
+}
+
+// Resolutions are the values accepted as resolution
+var Resolutions = []Resolution{
+	{"144", 144}, {"240", 240}, {"360", 360}, {"480", 480}, {"720", 720}, {"1080", 1080},
+	{"1440", 1440}, {"2160", 2160}, {"4320", 4320},
+	{"2k", 1440}, {"4k", 2160}, {"8k", 4320},
+}
+
+// maxHeight returns the height limit for resolution
+func maxHeight(resolution string) (int, bool) {
+	for _, r := range Resolutions {
+		if strings.EqualFold(r.Name, resolution) {
+			return r.Height, true
+		}
+	}
+	return 0, false
+}
+
+// DynamicRanges are the accepted dynamicRange values
+var DynamicRanges = []string{"sdr", "hdr"}
+
+// MaxFPSLimit bounds maxFps
+const MaxFPSLimit = 240
+
+var (
+	filesizePattern = regexp.MustCompile(`^[1-9][0-9]{0,5}[KMG]$`)
+	languagePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{1,8})*$`)
+)
+
+func (o Options) validateVideoSelection() error {
+	if o.Resolution != "" {
+		if _, ok := maxHeight(o.Resolution); !ok {
+			return fmt.Errorf("Invalid resolution %q. See GET /capabilities", o.Resolution)
+		}
+	}
+	if o.VideoCodec != "" {
+		if _, ok := findVideoCodec(o.VideoCodec); !ok {
+			return fmt.Errorf("Invalid videoCodec %q. Choose 'av1', 'vp9', 'h264' or 'hevc'", o.VideoCodec)
+		}
+	}
+	if o.MaxFPS < 0 || o.MaxFPS > MaxFPSLimit {
+		return fmt.Errorf("maxFps must be between 1 and %d", MaxFPSLimit)
+	}
+	if o.DynamicRange != "" && !slices.Contains(DynamicRanges, strings.ToLower(o.DynamicRange)) {
+		return errors.New("Invalid dynamicRange. Choose 'sdr' or 'hdr'")
+	}
+	if o.MaxFilesize != "" && !filesizePattern.MatchString(strings.ToUpper(o.MaxFilesize)) {
+		return errors.New("Invalid maxFilesize. Use a size such as '500M' or '2G'")
+	}
+	if o.AudioLanguage != "" && !languagePattern.MatchString(o.AudioLanguage) {
+		return fmt.Errorf("Invalid audioLanguage %q. Use a language code such as 'en' or 'pt-BR'", o.AudioLanguage)
+	}
+	// Video options on audio downloads are ignored: clients send their
+	// resolution and container selection regardless of format
+	return nil
+}
+
+func findVideoCodec(name string) (VideoCodec, bool) {
+	for _, codec := range VideoCodecs {
+		if strings.EqualFold(codec.Name, name) {
+			return codec, true
+		}
+	}
+	return VideoCodec{}, false
+}
+
+// preference is an optional filter for the video stream, the audio stream and
+// a format carrying both. Unmet preferences are dropped one at a time.
+type preference struct {
+	video, audio, combined string
+}
+
+// VideoSelector returns the yt-dlp -f expression for a video download.
+//
+// Limits (resolution, maxFps, maxFilesize) apply to every alternative.
+// Preferences are relaxed from the least important: audio container, video
+// container, codec, dynamic range and finally audio language. Every separate
+// video+audio alternative is tried before falling back to a single format
+// carrying both, which some sites only offer.
+func (o Options) VideoSelector() string {
+	limits := o.limitFilters()
+
+	var prefs []preference
+	if o.AudioLanguage != "" {
+		lang := "[language^=" + o.AudioLanguage + "]"
+		prefs = append(prefs, preference{audio: lang, combined: lang})
+	}
+	switch strings.ToLower(o.DynamicRange) {
+	case "hdr":
+		prefs = append(prefs, preference{video: "[dynamic_range!=SDR]", combined: "[dynamic_range!=SDR]"})
+	case "sdr":
+		prefs = append(prefs, preference{video: "[dynamic_range=?SDR]", combined: "[dynamic_range=?SDR]"})
+	}
+	if codec, ok := findVideoCodec(o.VideoCodec); ok {
+		prefs = append(prefs, preference{video: codec.filter, combined: codec.filter})
+	}
+	switch o.VideoFormat {
+	case "mp4":
+		prefs = append(prefs,
+			preference{video: "[ext=mp4]", combined: "[ext=mp4]"},
+			preference{audio: "[ext=m4a]"},
+		)
+	case "webm":
+		prefs = append(prefs,
+			preference{video: "[ext=webm]", combined: "[ext=webm]"},
+			preference{audio: "[ext=webm]"},
+		)
+	}
+	// Other containers, mkv included, hold any codec: --merge-output-format
+	// or the transcoding stage produces them, so there is nothing to filter on
+
+	var merged, single []string
+	for n := len(prefs); n >= 0; n-- {
+		var video, audio, combined string
+		for _, p := range prefs[:n] {
+			video += p.video
+			audio += p.audio
+			combined += p.combined
+		}
+		merged = appendUnique(merged, "bv*"+limits+video+"+ba"+audio)
+		single = appendUnique(single, "b"+limits+combined)
+	}
+	return strings.Join(append(merged, single...), "/")
+}
+
+// AudioSelector returns the yt-dlp -f expression for an audio download
+func (o Options) AudioSelector() string {
+	if o.AudioLanguage == "" {
+		return "bestaudio/best"
+	}
+	lang := "[language^=" + o.AudioLanguage + "]"
+	return "bestaudio" + lang + "/bestaudio/best" + lang + "/best"
+}
+
+// limitFilters returns the filters every alternative must pass. Frame rate
+// and size are often unknown before downloading, so unknown values pass.
+func (o Options) limitFilters() string {
+	var filters string
+	if height, ok := maxHeight(o.Resolution); ok {
+		filters += "[height<=" + strconv.Itoa(height) + "]"
+	}
+	if o.MaxFPS > 0 {
+		filters += "[fps<=?" + strconv.Itoa(o.MaxFPS) + "]"
+	}
+	if o.MaxFilesize != "" {
+		size := strings.ToUpper(o.MaxFilesize)
+		filters += "[filesize<?" + size + "][filesize_approx<?" + size + "]"
+	}
+	return filters
+}
+
+func appendUnique(list []string, value string) []string {
+	if slices.Contains(list, value) {
+		return list
+	}
+	return append(list, value)
+}
@@ -0,0 +1,176 @@
+package ytdlp
+
+import "testing"
+
+func TestVideoSelector(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{
+			name:     "defaults",
+			opts:     Options{},
+			expected: "bv*+ba/b",
+		},
+		{
+			name:     "best container",
+			opts:     Options{VideoFormat: "best"},
+			expected: "bv*+ba/b",
+		},
+		{
+			name:     "resolution only",
+			opts:     Options{Resolution: "720"},
+			expected: "bv*[height<=720]+ba/b[height<=720]",
+		},
+		{
+			name:     "1440p",
+			opts:     Options{Resolution: "1440"},
+			expected: "bv*[height<=1440]+ba/b[height<=1440]",
+		},
+		{
+			name:     "4k alias",
+			opts:     Options{Resolution: "4K"},
+			expected: "bv*[height<=2160]+ba/b[height<=2160]",
+		},
+		{
+			name:     "8k",
+			opts:     Options{Resolution: "4320"},
+			expected: "bv*[height<=4320]+ba/b[height<=4320]",
+		},
+		{
+			name:     "mp4",
+			opts:     Options{VideoFormat: "mp4"},
+			expected: "bv*[ext=mp4]+ba[ext=m4a]/bv*[ext=mp4]+ba/bv*+ba/b[ext=mp4]/b",
+		},
+		{
+			name:     "webm at 1080p",
+			opts:     Options{VideoFormat: "webm", Resolution: "1080"},
+			expected: "bv*[height<=1080][ext=webm]+ba[ext=webm]/bv*[height<=1080][ext=webm]+ba/bv*[height<=1080]+ba/b[height<=1080][ext=webm]/b[height<=1080]",
+		},
+		{
+			name:     "mkv has no extension filter",
+			opts:     Options{VideoFormat: "mkv", Resolution: "480"},
+			expected: "bv*[height<=480]+ba/b[height<=480]",
+		},
+		{
+			name:     "avi has no extension filter",
+			opts:     Options{VideoFormat: "avi"},
+			expected: "bv*+ba/b",
+		},
+		{
+			name:     "av1",
+			opts:     Options{VideoCodec: "av1"},
+			expected: "bv*[vcodec^=av01]+ba/bv*+ba/b[vcodec^=av01]/b",
+		},
+		{
+			name:     "vp9",
+			opts:     Options{VideoCodec: "VP9"},
+			expected: "bv*[vcodec~='^vp0?9']+ba/bv*+ba/b[vcodec~='^vp0?9']/b",
+		},
+		{
+			name:     "h264",
+			opts:     Options{VideoCodec: "h264"},
+			expected: "bv*[vcodec~='^(avc1|h264)']+ba/bv*+ba/b[vcodec~='^(avc1|h264)']/b",
+		},
+		{
+			name:     "hevc",
+			opts:     Options{VideoCodec: "hevc"},
+			expected: "bv*[vcodec~='^(hvc1|hev1|h265|hevc)']+ba/bv*+ba/b[vcodec~='^(hvc1|hev1|h265|hevc)']/b",
+		},
+		{
+			name:     "fps limit",
+			opts:     Options{MaxFPS: 30},
+			expected: "bv*[fps<=?30]+ba/b[fps<=?30]",
+		},
+		{
+			name:     "hdr",
+			opts:     Options{DynamicRange: "hdr"},
+			expected: "bv*[dynamic_range!=SDR]+ba/bv*+ba/b[dynamic_range!=SDR]/b",
+		},
+		{
+			name:     "sdr",
+			opts:     Options{DynamicRange: "SDR"},
+			expected: "bv*[dynamic_range=?SDR]+ba/bv*+ba/b[dynamic_range=?SDR]/b",
+		},
+		{
+			name:     "max filesize",
+			opts:     Options{MaxFilesize: "500m"},
+			expected: "bv*[filesize<?500M][filesize_approx<?500M]+ba/b[filesize<?500M][filesize_approx<?500M]",
+		},
+		{
+			name:     "audio language",
+			opts:     Options{AudioLanguage: "de"},
+			expected: "bv*+ba[language^=de]/bv*+ba/b[language^=de]/b",
+		},
+		{
+			name: "everything",
+			opts: Options{
+				Resolution: "2160", VideoFormat: "mp4", VideoCodec: "hevc", MaxFPS: 60,
+				DynamicRange: "hdr", MaxFilesize: "2G", AudioLanguage: "en-US",
+			},
+			expected: "bv*[height<=2160][fps<=?60][filesize<?2G][filesize_approx<?2G][dynamic_range!=SDR][vcodec~='^(hvc1|hev1|h265|hevc)'][ext=mp4]+ba[language^=en-US][ext=m4a]" +
+				"/bv*[height<=2160][fps<=?60][filesize<?2G][filesize_approx<?2G][dynamic_range!=SDR][vcodec~='^(hvc1|hev1|h265|hevc)'][ext=mp4]+ba[language^=en-US]" +
+				"/bv*[height<=2160][fps<=?60][filesize<?2G][filesize_approx<?2G][dynamic_range!=SDR][vcodec~='^(hvc1|hev1|h265|hevc)']+ba[language^=en-US]" +
+				"/bv*[height<=2160][fps<=?60][filesize<?2G][filesize_approx<?2G][dynamic_range!=SDR]+ba[language^=en-US]" +
+				"/bv*[height<=2160][fps<=?60][filesize<?2G][filesize_approx<?2G]+ba[language^=en-US]" +
+				"/bv*[height<=2160][fps<=?60][filesize<?2G][filesize_approx<?2G]+ba" +
+				"/b[height<=2160][fps<=?60][filesize<?2G][filesize_approx<?2G][language^=en-US][dynamic_range!=SDR][vcodec~='^(hvc1|hev1|h265|hevc)'][ext=mp4]" +
+				"/b[height<=2160][fps<=?60][filesize<?2G][filesize_approx<?2G][language^=en-US][dynamic_range!=SDR][vcodec~='^(hvc1|hev1|h265|hevc)']" +
+				"/b[height<=2160][fps<=?60][filesize<?2G][filesize_approx<?2G][language^=en-US][dynamic_range!=SDR]" +
+				"/b[height<=2160][fps<=?60][filesize<?2G][filesize_approx<?2G][language^=en-US]" +
+				"/b[height<=2160][fps<=?60][filesize<?2G][filesize_approx<?2G]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tt.opts.VideoSelector(); result != tt.expected {
+				t.Errorf("VideoSelector() =\n%s\nwant\n%s", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAudioSelector(t *testing.T) {
+	tests := []struct {
+		opts     Options
+		expected string
+	}{
+		{Options{Format: "audio"}, "bestaudio/best"},
+		{Options{Format: "audio", AudioLanguage: "ja"}, "bestaudio[language^=ja]/bestaudio/best[language^=ja]/best"},
+	}
+
+	for _, test := range tests {
+		if result := test.opts.AudioSelector(); result != test.expected {
+			t.Errorf("AudioSelector(%+v) = %q; want %q", test.opts, result, test.expected)
+		}
+	}
+}
+
+func TestValidateVideoSelection(t *testing.T) {
+	tests := []struct {
+		opts  Options
+		valid bool
+	}{
+		{Options{Format: "video", Resolution: "8k", VideoCodec: "av1", MaxFPS: 60, DynamicRange: "hdr", MaxFilesize: "4G", AudioLanguage: "pt-BR"}, true},
+		{Options{Format: "video", Resolution: "1081"}, false},
+		{Options{Format: "video", Resolution: "720]+ba/b[height<=99999"}, false},
+		{Options{Format: "video", VideoCodec: "mpeg2"}, false},
+		{Options{Format: "video", MaxFPS: -1}, false},
+		{Options{Format: "video", MaxFPS: 1000}, false},
+		{Options{Format: "video", DynamicRange: "dolby"}, false},
+		{Options{Format: "video", MaxFilesize: "500MB"}, false},
+		{Options{Format: "video", MaxFilesize: "0M"}, false},
+		{Options{Format: "video", AudioLanguage: "en]"}, false},
+		{Options{Format: "audio", AudioLanguage: "en", MaxFilesize: "50M"}, true},
+		{Options{Format: "audio", Resolution: "720", VideoFormat: "mp4"}, true},
+		{Options{Format: "audio", Resolution: "9000"}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.opts.Validate(); (err == nil) != test.valid {
+			t.Errorf("Validate(%+v) = %v; want valid=%v", test.opts, err, test.valid)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package ytdlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Chapter is one entry of a video's chapter list, in seconds
+type Chapter struct {
+	Title     string  `json:"title"`
+	StartTime float64 `json:"start_time"`
+	EndTime   float64 `json:"end_time"`
+}
+
+// Info is the subset of yt-dlp's metadata the server uses
+type Info struct {
+	ID         string    `json:"id"`
+	Title      string    `json:"title"`
+	Uploader   string    `json:"uploader"`
+	Extractor  string    `json:"extractor_key"`
+	WebpageURL string    `json:"webpage_url"`
+	FormatID   string    `json:"format_id"` // selected formats, e.g. "137+140"
+	Format     string    `json:"format"`    // their description, e.g. "137 - 1920x1080 (1080p)+140 - audio only (medium)"
+	Duration   float64   `json:"duration"`
+	UploadDate string    `json:"upload_date,omitempty"` // YYYYMMDD; only from FetchInfo
+	Chapters   []Chapter `json:"chapters"`
+	// SponsorBlock segments yt-dlp fetched, whether marked, removed or neither
+	SponsorBlockChapters []SponsorBlockChapter `json:"sponsorblock_chapters,omitempty"`
+}
+
+// InfoTemplate is a yt-dlp output template printing Info as JSON
+const InfoTemplate = "%(.{id,title,uploader,extractor_key,webpage_url,format_id,format,duration,chapters,sponsorblock_chapters})j"
+
+// ParseInfo reads the last Info printed with InfoTemplate, one JSON object per line
+func ParseInfo(data []byte) (*Info, error) {
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var info Info
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &info); err != nil {
+		return nil, fmt.Errorf("parsing yt-dlp metadata: %w", err)
+	}
+	return &info, nil
+}
+
+// FetchInfo asks yt-dlp for the metadata of a single video without downloading it
+func FetchInfo(ctx context.Context, url string) (*Info, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := Command(ctx, "--dump-single-json", "--no-playlist", "--skip-download", "--", url)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, lastLine(msg))
+		}
+		return nil, err
+	}
+
+	var info Info
+	if err := json.Unmarshal(stdout.Bytes(), &info); err != nil {
+		return nil, fmt.Errorf("parsing yt-dlp metadata: %w", err)
+	}
+	return &info, nil
+}
+
+func lastLine(s string) string {
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
@@ -0,0 +1,20 @@
+package ytdlp
+
+import "testing"
+
+func TestParseInfo(t *testing.T) {
+	data := []byte(`{"id":"first","title":"Ignored"}
+{"id":"abc","title":"Mix","uploader":"DJ","extractor_key":"Youtube","duration":125.5,"chapters":[{"title":"One","start_time":0,"end_time":60}]}
+`)
+	info, err := ParseInfo(data)
+	if err != nil {
+		t.Fatalf("ParseInfo() = %v", err)
+	}
+	if info.ID != "abc" || info.Extractor != "Youtube" || info.Duration != 125.5 || len(info.Chapters) != 1 || info.Chapters[0].EndTime != 60 {
+		t.Errorf("unexpected info %+v", info)
+	}
+
+	if _, err := ParseInfo([]byte("NA")); err == nil {
+		t.Error("expected an error for non-JSON output")
+	}
+}
@@ -0,0 +1,52 @@
+package ytdlp
+
+import (
+	"errors"
+	"fmt"
+
+	"downloader/ffmpeg"
+)
+
+// LoudnessSettings choose the level loudness normalization aims for. They
+// are part of the download options and the body of
+// POST /files/:filename/normalize.
+type LoudnessSettings struct {
+	TargetLUFS float64  `json:"targetLufs,omitempty" form:"targetLufs"` // integrated loudness; 0 means the default
+	TruePeak   *float64 `json:"truePeak,omitempty" form:"truePeak"`     // maximum true peak in dBTP
+}
+
+// Target returns the settings with defaults filled in
+func (s LoudnessSettings) Target() ffmpeg.LoudnessTarget {
+	target := ffmpeg.DefaultLoudnessTarget
+	if s.TargetLUFS != 0 {
+		target.Integrated = s.TargetLUFS
+	}
+	if s.TruePeak != nil {
+		target.TruePeak = *s.TruePeak
+	}
+	return target
+}
+
+// Validate checks the settings are within what loudnorm accepts
+func (s LoudnessSettings) Validate() error {
+	if s.TargetLUFS != 0 && (s.TargetLUFS < ffmpeg.MinIntegrated || s.TargetLUFS > ffmpeg.MaxIntegrated) {
+		return fmt.Errorf("targetLufs must be between %g and %g", ffmpeg.MinIntegrated, ffmpeg.MaxIntegrated)
+	}
+	if s.TruePeak != nil && (*s.TruePeak < ffmpeg.MinTruePeak || *s.TruePeak > ffmpeg.MaxTruePeak) {
+		return fmt.Errorf("truePeak must be between %g and %g", ffmpeg.MinTruePeak, ffmpeg.MaxTruePeak)
+	}
+	return nil
+}
+
+func (o Options) validateLoudness() error {
+	if !o.Normalize {
+		if o.TargetLUFS != 0 || o.TruePeak != nil {
+			return errors.New("targetLufs and truePeak require normalize")
+		}
+		return nil
+	}
+	if o.Format == "audio" && o.audioCodec() == CodecOriginal {
+		return errors.New("normalize re-encodes the audio and cannot be combined with audioCodec 'original'")
+	}
+	return o.LoudnessSettings.Validate()
+}
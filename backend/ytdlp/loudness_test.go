@@ -0,0 +1,41 @@
+package ytdlp
+
+import (
+	"testing"
+
+	"downloader/ffmpeg"
+)
+
+func TestValidateLoudness(t *testing.T) {
+	peak := -1.0
+	tooHigh := 1.0
+	tests := []struct {
+		opts  Options
+		valid bool
+	}{
+		{Options{Format: "audio", Normalize: true}, true},
+		{Options{Format: "video", Normalize: true, LoudnessSettings: LoudnessSettings{TargetLUFS: -23, TruePeak: &peak}}, true},
+		{Options{Format: "audio", Normalize: true, LoudnessSettings: LoudnessSettings{TargetLUFS: -80}}, false},
+		{Options{Format: "audio", Normalize: true, LoudnessSettings: LoudnessSettings{TruePeak: &tooHigh}}, false},
+		{Options{Format: "audio", LoudnessSettings: LoudnessSettings{TargetLUFS: -16}}, false},
+		{Options{Format: "audio", AudioCodec: "original", Normalize: true}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.opts.Validate(); (err == nil) != test.valid {
+			t.Errorf("Validate(%+v) = %v; want valid=%v", test.opts, err, test.valid)
+		}
+	}
+}
+
+func TestLoudnessTarget(t *testing.T) {
+	if got := (LoudnessSettings{}).Target(); got != ffmpeg.DefaultLoudnessTarget {
+		t.Errorf("Target() = %+v; want the default %+v", got, ffmpeg.DefaultLoudnessTarget)
+	}
+
+	zero := 0.0
+	got := LoudnessSettings{TargetLUFS: -23, TruePeak: &zero}.Target()
+	if got.Integrated != -23 || got.TruePeak != 0 || got.LRA != ffmpeg.DefaultLoudnessTarget.LRA {
+		t.Errorf("Target() = %+v; want -23 LUFS and 0 dBTP", got)
+	}
+}
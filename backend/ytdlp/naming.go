@@ -0,0 +1,219 @@
+package ytdlp
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"downloader/utils"
+)
+
+// DefaultNamingTemplate names files after the video title, directly in the
+// download folder
+const DefaultNamingTemplate = "{title}"
+
+// DefaultFilenameMaxLength bounds the text fields of a name, in bytes, so
+// that suffixes like " - 01 - Chapter.en.vtt" still fit filesystem limits
+const DefaultFilenameMaxLength = 150
+
+// Filename rules, from FILENAME_RULES
+const (
+	FilenameRulesWindows = "windows" // valid on Windows, macOS and Linux (default)
+	FilenameRulesASCII   = "ascii"   // also ASCII only, without spaces or "&"
+	FilenameRulesPOSIX   = "posix"   // only "/" is replaced; may not be valid on Windows
+)
+
+// namingFields maps the fields of a naming template to yt-dlp output template
+// fields. Text fields are truncated to the maximum length.
+var namingFields = map[string]struct {
+	field string
+	text  bool
+}{
+	"title":     {"title", true},
+	"id":        {"id", false},
+	"uploader":  {"uploader", true},
+	"channel":   {"channel", true},
+	"extractor": {"extractor_key", false},
+	"date":      {"upload_date>%Y-%m-%d", false},
+	"year":      {"upload_date>%Y", false},
+	"month":     {"upload_date>%m", false},
+	"day":       {"upload_date>%d", false},
+	"playlist":  {"playlist_title", true},
+	"index":     {"playlist_index", false},
+}
+
+// playlistFields are filled in from a PlaylistPosition rather than by yt-dlp,
+// which downloads every video on its own and never knows the playlist
+var playlistFields = map[string]bool{"playlist": true, "index": true}
+
+// NamingFields returns the field names a naming template accepts
+func NamingFields() []string {
+	fields := make([]string, 0, len(namingFields))
+	for name := range namingFields {
+		fields = append(fields, name)
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+// NamingTemplate returns the naming template used when a request sets none:
+// OUTPUT_TEMPLATE if set, otherwise DefaultNamingTemplate
+func NamingTemplate() string {
+	if template := os.Getenv("OUTPUT_TEMPLATE"); template != "" {
+		return template
+	}
+	return DefaultNamingTemplate
+}
+
+// FilenameRules returns the sanitization rules from FILENAME_RULES, defaulting
+// to windows
+func FilenameRules() string {
+	switch rules := os.Getenv("FILENAME_RULES"); rules {
+	case FilenameRulesASCII, FilenameRulesPOSIX:
+		return rules
+	default:
+		return FilenameRulesWindows
+	}
+}
+
+// FilenameMaxLength returns FILENAME_MAX_LENGTH, defaulting to DefaultFilenameMaxLength
+func FilenameMaxLength() int {
+	return utils.EnvInt("FILENAME_MAX_LENGTH", DefaultFilenameMaxLength)
+}
+
+// ValidateNamingTemplate checks a naming template such as
+// "{uploader}/{year}/{title} [{id}]": "/" separates folders below the
+// download folder, {field} is replaced by one of NamingFields and the
+// extension is added. Folders may not be empty, and no folder or name may
+// start with ".": hidden entries are left out of file listings and could
+// clash with the staging folders. The text may not contain characters some
+// platforms reject in names.
+func ValidateNamingTemplate(template string) error {
+	if template == "" {
+		return errors.New("Naming template is empty")
+	}
+	for _, segment := range strings.Split(template, "/") {
+		if segment == "" {
+			return fmt.Errorf("Invalid naming template %q: empty folder", template)
+		}
+		if strings.HasPrefix(segment, ".") {
+			return fmt.Errorf("Invalid naming template %q: %q starts with \".\", which hides it", template, segment)
+		}
+		if _, err := translateSegment(segment, 0, nil); err != nil {
+			return fmt.Errorf("Invalid naming template %q: %w", template, err)
+		}
+	}
+	return nil
+}
+
+// translateSegment turns one folder or file name of a naming template into
+// yt-dlp output template syntax, truncating text fields to maxLength bytes
+// unless it is 0. The playlist fields come from position if it is set and
+// are left to yt-dlp, which writes "NA", otherwise.
+func translateSegment(segment string, maxLength int, position *PlaylistPosition) (string, error) {
+	var b strings.Builder
+	for segment != "" {
+		open := strings.IndexByte(segment, '{')
+		if open < 0 {
+			open = len(segment)
+		}
+		literal := segment[:open]
+		if strings.ContainsAny(literal, `}\:*?"<>|`) || strings.ContainsFunc(literal, func(r rune) bool { return r < 0x20 }) {
+			return "", fmt.Errorf("%q contains a character not allowed in names", literal)
+		}
+		b.WriteString(strings.ReplaceAll(literal, "%", "%%"))
+		segment = segment[open:]
+		if segment == "" {
+			break
+		}
+
+		end := strings.IndexByte(segment, '}')
+		if end < 0 {
+			return "", errors.New("unclosed {")
+		}
+		name := segment[1:end]
+		field, ok := namingFields[name]
+		if !ok {
+			return "", fmt.Errorf("unknown field {%s}; use one of %s", name, strings.Join(NamingFields(), ", "))
+		}
+		if position != nil && playlistFields[name] {
+			b.WriteString(strings.ReplaceAll(position.value(name, maxLength), "%", "%%"))
+		} else if field.text && maxLength > 0 {
+			b.WriteString("%(" + field.field + ")." + strconv.Itoa(maxLength) + "B")
+		} else {
+			b.WriteString("%(" + field.field + ")s")
+		}
+		segment = segment[end+1:]
+	}
+	return b.String(), nil
+}
+
+// naming returns the naming template of the options
+func (o Options) naming() string {
+	if o.Naming != "" {
+		return o.Naming
+	}
+	return NamingTemplate()
+}
+
+// OutputTemplate returns the yt-dlp output template, relative to the
+// download folder, that names files by the naming template with suffix
+// appended before the extension. position, if known, fills in {playlist}
+// and {index}.
+func (o Options) OutputTemplate(suffix string, position *PlaylistPosition) string {
+	segments := strings.Split(o.naming(), "/")
+	for i, segment := range segments {
+		// Validate rejected invalid templates before a job was created
+		segments[i], _ = translateSegment(segment, FilenameMaxLength(), position)
+	}
+	return strings.Join(segments, "/") + strings.ReplaceAll(suffix, "%", "%%") + ".%(ext)s"
+}
+
+// value returns the text of the playlist field name, made safe for a name
+// by the same FilenameRules yt-dlp applies to the fields it fills in
+func (p *PlaylistPosition) value(name string, maxLength int) string {
+	if name == "index" {
+		return strconv.Itoa(p.Index)
+	}
+
+	var title string
+	switch FilenameRules() {
+	case FilenameRulesPOSIX:
+		title = strings.ReplaceAll(p.Title, "/", "_")
+	case FilenameRulesASCII:
+		title = strings.Map(func(r rune) rune {
+			if r > unicode.MaxASCII || r == ' ' || r == '&' {
+				return '_'
+			}
+			return r
+		}, utils.SanitizeFilename(p.Title))
+	default:
+		title = utils.SanitizeFilename(p.Title)
+	}
+	if maxLength > 0 && len(title) > maxLength {
+		// Cut on a rune boundary
+		cut := maxLength
+		for cut > 0 && !utf8.RuneStart(title[cut]) {
+			cut--
+		}
+		title = title[:cut]
+	}
+	return title
+}
+
+// filenameArgs returns the yt-dlp arguments applying FilenameRules
+func filenameArgs() []string {
+	switch FilenameRules() {
+	case FilenameRulesASCII:
+		return []string{"--windows-filenames", "--restrict-filenames"}
+	case FilenameRulesPOSIX:
+		return []string{"--no-windows-filenames"}
+	default:
+		return []string{"--windows-filenames"}
+	}
+}
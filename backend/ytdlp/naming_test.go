@@ -0,0 +1,124 @@
+package ytdlp
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestValidateNamingTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		valid    bool
+	}{
+		{"{title}", true},
+		{"{uploader}/{year}/{title} [{id}]", true},
+		{"{playlist}/{index} - {title}", true},
+		{"100% {title}", true},
+		{"", false},
+		{"{title", false},
+		{"{views}", false},
+		{"/{title}", false},
+		{"{uploader}//{title}", false},
+		{"../{title}", false},
+		{"{uploader}/./{title}", false},
+		{".cache/{title}", false},
+		{"{uploader}/.{title}", false},
+		{".staging-x/{title}", false},
+		{"{uploader}/{title}.part1", true},
+		{"{title}?", false},
+		{`{uploader}\{title}`, false},
+	}
+
+	for _, test := range tests {
+		if err := ValidateNamingTemplate(test.template); (err == nil) != test.valid {
+			t.Errorf("ValidateNamingTemplate(%q) = %v; want valid=%v", test.template, err, test.valid)
+		}
+	}
+}
+
+func TestOutputTemplate(t *testing.T) {
+	t.Setenv("OUTPUT_TEMPLATE", "")
+	t.Setenv("FILENAME_MAX_LENGTH", "")
+
+	position := &PlaylistPosition{Title: "Best of: 100% \"Live\"", Index: 7}
+	tests := []struct {
+		naming   string
+		suffix   string
+		position *PlaylistPosition
+		want     string
+	}{
+		{"", "", nil, "%(title).150B.%(ext)s"},
+		{"", " [1m30s-2m00s]", nil, "%(title).150B [1m30s-2m00s].%(ext)s"},
+		{"{uploader}/{year}/{title} [{id}]", "", nil, "%(uploader).150B/%(upload_date>%Y)s/%(title).150B [%(id)s].%(ext)s"},
+		{"{index} - {title} 100%", "", nil, "%(playlist_index)s - %(title).150B 100%%.%(ext)s"},
+		{"{playlist}/{index} - {title}", "", position, "Best of_ 100%% _Live_/7 - %(title).150B.%(ext)s"},
+	}
+
+	for _, test := range tests {
+		opts := Options{Naming: test.naming}
+		if got := opts.OutputTemplate(test.suffix, test.position); got != test.want {
+			t.Errorf("OutputTemplate(%q) with naming %q = %q; want %q", test.suffix, test.naming, got, test.want)
+		}
+	}
+
+	t.Setenv("OUTPUT_TEMPLATE", "{channel}/{title}")
+	t.Setenv("FILENAME_MAX_LENGTH", "80")
+	if got, want := (Options{}).OutputTemplate("", nil), "%(channel).80B/%(title).80B.%(ext)s"; got != want {
+		t.Errorf("OutputTemplate with OUTPUT_TEMPLATE = %q; want %q", got, want)
+	}
+}
+
+func TestPlaylistPositionValue(t *testing.T) {
+	position := &PlaylistPosition{Title: "Café & Friends/Live", Index: 12}
+	tests := []struct {
+		rules     string
+		maxLength int
+		want      string
+	}{
+		{"windows", 0, "Café & Friends_Live"},
+		{"ascii", 0, "Caf____Friends_Live"},
+		{"posix", 0, "Café & Friends_Live"},
+		{"windows", 4, "Caf"},
+	}
+
+	for _, test := range tests {
+		t.Setenv("FILENAME_RULES", test.rules)
+		if got := position.value("playlist", test.maxLength); got != test.want {
+			t.Errorf("value(playlist) with %s rules and length %d = %q; want %q", test.rules, test.maxLength, got, test.want)
+		}
+	}
+	if got := position.value("index", 0); got != "12" {
+		t.Errorf("value(index) = %q; want 12", got)
+	}
+}
+
+func TestFilenameRules(t *testing.T) {
+	tests := []struct {
+		rules string
+		want  []string
+	}{
+		{"", []string{"--windows-filenames"}},
+		{"windows", []string{"--windows-filenames"}},
+		{"ascii", []string{"--windows-filenames", "--restrict-filenames"}},
+		{"posix", []string{"--no-windows-filenames"}},
+	}
+
+	for _, test := range tests {
+		t.Setenv("FILENAME_RULES", test.rules)
+		args := Options{Format: "video"}.Args("out")
+		for _, want := range test.want {
+			if !slices.Contains(args, want) {
+				t.Errorf("FILENAME_RULES=%q: expected %s in %v", test.rules, want, args)
+			}
+		}
+	}
+}
+
+func TestNamingValidatedWithOptions(t *testing.T) {
+	if err := (Options{Format: "video", Naming: "{uploader}/{title}"}).Validate(); err != nil {
+		t.Errorf("Validate() = %v; want nil", err)
+	}
+	if err := (Options{Format: "video", Naming: "../{title}"}).Validate(); err == nil {
+		t.Error("expected a naming template leaving the download folder to be rejected")
+	}
+}
@@ -0,0 +1,254 @@
+package ytdlp
+
+import (
+	"downloader/subtitles"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ProgressTemplate formats yt-dlp progress lines streamed to clients
+const ProgressTemplate = "download:%(progress._percent_str)s (%(progress.eta)s remaining)"
+
+// Options are the user-selectable download settings shared by every way of
+// starting a download. The form tags let GET handlers bind them from the query.
+type Options struct {
+	Format      string `json:"format" form:"format"`           // "video" or "audio"
+	Resolution  string `json:"resolution" form:"resolution"`   // "144" to "4320", or "2k", "4k", "8k"
+	VideoFormat string `json:"videoFormat" form:"videoFormat"` // "mp4", "webm", "mkv", "avi", "mov", "flv", "3gp", "best"
+	Preset      string `json:"preset,omitempty" form:"preset"` // "compatible", "small", "archive"; see ffmpeg.Presets
+
+	// Video selection; see VideoSelector
+	VideoCodec    string `json:"videoCodec,omitempty" form:"videoCodec"`       // preferred: "av1", "vp9", "h264", "hevc"
+	MaxFPS        int    `json:"maxFps,omitempty" form:"maxFps"`               // frame rate limit
+	DynamicRange  string `json:"dynamicRange,omitempty" form:"dynamicRange"`   // preferred: "sdr" or "hdr"
+	MaxFilesize   string `json:"maxFilesize,omitempty" form:"maxFilesize"`     // size limit such as "500M"
+	AudioLanguage string `json:"audioLanguage,omitempty" form:"audioLanguage"` // preferred audio track language
+
+	// Audio downloads only; the choices are listed by Supported
+	AudioCodec   string `json:"audioCodec,omitempty" form:"audioCodec"`     // "mp3" (default), "aac", "m4a", "opus", "vorbis", "flac", "wav", "original"
+	AudioBitrate string `json:"audioBitrate,omitempty" form:"audioBitrate"` // constant bitrate such as "192K"
+	AudioQuality *int   `json:"audioQuality,omitempty" form:"audioQuality"` // VBR quality, 0 (best) to 10
+	SampleRate   int    `json:"sampleRate,omitempty" form:"sampleRate"`     // Hz
+	Channels     int    `json:"channels,omitempty" form:"channels"`         // 1 or 2
+
+	// Subtitles are only fetched when at least one language is requested
+	SubtitleLangs  []string `json:"subtitleLangs,omitempty" form:"subtitleLangs"`   // e.g. "en", "de", "en.*", "all"
+	SubtitleSource string   `json:"subtitleSource,omitempty" form:"subtitleSource"` // "manual" (default), "auto", "both"
+	SubtitleFormat string   `json:"subtitleFormat,omitempty" form:"subtitleFormat"` // "vtt" (default), "srt", "ass"
+	EmbedSubtitles bool     `json:"embedSubtitles,omitempty" form:"embedSubtitles"`
+
+	// Start and End select part of the media; each is a timestamp or a chapter name
+	Start string `json:"start,omitempty" form:"start"`
+	End   string `json:"end,omitempty" form:"end"`
+	Cut   string `json:"cut,omitempty" form:"cut"` // "fast" (default, at keyframes) or "accurate"
+
+	// SplitChapters replaces the download with one file per chapter
+	SplitChapters bool `json:"splitChapters,omitempty" form:"splitChapters"`
+	CueSheet      bool `json:"cueSheet,omitempty" form:"cueSheet"` // write a CUE sheet for the chapter files
+	M3U           bool `json:"m3u,omitempty" form:"m3u"`           // write an M3U playlist for the chapter files
+
+	// Thumbnails: embed as cover art, keep as a sidecar image, crop square (audio only)
+	EmbedThumbnail  bool `json:"embedThumbnail,omitempty" form:"embedThumbnail"`
+	WriteThumbnail  bool `json:"writeThumbnail,omitempty" form:"writeThumbnail"`
+	SquareThumbnail bool `json:"squareThumbnail,omitempty" form:"squareThumbnail"`
+
+	// SponsorBlock categories to mark as chapters or cut out, e.g. "sponsor", "intro", "all"
+	SponsorBlockMark   []string `json:"sponsorBlockMark,omitempty" form:"sponsorBlockMark"`
+	SponsorBlockRemove []string `json:"sponsorBlockRemove,omitempty" form:"sponsorBlockRemove"`
+
+	// Normalize adjusts the audio to a common loudness after download
+	Normalize bool `json:"normalize,omitempty" form:"normalize"`
+	LoudnessSettings
+
+	// Naming names the files and the folders they go in, e.g. "{uploader}/{year}/{title}";
+	// see ValidateNamingTemplate. The default is NamingTemplate.
+	Naming string `json:"naming,omitempty" form:"naming"`
+
+	// DuplicatePolicy decides what happens when the video was downloaded before
+	DuplicatePolicy string `json:"duplicatePolicy,omitempty" form:"duplicatePolicy"` // "reuse" (default), "redownload", "copy"
+}
+
+// Subtitle languages are yt-dlp --sub-langs entries: codes, regexes like
+// "en.*", "all", or exclusions like "-live_chat"
+var subtitleLangPattern = regexp.MustCompile(`^-?[A-Za-z0-9_.*-]{1,32}$`)
+
+// Validate checks the options before a job is created
+func (o Options) Validate() error {
+	if o.Format != "video" && o.Format != "audio" {
+		return errors.New("Invalid format. Choose 'video' or 'audio'")
+	}
+	if err := o.validateContainer(); err != nil {
+		return err
+	}
+	if err := o.validateVideoSelection(); err != nil {
+		return err
+	}
+	if err := o.validateAudio(); err != nil {
+		return err
+	}
+	if err := o.validateSubtitles(); err != nil {
+		return err
+	}
+	if err := o.validateThumbnail(); err != nil {
+		return err
+	}
+	if err := o.validateSponsorBlock(); err != nil {
+		return err
+	}
+	if err := o.validateLoudness(); err != nil {
+		return err
+	}
+	if err := o.validateDuplicates(); err != nil {
+		return err
+	}
+	if o.Naming != "" {
+		if err := ValidateNamingTemplate(o.Naming); err != nil {
+			return err
+		}
+	}
+	if (o.CueSheet || o.M3U) && !o.SplitChapters {
+		return errors.New("cueSheet and m3u require splitChapters")
+	}
+	if o.CueSheet && o.Format != "audio" {
+		return errors.New("cueSheet is only available for audio downloads")
+	}
+	if o.SplitChapters && o.Clipped() {
+		return errors.New("splitChapters cannot be combined with start or end")
+	}
+	return o.validateClip()
+}
+
+func (o Options) validateSubtitles() error {
+	langs := o.subtitleLangs()
+	for _, lang := range langs {
+		if !subtitleLangPattern.MatchString(lang) {
+			return fmt.Errorf("Invalid subtitle language %q", lang)
+		}
+	}
+
+	switch o.SubtitleSource {
+	case "", "manual", "auto", "both":
+	default:
+		return errors.New("Invalid subtitleSource. Choose 'manual', 'auto' or 'both'")
+	}
+	if o.SubtitleFormat != "" && !subtitles.IsSupported(o.SubtitleFormat) {
+		return errors.New("Invalid subtitleFormat. Choose 'vtt', 'srt' or 'ass'")
+	}
+
+	if o.EmbedSubtitles {
+		if len(langs) == 0 {
+			return errors.New("embedSubtitles requires at least one subtitle language")
+		}
+		if o.Format != "video" {
+			return errors.New("Subtitles can only be embedded in video downloads")
+		}
+		switch o.VideoFormat {
+		case "", "best", "mp4", "mkv", "webm":
+		default:
+			return fmt.Errorf("Subtitles cannot be embedded in %s; use mp4, mkv or webm", o.VideoFormat)
+		}
+	}
+	return nil
+}
+
+// subtitleLangs returns the requested languages, accepting both repeated
+// values and comma-separated lists
+func (o Options) subtitleLangs() []string {
+	return splitList(o.SubtitleLangs)
+}
+
+// splitList flattens repeated values and comma-separated lists, as query
+// strings send either
+func splitList(entries []string) []string {
+	var items []string
+	for _, entry := range entries {
+		for _, item := range strings.Split(entry, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// subtitleFormatPreference lists the requested format first, then the
+// formats that can be converted to it
+func (o Options) subtitleFormatPreference() string {
+	formats := []string{o.SubtitleTarget()}
+	for _, f := range []string{subtitles.FormatVTT, subtitles.FormatSRT} {
+		if f != formats[0] {
+			formats = append(formats, f)
+		}
+	}
+	return strings.Join(append(formats, "best"), "/")
+}
+
+// WantsSubtitles reports whether subtitle files are requested
+func (o Options) WantsSubtitles() bool {
+	return len(o.subtitleLangs()) > 0
+}
+
+// SubtitleTarget returns the format subtitle files are converted to
+func (o Options) SubtitleTarget() string {
+	if o.SubtitleFormat == "" {
+		return subtitles.FormatVTT
+	}
+	return strings.ToLower(o.SubtitleFormat)
+}
+
+// Args returns the yt-dlp arguments for these options writing into
+// outputTemplate, a yt-dlp output template such as "/dl/%(title)s.%(ext)s"
+// built with OutputTemplate. The caller appends the URL.
+func (o Options) Args(outputTemplate string) []string {
+	var args []string
+
+	if o.Format == "audio" {
+		args = o.audioArgs()
+	} else {
+		args = []string{"-f", o.VideoSelector()}
+	}
+
+	args = append(args,
+		"--no-playlist", "--prefer-free-formats",
+		"--embed-metadata", "--add-metadata",
+	)
+	args = append(args, filenameArgs()...)
+
+	// yt-dlp merges separate streams into mp4 or mkv; the transcoding stage
+	// turns the mkv into any other container
+	if o.Format == "video" {
+		switch o.VideoFormat {
+		case "", "best":
+		case "mp4":
+			args = append(args, "--merge-output-format", "mp4")
+		default:
+			args = append(args, "--merge-output-format", "mkv")
+		}
+	}
+
+	if o.WantsSubtitles() {
+		switch o.SubtitleSource {
+		case "auto":
+			args = append(args, "--write-auto-subs")
+		case "both":
+			args = append(args, "--write-subs", "--write-auto-subs")
+		default:
+			args = append(args, "--write-subs")
+		}
+		// Prefer the requested format; anything else yt-dlp offers in vtt or
+		// srt is converted afterwards
+		args = append(args,
+			"--sub-langs", strings.Join(o.subtitleLangs(), ","),
+			"--sub-format", o.subtitleFormatPreference(),
+		)
+		if o.EmbedSubtitles {
+			args = append(args, "--embed-subs")
+		}
+	}
+	args = append(args, o.thumbnailArgs()...)
+	args = append(args, o.sponsorBlockArgs()...)
+
+	return append(args, "-o", outputTemplate, "--progress-template", ProgressTemplate)
+}
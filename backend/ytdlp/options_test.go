@@ -0,0 +1,271 @@
+package ytdlp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	tests := []struct {
+		opts  Options
+		valid bool
+	}{
+		{Options{Format: "video"}, true},
+		{Options{Format: "audio"}, true},
+		{Options{Format: ""}, false},
+		{Options{Format: "invalid"}, false},
+		{Options{Format: "video", SubtitleLangs: []string{"en", "de,fr"}, SubtitleFormat: "srt"}, true},
+		{Options{Format: "video", SubtitleLangs: []string{"en.*", "-live_chat"}, SubtitleSource: "both"}, true},
+		{Options{Format: "video", SubtitleLangs: []string{"en;rm -rf"}}, false},
+		{Options{Format: "video", SubtitleLangs: []string{"en"}, SubtitleSource: "machine"}, false},
+		{Options{Format: "video", SubtitleLangs: []string{"en"}, SubtitleFormat: "sub"}, false},
+		{Options{Format: "video", VideoFormat: "mkv", SubtitleLangs: []string{"en"}, EmbedSubtitles: true}, true},
+		{Options{Format: "video", VideoFormat: "avi", SubtitleLangs: []string{"en"}, EmbedSubtitles: true}, false},
+		{Options{Format: "audio", SubtitleLangs: []string{"en"}, EmbedSubtitles: true}, false},
+		{Options{Format: "video", EmbedSubtitles: true}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.opts.Validate(); (err == nil) != test.valid {
+			t.Errorf("Validate(%+v) = %v; want valid=%v", test.opts, err, test.valid)
+		}
+	}
+}
+
+func TestOptionsArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		contains []string
+		excludes []string
+	}{
+		{
+			name:     "audio",
+			opts:     Options{Format: "audio", VideoFormat: "mp4"},
+			contains: []string{"-f bestaudio/best", "--extract-audio --audio-format mp3 --audio-quality 192K"},
+			excludes: []string{"--merge-output-format", "--postprocessor-args"},
+		},
+		{
+			name:     "audio opus bitrate resampled mono",
+			opts:     Options{Format: "audio", AudioCodec: "opus", AudioBitrate: "96k", SampleRate: 48000, Channels: 1},
+			contains: []string{"--extract-audio --audio-format opus --audio-quality 96K --postprocessor-args ExtractAudio:-ar 48000 -ac 1"},
+		},
+		{
+			name:     "audio vbr",
+			opts:     Options{Format: "audio", AudioCodec: "vorbis", AudioQuality: intPtr(0)},
+			contains: []string{"--audio-format vorbis --audio-quality 0"},
+		},
+		{
+			name:     "audio lossless",
+			opts:     Options{Format: "audio", AudioCodec: "flac"},
+			contains: []string{"--audio-format flac"},
+			excludes: []string{"--audio-quality"},
+		},
+		{
+			name:     "audio original",
+			opts:     Options{Format: "audio", AudioCodec: "original"},
+			contains: []string{"-f bestaudio/best --extract-audio"},
+			excludes: []string{"--audio-format", "--audio-quality"},
+		},
+		{
+			name:     "video mp4",
+			opts:     Options{Format: "video", Resolution: "720", VideoFormat: "mp4"},
+			contains: []string{"[height<=720]", "--merge-output-format mp4"},
+			excludes: []string{"--extract-audio"},
+		},
+		{
+			name:     "video webm",
+			opts:     Options{Format: "video", VideoFormat: "webm"},
+			contains: []string{"-f bv*[ext=webm]+ba[ext=webm]/", "--merge-output-format mkv"},
+			excludes: []string{"--write-subs"},
+		},
+		{
+			name:     "video best",
+			opts:     Options{Format: "video", VideoFormat: "best"},
+			excludes: []string{"--merge-output-format"},
+		},
+		{
+			name:     "video mov",
+			opts:     Options{Format: "video", VideoFormat: "mov", Preset: "small"},
+			contains: []string{"--merge-output-format mkv"},
+		},
+		{
+			name:     "manual subtitles",
+			opts:     Options{Format: "video", VideoFormat: "mp4", SubtitleLangs: []string{"en", "de"}, SubtitleFormat: "srt"},
+			contains: []string{"--write-subs --sub-langs en,de --sub-format srt/vtt/best"},
+			excludes: []string{"--write-auto-subs", "--embed-subs"},
+		},
+		{
+			name:     "auto subtitles embedded",
+			opts:     Options{Format: "video", VideoFormat: "mkv", SubtitleLangs: []string{"en"}, SubtitleSource: "auto", EmbedSubtitles: true},
+			contains: []string{"--write-auto-subs --sub-langs en --sub-format vtt/srt/best --embed-subs"},
+			excludes: []string{"--write-subs "},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := strings.Join(tt.opts.Args("/dl/%(title)s.%(ext)s"), " ")
+			for _, want := range append(tt.contains, "-o /dl/%(title)s.%(ext)s", "--progress-template") {
+				if !strings.Contains(args, want) {
+					t.Errorf("expected args to contain %q, got %s", want, args)
+				}
+			}
+			for _, unwanted := range tt.excludes {
+				if strings.Contains(args, unwanted) {
+					t.Errorf("expected args not to contain %q, got %s", unwanted, args)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateChapterOptions(t *testing.T) {
+	tests := []struct {
+		opts  Options
+		valid bool
+	}{
+		{Options{Format: "audio", SplitChapters: true, CueSheet: true, M3U: true}, true},
+		{Options{Format: "video", SplitChapters: true, M3U: true}, true},
+		{Options{Format: "video", SplitChapters: true, CueSheet: true}, false},
+		{Options{Format: "audio", M3U: true}, false},
+		{Options{Format: "audio", SplitChapters: true, Start: "1:00"}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.opts.Validate(); (err == nil) != test.valid {
+			t.Errorf("Validate(%+v) = %v; want valid=%v", test.opts, err, test.valid)
+		}
+	}
+}
+
+func intPtr(n int) *int { return &n }
+
+func TestValidateAudioOptions(t *testing.T) {
+	tests := []struct {
+		opts  Options
+		valid bool
+	}{
+		{Options{Format: "audio", AudioCodec: "m4a", AudioBitrate: "256K", SampleRate: 44100, Channels: 2}, true},
+		{Options{Format: "audio", AudioCodec: "mp3", AudioQuality: intPtr(10)}, true},
+		{Options{Format: "audio", AudioCodec: "wav", SampleRate: 96000}, true},
+		{Options{Format: "audio", AudioCodec: "wma"}, false},
+		{Options{Format: "audio", AudioCodec: "mp3", AudioBitrate: "1000K"}, false},
+		{Options{Format: "audio", AudioCodec: "mp3", AudioBitrate: "192K", AudioQuality: intPtr(2)}, false},
+		{Options{Format: "audio", AudioCodec: "mp3", AudioQuality: intPtr(11)}, false},
+		{Options{Format: "audio", AudioCodec: "flac", AudioQuality: intPtr(0)}, false},
+		{Options{Format: "audio", AudioCodec: "flac", AudioBitrate: "320K"}, false},
+		{Options{Format: "audio", AudioCodec: "mp3", SampleRate: 96000}, false},
+		{Options{Format: "audio", AudioCodec: "opus", SampleRate: 44100}, false},
+		{Options{Format: "audio", Channels: 6}, false},
+		{Options{Format: "audio", AudioCodec: "original", AudioBitrate: "128K"}, false},
+		{Options{Format: "video", AudioCodec: "mp3"}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.opts.Validate(); (err == nil) != test.valid {
+			t.Errorf("Validate(%+v) = %v; want valid=%v", test.opts, err, test.valid)
+		}
+	}
+}
+
+func TestValidateSponsorBlock(t *testing.T) {
+	tests := []struct {
+		opts  Options
+		valid bool
+	}{
+		{Options{Format: "video", SponsorBlockMark: []string{"all"}}, true},
+		{Options{Format: "audio", SponsorBlockRemove: []string{"sponsor,selfpromo"}, SponsorBlockMark: []string{"intro"}}, true},
+		{Options{Format: "video", SponsorBlockMark: []string{"ads"}}, false},
+		{Options{Format: "video", SponsorBlockRemove: []string{"poi_highlight"}}, false},
+		{Options{Format: "video", SponsorBlockRemove: []string{"sponsor"}, SponsorBlockMark: []string{"sponsor"}}, false},
+		{Options{Format: "video", SponsorBlockRemove: []string{"sponsor"}, Start: "1:00"}, false},
+		{Options{Format: "video", SponsorBlockMark: []string{"sponsor"}, Start: "1:00"}, true},
+	}
+
+	for _, test := range tests {
+		if err := test.opts.Validate(); (err == nil) != test.valid {
+			t.Errorf("Validate(%+v) = %v; want valid=%v", test.opts, err, test.valid)
+		}
+	}
+}
+
+func TestSponsorBlockArgs(t *testing.T) {
+	t.Setenv("SPONSORBLOCK_API_URL", "http://localhost:8080/")
+
+	opts := Options{Format: "video", SponsorBlockMark: []string{"intro", "outro"}, SponsorBlockRemove: []string{"sponsor"}}
+	args := strings.Join(opts.Args("/dl/%(title)s.%(ext)s"), " ")
+	want := "--sponsorblock-mark intro,outro --sponsorblock-remove sponsor --sponsorblock-api http://localhost:8080"
+	if !strings.Contains(args, want) {
+		t.Errorf("expected args to contain %q, got %s", want, args)
+	}
+
+	if args := strings.Join((Options{Format: "video"}).Args("/dl/x"), " "); strings.Contains(args, "--sponsorblock") {
+		t.Errorf("expected no SponsorBlock args, got %s", args)
+	}
+}
+
+func TestValidateThumbnail(t *testing.T) {
+	tests := []struct {
+		opts  Options
+		valid bool
+	}{
+		{Options{Format: "audio", EmbedThumbnail: true, SquareThumbnail: true}, true},
+		{Options{Format: "audio", AudioCodec: "flac", EmbedThumbnail: true, WriteThumbnail: true}, true},
+		{Options{Format: "audio", AudioCodec: "opus", EmbedThumbnail: true}, false},
+		{Options{Format: "audio", AudioCodec: "opus", WriteThumbnail: true}, true},
+		{Options{Format: "audio", SquareThumbnail: true}, false},
+		{Options{Format: "video", VideoFormat: "mkv", EmbedThumbnail: true}, true},
+		{Options{Format: "video", VideoFormat: "webm", EmbedThumbnail: true}, false},
+		{Options{Format: "video", VideoFormat: "best", WriteThumbnail: true}, true},
+		{Options{Format: "video", VideoFormat: "mp4", EmbedThumbnail: true, SquareThumbnail: true}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.opts.Validate(); (err == nil) != test.valid {
+			t.Errorf("Validate(%+v) = %v; want valid=%v", test.opts, err, test.valid)
+		}
+	}
+}
+
+func TestThumbnailArgs(t *testing.T) {
+	args := strings.Join((Options{Format: "audio", EmbedThumbnail: true}).Args("/dl/x"), " ")
+	if !strings.Contains(args, "--write-thumbnail --convert-thumbnails jpg") || strings.Contains(args, "--embed-thumbnail") {
+		t.Errorf("expected the thumbnail to be written as JPEG for the server to embed, got %s", args)
+	}
+}
+
+func TestDuplicatePolicy(t *testing.T) {
+	tests := []struct {
+		policy string
+		valid  bool
+	}{
+		{"", true},
+		{"reuse", true},
+		{"redownload", true},
+		{"copy", true},
+		{"skip", false},
+	}
+
+	for _, test := range tests {
+		opts := Options{Format: "video", DuplicatePolicy: test.policy}
+		if err := opts.Validate(); (err == nil) != test.valid {
+			t.Errorf("Validate(%q) = %v; want valid=%v", test.policy, err, test.valid)
+		}
+	}
+
+	a := Options{Format: "audio", SubtitleLangs: []string{"en"}, DuplicatePolicy: "reuse"}
+	if !a.SameDownload(Options{Format: "audio", SubtitleLangs: []string{"en"}}) {
+		t.Error("expected the duplicate policy to be ignored")
+	}
+	if a.SameDownload(Options{Format: "audio", SubtitleLangs: []string{"de"}}) {
+		t.Error("expected different subtitle languages to differ")
+	}
+	if !(Options{Format: "video", SubtitleLangs: []string{}}).SameDownload(Options{Format: "video"}) {
+		t.Error("expected an empty list to equal a missing one")
+	}
+	quality, same := 2, 2
+	if !(Options{Format: "audio", AudioQuality: &quality}).SameDownload(Options{Format: "audio", AudioQuality: &same}) {
+		t.Error("expected equal values behind pointers to be the same")
+	}
+}
@@ -0,0 +1,100 @@
+package ytdlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Entry is one video of a channel or playlist as listed without visiting it.
+// Listings may leave out the duration and upload date.
+type Entry struct {
+	ID         string  `json:"id"`
+	Title      string  `json:"title"`
+	URL        string  `json:"url"`
+	Extractor  string  `json:"ie_key"`         // extractor key of the video, e.g. "Youtube"
+	Duration   float64 `json:"duration"`       // seconds; 0 if unknown
+	UploadDate string  `json:"upload_date"`    // YYYYMMDD; empty if unknown
+	LiveStatus string  `json:"live_status"`    // e.g. "is_upcoming" for scheduled streams
+	Playlist   string  `json:"playlist_title"` // title of the listed playlist or channel tab; empty for a single video
+	Index      int     `json:"playlist_index"` // 1-based position in the listing
+}
+
+// PlaylistPosition places a video in the playlist it was listed in. Videos
+// are downloaded one by one, so yt-dlp does not know it; it fills in the
+// {playlist} and {index} fields of the naming template instead.
+type PlaylistPosition struct {
+	Title string `json:"title"`
+	Index int    `json:"index"`
+}
+
+// Position returns where the entry was listed, or nil for a single video
+func (e Entry) Position() *PlaylistPosition {
+	if e.Playlist == "" {
+		return nil
+	}
+	return &PlaylistPosition{Title: e.Playlist, Index: e.Index}
+}
+
+// EntryTemplate is a yt-dlp output template printing an Entry as JSON.
+// Single videos have no ie_key or url in flat listings, so the extractor_key
+// and webpage_url stand in for them.
+const EntryTemplate = "%(.{id,title,url,webpage_url,ie_key,extractor_key,duration,upload_date,live_status,playlist_title,playlist_index})j"
+
+// ParseEntries reads the entries printed with EntryTemplate, one JSON object
+// per line; lines that are not entries are skipped
+func ParseEntries(data []byte) ([]Entry, error) {
+	var entries []Entry
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+		var entry struct {
+			Entry
+			WebpageURL   string `json:"webpage_url"`
+			ExtractorKey string `json:"extractor_key"`
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, fmt.Errorf("parsing yt-dlp playlist entry: %w", err)
+		}
+		if entry.URL == "" {
+			entry.URL = entry.WebpageURL
+		}
+		if entry.Extractor == "" {
+			entry.Extractor = entry.ExtractorKey
+		}
+		if entry.ID != "" && entry.URL != "" {
+			entries = append(entries, entry.Entry)
+		}
+	}
+	return entries, nil
+}
+
+// ListEntries asks yt-dlp for the first limit entries of a channel or
+// playlist, in the site's order, without downloading them. A single video
+// is listed as its only entry.
+func ListEntries(ctx context.Context, url string, limit int) ([]Entry, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := Command(ctx,
+		"--flat-playlist",
+		"--skip-download",
+		"--playlist-end", strconv.Itoa(limit),
+		// YouTube channel tabs only list upload dates when asked
+		"--extractor-args", "youtubetab:approximate_date",
+		"--print", EntryTemplate,
+		"--", url,
+	)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, lastLine(msg))
+		}
+		return nil, err
+	}
+	return ParseEntries(stdout.Bytes())
+}
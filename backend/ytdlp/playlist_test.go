@@ -0,0 +1,39 @@
+package ytdlp
+
+import "testing"
+
+func TestParseEntries(t *testing.T) {
+	data := []byte(`WARNING: [youtube:tab] Incomplete data received
+{"id":"a1","title":"Newest","url":"https://www.youtube.com/watch?v=a1","ie_key":"Youtube","duration":612,"upload_date":"20250710","live_status":null,"playlist_title":"Fake Channel - Videos","playlist_index":1}
+{"id":"b2","title":"Premiere","url":"https://www.youtube.com/watch?v=b2","ie_key":"Youtube","duration":null,"upload_date":null,"live_status":"is_upcoming"}
+{"id":"c3","title":"Single","url":null,"webpage_url":"https://vimeo.com/c3","ie_key":null,"extractor_key":"Vimeo","duration":30.5}
+{"id":"d4","title":"No URL"}
+
+`)
+	entries, err := ParseEntries(data)
+	if err != nil {
+		t.Fatalf("ParseEntries() = %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %+v", entries)
+	}
+	if e := entries[0]; e.ID != "a1" || e.Extractor != "Youtube" || e.Duration != 612 || e.UploadDate != "20250710" || e.LiveStatus != "" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if e := entries[1]; e.UploadDate != "" || e.LiveStatus != "is_upcoming" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if e := entries[2]; e.URL != "https://vimeo.com/c3" || e.Extractor != "Vimeo" {
+		t.Errorf("expected the webpage URL and extractor key of a single video, got %+v", e)
+	}
+	if p := entries[0].Position(); p == nil || *p != (PlaylistPosition{Title: "Fake Channel - Videos", Index: 1}) {
+		t.Errorf("expected the entry's playlist position, got %+v", p)
+	}
+	if p := entries[2].Position(); p != nil {
+		t.Errorf("expected no playlist position for a single video, got %+v", p)
+	}
+
+	if _, err := ParseEntries([]byte(`{"id": 1}`)); err == nil {
+		t.Error("expected an error for a malformed entry")
+	}
+}
@@ -0,0 +1,133 @@
+package ytdlp
+
+import (
+	"bufio"
+	"context"
+	"downloader/metrics"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Binary is the yt-dlp executable looked up on PATH
+var Binary = "yt-dlp"
+
+// Proxy, when set, is passed to every yt-dlp run as --proxy. The server sets
+// it to a utils.PolicyProxy so the URL policy holds for every connection
+// yt-dlp makes, not just for the URL it was given.
+var Proxy string
+
+// Command builds the process for a yt-dlp run. It is a variable so tests can
+// substitute a fake process.
+var Command = func(ctx context.Context, args ...string) *exec.Cmd {
+	if Proxy != "" {
+		args = append([]string{"--proxy", Proxy}, args...)
+	}
+	return exec.CommandContext(ctx, Binary, args...)
+}
+
+// Run starts yt-dlp with args and passes every line of its stdout and stderr
+// to onLine, in order per stream, until the process exits
+func Run(ctx context.Context, args []string, onLine func(string)) error {
+	cmd := Command(ctx, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = cmd.Start()
+	metrics.SpawnLatency.Observe(time.Since(start).Seconds())
+	if err != nil {
+		return err
+	}
+
+	// onLine is called from both readers, so serialize it
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, r := range []io.Reader{stdout, stderr} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scanner := bufio.NewScanner(r)
+			scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+			scanner.Split(scanLines)
+			for scanner.Scan() {
+				mu.Lock()
+				onLine(scanner.Text())
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return cmd.Wait()
+}
+
+// scanLines splits on \n and on the bare \r yt-dlp uses to redraw progress
+func scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	for i, b := range data {
+		if b == '\n' || b == '\r' {
+			return i + 1, data[:i], nil
+		}
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+var destinationPattern = regexp.MustCompile(`^\[[A-Za-z]+\] Destination: (.+)$`)
+
+// Destination returns the file path announced by a "[download] Destination:"
+// style line, or "" for any other line
+func Destination(line string) string {
+	if m := destinationPattern.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+var subtitlePattern = regexp.MustCompile(`^\[info\] Writing video (?:automatic )?subtitles to: (.+)$`)
+
+// SubtitleFile returns the path announced by an "[info] Writing video
+// subtitles to:" line, or "" for any other line
+func SubtitleFile(line string) string {
+	if m := subtitlePattern.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+var thumbnailPattern = regexp.MustCompile(`^\[info\] Writing video thumbnail (?:\S+ )?to: (.+)$`)
+
+// ThumbnailFile returns where the thumbnail announced by an "[info] Writing
+// video thumbnail" line ends up once converted to JPEG, or "" for any other line
+func ThumbnailFile(line string) string {
+	m := thumbnailPattern.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return ""
+	}
+	return strings.TrimSuffix(m[1], filepath.Ext(m[1])) + "." + ThumbnailFormat
+}
+
+var archivedPattern = regexp.MustCompile(`^\[([A-Za-z0-9]+)\] (\S+): has already been recorded in the archive$`)
+
+// ArchivedVideo returns the extractor key and video ID of an "[<extractor>]
+// <id>: has already been recorded in the archive" line, which yt-dlp prints
+// when it skips a video of the --download-archive before extracting it, or
+// empty strings for any other line
+func ArchivedVideo(line string) (extractor, id string) {
+	if m := archivedPattern.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+		return m[1], m[2]
+	}
+	return "", ""
+}
@@ -0,0 +1,109 @@
+package ytdlp
+
+import (
+	"bufio"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestDestination(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{"[download] Destination: /dl/My Video.f137.mp4", "/dl/My Video.f137.mp4"},
+		{"[ExtractAudio] Destination: /dl/Song.mp3", "/dl/Song.mp3"},
+		{"[download]  42.0% of 10.00MiB", ""},
+		{"[Merger] Merging formats into \"/dl/My Video.mp4\"", ""},
+	}
+
+	for _, test := range tests {
+		if result := Destination(test.line); result != test.expected {
+			t.Errorf("Destination(%q) = %q; want %q", test.line, result, test.expected)
+		}
+	}
+}
+
+func TestSubtitleFile(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{"[info] Writing video subtitles to: /dl/My Video.en.vtt", "/dl/My Video.en.vtt"},
+		{"[info] Writing video automatic subtitles to: /dl/My Video.de.vtt", "/dl/My Video.de.vtt"},
+		{"[info] There are no subtitles for the requested languages", ""},
+		{"[download] Destination: /dl/My Video.mp4", ""},
+	}
+
+	for _, test := range tests {
+		if result := SubtitleFile(test.line); result != test.expected {
+			t.Errorf("SubtitleFile(%q) = %q; want %q", test.line, result, test.expected)
+		}
+	}
+}
+
+func TestThumbnailFile(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{"[info] Writing video thumbnail original to: /dl/My Video.webp", "/dl/My Video.jpg"},
+		{"[info] Writing video thumbnail 41 to: /dl/Song.jpg", "/dl/Song.jpg"},
+		{"[ThumbnailsConvertor] Converting thumbnail \"/dl/My Video.webp\" to jpg", ""},
+		{"[info] Writing video subtitles to: /dl/My Video.en.vtt", ""},
+	}
+
+	for _, test := range tests {
+		if result := ThumbnailFile(test.line); result != test.expected {
+			t.Errorf("ThumbnailFile(%q) = %q; want %q", test.line, result, test.expected)
+		}
+	}
+}
+
+func TestArchivedVideo(t *testing.T) {
+	tests := []struct {
+		line      string
+		extractor string
+		id        string
+	}{
+		{"[Youtube] dQw4w9WgXcQ: has already been recorded in the archive", "Youtube", "dQw4w9WgXcQ"},
+		{"[download] Never Gonna Give You Up has already been recorded in the archive", "", ""},
+		{"[youtube] dQw4w9WgXcQ: Downloading webpage", "", ""},
+	}
+
+	for _, test := range tests {
+		if extractor, id := ArchivedVideo(test.line); extractor != test.extractor || id != test.id {
+			t.Errorf("ArchivedVideo(%q) = %q, %q; want %q, %q", test.line, extractor, id, test.extractor, test.id)
+		}
+	}
+}
+
+func TestScanLinesSplitsCarriageReturns(t *testing.T) {
+	scanner := bufio.NewScanner(strings.NewReader("[youtube] abc\n  1.0%\r 50.0%\r100.0%\nlast"))
+	scanner.Split(scanLines)
+
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	expected := []string{"[youtube] abc", "  1.0%", " 50.0%", "100.0%", "last"}
+	if strings.Join(lines, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected %q, got %q", expected, lines)
+	}
+}
+
+func TestCommandPassesProxy(t *testing.T) {
+	defer func(proxy string) { Proxy = proxy }(Proxy)
+
+	Proxy = ""
+	if args := strings.Join(Command(context.Background(), "-J", "https://example.com").Args[1:], " "); args != "-J https://example.com" {
+		t.Errorf("expected no proxy, got %q", args)
+	}
+
+	Proxy = "http://127.0.0.1:8899"
+	if args := strings.Join(Command(context.Background(), "-J", "https://example.com").Args[1:], " "); args != "--proxy http://127.0.0.1:8899 -J https://example.com" {
+		t.Errorf("expected the proxy first, got %q", args)
+	}
+}
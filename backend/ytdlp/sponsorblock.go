@@ -0,0 +1,73 @@
+package ytdlp
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"downloader/sponsorblock"
+)
+
+// SponsorBlockChapter is one entry of yt-dlp's sponsorblock_chapters field
+type SponsorBlockChapter struct {
+	Category  string  `json:"category"`
+	Type      string  `json:"type"` // the action type: "skip", "poi" or "chapter"
+	StartTime float64 `json:"start_time"`
+	EndTime   float64 `json:"end_time"`
+}
+
+// sponsorBlockMark returns the categories marked as chapters
+func (o Options) sponsorBlockMark() []string {
+	return splitList(o.SponsorBlockMark)
+}
+
+// SponsorBlockRemoved returns the categories cut out of the download
+func (o Options) SponsorBlockRemoved() []string {
+	return splitList(o.SponsorBlockRemove)
+}
+
+// UsesSponsorBlock reports whether SponsorBlock segments are marked or removed
+func (o Options) UsesSponsorBlock() bool {
+	return len(o.sponsorBlockMark()) > 0 || len(o.SponsorBlockRemoved()) > 0
+}
+
+func (o Options) validateSponsorBlock() error {
+	mark, remove := o.sponsorBlockMark(), o.SponsorBlockRemoved()
+	for _, category := range mark {
+		if !sponsorblock.IsCategory(category) {
+			return fmt.Errorf("Invalid SponsorBlock category %q. See GET /capabilities", category)
+		}
+	}
+	for _, category := range remove {
+		if !sponsorblock.IsCategory(category) {
+			return fmt.Errorf("Invalid SponsorBlock category %q. See GET /capabilities", category)
+		}
+		if slices.Contains(sponsorblock.Unremovable, category) {
+			return fmt.Errorf("SponsorBlock category %q cannot be removed, only marked", category)
+		}
+		if slices.Contains(mark, category) {
+			return fmt.Errorf("SponsorBlock category %q is both marked and removed", category)
+		}
+	}
+	if len(remove) > 0 && o.Clipped() {
+		return errors.New("sponsorBlockRemove cannot be combined with start or end")
+	}
+	return nil
+}
+
+// sponsorBlockArgs marks categories as chapters and cuts others out, using
+// the configured API
+func (o Options) sponsorBlockArgs() []string {
+	if !o.UsesSponsorBlock() {
+		return nil
+	}
+	var args []string
+	if mark := o.sponsorBlockMark(); len(mark) > 0 {
+		args = append(args, "--sponsorblock-mark", strings.Join(mark, ","))
+	}
+	if remove := o.SponsorBlockRemoved(); len(remove) > 0 {
+		args = append(args, "--sponsorblock-remove", strings.Join(remove, ","))
+	}
+	return append(args, "--sponsorblock-api", sponsorblock.BaseURL())
+}
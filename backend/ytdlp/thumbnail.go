@@ -0,0 +1,51 @@
+package ytdlp
+
+import (
+	"errors"
+	"slices"
+)
+
+// ThumbnailFormat is the image format thumbnails are converted to; every
+// container that takes cover art accepts JPEG
+const ThumbnailFormat = "jpg"
+
+// Cover art can be embedded in these outputs
+var (
+	thumbnailAudioCodecs = []string{"mp3", "m4a", "flac"}
+	thumbnailContainers  = []string{"mp4", "mkv", "mov"}
+)
+
+// WantsThumbnail reports whether the thumbnail is downloaded
+func (o Options) WantsThumbnail() bool {
+	return o.EmbedThumbnail || o.WriteThumbnail
+}
+
+func (o Options) validateThumbnail() error {
+	if o.SquareThumbnail {
+		if o.Format != "audio" {
+			return errors.New("squareThumbnail is only available for audio downloads")
+		}
+		if !o.WantsThumbnail() {
+			return errors.New("squareThumbnail requires embedThumbnail or writeThumbnail")
+		}
+	}
+	if !o.EmbedThumbnail {
+		return nil
+	}
+	if o.Format == "audio" && !slices.Contains(thumbnailAudioCodecs, o.audioCodec()) {
+		return errors.New("Cover art can only be embedded in mp3, m4a and flac audio")
+	}
+	if o.Format == "video" && !slices.Contains(thumbnailContainers, o.VideoFormat) {
+		return errors.New("Thumbnails can only be embedded in mp4, mkv and mov video")
+	}
+	return nil
+}
+
+// thumbnailArgs fetches the thumbnail as a JPEG beside the media; the
+// server embeds, crops and removes it after post-processing
+func (o Options) thumbnailArgs() []string {
+	if !o.WantsThumbnail() {
+		return nil
+	}
+	return []string{"--write-thumbnail", "--convert-thumbnails", ThumbnailFormat}
+}